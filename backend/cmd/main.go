@@ -2,10 +2,12 @@ package main
 
 import (
 	"log"
+	"time"
 	
 	"ai-doc-system/internal/api"
 	"ai-doc-system/internal/config"
 	"ai-doc-system/internal/database"
+	"ai-doc-system/internal/realtime"
 )
 
 func main() {
@@ -24,8 +26,20 @@ func main() {
 		log.Fatal("Failed to run migrations:", err)
 	}
 	
+	// Real-time event hub, fanned out across instances through Postgres LISTEN/NOTIFY
+	hub := realtime.NewHub()
+	go hub.RunPruning(time.Minute)
+	broker, err := realtime.NewPGBroker(db, database.DSN(cfg), hub)
+	if err != nil {
+		log.Printf("Failed to start realtime broker, events are delivered locally only: %v", err)
+	} else {
+		defer broker.Close()
+		hub.SetBroker(broker)
+		go broker.RunCleanup(time.Minute)
+	}
+	
 	// Setup routes
-	router := api.SetupRouter(db, cfg.JWTSecret)
+	router := api.SetupRouter(db, cfg, hub)
 	
 	// Start server
 	log.Printf("Server starting on port %s", cfg.Port)
@@ -0,0 +1,34 @@
+// Command oidc-dev-issuer is a minimal OpenID Connect provider for trying out and testing
+// single sign-on locally. It runs the authorization code flow with PKCE and lets you pick
+// the subject, username, email and groups of each login on a form. Never expose it: it
+// authenticates nobody.
+//
+//	go run ./cmd/oidc-dev-issuer -addr :9000 -client-id ai-doc-system -client-secret dev-secret
+//
+// and start the backend with OIDC_ISSUER_URL=http://localhost:9000, OIDC_CLIENT_ID and
+// OIDC_CLIENT_SECRET set to the same values.
+package main
+
+import (
+	"flag"
+	"log"
+	"net/http"
+
+	"ai-doc-system/internal/oidc/oidctest"
+)
+
+func main() {
+	addr := flag.String("addr", ":9000", "listen address")
+	issuerURL := flag.String("issuer", "http://localhost:9000", "issuer URL as the backend reaches it")
+	clientID := flag.String("client-id", "ai-doc-system", "accepted client ID")
+	clientSecret := flag.String("client-secret", "dev-secret", "accepted client secret")
+	flag.Parse()
+
+	issuer, err := oidctest.NewIssuer(*issuerURL, *clientID, *clientSecret)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	log.Printf("OIDC dev issuer %s listening on %s", issuer.URL, *addr)
+	log.Fatal(http.ListenAndServe(*addr, issuer.Handler()))
+}
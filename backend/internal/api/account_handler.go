@@ -0,0 +1,121 @@
+package api
+
+import (
+	"net/http"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+	"ai-doc-system/internal/services"
+)
+
+type AccountHandler struct {
+	accountDeletionService *services.AccountDeletionService
+	dataExportService      *services.DataExportService
+	auditService           *services.AuditService
+}
+
+func NewAccountHandler(accountDeletionService *services.AccountDeletionService, dataExportService *services.DataExportService,
+	auditService *services.AuditService) *AccountHandler {
+	return &AccountHandler{
+		accountDeletionService: accountDeletionService,
+		dataExportService:      dataExportService,
+		auditService:           auditService,
+	}
+}
+
+type DeleteAccountRequest struct {
+	Username string `json:"username" binding:"required"` // Typed by the user to confirm
+	Password string `json:"password"`                    // Not needed for accounts without a password
+}
+
+func (h *AccountHandler) GetDeletionStatus(c *gin.Context) {
+	userID, _ := c.Get("user_id")
+
+	status, err := h.accountDeletionService.GetDeletionStatus(userID.(int))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get account deletion status"})
+		return
+	}
+
+	c.JSON(http.StatusOK, status)
+}
+
+// Schedule the account for deletion after the grace period
+func (h *AccountHandler) ScheduleDeletion(c *gin.Context) {
+	userID, _ := c.Get("user_id")
+
+	var req DeleteAccountRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	deleteAt, err := h.accountDeletionService.ScheduleDeletion(userID.(int), req.Username, req.Password)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	recordAudit(c, h.auditService, "account.deletion_scheduled", "user", strconv.Itoa(userID.(int)), gin.H{"scheduled_at": deleteAt})
+	c.JSON(http.StatusOK, gin.H{
+		"message":      "Account deletion scheduled successfully",
+		"scheduled_at": deleteAt,
+	})
+}
+
+func (h *AccountHandler) CancelDeletion(c *gin.Context) {
+	userID, _ := c.Get("user_id")
+
+	if err := h.accountDeletionService.CancelDeletion(userID.(int)); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	recordAudit(c, h.auditService, "account.deletion_cancelled", "user", strconv.Itoa(userID.(int)), nil)
+	c.JSON(http.StatusOK, gin.H{"message": "Account deletion cancelled successfully"})
+}
+
+// Queue an export of everything stored about the user, a notification tells when it is ready
+func (h *AccountHandler) RequestExport(c *gin.Context) {
+	userID, _ := c.Get("user_id")
+
+	export, err := h.dataExportService.RequestExport(userID.(int))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusCreated, gin.H{
+		"message": "Data export requested successfully",
+		"export":  export,
+	})
+}
+
+func (h *AccountHandler) GetExports(c *gin.Context) {
+	userID, _ := c.Get("user_id")
+
+	exports, err := h.dataExportService.GetExports(userID.(int))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get data exports"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"exports": exports})
+}
+
+func (h *AccountHandler) DownloadExport(c *gin.Context) {
+	userID, _ := c.Get("user_id")
+	exportID, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid export ID"})
+		return
+	}
+
+	filePath, createdAt, err := h.dataExportService.GetExportFile(exportID, userID.(int))
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.FileAttachment(filePath, "data-export-"+createdAt.Format("2006-01-02")+".zip")
+}
@@ -0,0 +1,224 @@
+package api
+
+import (
+	"net/http"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+	"ai-doc-system/internal/services"
+)
+
+type AdminUserHandler struct {
+	userAdminService       *services.UserAdminService
+	sessionService         *services.SessionService
+	fileShareService       *services.FileShareService
+	passwordResetService   *services.PasswordResetService
+	accountDeletionService *services.AccountDeletionService
+	roleService            *services.RoleService
+	auditService           *services.AuditService
+}
+
+func NewAdminUserHandler(userAdminService *services.UserAdminService, sessionService *services.SessionService,
+	fileShareService *services.FileShareService, passwordResetService *services.PasswordResetService,
+	accountDeletionService *services.AccountDeletionService, roleService *services.RoleService,
+	auditService *services.AuditService) *AdminUserHandler {
+	return &AdminUserHandler{
+		userAdminService:       userAdminService,
+		sessionService:         sessionService,
+		fileShareService:       fileShareService,
+		passwordResetService:   passwordResetService,
+		accountDeletionService: accountDeletionService,
+		roleService:            roleService,
+		auditService:           auditService,
+	}
+}
+
+// Search users by username or email, filtered by role, status and where their password is checked
+func (h *AdminUserHandler) GetUsers(c *gin.Context) {
+	opts := services.UserQueryOptions{
+		Search:     c.Query("q"),
+		Role:       c.Query("role"),
+		Status:     c.Query("status"),
+		AuthSource: c.Query("auth_source"),
+		Sort:       c.Query("sort"),
+	}
+
+	var err error
+	opts.Limit, err = strconv.Atoi(c.DefaultQuery("limit", "50"))
+	if err != nil || opts.Limit <= 0 || opts.Limit > 200 {
+		opts.Limit = 50
+	}
+	opts.Offset, err = strconv.Atoi(c.DefaultQuery("offset", "0"))
+	if err != nil || opts.Offset < 0 {
+		opts.Offset = 0
+	}
+
+	page, err := h.userAdminService.GetUsers(opts)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, page)
+}
+
+func (h *AdminUserHandler) GetUser(c *gin.Context) {
+	userID, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid user ID"})
+		return
+	}
+
+	user, err := h.userAdminService.GetUser(userID)
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "User not found"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"user": user})
+}
+
+// Disable a user's account; their sessions end and tokens are rejected right away
+func (h *AdminUserHandler) DisableUser(c *gin.Context) {
+	actorID, _ := c.Get("user_id")
+	userID, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid user ID"})
+		return
+	}
+
+	if err := h.userAdminService.DisableUser(actorID.(int), userID); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	recordAudit(c, h.auditService, "user.disable", "user", strconv.Itoa(userID), nil)
+	c.JSON(http.StatusOK, gin.H{"message": "User disabled successfully"})
+}
+
+func (h *AdminUserHandler) EnableUser(c *gin.Context) {
+	userID, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid user ID"})
+		return
+	}
+
+	if err := h.userAdminService.EnableUser(userID); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	recordAudit(c, h.auditService, "user.enable", "user", strconv.Itoa(userID), nil)
+	c.JSON(http.StatusOK, gin.H{"message": "User enabled successfully"})
+}
+
+// Make the user choose a new password, the reset link is emailed to them
+func (h *AdminUserHandler) ForcePasswordReset(c *gin.Context) {
+	actorID, _ := c.Get("user_id")
+	userID, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid user ID"})
+		return
+	}
+
+	if err := h.roleService.CanManageUser(actorID.(int), userID); err != nil {
+		c.JSON(http.StatusForbidden, gin.H{"error": err.Error()})
+		return
+	}
+
+	if err := h.passwordResetService.ForceReset(userID); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	recordAudit(c, h.auditService, "user.password_reset", "user", strconv.Itoa(userID), nil)
+	c.JSON(http.StatusOK, gin.H{"message": "Password reset forced successfully, the reset link was emailed"})
+}
+
+// Delete a user's account and everything it owns right away, without a grace period
+func (h *AdminUserHandler) DeleteUser(c *gin.Context) {
+	actorID, _ := c.Get("user_id")
+	userID, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid user ID"})
+		return
+	}
+	if userID == actorID.(int) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "You cannot delete your own account here"})
+		return
+	}
+
+	if _, err := h.accountDeletionService.DeleteAccount(actorID.(int), userID); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	recordAudit(c, h.auditService, "user.delete", "user", strconv.Itoa(userID), nil)
+	c.JSON(http.StatusOK, gin.H{"message": "User deleted successfully"})
+}
+
+// A user's active sessions
+func (h *AdminUserHandler) GetSessions(c *gin.Context) {
+	userID, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid user ID"})
+		return
+	}
+
+	sessions, err := h.sessionService.GetSessions(userID, "")
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get sessions"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"sessions": sessions})
+}
+
+// Log a user out everywhere
+func (h *AdminUserHandler) RevokeSessions(c *gin.Context) {
+	actorID, _ := c.Get("user_id")
+	userID, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid user ID"})
+		return
+	}
+
+	if err := h.roleService.CanManageUser(actorID.(int), userID); err != nil {
+		c.JSON(http.StatusForbidden, gin.H{"error": err.Error()})
+		return
+	}
+
+	revoked, err := h.sessionService.RevokeAllSessions(userID, "", "revoked_by_admin")
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to revoke sessions"})
+		return
+	}
+
+	recordAudit(c, h.auditService, "user.sessions_revoke", "user", strconv.Itoa(userID), gin.H{"revoked": revoked})
+	c.JSON(http.StatusOK, gin.H{
+		"message": "Sessions revoked successfully",
+		"revoked": revoked,
+	})
+}
+
+// Shares the user created
+func (h *AdminUserHandler) GetShares(c *gin.Context) {
+	userID, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid user ID"})
+		return
+	}
+
+	shares, err := h.fileShareService.GetMySharedFiles(userID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get shares"})
+		return
+	}
+
+	// Public link tokens would let the admin download the files
+	for _, share := range shares {
+		delete(share, "share_token")
+	}
+
+	c.JSON(http.StatusOK, gin.H{"shares": shares})
+}
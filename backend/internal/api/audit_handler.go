@@ -0,0 +1,62 @@
+package api
+
+import (
+	"log"
+	"net/http"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+	"ai-doc-system/internal/services"
+)
+
+type AuditHandler struct {
+	auditService *services.AuditService
+}
+
+func NewAuditHandler(auditService *services.AuditService) *AuditHandler {
+	return &AuditHandler{auditService: auditService}
+}
+
+// Read the audit log, filtered by action (or an action prefix such as "role."), actor and target
+func (h *AuditHandler) GetEntries(c *gin.Context) {
+	opts := services.AuditQueryOptions{
+		Action:     c.Query("action"),
+		TargetType: c.Query("target_type"),
+		TargetID:   c.Query("target_id"),
+	}
+
+	var err error
+	if actorID := c.Query("actor_id"); actorID != "" {
+		opts.ActorID, err = strconv.Atoi(actorID)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid user ID"})
+			return
+		}
+	}
+	opts.Limit, err = strconv.Atoi(c.DefaultQuery("limit", "50"))
+	if err != nil || opts.Limit <= 0 || opts.Limit > 200 {
+		opts.Limit = 50
+	}
+	opts.Offset, err = strconv.Atoi(c.DefaultQuery("offset", "0"))
+	if err != nil || opts.Offset < 0 {
+		opts.Offset = 0
+	}
+
+	page, err := h.auditService.GetEntries(opts)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get audit log"})
+		return
+	}
+
+	c.JSON(http.StatusOK, page)
+}
+
+// Record an administrative action of the request's user. The action already happened,
+// so a failure is logged rather than returned.
+func recordAudit(c *gin.Context, auditService *services.AuditService, action, targetType, targetID string, details gin.H) {
+	userID, _ := c.Get("user_id")
+	actorID, _ := userID.(int)
+	if err := auditService.Record(actorID, c.ClientIP(), action, targetType, targetID, details); err != nil {
+		log.Printf("Failed to record audit entry %s: %v", action, err)
+	}
+}
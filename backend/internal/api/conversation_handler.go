@@ -0,0 +1,383 @@
+package api
+
+import (
+	"net/http"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+	"ai-doc-system/internal/auth"
+	"ai-doc-system/internal/models"
+	"ai-doc-system/internal/services"
+)
+
+type ConversationHandler struct {
+	conversationService *services.ConversationService
+	messageService      *services.MessageService
+}
+
+func NewConversationHandler(conversationService *services.ConversationService, messageService *services.MessageService) *ConversationHandler {
+	return &ConversationHandler{
+		conversationService: conversationService,
+		messageService:      messageService,
+	}
+}
+
+type CreateGroupConversationRequest struct {
+	Name      string `json:"name" binding:"required,min=1,max=100"`
+	MemberIDs []int  `json:"member_ids" binding:"required,min=1"`
+}
+
+type RenameConversationRequest struct {
+	Name string `json:"name" binding:"required,min=1,max=100"`
+}
+
+type AddMembersRequest struct {
+	UserIDs []int `json:"user_ids" binding:"required,min=1"`
+}
+
+type SetMemberRoleRequest struct {
+	Role string `json:"role" binding:"required,oneof=admin member"`
+}
+
+type SendConversationMessageRequest struct {
+	Content   string `json:"content" binding:"max=1000"`
+	FileID    int    `json:"file_id"` // Send one of the user's files, content becomes an optional caption
+	ReplyToID *int   `json:"reply_to_id"` // Parent message in the same conversation
+}
+
+func (h *ConversationHandler) CreateGroup(c *gin.Context) {
+	userID, _ := c.Get("user_id")
+
+	var req CreateGroupConversationRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	conversation, err := h.conversationService.CreateGroup(userID.(int), req.Name, req.MemberIDs)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusCreated, gin.H{
+		"message":      "Group created successfully",
+		"conversation": conversation,
+	})
+}
+
+func (h *ConversationHandler) GetConversation(c *gin.Context) {
+	userID, _ := c.Get("user_id")
+	conversationID, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid conversation ID"})
+		return
+	}
+
+	conversation, err := h.conversationService.GetConversation(conversationID, userID.(int))
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"conversation": conversation})
+}
+
+func (h *ConversationHandler) RenameGroup(c *gin.Context) {
+	userID, _ := c.Get("user_id")
+	conversationID, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid conversation ID"})
+		return
+	}
+
+	var req RenameConversationRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	err = h.conversationService.RenameGroup(conversationID, userID.(int), req.Name)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Group renamed successfully"})
+}
+
+func (h *ConversationHandler) AddMembers(c *gin.Context) {
+	userID, _ := c.Get("user_id")
+	conversationID, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid conversation ID"})
+		return
+	}
+
+	var req AddMembersRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	err = h.conversationService.AddMembers(conversationID, userID.(int), req.UserIDs)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Members added successfully"})
+}
+
+func (h *ConversationHandler) RemoveMember(c *gin.Context) {
+	userID, _ := c.Get("user_id")
+	conversationID, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid conversation ID"})
+		return
+	}
+	memberID, err := strconv.Atoi(c.Param("user_id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid user ID"})
+		return
+	}
+
+	err = h.conversationService.RemoveMember(conversationID, userID.(int), memberID)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Member removed successfully"})
+}
+
+func (h *ConversationHandler) SetMemberRole(c *gin.Context) {
+	userID, _ := c.Get("user_id")
+	conversationID, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid conversation ID"})
+		return
+	}
+	memberID, err := strconv.Atoi(c.Param("user_id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid user ID"})
+		return
+	}
+
+	var req SetMemberRoleRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	err = h.conversationService.SetMemberRole(conversationID, userID.(int), memberID, req.Role)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Member role updated successfully"})
+}
+
+func (h *ConversationHandler) LeaveConversation(c *gin.Context) {
+	userID, _ := c.Get("user_id")
+	conversationID, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid conversation ID"})
+		return
+	}
+
+	err = h.conversationService.LeaveConversation(conversationID, userID.(int))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Left group successfully"})
+}
+
+func (h *ConversationHandler) GetMessages(c *gin.Context) {
+	userID, _ := c.Get("user_id")
+	conversationID, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid conversation ID"})
+		return
+	}
+
+	opts, err := historyOptions(c)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	page, err := h.messageService.GetConversationHistory(conversationID, userID.(int), opts)
+	if err != nil {
+		historyError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, page)
+}
+
+func (h *ConversationHandler) SendMessage(c *gin.Context) {
+	userID, _ := c.Get("user_id")
+	conversationID, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid conversation ID"})
+		return
+	}
+
+	var req SendConversationMessageRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	if req.FileID == 0 && req.Content == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Content is required"})
+		return
+	}
+
+	// Sending a file shares it with the members
+	if req.FileID != 0 && !auth.HasScope(c, auth.ScopeShares) {
+		c.JSON(http.StatusForbidden, gin.H{"error": "Token requires the " + auth.ScopeShares + " scope"})
+		return
+	}
+
+	var message *models.Message
+	if req.FileID != 0 {
+		message, err = h.messageService.SendConversationFile(conversationID, userID.(int), req.FileID, req.Content, req.ReplyToID)
+	} else {
+		message, err = h.messageService.SendConversationMessage(conversationID, userID.(int), req.Content, req.ReplyToID)
+	}
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusCreated, gin.H{
+		"message": "Message sent successfully",
+		"data":    message,
+	})
+}
+
+// Upload a new file directly into a conversation
+func (h *ConversationHandler) UploadFile(c *gin.Context) {
+	userID, _ := c.Get("user_id")
+	conversationID, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid conversation ID"})
+		return
+	}
+
+	// The upload goes into the user's storage and is shared with the members
+	for _, scope := range []string{auth.ScopeFilesWrite, auth.ScopeShares} {
+		if !auth.HasScope(c, scope) {
+			c.JSON(http.StatusForbidden, gin.H{"error": "Token requires the " + scope + " scope"})
+			return
+		}
+	}
+
+	file, err := c.FormFile("file")
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "No file uploaded"})
+		return
+	}
+
+	caption := c.PostForm("content")
+	if len(caption) > 1000 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Content is too long"})
+		return
+	}
+
+	var replyToID *int
+	if replyToStr := c.PostForm("reply_to_id"); replyToStr != "" {
+		id, err := strconv.Atoi(replyToStr)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid message ID"})
+			return
+		}
+		replyToID = &id
+	}
+
+	message, err := h.messageService.UploadConversationFile(conversationID, userID.(int), file, caption, replyToID)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusCreated, gin.H{
+		"message": "File sent successfully",
+		"data":    message,
+	})
+}
+
+func (h *ConversationHandler) MarkAsRead(c *gin.Context) {
+	userID, _ := c.Get("user_id")
+	conversationID, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid conversation ID"})
+		return
+	}
+
+	upToMessageID, err := strconv.Atoi(c.DefaultQuery("up_to_message_id", "0"))
+	if err != nil || upToMessageID < 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid message ID"})
+		return
+	}
+
+	count, err := h.messageService.MarkConversationAsRead(conversationID, userID.(int), upToMessageID)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"message":      "Messages marked as read",
+		"marked_count": count,
+	})
+}
+
+func (h *ConversationHandler) GetMessageEdits(c *gin.Context) {
+	userID, _ := c.Get("user_id")
+	conversationID, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid conversation ID"})
+		return
+	}
+	messageID, err := strconv.Atoi(c.Param("message_id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid message ID"})
+		return
+	}
+
+	edits, err := h.messageService.GetMessageEdits(conversationID, messageID, userID.(int))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"edits": edits})
+}
+
+func (h *ConversationHandler) GetReplies(c *gin.Context) {
+	userID, _ := c.Get("user_id")
+	conversationID, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid conversation ID"})
+		return
+	}
+	messageID, err := strconv.Atoi(c.Param("message_id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid message ID"})
+		return
+	}
+
+	replies, err := h.messageService.GetReplies(conversationID, messageID, userID.(int))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"messages": replies})
+}
@@ -0,0 +1,38 @@
+package api
+
+import (
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"ai-doc-system/internal/services"
+)
+
+type DirectoryHandler struct {
+	ldapService  *services.LDAPService
+	auditService *services.AuditService
+}
+
+func NewDirectoryHandler(ldapService *services.LDAPService, auditService *services.AuditService) *DirectoryHandler {
+	return &DirectoryHandler{ldapService: ldapService, auditService: auditService}
+}
+
+// Whether users can log in with directory passwords
+func (h *DirectoryHandler) GetStatus(c *gin.Context) {
+	c.JSON(http.StatusOK, gin.H{"enabled": h.ldapService.Enabled()})
+}
+
+// Sync directory users now instead of waiting for the next scheduled sync
+func (h *DirectoryHandler) Sync(c *gin.Context) {
+	result, err := h.ldapService.Sync()
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	recordAudit(c, h.auditService, "directory.sync", "setting", "directory", gin.H{
+		"updated":  result.Updated,
+		"disabled": result.Disabled,
+	})
+
+	c.JSON(http.StatusOK, result)
+}
@@ -0,0 +1,90 @@
+package api
+
+import (
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"ai-doc-system/internal/services"
+)
+
+type EmailHandler struct {
+	emailService *services.EmailService
+	userService  *services.UserService
+}
+
+func NewEmailHandler(emailService *services.EmailService, userService *services.UserService) *EmailHandler {
+	return &EmailHandler{
+		emailService: emailService,
+		userService:  userService,
+	}
+}
+
+type UpdateEmailSettingsRequest struct {
+	Email              string `json:"email" binding:"omitempty,email,max=255"` // Empty removes the address
+	EmailNotifications string `json:"email_notifications" binding:"required,oneof=instant digest off"`
+}
+
+type SendTestEmailRequest struct {
+	To string `json:"to" binding:"omitempty,email"` // Defaults to the admin's own address
+}
+
+func (h *EmailHandler) GetEmailSettings(c *gin.Context) {
+	userID, _ := c.Get("user_id")
+
+	user, err := h.userService.GetUserByID(userID.(int))
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "User not found"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"email":               user.Email,
+		"email_notifications": user.EmailNotifications,
+		"email_enabled":       h.emailService.Enabled(),
+	})
+}
+
+func (h *EmailHandler) UpdateEmailSettings(c *gin.Context) {
+	userID, _ := c.Get("user_id")
+
+	var req UpdateEmailSettingsRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	err := h.emailService.UpdateEmailSettings(userID.(int), req.Email, req.EmailNotifications)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Email settings updated successfully"})
+}
+
+func (h *EmailHandler) SendTestEmail(c *gin.Context) {
+	userID, _ := c.Get("user_id")
+
+	var req SendTestEmailRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	to := req.To
+	if to == "" {
+		user, err := h.userService.GetUserByID(userID.(int))
+		if err != nil || !user.Email.Valid {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "No recipient address"})
+			return
+		}
+		to = user.Email.String
+	}
+
+	if err := h.emailService.SendTestEmail(to); err != nil {
+		c.JSON(http.StatusBadGateway, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Test email sent"})
+}
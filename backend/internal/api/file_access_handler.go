@@ -0,0 +1,96 @@
+package api
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"ai-doc-system/internal/auth"
+	"ai-doc-system/internal/services"
+)
+
+type FileAccessHandler struct {
+	fileAccessService *services.FileAccessService
+}
+
+func NewFileAccessHandler(fileAccessService *services.FileAccessService) *FileAccessHandler {
+	return &FileAccessHandler{fileAccessService: fileAccessService}
+}
+
+type FileAccessTokenRequest struct {
+	Action    string `json:"action" binding:"required,oneof=download preview edit"`
+	OneTime   bool   `json:"one_time"`
+	ExpiresIn int    `json:"expires_in" binding:"omitempty,min=1,max=3600"` // Seconds, 5 minutes by default
+}
+
+// Issue a scoped token and the URL it unlocks, e.g. for an editor iframe or a download link
+func (h *FileAccessHandler) CreateAccessToken(c *gin.Context) {
+	userID, _ := c.Get("user_id")
+	fileID, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid file ID"})
+		return
+	}
+
+	var req FileAccessTokenRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	// Tokens limited to reading files cannot hand out edit access
+	if req.Action == auth.FileActionEdit && !auth.HasScope(c, auth.ScopeFilesWrite) {
+		c.JSON(http.StatusForbidden, gin.H{"error": "Token requires the " + auth.ScopeFilesWrite + " scope"})
+		return
+	}
+
+	token, expiresAt, err := h.fileAccessService.IssueToken(fileID, userID.(int), req.Action, req.OneTime, time.Duration(req.ExpiresIn)*time.Second)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusCreated, gin.H{
+		"token":      token,
+		"url":        fmt.Sprintf("/api/files/%d/%s?token=%s", fileID, req.Action, token),
+		"expires_at": expiresAt,
+	})
+}
+
+// Resolve the user behind a download, preview or edit request. Session tokens and personal
+// access tokens are only accepted in the Authorization header, query strings must carry a
+// file access token scoped to the file and action.
+func fileRequestUser(c *gin.Context, authenticator *auth.Authenticator, fileAccessService *services.FileAccessService, fileID int, action string) (int, bool) {
+	if authHeader := c.GetHeader("Authorization"); strings.HasPrefix(authHeader, "Bearer ") {
+		scope := auth.ScopeFilesRead
+		if action == auth.FileActionEdit {
+			scope = auth.ScopeFilesWrite
+		}
+		claims, err := authenticator.AuthenticateScoped(strings.TrimPrefix(authHeader, "Bearer "), scope)
+		if err == auth.ErrMissingScope {
+			c.JSON(http.StatusForbidden, gin.H{"error": "Token requires the " + scope + " scope"})
+			return 0, false
+		}
+		if err != nil {
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid token"})
+			return 0, false
+		}
+		return claims.UserID, true
+	}
+
+	token := c.Query("token")
+	if token == "" {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Authentication required"})
+		return 0, false
+	}
+
+	claims, err := fileAccessService.ValidateToken(token, fileID, action)
+	if err != nil {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": err.Error()})
+		return 0, false
+	}
+	return claims.UserID, true
+}
@@ -1,6 +1,7 @@
 package api
 
 import (
+	"errors"
 	"fmt"
 	"net/http"
 	"path/filepath"
@@ -11,17 +12,20 @@ import (
 	"ai-doc-system/internal/models"
 	"ai-doc-system/internal/services"
 	"ai-doc-system/internal/auth"
+	"ai-doc-system/internal/utils"
 )
 
 type FileHandler struct {
-	fileService *services.FileService
-	jwtSecret   string
+	fileService       *services.FileService
+	authenticator     *auth.Authenticator
+	fileAccessService *services.FileAccessService
 }
 
-func NewFileHandler(fileService *services.FileService, jwtSecret string) *FileHandler {
+func NewFileHandler(fileService *services.FileService, authenticator *auth.Authenticator, fileAccessService *services.FileAccessService) *FileHandler {
 	return &FileHandler{
-		fileService: fileService,
-		jwtSecret:   jwtSecret,
+		fileService:       fileService,
+		authenticator:     authenticator,
+		fileAccessService: fileAccessService,
 	}
 }
 
@@ -38,7 +42,7 @@ func (h *FileHandler) UploadFile(c *gin.Context) {
 		return
 	}
 	
-	uploadedFile, err := h.fileService.UploadFile(userID.(int), file)
+	uploadedFile, err := h.fileService.UploadFileToFolder(userID.(int), file, c.PostForm("folder"))
 	if err != nil {
 		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
 		return
@@ -53,13 +57,63 @@ func (h *FileHandler) UploadFile(c *gin.Context) {
 func (h *FileHandler) GetUserFiles(c *gin.Context) {
 	userID, _ := c.Get("user_id")
 	
-	files, err := h.fileService.GetUserFiles(userID.(int))
+	opts, err := fileListOptions(c)
 	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	opts.UserID = userID.(int)
+	
+	page, err := h.fileService.ListFiles(opts)
+	if err != nil {
+		if err == utils.ErrInvalidCursor {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get files"})
 		return
 	}
 	
-	c.JSON(http.StatusOK, gin.H{"files": files})
+	c.JSON(http.StatusOK, page)
+}
+
+// Parse file listing parameters: sort, order, mime_type, from, to, name_prefix, before, after, limit
+func fileListOptions(c *gin.Context) (services.FileListOptions, error) {
+	opts := services.FileListOptions{
+		Sort:       c.Query("sort"),
+		Order:      c.Query("order"),
+		MimeType:   c.Query("mime_type"),
+		NamePrefix: c.Query("name_prefix"),
+		Before:     c.Query("before"),
+		After:      c.Query("after"),
+	}
+	
+	switch opts.Sort {
+	case "", "created_at", "updated_at", "name", "size":
+	default:
+		return opts, errors.New("sort must be one of created_at, updated_at, name, size")
+	}
+	if opts.Order != "" && opts.Order != "asc" && opts.Order != "desc" {
+		return opts, errors.New("order must be asc or desc")
+	}
+	if opts.Before != "" && opts.After != "" {
+		return opts, errors.New("only one of before and after may be given")
+	}
+	
+	var err error
+	if opts.From, err = parseDateQuery(c.Query("from"), false); err != nil {
+		return opts, errors.New("invalid from date")
+	}
+	if opts.To, err = parseDateQuery(c.Query("to"), true); err != nil {
+		return opts, errors.New("invalid to date")
+	}
+	
+	opts.Limit, err = strconv.Atoi(c.DefaultQuery("limit", "50"))
+	if err != nil || opts.Limit <= 0 || opts.Limit > 200 {
+		opts.Limit = 50
+	}
+	
+	return opts, nil
 }
 
 func (h *FileHandler) GetFile(c *gin.Context) {
@@ -80,66 +134,26 @@ func (h *FileHandler) GetFile(c *gin.Context) {
 }
 
 func (h *FileHandler) DownloadFile(c *gin.Context) {
-	fmt.Printf("DownloadFile called for path: %s\n", c.Request.URL.Path)
-	fmt.Printf("Authorization header: %s\n", c.GetHeader("Authorization"))
-	
 	fileIDStr := c.Param("id")
 	fileID, err := strconv.Atoi(fileIDStr)
 	if err != nil {
-		fmt.Printf("File ID parameter: %s\n", fileIDStr)
 		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid file ID"})
 		return
 	}
-	fmt.Printf("File ID parameter: %d\n", fileID)
 
-	// Check authentication - either from header or query parameter
-	var authenticated bool
-	var userID interface{}
-	
-	// First try to get user_id from context (set by middleware)
-	if uid, exists := c.Get("user_id"); exists {
-		authenticated = true
-		userID = uid
-		fmt.Printf("User ID from context: %v\n", userID)
-	} else {
-		// If not authenticated via middleware, try token from query parameter
-		tokenFromQuery := c.Query("token")
-		if tokenFromQuery != "" {
-			fmt.Printf("Trying token from query: %s\n", tokenFromQuery)
-			// Validate token from query parameter
-			claims, err := auth.ValidateToken(tokenFromQuery, h.jwtSecret)
-			if err == nil {
-				authenticated = true
-				userID = claims.UserID
-				fmt.Printf("Token validated, user ID: %v\n", userID)
-				// Set context for consistency
-				c.Set("user_id", claims.UserID)
-				c.Set("username", claims.Username)
-				c.Set("role", claims.Role)
-			} else {
-				fmt.Printf("Token validation failed: %v\n", err)
-			}
-		}
-	}
-	
-	if !authenticated {
-		fmt.Printf("User not authenticated\n")
-		c.JSON(http.StatusUnauthorized, gin.H{"error": "User not authenticated"})
+	userID, ok := fileRequestUser(c, h.authenticator, h.fileAccessService, fileID, auth.FileActionDownload)
+	if !ok {
 		return
 	}
 	
-	fmt.Printf("Getting file by ID: %d\n", fileID)
 	file, err := h.fileService.GetFileByID(fileID)
 	if err != nil {
-		fmt.Printf("File not found error: %v\n", err)
 		c.JSON(http.StatusNotFound, gin.H{"error": "File not found"})
 		return
 	}
 	
-	fmt.Printf("File found: %+v\n", file)
 	// Check if user owns the file
-	if file.UserID != userID.(int) {
-		fmt.Printf("Access denied - file.UserID: %d, userID: %d\n", file.UserID, userID.(int))
+	if file.UserID != userID {
 		c.JSON(http.StatusForbidden, gin.H{"error": "Access denied"})
 		return
 	}
@@ -208,28 +222,51 @@ func (h *FileHandler) RenameFile(c *gin.Context) {
 }
 
 func (h *FileHandler) GetAllFiles(c *gin.Context) {
-	files, err := h.fileService.GetAllFiles()
+	opts, err := fileListOptions(c)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	
+	// Optionally narrow down to one owner
+	if ownerStr := c.Query("user_id"); ownerStr != "" {
+		if opts.UserID, err = strconv.Atoi(ownerStr); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid user ID"})
+			return
+		}
+	}
+	
+	page, err := h.fileService.ListFiles(opts)
 	if err != nil {
+		if err == utils.ErrInvalidCursor {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get files"})
 		return
 	}
 	
-	c.JSON(http.StatusOK, gin.H{"files": files})
+	c.JSON(http.StatusOK, page)
 }
 
 func (h *FileHandler) GetStorageUsage(c *gin.Context) {
 	userID, _ := c.Get("user_id")
 	
-	usage, err := h.fileService.GetUserStorageUsage(userID.(int))
+	usage, limit, err := h.fileService.GetUserStorageUsage(userID.(int))
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get storage usage"})
 		return
 	}
 	
+	percentage := 100.0
+	if limit > 0 {
+		percentage = float64(usage) / float64(limit) * 100
+	}
+	
 	c.JSON(http.StatusOK, gin.H{
 		"used":  usage,
-		"limit": 100 * 1024 * 1024, // 100MB
-		"percentage": float64(usage) / (100 * 1024 * 1024) * 100,
+		"limit": limit,
+		"percentage": percentage,
 	})
 }
 
@@ -242,30 +279,8 @@ func (h *FileHandler) EditFile(c *gin.Context) {
 		return
 	}
 	
-	// Check authentication - either from header or query parameter
-	var authenticated bool
-	
-	// First try to get user_id from context (set by middleware)
-	if _, exists := c.Get("user_id"); exists {
-		authenticated = true
-	} else {
-		// If not authenticated via middleware, try token from query parameter
-		tokenFromQuery := c.Query("token")
-		if tokenFromQuery != "" {
-			// Validate token from query parameter
-			claims, err := auth.ValidateToken(tokenFromQuery, h.jwtSecret)
-			if err == nil {
-				authenticated = true
-				// Set context for consistency
-				c.Set("user_id", claims.UserID)
-				c.Set("username", claims.Username)
-				c.Set("role", claims.Role)
-			}
-		}
-	}
-	
-	if !authenticated {
-		c.JSON(http.StatusUnauthorized, gin.H{"error": "Authentication required"})
+	userID, ok := fileRequestUser(c, h.authenticator, h.fileAccessService, fileID, auth.FileActionEdit)
+	if !ok {
 		return
 	}
 	
@@ -275,6 +290,11 @@ func (h *FileHandler) EditFile(c *gin.Context) {
 		return
 	}
 	
+	if file.UserID != userID {
+		c.JSON(http.StatusForbidden, gin.H{"error": "Access denied"})
+		return
+	}
+	
 	// Check if file type supports online editing
 	if !isSupportedFileType(file.MimeType) {
 		c.JSON(http.StatusBadRequest, gin.H{"error": "File type not supported for online editing"})
@@ -284,8 +304,16 @@ func (h *FileHandler) EditFile(c *gin.Context) {
 	// Return appropriate editor based on file type
 	editorType := getEditorType(file.MimeType)
 	
+	// The page loads its OnlyOffice configuration with a single-use token of its own,
+	// the token in the page URL may already have been redeemed
+	configToken, _, err := h.fileAccessService.IssueToken(fileID, userID, auth.FileActionEdit, true, services.DefaultFileTokenTTL)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to open editor"})
+		return
+	}
+	
 	// Build editor HTML page
-	editorHTML := generateEditorHTML(file, editorType)
+	editorHTML := generateEditorHTML(file, editorType, configToken)
 	
 	c.Header("Content-Type", "text/html; charset=utf-8")
 	c.String(http.StatusOK, editorHTML)
@@ -300,11 +328,20 @@ func (h *FileHandler) PreviewFile(c *gin.Context) {
 		return
 	}
 	
+	userID, ok := fileRequestUser(c, h.authenticator, h.fileAccessService, fileID, auth.FileActionPreview)
+	if !ok {
+		return
+	}
+	
 	file, err := h.fileService.GetFileByID(fileID)
 	if err != nil {
 		c.JSON(http.StatusNotFound, gin.H{"error": "File not found"})
 		return
 	}
+	if file.UserID != userID {
+		c.JSON(http.StatusForbidden, gin.H{"error": "Access denied"})
+		return
+	}
 	
 	// For PDF files, return file content directly
 	if file.MimeType == "application/pdf" {
@@ -363,7 +400,7 @@ func getEditorType(mimeType string) string {
 }
 
 // Generate editor HTML page
-func generateEditorHTML(file *models.File, editorType string) string {
+func generateEditorHTML(file *models.File, editorType, configToken string) string {
 	return fmt.Sprintf(`
 <!DOCTYPE html>
 <html lang="en">
@@ -381,23 +418,12 @@ func generateEditorHTML(file *models.File, editorType string) string {
 <body>
     <div id="onlyoffice-editor"></div>
     <script>
-        function getTokenFromURL() {
-            const urlParams = new URLSearchParams(window.location.search);
-            return urlParams.get('token');
-        }
-
         async function initEditor() {
-            const token = getTokenFromURL();
-            if (!token) {
-                document.body.innerHTML = '<div style="display:flex;align-items:center;justify-content:center;height:100vh;color:#d32f2f;">Authentication token not found</div>';
-                return;
-            }
+            const token = '%s';
             const fileId = window.location.pathname.split('/')[3];
 
             try {
-                const resp = await fetch('/api/files/' + fileId + '/onlyoffice/config', {
-                    headers: { 'Authorization': 'Bearer ' + token }
-                });
+                const resp = await fetch('/api/files/' + fileId + '/onlyoffice/config?token=' + encodeURIComponent(token));
                 if (!resp.ok) throw new Error('Failed to get OnlyOffice configuration');
                 const config = await resp.json();
 
@@ -416,7 +442,7 @@ func generateEditorHTML(file *models.File, editorType string) string {
     </script>
 </body>
 </html>
-`, file.Filename)
+`, file.Filename, configToken)
 }
 
 // Generate preview HTML page
@@ -0,0 +1,226 @@
+package api
+
+import (
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"ai-doc-system/internal/models"
+	"ai-doc-system/internal/services"
+)
+
+type FileRequestHandler struct {
+	fileRequestService   *services.FileRequestService
+	loginThrottleService *services.LoginThrottleService
+}
+
+func NewFileRequestHandler(fileRequestService *services.FileRequestService, loginThrottleService *services.LoginThrottleService) *FileRequestHandler {
+	return &FileRequestHandler{
+		fileRequestService:   fileRequestService,
+		loginThrottleService: loginThrottleService,
+	}
+}
+
+type CreateFileRequestRequest struct {
+	Title             string   `json:"title" binding:"required,min=1,max=255"`
+	Description       string   `json:"description"`
+	Folder            string   `json:"folder"`
+	Password          string   `json:"password"`
+	MaxFileSize       *int64   `json:"max_file_size"`      // Bytes per file
+	MaxFiles          *int     `json:"max_files"`          // Total number of uploads
+	AllowedExtensions []string `json:"allowed_extensions"` // e.g. ["pdf", "docx"]
+	ExpiresIn         *int     `json:"expires_in"`         // Expiration time (hours)
+}
+
+func (h *FileRequestHandler) CreateFileRequest(c *gin.Context) {
+	userID, _ := c.Get("user_id")
+
+	var req CreateFileRequestRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	if (req.MaxFileSize != nil && *req.MaxFileSize <= 0) || (req.MaxFiles != nil && *req.MaxFiles <= 0) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Limits must be positive"})
+		return
+	}
+
+	request := &models.FileRequest{
+		Title:             req.Title,
+		Description:       req.Description,
+		Folder:            req.Folder,
+		MaxFileSize:       req.MaxFileSize,
+		MaxFiles:          req.MaxFiles,
+		AllowedExtensions: req.AllowedExtensions,
+	}
+	if req.ExpiresIn != nil && *req.ExpiresIn > 0 {
+		expiry := time.Now().Add(time.Duration(*req.ExpiresIn) * time.Hour)
+		request.ExpiresAt = &expiry
+	}
+
+	fileRequest, err := h.fileRequestService.CreateFileRequest(userID.(int), request, req.Password)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusCreated, gin.H{
+		"message":      "File request created successfully",
+		"file_request": fileRequest,
+	})
+}
+
+func (h *FileRequestHandler) GetFileRequests(c *gin.Context) {
+	userID, _ := c.Get("user_id")
+
+	requests, err := h.fileRequestService.GetFileRequests(userID.(int))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get file requests"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"file_requests": requests})
+}
+
+func (h *FileRequestHandler) GetFileRequestUploads(c *gin.Context) {
+	userID, _ := c.Get("user_id")
+	requestIDStr := c.Param("id")
+	requestID, err := strconv.Atoi(requestIDStr)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid file request ID"})
+		return
+	}
+
+	uploads, err := h.fileRequestService.GetFileRequestUploads(requestID, userID.(int))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"uploads": uploads})
+}
+
+func (h *FileRequestHandler) CloseFileRequest(c *gin.Context) {
+	userID, _ := c.Get("user_id")
+	requestIDStr := c.Param("id")
+	requestID, err := strconv.Atoi(requestIDStr)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid file request ID"})
+		return
+	}
+
+	err = h.fileRequestService.CloseFileRequest(requestID, userID.(int))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "File request closed successfully"})
+}
+
+func (h *FileRequestHandler) DeleteFileRequest(c *gin.Context) {
+	userID, _ := c.Get("user_id")
+	requestIDStr := c.Param("id")
+	requestID, err := strconv.Atoi(requestIDStr)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid file request ID"})
+		return
+	}
+
+	err = h.fileRequestService.DeleteFileRequest(requestID, userID.(int))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "File request deleted successfully"})
+}
+
+// GetPublicFileRequest describes an upload link to anonymous visitors without exposing any existing files
+func (h *FileRequestHandler) GetPublicFileRequest(c *gin.Context) {
+	request, err := h.fileRequestService.GetActiveFileRequest(c.Param("token"))
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "File request not found or expired"})
+		return
+	}
+
+	remaining := -1
+	if request.MaxFiles != nil {
+		remaining = *request.MaxFiles - request.UploadCount
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"file_request": gin.H{
+			"title":              request.Title,
+			"description":        request.Description,
+			"has_password":       request.HasPassword,
+			"max_file_size":      request.MaxFileSize,
+			"remaining_uploads":  remaining,
+			"allowed_extensions": request.AllowedExtensions,
+			"expires_at":         request.ExpiresAt,
+		},
+	})
+}
+
+// UploadToFileRequest accepts an anonymous upload into the owner's folder
+func (h *FileRequestHandler) UploadToFileRequest(c *gin.Context) {
+	file, err := c.FormFile("file")
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "No file uploaded"})
+		return
+	}
+
+	request, err := h.fileRequestService.GetActiveFileRequest(c.Param("token"))
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "File request not found or expired"})
+		return
+	}
+
+	// Password guesses are throttled per request and per address like logins
+	if request.HasPassword {
+		wait, err := h.loginThrottleService.CheckFileRequest(request.ID, c.ClientIP())
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to upload file"})
+			return
+		}
+		if wait > 0 {
+			c.Header("Retry-After", strconv.Itoa(int(wait.Seconds())))
+			c.JSON(http.StatusTooManyRequests, gin.H{
+				"error":       "Too many wrong passwords, please try again later",
+				"retry_after": int(wait.Seconds()),
+			})
+			return
+		}
+	}
+
+	uploadedFile, err := h.fileRequestService.UploadToFileRequest(c.Param("token"), c.PostForm("password"),
+		file, c.PostForm("uploader_name"), c.ClientIP())
+	if err == services.ErrInvalidFileRequestPassword {
+		if err := h.loginThrottleService.RecordFileRequestFailure(request.ID, c.ClientIP()); err != nil {
+			log.Printf("Failed to record wrong file request password: %v", err)
+		}
+		c.JSON(http.StatusUnauthorized, gin.H{"error": err.Error()})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if request.HasPassword {
+		if err := h.loginThrottleService.RecordFileRequestSuccess(request.ID); err != nil {
+			log.Printf("Failed to reset wrong file request passwords: %v", err)
+		}
+	}
+
+	// Only echo back what the visitor sent, never the owner's file metadata
+	c.JSON(http.StatusCreated, gin.H{
+		"message": "File uploaded successfully",
+		"file": gin.H{
+			"name": uploadedFile.OriginalName,
+			"size": uploadedFile.FileSize,
+		},
+	})
+}
@@ -0,0 +1,169 @@
+package api
+
+import (
+	"net/http"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+	"ai-doc-system/internal/auth"
+	"ai-doc-system/internal/services"
+)
+
+type FileTransferHandler struct {
+	fileTransferService *services.FileTransferService
+	roleService         *services.RoleService
+	auditService        *services.AuditService
+}
+
+func NewFileTransferHandler(fileTransferService *services.FileTransferService, roleService *services.RoleService, auditService *services.AuditService) *FileTransferHandler {
+	return &FileTransferHandler{
+		fileTransferService: fileTransferService,
+		roleService:         roleService,
+		auditService:        auditService,
+	}
+}
+
+type OfferTransferRequest struct {
+	FileID     *int    `json:"file_id"`
+	Folder     *string `json:"folder"`
+	FromUserID int     `json:"from_user_id"` // files:transfer_all permission only, owner of the folder to transfer
+	ToUserID   int     `json:"to_user_id" binding:"required"`
+}
+
+type BulkTransferRequest struct {
+	ToUserID int `json:"to_user_id" binding:"required"`
+}
+
+func (h *FileTransferHandler) OfferTransfer(c *gin.Context) {
+	userID, _ := c.Get("user_id")
+
+	var req OfferTransferRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	fromUserID := req.FromUserID
+	if fromUserID == 0 {
+		fromUserID = userID.(int)
+	}
+
+	transferAll, err := h.roleService.HasPermission(userID.(int), auth.PermFilesTransferAll)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to check permissions"})
+		return
+	}
+
+	transfer, err := h.fileTransferService.OfferTransfer(userID.(int), transferAll, fromUserID,
+		req.FileID, req.Folder, req.ToUserID)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusCreated, gin.H{
+		"message":  "Transfer offered successfully",
+		"transfer": transfer,
+	})
+}
+
+func (h *FileTransferHandler) GetTransfers(c *gin.Context) {
+	userID, _ := c.Get("user_id")
+
+	transfers, err := h.fileTransferService.GetTransfers(userID.(int))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get transfers"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"transfers": transfers})
+}
+
+func (h *FileTransferHandler) AcceptTransfer(c *gin.Context) {
+	userID, _ := c.Get("user_id")
+	transferIDStr := c.Param("id")
+	transferID, err := strconv.Atoi(transferIDStr)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid transfer ID"})
+		return
+	}
+
+	transfer, err := h.fileTransferService.AcceptTransfer(transferID, userID.(int))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"message":  "Transfer accepted",
+		"transfer": transfer,
+	})
+}
+
+func (h *FileTransferHandler) DeclineTransfer(c *gin.Context) {
+	userID, _ := c.Get("user_id")
+	transferIDStr := c.Param("id")
+	transferID, err := strconv.Atoi(transferIDStr)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid transfer ID"})
+		return
+	}
+
+	err = h.fileTransferService.DeclineTransfer(transferID, userID.(int))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Transfer declined"})
+}
+
+func (h *FileTransferHandler) CancelTransfer(c *gin.Context) {
+	userID, _ := c.Get("user_id")
+	transferIDStr := c.Param("id")
+	transferID, err := strconv.Atoi(transferIDStr)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid transfer ID"})
+		return
+	}
+
+	err = h.fileTransferService.CancelTransfer(transferID, userID.(int))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Transfer cancelled"})
+}
+
+func (h *FileTransferHandler) BulkTransfer(c *gin.Context) {
+	adminID, _ := c.Get("user_id")
+	fromUserIDStr := c.Param("id")
+	fromUserID, err := strconv.Atoi(fromUserIDStr)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid user ID"})
+		return
+	}
+
+	var req BulkTransferRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	transfer, err := h.fileTransferService.BulkTransfer(adminID.(int), fromUserID, req.ToUserID)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	recordAudit(c, h.auditService, "files.bulk_transfer", "user", strconv.Itoa(fromUserID), gin.H{
+		"to_user_id": req.ToUserID,
+		"file_count": transfer.FileCount,
+	})
+
+	c.JSON(http.StatusOK, gin.H{
+		"message":  "Files transferred successfully",
+		"transfer": transfer,
+	})
+}
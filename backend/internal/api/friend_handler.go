@@ -28,7 +28,10 @@ type CreateGroupRequest struct {
 
 type AddToGroupRequest struct {
 	FriendID int `json:"friend_id" binding:"required"`
-	GroupID  int `json:"group_id" binding:"required"`
+}
+
+type ReorderGroupsRequest struct {
+	GroupIDs []int `json:"group_ids" binding:"required"` // Every group of the user, in the new order
 }
 
 func (h *FriendHandler) SendFriendRequest(c *gin.Context) {
@@ -112,7 +115,17 @@ func (h *FriendHandler) GetFriends(c *gin.Context) {
 		return
 	}
 	
-	c.JSON(http.StatusOK, gin.H{"friends": friends})
+	groups, ungrouped, err := h.friendService.GetGroupedFriends(userID.(int))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get friends"})
+		return
+	}
+	
+	c.JSON(http.StatusOK, gin.H{
+		"friends":   friends,
+		"groups":    groups,
+		"ungrouped": ungrouped,
+	})
 }
 
 func (h *FriendHandler) GetPendingRequests(c *gin.Context) {
@@ -145,6 +158,88 @@ func (h *FriendHandler) SearchUsers(c *gin.Context) {
 	c.JSON(http.StatusOK, gin.H{"users": users})
 }
 
+func (h *FriendHandler) GetSuggestions(c *gin.Context) {
+	userID, _ := c.Get("user_id")
+	limit, err := strconv.Atoi(c.DefaultQuery("limit", "10"))
+	if err != nil || limit <= 0 || limit > 50 {
+		limit = 10
+	}
+	
+	suggestions, err := h.friendService.GetSuggestions(userID.(int), limit)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get friend suggestions"})
+		return
+	}
+	
+	c.JSON(http.StatusOK, gin.H{"suggestions": suggestions})
+}
+
+func (h *FriendHandler) DismissSuggestion(c *gin.Context) {
+	userID, _ := c.Get("user_id")
+	suggestedIDStr := c.Param("id")
+	suggestedID, err := strconv.Atoi(suggestedIDStr)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid user ID"})
+		return
+	}
+	
+	err = h.friendService.DismissSuggestion(userID.(int), suggestedID)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	
+	c.JSON(http.StatusOK, gin.H{"message": "Suggestion dismissed"})
+}
+
+func (h *FriendHandler) BlockUser(c *gin.Context) {
+	userID, _ := c.Get("user_id")
+	blockedIDStr := c.Param("id")
+	blockedID, err := strconv.Atoi(blockedIDStr)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid user ID"})
+		return
+	}
+	
+	err = h.friendService.BlockUser(userID.(int), blockedID)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	
+	c.JSON(http.StatusOK, gin.H{"message": "User blocked successfully"})
+}
+
+func (h *FriendHandler) UnblockUser(c *gin.Context) {
+	userID, _ := c.Get("user_id")
+	blockedIDStr := c.Param("id")
+	blockedID, err := strconv.Atoi(blockedIDStr)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid user ID"})
+		return
+	}
+	
+	err = h.friendService.UnblockUser(userID.(int), blockedID)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	
+	c.JSON(http.StatusOK, gin.H{"message": "User unblocked successfully"})
+}
+
+func (h *FriendHandler) GetBlockedUsers(c *gin.Context) {
+	userID, _ := c.Get("user_id")
+	
+	users, err := h.friendService.GetBlockedUsers(userID.(int))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get blocked users"})
+		return
+	}
+	
+	c.JSON(http.StatusOK, gin.H{"users": users})
+}
+
 func (h *FriendHandler) CreateFriendGroup(c *gin.Context) {
 	userID, _ := c.Get("user_id")
 	
@@ -178,8 +273,71 @@ func (h *FriendHandler) GetFriendGroups(c *gin.Context) {
 	c.JSON(http.StatusOK, gin.H{"groups": groups})
 }
 
+func (h *FriendHandler) RenameFriendGroup(c *gin.Context) {
+	userID, _ := c.Get("user_id")
+	groupID, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid group ID"})
+		return
+	}
+	
+	var req CreateGroupRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	
+	err = h.friendService.RenameFriendGroup(userID.(int), groupID, req.Name)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	
+	c.JSON(http.StatusOK, gin.H{"message": "Friend group renamed successfully"})
+}
+
+func (h *FriendHandler) DeleteFriendGroup(c *gin.Context) {
+	userID, _ := c.Get("user_id")
+	groupID, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid group ID"})
+		return
+	}
+	
+	err = h.friendService.DeleteFriendGroup(userID.(int), groupID)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	
+	c.JSON(http.StatusOK, gin.H{"message": "Friend group deleted successfully"})
+}
+
+func (h *FriendHandler) ReorderFriendGroups(c *gin.Context) {
+	userID, _ := c.Get("user_id")
+	
+	var req ReorderGroupsRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	
+	err := h.friendService.ReorderFriendGroups(userID.(int), req.GroupIDs)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	
+	c.JSON(http.StatusOK, gin.H{"message": "Friend groups reordered successfully"})
+}
+
 func (h *FriendHandler) AddFriendToGroup(c *gin.Context) {
 	userID, _ := c.Get("user_id")
+	groupID, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid group ID"})
+		return
+	}
 	
 	var req AddToGroupRequest
 	if err := c.ShouldBindJSON(&req); err != nil {
@@ -187,11 +345,33 @@ func (h *FriendHandler) AddFriendToGroup(c *gin.Context) {
 		return
 	}
 	
-	err := h.friendService.AddFriendToGroup(userID.(int), req.FriendID, req.GroupID)
+	err = h.friendService.AddFriendToGroup(userID.(int), req.FriendID, groupID)
 	if err != nil {
 		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
 		return
 	}
 	
 	c.JSON(http.StatusOK, gin.H{"message": "Friend added to group successfully"})
-}
\ No newline at end of file
+}
+
+func (h *FriendHandler) RemoveFriendFromGroup(c *gin.Context) {
+	userID, _ := c.Get("user_id")
+	groupID, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid group ID"})
+		return
+	}
+	friendID, err := strconv.Atoi(c.Param("friend_id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid friend ID"})
+		return
+	}
+	
+	err = h.friendService.RemoveFriendFromGroup(userID.(int), friendID, groupID)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	
+	c.JSON(http.StatusOK, gin.H{"message": "Friend removed from group successfully"})
+}
@@ -0,0 +1,100 @@
+package api
+
+import (
+	"log"
+	"net/http"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+	"ai-doc-system/internal/services"
+)
+
+type LockoutHandler struct {
+	loginThrottleService *services.LoginThrottleService
+	auditService         *services.AuditService
+}
+
+func NewLockoutHandler(loginThrottleService *services.LoginThrottleService, auditService *services.AuditService) *LockoutHandler {
+	return &LockoutHandler{
+		loginThrottleService: loginThrottleService,
+		auditService:         auditService,
+	}
+}
+
+// Refuse a login step while the username or the client's address is locked out, reports
+// whether it was refused. The message is the same for both and for unknown usernames.
+func refuseLockedOutLogin(c *gin.Context, loginThrottleService *services.LoginThrottleService, username string) bool {
+	wait, err := loginThrottleService.Check(username, c.ClientIP())
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to log in"})
+		return true
+	}
+	if wait > 0 {
+		c.Header("Retry-After", strconv.Itoa(int(wait.Seconds())))
+		c.JSON(http.StatusTooManyRequests, gin.H{
+			"error":       "Too many failed login attempts, please try again later",
+			"retry_after": int(wait.Seconds()),
+		})
+		return true
+	}
+	return false
+}
+
+// Count a failed login step against the username and the client's address
+func recordFailedLogin(c *gin.Context, loginThrottleService *services.LoginThrottleService, username string) {
+	if err := loginThrottleService.RecordFailure(username, c.ClientIP()); err != nil {
+		log.Printf("Failed to record failed login: %v", err)
+	}
+}
+
+// Clear the username's failures once the whole login, including the second factor, succeeded
+func recordSuccessfulLogin(loginThrottleService *services.LoginThrottleService, username string) {
+	if err := loginThrottleService.RecordSuccess(username); err != nil {
+		log.Printf("Failed to reset failed logins: %v", err)
+	}
+}
+
+// Usernames and addresses currently locked out after failed logins
+func (h *LockoutHandler) GetLockouts(c *gin.Context) {
+	lockouts, err := h.loginThrottleService.GetLockouts()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get lockouts"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"lockouts": lockouts})
+}
+
+func (h *LockoutHandler) Unlock(c *gin.Context) {
+	lockoutID, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid lockout ID"})
+		return
+	}
+
+	lockout, err := h.loginThrottleService.Unlock(lockoutID)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	recordAudit(c, h.auditService, "login.unlock", lockout.Scope, lockout.Identifier, gin.H{"failures": lockout.Failures})
+	c.JSON(http.StatusOK, gin.H{"message": "Lockout lifted successfully"})
+}
+
+// Lift the lockout of a user's account
+func (h *LockoutHandler) UnlockUser(c *gin.Context) {
+	userID, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid user ID"})
+		return
+	}
+
+	if err := h.loginThrottleService.UnlockUser(userID); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	recordAudit(c, h.auditService, "login.unlock", "user", strconv.Itoa(userID), nil)
+	c.JSON(http.StatusOK, gin.H{"message": "Account unlocked successfully"})
+}
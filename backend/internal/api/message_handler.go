@@ -1,11 +1,17 @@
 package api
 
 import (
+	"errors"
 	"net/http"
 	"strconv"
+	"strings"
+	"time"
 	
 	"github.com/gin-gonic/gin"
+	"ai-doc-system/internal/auth"
+	"ai-doc-system/internal/models"
 	"ai-doc-system/internal/services"
+	"ai-doc-system/internal/utils"
 )
 
 type MessageHandler struct {
@@ -19,8 +25,19 @@ func NewMessageHandler(messageService *services.MessageService) *MessageHandler
 }
 
 type SendMessageRequest struct {
-	ToUserID int    `json:"to_user_id" binding:"required"`
-	Content  string `json:"content" binding:"required,min=1,max=1000"`
+	ToUserID       int    `json:"to_user_id"`      // Direct message to a friend
+	ConversationID int    `json:"conversation_id"` // Or message to a conversation
+	Content        string `json:"content" binding:"max=1000"`
+	FileID         int    `json:"file_id"` // Send one of the user's files, content becomes an optional caption
+	ReplyToID      *int   `json:"reply_to_id"` // Parent message in the same conversation
+}
+
+type EditMessageRequest struct {
+	Content string `json:"content" binding:"required,min=1,max=1000"`
+}
+
+type ToggleReactionRequest struct {
+	Emoji string `json:"emoji" binding:"required,min=1,max=32"`
 }
 
 func (h *MessageHandler) SendMessage(c *gin.Context) {
@@ -32,7 +49,33 @@ func (h *MessageHandler) SendMessage(c *gin.Context) {
 		return
 	}
 	
-	message, err := h.messageService.SendMessage(userID.(int), req.ToUserID, req.Content)
+	if (req.ToUserID == 0) == (req.ConversationID == 0) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Either to_user_id or conversation_id is required"})
+		return
+	}
+	if req.FileID == 0 && req.Content == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Content is required"})
+		return
+	}
+	
+	// Sending a file shares it with the receivers
+	if req.FileID != 0 && !auth.HasScope(c, auth.ScopeShares) {
+		c.JSON(http.StatusForbidden, gin.H{"error": "Token requires the " + auth.ScopeShares + " scope"})
+		return
+	}
+	
+	var message *models.Message
+	var err error
+	switch {
+	case req.FileID != 0 && req.ConversationID != 0:
+		message, err = h.messageService.SendConversationFile(req.ConversationID, userID.(int), req.FileID, req.Content, req.ReplyToID)
+	case req.FileID != 0:
+		message, err = h.messageService.SendFileMessage(userID.(int), req.ToUserID, req.FileID, req.Content, req.ReplyToID)
+	case req.ConversationID != 0:
+		message, err = h.messageService.SendConversationMessage(req.ConversationID, userID.(int), req.Content, req.ReplyToID)
+	default:
+		message, err = h.messageService.SendMessage(userID.(int), req.ToUserID, req.Content, req.ReplyToID)
+	}
 	if err != nil {
 		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
 		return
@@ -53,27 +96,147 @@ func (h *MessageHandler) GetChatHistory(c *gin.Context) {
 		return
 	}
 	
-	// Get pagination parameters
-	limitStr := c.DefaultQuery("limit", "20")
-	offsetStr := c.DefaultQuery("offset", "0")
+	opts, err := historyOptions(c)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	
+	page, err := h.messageService.GetChatHistory(userID.(int), friendID, opts)
+	if err != nil {
+		historyError(c, err)
+		return
+	}
 	
-	limit, err := strconv.Atoi(limitStr)
+	c.JSON(http.StatusOK, page)
+}
+
+// Respond to a failed history request: bad cursors and unknown conversations or messages are
+// the client's, anything else is ours
+func historyError(c *gin.Context, err error) {
+	switch err {
+	case utils.ErrInvalidCursor:
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+	case services.ErrConversationNotFound, services.ErrMessageNotFound:
+		c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+	default:
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get chat history"})
+	}
+}
+
+// Parse history pagination parameters: limit, and at most one of before, after or around_message_id
+func historyOptions(c *gin.Context) (services.HistoryOptions, error) {
+	opts := services.HistoryOptions{
+		Before: c.Query("before"),
+		After:  c.Query("after"),
+	}
+	
+	limit, err := strconv.Atoi(c.DefaultQuery("limit", "20"))
 	if err != nil || limit <= 0 || limit > 100 {
 		limit = 20
 	}
+	opts.Limit = limit
+	
+	// Jump to the page around a message, e.g. a search hit
+	if aroundStr := c.Query("around_message_id"); aroundStr != "" {
+		opts.AroundMessageID, err = strconv.Atoi(aroundStr)
+		if err != nil || opts.AroundMessageID <= 0 {
+			return opts, errors.New("invalid message ID")
+		}
+	}
+	
+	set := 0
+	for _, given := range []bool{opts.Before != "", opts.After != "", opts.AroundMessageID != 0} {
+		if given {
+			set++
+		}
+	}
+	if set > 1 {
+		return opts, errors.New("only one of before, after and around_message_id may be given")
+	}
+	
+	return opts, nil
+}
+
+// Parse a date (2006-01-02) or RFC3339 timestamp query parameter.
+// A plain end date includes the whole day.
+func parseDateQuery(value string, endOfRange bool) (*time.Time, error) {
+	if value == "" {
+		return nil, nil
+	}
+	if t, err := time.Parse(time.RFC3339, value); err == nil {
+		return &t, nil
+	}
+	t, err := time.Parse("2006-01-02", value)
+	if err != nil {
+		return nil, err
+	}
+	if endOfRange {
+		t = t.AddDate(0, 0, 1)
+	}
+	return &t, nil
+}
+
+func (h *MessageHandler) SearchMessages(c *gin.Context) {
+	userID, _ := c.Get("user_id")
+	
+	query := c.Query("q")
+	if query == "" || len(query) > 200 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Search query must be 1 to 200 characters"})
+		return
+	}
+	
+	opts := services.MessageSearchOptions{
+		Query:       query,
+		MessageType: c.Query("type"),
+	}
 	
-	offset, err := strconv.Atoi(offsetStr)
-	if err != nil || offset < 0 {
-		offset = 0
+	var err error
+	if friendIDStr := c.Query("friend_id"); friendIDStr != "" {
+		if opts.FriendID, err = strconv.Atoi(friendIDStr); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid friend ID"})
+			return
+		}
+	}
+	if conversationIDStr := c.Query("conversation_id"); conversationIDStr != "" {
+		if opts.ConversationID, err = strconv.Atoi(conversationIDStr); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid conversation ID"})
+			return
+		}
+	}
+	if opts.From, err = parseDateQuery(c.Query("from"), false); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid from date"})
+		return
+	}
+	if opts.To, err = parseDateQuery(c.Query("to"), true); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid to date"})
+		return
+	}
+	if opts.MessageType != "" && opts.MessageType != "text" && opts.MessageType != "file" && opts.MessageType != "system" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid message type"})
+		return
 	}
 	
-	messages, err := h.messageService.GetChatHistory(userID.(int), friendID, limit, offset)
+	opts.Limit, err = strconv.Atoi(c.DefaultQuery("limit", "20"))
+	if err != nil || opts.Limit <= 0 || opts.Limit > 50 {
+		opts.Limit = 20
+	}
+	opts.Offset, err = strconv.Atoi(c.DefaultQuery("offset", "0"))
+	if err != nil || opts.Offset < 0 {
+		opts.Offset = 0
+	}
+	opts.Context, err = strconv.Atoi(c.DefaultQuery("context", "2"))
+	if err != nil || opts.Context < 0 || opts.Context > 5 {
+		opts.Context = 2
+	}
+	
+	hits, err := h.messageService.SearchMessages(userID.(int), opts)
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get chat history"})
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to search messages"})
 		return
 	}
 	
-	c.JSON(http.StatusOK, gin.H{"messages": messages})
+	c.JSON(http.StatusOK, gin.H{"results": hits})
 }
 
 func (h *MessageHandler) GetChatList(c *gin.Context) {
@@ -88,22 +251,54 @@ func (h *MessageHandler) GetChatList(c *gin.Context) {
 	c.JSON(http.StatusOK, gin.H{"chats": chatList})
 }
 
+// MarkAsRead marks the conversation containing the message as read up to that message
 func (h *MessageHandler) MarkAsRead(c *gin.Context) {
 	userID, _ := c.Get("user_id")
-	fromUserIDStr := c.Param("from_user_id")
-	fromUserID, err := strconv.Atoi(fromUserIDStr)
+	messageIDStr := c.Param("id")
+	messageID, err := strconv.Atoi(messageIDStr)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid message ID"})
+		return
+	}
+	
+	count, err := h.messageService.MarkAsReadUpTo(userID.(int), messageID)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	
+	c.JSON(http.StatusOK, gin.H{
+		"message":      "Messages marked as read",
+		"marked_count": count,
+	})
+}
+
+// MarkConversationAsRead marks messages from a friend as read, optionally only up to ?up_to_message_id=
+func (h *MessageHandler) MarkConversationAsRead(c *gin.Context) {
+	userID, _ := c.Get("user_id")
+	friendIDStr := c.Param("friend_id")
+	friendID, err := strconv.Atoi(friendIDStr)
 	if err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid user ID"})
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid friend ID"})
+		return
+	}
+	
+	upToMessageID, err := strconv.Atoi(c.DefaultQuery("up_to_message_id", "0"))
+	if err != nil || upToMessageID < 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid message ID"})
 		return
 	}
 	
-	err = h.messageService.MarkMessagesAsRead(userID.(int), fromUserID)
+	count, err := h.messageService.MarkMessagesAsRead(userID.(int), friendID, upToMessageID)
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to mark messages as read"})
 		return
 	}
 	
-	c.JSON(http.StatusOK, gin.H{"message": "Messages marked as read"})
+	c.JSON(http.StatusOK, gin.H{
+		"message":      "Messages marked as read",
+		"marked_count": count,
+	})
 }
 
 func (h *MessageHandler) GetUnreadCount(c *gin.Context) {
@@ -115,7 +310,17 @@ func (h *MessageHandler) GetUnreadCount(c *gin.Context) {
 		return
 	}
 	
-	c.JSON(http.StatusOK, gin.H{"unread_count": count})
+	byConversation, byFriend, err := h.messageService.GetUnreadCountsByConversation(userID.(int))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get unread count"})
+		return
+	}
+	
+	c.JSON(http.StatusOK, gin.H{
+		"unread_count":    count,
+		"by_conversation": byConversation,
+		"by_friend":       byFriend,
+	})
 }
 
 func (h *MessageHandler) DeleteMessage(c *gin.Context) {
@@ -127,11 +332,71 @@ func (h *MessageHandler) DeleteMessage(c *gin.Context) {
 		return
 	}
 	
-	err = h.messageService.DeleteMessage(messageID, userID.(int))
+	// Optionally revoke the file shares created by sending a file
+	revokeShares := c.Query("revoke_share") == "true"
+	
+	err = h.messageService.DeleteMessage(messageID, userID.(int), revokeShares)
 	if err != nil {
 		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
 		return
 	}
 	
 	c.JSON(http.StatusOK, gin.H{"message": "Message deleted successfully"})
-}
\ No newline at end of file
+}
+
+func (h *MessageHandler) EditMessage(c *gin.Context) {
+	userID, _ := c.Get("user_id")
+	messageID, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid message ID"})
+		return
+	}
+	
+	var req EditMessageRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	
+	message, err := h.messageService.EditMessage(messageID, userID.(int), req.Content)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	
+	c.JSON(http.StatusOK, gin.H{
+		"message": "Message edited successfully",
+		"data":    message,
+	})
+}
+
+// Add the reaction, or remove it if the user already reacted with the same emoji
+func (h *MessageHandler) ToggleReaction(c *gin.Context) {
+	userID, _ := c.Get("user_id")
+	messageID, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid message ID"})
+		return
+	}
+	
+	var req ToggleReactionRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if strings.ContainsAny(req.Emoji, " \t\r\n") {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid emoji"})
+		return
+	}
+	
+	added, err := h.messageService.ToggleReaction(messageID, userID.(int), req.Emoji)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	
+	c.JSON(http.StatusOK, gin.H{
+		"message": "Reaction updated",
+		"added":   added,
+	})
+}
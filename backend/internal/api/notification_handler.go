@@ -0,0 +1,239 @@
+package api
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"ai-doc-system/internal/auth"
+	"ai-doc-system/internal/realtime"
+	"ai-doc-system/internal/services"
+)
+
+const sseHeartbeatInterval = 30 * time.Second
+
+type NotificationHandler struct {
+	notificationService *services.NotificationService
+	hub                 *realtime.Hub
+	authenticator       *auth.Authenticator
+	streamTicketService *services.StreamTicketService
+}
+
+func NewNotificationHandler(notificationService *services.NotificationService, hub *realtime.Hub, authenticator *auth.Authenticator,
+	streamTicketService *services.StreamTicketService) *NotificationHandler {
+	return &NotificationHandler{
+		notificationService: notificationService,
+		hub:                 hub,
+		authenticator:       authenticator,
+		streamTicketService: streamTicketService,
+	}
+}
+
+type UpdateNotificationPreferencesRequest struct {
+	Preferences map[string]bool `json:"preferences" binding:"required"` // Type to enabled
+}
+
+func (h *NotificationHandler) GetNotifications(c *gin.Context) {
+	userID, _ := c.Get("user_id")
+	unreadOnly := c.Query("unread") == "true"
+
+	beforeID, err := strconv.Atoi(c.DefaultQuery("before_id", "0"))
+	if err != nil || beforeID < 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid notification ID"})
+		return
+	}
+	limit, err := strconv.Atoi(c.DefaultQuery("limit", "50"))
+	if err != nil || limit <= 0 || limit > 100 {
+		limit = 50
+	}
+
+	notifications, err := h.notificationService.GetNotifications(userID.(int), unreadOnly, beforeID, limit)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get notifications"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"notifications": notifications})
+}
+
+func (h *NotificationHandler) GetUnreadCount(c *gin.Context) {
+	userID, _ := c.Get("user_id")
+
+	count, err := h.notificationService.GetUnreadCount(userID.(int))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get unread count"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"unread_count": count})
+}
+
+func (h *NotificationHandler) MarkAsRead(c *gin.Context) {
+	userID, _ := c.Get("user_id")
+	notificationIDStr := c.Param("id")
+	notificationID, err := strconv.Atoi(notificationIDStr)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid notification ID"})
+		return
+	}
+
+	err = h.notificationService.MarkAsRead(notificationID, userID.(int))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Notification marked as read"})
+}
+
+func (h *NotificationHandler) MarkAllAsRead(c *gin.Context) {
+	userID, _ := c.Get("user_id")
+
+	count, err := h.notificationService.MarkAllAsRead(userID.(int))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to mark notifications as read"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"message":      "Notifications marked as read",
+		"marked_count": count,
+	})
+}
+
+func (h *NotificationHandler) DeleteNotification(c *gin.Context) {
+	userID, _ := c.Get("user_id")
+	notificationID, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid notification ID"})
+		return
+	}
+
+	err = h.notificationService.DeleteNotification(notificationID, userID.(int))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Notification deleted successfully"})
+}
+
+// Clear all notifications, or only the read ones with ?read=true
+func (h *NotificationHandler) ClearNotifications(c *gin.Context) {
+	userID, _ := c.Get("user_id")
+	readOnly := c.Query("read") == "true"
+
+	count, err := h.notificationService.ClearNotifications(userID.(int), readOnly)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to clear notifications"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"message":       "Notifications cleared",
+		"deleted_count": count,
+	})
+}
+
+func (h *NotificationHandler) GetPreferences(c *gin.Context) {
+	userID, _ := c.Get("user_id")
+
+	preferences, err := h.notificationService.GetPreferences(userID.(int))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get notification preferences"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"preferences": preferences})
+}
+
+func (h *NotificationHandler) UpdatePreferences(c *gin.Context) {
+	userID, _ := c.Get("user_id")
+
+	var req UpdateNotificationPreferencesRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	err := h.notificationService.UpdatePreferences(userID.(int), req.Preferences)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Notification preferences updated"})
+}
+
+// Stream delivers notification events as server-sent events, opened with a stream ticket
+// as ?ticket= since EventSource cannot set headers. Tickets are single-use, so clients open
+// a new stream with a new ticket instead of letting EventSource reconnect. Missed events are
+// replayed from Last-Event-ID (or ?since=).
+func (h *NotificationHandler) Stream(c *gin.Context) {
+	userID, sessionID, err := streamUser(c, h.authenticator, h.streamTicketService)
+	if err != nil {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid token"})
+		return
+	}
+
+	// Subscribe before reading the backlog so no event slips in between
+	sub := h.hub.Subscribe(userID, sessionID)
+	defer h.hub.Unsubscribe(userID, sub)
+
+	lastEventID := c.GetHeader("Last-Event-ID")
+	if lastEventID == "" {
+		lastEventID = c.Query("since")
+	}
+	var pending []realtime.Event
+	if since, err := strconv.ParseInt(lastEventID, 10, 64); err == nil {
+		pending = h.hub.EventsSince(userID, since)
+	}
+
+	c.Header("Content-Type", "text/event-stream")
+	c.Header("Cache-Control", "no-cache")
+	c.Header("Connection", "keep-alive")
+	c.Header("X-Accel-Buffering", "no")
+	c.Status(http.StatusOK)
+	c.Writer.Flush()
+
+	ticker := time.NewTicker(sseHeartbeatInterval)
+	defer ticker.Stop()
+
+	c.Stream(func(w io.Writer) bool {
+		for _, event := range pending {
+			writeNotificationEvent(w, event)
+		}
+		pending = nil
+
+		select {
+		case event := <-sub.Events():
+			writeNotificationEvent(w, event)
+			return true
+		case <-sub.Done():
+			// The session was revoked or the account disabled
+			return false
+		case <-ticker.C:
+			// Comment line keeps proxies from closing an idle stream
+			io.WriteString(w, ": ping\n\n")
+			return true
+		case <-c.Request.Context().Done():
+			return false
+		}
+	})
+}
+
+// Write a notification event in SSE framing, other realtime events are skipped
+func writeNotificationEvent(w io.Writer, event realtime.Event) {
+	if !strings.HasPrefix(event.Type, "notification.") {
+		return
+	}
+	// Events without an ID cannot be replayed, Last-Event-ID stays at the previous one
+	if event.ID != 0 {
+		fmt.Fprintf(w, "id: %d\n", event.ID)
+	}
+	fmt.Fprintf(w, "event: %s\ndata: %s\n\n", event.Type, event.Data)
+}
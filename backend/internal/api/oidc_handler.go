@@ -0,0 +1,180 @@
+package api
+
+import (
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"ai-doc-system/internal/services"
+)
+
+type OIDCHandler struct {
+	oidcService      *services.OIDCService
+	userService      *services.UserService
+	sessionService   *services.SessionService
+	twoFactorService *services.TwoFactorService
+	appURL           string
+}
+
+func NewOIDCHandler(oidcService *services.OIDCService, userService *services.UserService, sessionService *services.SessionService, twoFactorService *services.TwoFactorService, appURL string) *OIDCHandler {
+	return &OIDCHandler{
+		oidcService:      oidcService,
+		userService:      userService,
+		sessionService:   sessionService,
+		twoFactorService: twoFactorService,
+		appURL:           strings.TrimRight(appURL, "/"),
+	}
+}
+
+// Holds the browser binding of a login in progress, only sent back to the callback
+const (
+	oidcStateCookie     = "oidc_state"
+	oidcStateCookiePath = "/api/auth/oidc/callback"
+)
+
+// Keep the login's browser binding until the identity provider redirects back. SameSite=Lax
+// still sends it with that top-level redirect.
+func (h *OIDCHandler) setStateCookie(c *gin.Context, binding string, maxAge int) {
+	c.SetSameSite(http.SameSiteLaxMode)
+	c.SetCookie(oidcStateCookie, binding, maxAge, oidcStateCookiePath, "", strings.HasPrefix(h.appURL, "https://"), true)
+}
+
+type SSOExchangeRequest struct {
+	Code string `json:"code" binding:"required"`
+}
+
+// Whether the login page offers single sign-on
+func (h *OIDCHandler) GetConfig(c *gin.Context) {
+	c.JSON(http.StatusOK, gin.H{"enabled": h.oidcService.Enabled()})
+}
+
+// Send the browser to the identity provider
+func (h *OIDCHandler) Login(c *gin.Context) {
+	authURL, binding, err := h.oidcService.StartLogin(c.Request.Context(), 0)
+	if err != nil {
+		c.Redirect(http.StatusFound, h.appURL+"/login?sso_error="+url.QueryEscape(err.Error()))
+		return
+	}
+	h.setStateCookie(c, binding, int(services.OIDCStateTTL.Seconds()))
+	c.Redirect(http.StatusFound, authURL)
+}
+
+// Start linking an identity to the logged-in user. Returns the URL instead of redirecting
+// since the request carries the session in its Authorization header; the browser keeps the
+// state cookie from this response, so only it can complete the link.
+func (h *OIDCHandler) StartLink(c *gin.Context) {
+	userID, _ := c.Get("user_id")
+
+	authURL, binding, err := h.oidcService.StartLogin(c.Request.Context(), userID.(int))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	h.setStateCookie(c, binding, int(services.OIDCStateTTL.Seconds()))
+
+	c.JSON(http.StatusOK, gin.H{"authorization_url": authURL})
+}
+
+// Redirect target of the identity provider. The frontend gets a short-lived handoff code in
+// the URL fragment, which never reaches server logs, and exchanges it for a session.
+func (h *OIDCHandler) Callback(c *gin.Context) {
+	if errorCode := c.Query("error"); errorCode != "" {
+		message := c.Query("error_description")
+		if message == "" {
+			message = errorCode
+		}
+		c.Redirect(http.StatusFound, h.appURL+"/login?sso_error="+url.QueryEscape(message))
+		return
+	}
+
+	binding, _ := c.Cookie(oidcStateCookie)
+	h.setStateCookie(c, "", -1)
+
+	result, err := h.oidcService.CompleteLogin(c.Request.Context(), c.Query("state"), binding, c.Query("code"))
+	if err != nil {
+		c.Redirect(http.StatusFound, h.appURL+"/login?sso_error="+url.QueryEscape(err.Error()))
+		return
+	}
+
+	if result.Linked {
+		c.Redirect(http.StatusFound, h.appURL+"/dashboard?sso_linked=1")
+		return
+	}
+	c.Redirect(http.StatusFound, h.appURL+"/login#sso_code="+url.QueryEscape(result.HandoffCode))
+}
+
+// Trade the handoff code for a session, answering like the password login so the
+// two-factor policy applies to single sign-on too
+func (h *OIDCHandler) Exchange(c *gin.Context) {
+	var req SSOExchangeRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	userID, err := h.oidcService.ExchangeHandoff(req.Code)
+	if err != nil {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": err.Error()})
+		return
+	}
+
+	user, err := h.userService.GetUserByID(userID)
+	if err != nil {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "User not found"})
+		return
+	}
+
+	challenge, err := h.twoFactorService.StartLogin(user)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to log in"})
+		return
+	}
+	if challenge != nil {
+		c.JSON(http.StatusOK, challenge)
+		return
+	}
+
+	tokens, err := h.sessionService.CreateSession(user, c.Request.UserAgent(), c.ClientIP())
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to generate token"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"user":          user,
+		"token":         tokens.AccessToken,
+		"refresh_token": tokens.RefreshToken,
+		"expires_in":    tokens.ExpiresIn,
+	})
+}
+
+// Identities linked to the current user
+func (h *OIDCHandler) GetIdentities(c *gin.Context) {
+	userID, _ := c.Get("user_id")
+
+	identities, err := h.oidcService.GetIdentities(userID.(int))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get identities"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"identities": identities, "sso_enabled": h.oidcService.Enabled()})
+}
+
+func (h *OIDCHandler) UnlinkIdentity(c *gin.Context) {
+	userID, _ := c.Get("user_id")
+	identityID, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid identity ID"})
+		return
+	}
+
+	if err := h.oidcService.UnlinkIdentity(identityID, userID.(int)); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Identity unlinked successfully"})
+}
@@ -4,6 +4,7 @@ import (
 	"fmt"
 	"io"
 	"net/http"
+	"net/url"
 	"os"
 	"path/filepath"
 	"strconv"
@@ -15,20 +16,24 @@ import (
 )
 
 type OnlyOfficeHandler struct {
-	onlyOfficeURL string
-	callbackURL   string
-	jwtSecret     string
-	uploadPath    string
-	fileService   *services.FileService
+	onlyOfficeURL     string
+	callbackURL       string
+	authenticator     *auth.Authenticator
+	uploadPath        string
+	fileService       *services.FileService
+	fileAccessService *services.FileAccessService
+	userService       *services.UserService
 }
 
-func NewOnlyOfficeHandler(jwtSecret string, fileService *services.FileService) *OnlyOfficeHandler {
+func NewOnlyOfficeHandler(authenticator *auth.Authenticator, fileService *services.FileService, fileAccessService *services.FileAccessService, userService *services.UserService) *OnlyOfficeHandler {
 	return &OnlyOfficeHandler{
-		onlyOfficeURL: "http://onlyoffice:80", // Internal Docker network URL
-		callbackURL:   "http://backend:8080/api/onlyoffice/callback",
-		jwtSecret:     jwtSecret,
-		uploadPath:    "./storage/files",
-		fileService:   fileService,
+		onlyOfficeURL:     "http://onlyoffice:80", // Internal Docker network URL
+		callbackURL:       "http://backend:8080/api/onlyoffice/callback",
+		authenticator:     authenticator,
+		uploadPath:        "./storage/files",
+		fileService:       fileService,
+		fileAccessService: fileAccessService,
+		userService:       userService,
 	}
 }
 
@@ -66,22 +71,15 @@ func (h *OnlyOfficeHandler) GetOnlyOfficeConfig(c *gin.Context) {
 		return
 	}
 
-	// Parse token from query or Authorization header
-	token := c.Query("token")
-	if token == "" {
-		authHeader := c.GetHeader("Authorization")
-		if strings.HasPrefix(authHeader, "Bearer ") {
-			token = strings.TrimPrefix(authHeader, "Bearer ")
-		}
-	}
-	if token == "" {
-		c.JSON(http.StatusUnauthorized, gin.H{"error": "Authentication token required"})
+	// Session token in the Authorization header, or an edit token for the file from the editor page
+	userID, ok := fileRequestUser(c, h.authenticator, h.fileAccessService, fileID, auth.FileActionEdit)
+	if !ok {
 		return
 	}
 
-	claims, err := auth.ValidateToken(token, h.jwtSecret)
+	user, err := h.userService.GetUserByID(userID)
 	if err != nil {
-		c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid token"})
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "User not found"})
 		return
 	}
 
@@ -91,7 +89,7 @@ func (h *OnlyOfficeHandler) GetOnlyOfficeConfig(c *gin.Context) {
 		c.JSON(http.StatusNotFound, gin.H{"error": "File not found"})
 		return
 	}
-	if file.UserID != claims.UserID {
+	if file.UserID != user.ID {
 		c.JSON(http.StatusForbidden, gin.H{"error": "Access denied"})
 		return
 	}
@@ -110,11 +108,19 @@ func (h *OnlyOfficeHandler) GetOnlyOfficeConfig(c *gin.Context) {
 		Width:        "100%",
 	}
 
+	// OnlyOffice fetches the document itself and may do so more than once, so the
+	// download token is reusable but only valid for this file and version
+	downloadToken, _, err := h.fileAccessService.IssueToken(fileID, user.ID, auth.FileActionDownload, false, services.MaxFileTokenTTL)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to issue download token"})
+		return
+	}
+
 	// Document configuration
 	config.Document.FileType = fileType
-	config.Document.Key = fmt.Sprintf("file_%d_%d", fileID, claims.UserID)
+	config.Document.Key = fmt.Sprintf("file_%d_%d", fileID, user.ID)
 	config.Document.Title = filename
-	config.Document.URL = fmt.Sprintf("http://backend:8080/api/files/%d/download?token=%s", fileID, token)
+	config.Document.URL = fmt.Sprintf("http://backend:8080/api/files/%d/download?token=%s", fileID, url.QueryEscape(downloadToken))
 	config.Document.Permissions = map[string]bool{
 		"comment":              true,
 		"copy":                 true,
@@ -135,8 +141,8 @@ func (h *OnlyOfficeHandler) GetOnlyOfficeConfig(c *gin.Context) {
 	config.EditorConfig.CallbackURL = h.callbackURL
 	config.EditorConfig.Lang = "en"
 	config.EditorConfig.Mode = "edit"
-	config.EditorConfig.User.ID = fmt.Sprintf("%d", claims.UserID)
-	config.EditorConfig.User.Name = claims.Username
+	config.EditorConfig.User.ID = fmt.Sprintf("%d", user.ID)
+	config.EditorConfig.User.Name = user.Username
 
 	c.JSON(http.StatusOK, config)
 }
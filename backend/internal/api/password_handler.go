@@ -0,0 +1,122 @@
+package api
+
+import (
+	"log"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"ai-doc-system/internal/services"
+)
+
+type PasswordHandler struct {
+	userService          *services.UserService
+	sessionService       *services.SessionService
+	passwordResetService *services.PasswordResetService
+	apiTokenService      *services.APITokenService
+}
+
+func NewPasswordHandler(userService *services.UserService, sessionService *services.SessionService, passwordResetService *services.PasswordResetService,
+	apiTokenService *services.APITokenService) *PasswordHandler {
+	return &PasswordHandler{
+		userService:          userService,
+		sessionService:       sessionService,
+		passwordResetService: passwordResetService,
+		apiTokenService:      apiTokenService,
+	}
+}
+
+type ChangePasswordRequest struct {
+	CurrentPassword string `json:"current_password" binding:"required"`
+	NewPassword     string `json:"new_password" binding:"required"`
+	RevokeAPITokens bool   `json:"revoke_api_tokens"` // Also revoke personal access tokens, for a password that leaked
+}
+
+type ForgotPasswordRequest struct {
+	Email string `json:"email" binding:"required,email"`
+}
+
+type ResetPasswordRequest struct {
+	Token       string `json:"token" binding:"required"`
+	NewPassword string `json:"new_password" binding:"required"`
+}
+
+// Requirements for new passwords, for the registration and password forms
+func (h *PasswordHandler) GetPolicy(c *gin.Context) {
+	c.JSON(http.StatusOK, gin.H{"policy": h.userService.PasswordPolicy()})
+}
+
+// Change the password and end the user's other sessions, the current one stays logged in.
+// Personal access tokens are revoked as well when asked to.
+func (h *PasswordHandler) ChangePassword(c *gin.Context) {
+	userID, _ := c.Get("user_id")
+	sessionID, _ := c.Get("session_id")
+
+	var req ChangePasswordRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	err := h.userService.ChangePassword(userID.(int), req.CurrentPassword, req.NewPassword)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	count, err := h.sessionService.RevokeAllSessions(userID.(int), sessionID.(string), "password_change")
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Password changed but other sessions could not be ended"})
+		return
+	}
+
+	revokedTokens := 0
+	if req.RevokeAPITokens {
+		revokedTokens, err = h.apiTokenService.RevokeAllTokens(userID.(int))
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Password changed but personal access tokens could not be revoked"})
+			return
+		}
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"message":              "Password changed successfully",
+		"revoked_count":        count,
+		"revoked_tokens_count": revokedTokens,
+	})
+}
+
+// Email a reset link; the answer is the same whether or not the address is registered
+func (h *PasswordHandler) ForgotPassword(c *gin.Context) {
+	var req ForgotPasswordRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	if err := h.passwordResetService.RequestReset(req.Email); err != nil {
+		if err == services.ErrPasswordResetUnavailable {
+			c.JSON(http.StatusServiceUnavailable, gin.H{"error": err.Error()})
+			return
+		}
+		log.Printf("Failed to start password reset: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to start password reset"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "If the address belongs to an account, a reset link has been sent to it"})
+}
+
+func (h *PasswordHandler) ResetPassword(c *gin.Context) {
+	var req ResetPasswordRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	if err := h.passwordResetService.ResetPassword(req.Token, req.NewPassword); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Password reset successfully, please log in"})
+}
@@ -0,0 +1,65 @@
+package api
+
+import (
+	"net/http"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+	"ai-doc-system/internal/services"
+)
+
+type QuotaHandler struct {
+	fileService  *services.FileService
+	auditService *services.AuditService
+}
+
+func NewQuotaHandler(fileService *services.FileService, auditService *services.AuditService) *QuotaHandler {
+	return &QuotaHandler{
+		fileService:  fileService,
+		auditService: auditService,
+	}
+}
+
+type SetStorageQuotaRequest struct {
+	StorageQuota *int64 `json:"storage_quota"` // Bytes, null restores the default limit
+}
+
+// Storage a user uses and their limit
+func (h *QuotaHandler) GetStorageQuota(c *gin.Context) {
+	userID, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid user ID"})
+		return
+	}
+
+	used, limit, err := h.fileService.GetUserStorageUsage(userID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get storage usage"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"used": used, "limit": limit})
+}
+
+// Change a user's storage quota; files already over it stay, new uploads are refused
+func (h *QuotaHandler) SetStorageQuota(c *gin.Context) {
+	userID, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid user ID"})
+		return
+	}
+
+	var req SetStorageQuotaRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	if err := h.fileService.SetStorageQuota(userID, req.StorageQuota); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	recordAudit(c, h.auditService, "user.storage_quota", "user", strconv.Itoa(userID), gin.H{"storage_quota": req.StorageQuota})
+	c.JSON(http.StatusOK, gin.H{"message": "Storage quota updated successfully"})
+}
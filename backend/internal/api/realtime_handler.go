@@ -0,0 +1,217 @@
+package api
+
+import (
+	"errors"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/gorilla/websocket"
+	"ai-doc-system/internal/auth"
+	"ai-doc-system/internal/realtime"
+	"ai-doc-system/internal/services"
+)
+
+const (
+	wsWriteTimeout     = 10 * time.Second
+	wsPongTimeout      = 60 * time.Second
+	wsPingInterval     = 45 * time.Second
+	pollMaxTimeout     = 60
+	pollDefaultTimeout = 25
+)
+
+type RealtimeHandler struct {
+	hub                 *realtime.Hub
+	authenticator       *auth.Authenticator
+	streamTicketService *services.StreamTicketService
+	upgrader            websocket.Upgrader
+}
+
+func NewRealtimeHandler(hub *realtime.Hub, authenticator *auth.Authenticator, streamTicketService *services.StreamTicketService,
+	appURL string) *RealtimeHandler {
+	return &RealtimeHandler{
+		hub:                 hub,
+		authenticator:       authenticator,
+		streamTicketService: streamTicketService,
+		upgrader: websocket.Upgrader{
+			ReadBufferSize:  1024,
+			WriteBufferSize: 1024,
+			CheckOrigin:     frontendOrigin(appURL),
+		},
+	}
+}
+
+// Browsers send the page's origin with WebSocket handshakes, only pages of the frontend
+// may connect. Other clients send no origin.
+func frontendOrigin(appURL string) func(r *http.Request) bool {
+	allowed := ""
+	if u, err := url.Parse(appURL); err == nil && u.Host != "" {
+		allowed = u.Scheme + "://" + u.Host
+	}
+	return func(r *http.Request) bool {
+		origin := r.Header.Get("Origin")
+		return origin == "" || (allowed != "" && strings.EqualFold(origin, allowed))
+	}
+}
+
+// Authenticate a WebSocket or event stream request, returns the user and session IDs.
+// Browsers cannot set headers on these requests, so they pass a stream ticket as ?ticket=;
+// other clients may send the session's access token in the Authorization header. Access
+// tokens are never accepted in the URL, where proxies would log them.
+func streamUser(c *gin.Context, authenticator *auth.Authenticator, streamTicketService *services.StreamTicketService) (int, string, error) {
+	if ticket := c.Query("ticket"); ticket != "" {
+		claims, err := streamTicketService.RedeemTicket(ticket)
+		if err != nil {
+			return 0, "", err
+		}
+		return claims.UserID, claims.SessionID, nil
+	}
+
+	header := c.GetHeader("Authorization")
+	token := strings.TrimPrefix(header, "Bearer ")
+	if token == header {
+		return 0, "", errors.New("stream ticket or bearer token required")
+	}
+	claims, err := authenticator.Authenticate(token)
+	if err != nil {
+		return 0, "", err
+	}
+	return claims.UserID, claims.SessionID, nil
+}
+
+// CreateTicket issues a single-use ticket that opens one WebSocket or event stream of the session
+func (h *RealtimeHandler) CreateTicket(c *gin.Context) {
+	userID, _ := c.Get("user_id")
+	sessionID, _ := c.Get("session_id")
+
+	ticket, expiresAt, err := h.streamTicketService.IssueTicket(userID.(int), sessionID.(string))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create stream ticket"})
+		return
+	}
+
+	c.JSON(http.StatusCreated, gin.H{
+		"ticket":     ticket,
+		"expires_at": expiresAt,
+	})
+}
+
+// HandleWebSocket streams events of the authenticated user over a WebSocket
+func (h *RealtimeHandler) HandleWebSocket(c *gin.Context) {
+	userID, sessionID, err := streamUser(c, h.authenticator, h.streamTicketService)
+	if err != nil {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid token"})
+		return
+	}
+
+	conn, err := h.upgrader.Upgrade(c.Writer, c.Request, nil)
+	if err != nil {
+		// Upgrade already wrote the error response
+		return
+	}
+	defer conn.Close()
+
+	sub := h.hub.Subscribe(userID, sessionID)
+	defer h.hub.Unsubscribe(userID, sub)
+
+	// Reader: clients only send control frames, a read error means the connection is gone
+	closed := make(chan struct{})
+	go func() {
+		defer close(closed)
+		conn.SetReadLimit(512)
+		conn.SetReadDeadline(time.Now().Add(wsPongTimeout))
+		conn.SetPongHandler(func(string) error {
+			return conn.SetReadDeadline(time.Now().Add(wsPongTimeout))
+		})
+		for {
+			if _, _, err := conn.ReadMessage(); err != nil {
+				return
+			}
+		}
+	}()
+
+	// Replay events the client missed while reconnecting
+	if since, err := strconv.ParseInt(c.Query("since"), 10, 64); err == nil {
+		for _, event := range h.hub.EventsSince(userID, since) {
+			conn.SetWriteDeadline(time.Now().Add(wsWriteTimeout))
+			if err := conn.WriteJSON(event); err != nil {
+				return
+			}
+		}
+	}
+
+	ticker := time.NewTicker(wsPingInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case event := <-sub.Events():
+			conn.SetWriteDeadline(time.Now().Add(wsWriteTimeout))
+			if err := conn.WriteJSON(event); err != nil {
+				return
+			}
+		case <-sub.Done():
+			// The session was revoked or the account disabled
+			conn.WriteControl(websocket.CloseMessage,
+				websocket.FormatCloseMessage(websocket.ClosePolicyViolation, "session ended"),
+				time.Now().Add(wsWriteTimeout))
+			return
+		case <-ticker.C:
+			conn.SetWriteDeadline(time.Now().Add(wsWriteTimeout))
+			if err := conn.WriteMessage(websocket.PingMessage, nil); err != nil {
+				return
+			}
+		case <-closed:
+			return
+		}
+	}
+}
+
+// Poll is the long polling fallback: it returns buffered events newer than ?since=
+// or waits up to ?timeout= seconds for the next one
+func (h *RealtimeHandler) Poll(c *gin.Context) {
+	userID, _ := c.Get("user_id")
+	sessionID, _ := c.Get("session_id")
+
+	since, _ := strconv.ParseInt(c.DefaultQuery("since", "0"), 10, 64)
+	timeout, err := strconv.Atoi(c.DefaultQuery("timeout", strconv.Itoa(pollDefaultTimeout)))
+	if err != nil || timeout < 0 || timeout > pollMaxTimeout {
+		timeout = pollDefaultTimeout
+	}
+
+	// Subscribe before reading the backlog so no event slips in between
+	sub := h.hub.Subscribe(userID.(int), sessionID.(string))
+	defer h.hub.Unsubscribe(userID.(int), sub)
+
+	pending := h.hub.EventsSince(userID.(int), since)
+	if len(pending) == 0 {
+		select {
+		case event := <-sub.Events():
+			pending = append(pending, event)
+		case <-sub.Done():
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "Session ended"})
+			return
+		case <-time.After(time.Duration(timeout) * time.Second):
+		case <-c.Request.Context().Done():
+			return
+		}
+	}
+
+	cursor := since
+	for _, event := range pending {
+		if event.ID > cursor {
+			cursor = event.ID
+		}
+	}
+
+	if pending == nil {
+		pending = []realtime.Event{}
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"events": pending,
+		"cursor": cursor,
+	})
+}
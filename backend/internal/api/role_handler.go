@@ -0,0 +1,143 @@
+package api
+
+import (
+	"net/http"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+	"ai-doc-system/internal/auth"
+	"ai-doc-system/internal/services"
+)
+
+type RoleHandler struct {
+	roleService  *services.RoleService
+	auditService *services.AuditService
+}
+
+func NewRoleHandler(roleService *services.RoleService, auditService *services.AuditService) *RoleHandler {
+	return &RoleHandler{
+		roleService:  roleService,
+		auditService: auditService,
+	}
+}
+
+type CreateRoleRequest struct {
+	Name        string   `json:"name" binding:"required"`
+	Description string   `json:"description" binding:"max=255"`
+	Permissions []string `json:"permissions"`
+}
+
+type UpdateRoleRequest struct {
+	Description string   `json:"description" binding:"max=255"`
+	Permissions []string `json:"permissions"` // Replaces the role's permissions
+}
+
+type AssignRoleRequest struct {
+	Role string `json:"role" binding:"required"`
+}
+
+// Permissions of the current user's role
+func (h *RoleHandler) GetMyPermissions(c *gin.Context) {
+	userID, _ := c.Get("user_id")
+
+	permissions, err := h.roleService.GetUserPermissions(userID.(int))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get permissions"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"permissions": permissions})
+}
+
+// Permissions roles can be granted
+func (h *RoleHandler) GetPermissions(c *gin.Context) {
+	c.JSON(http.StatusOK, gin.H{"permissions": auth.Permissions})
+}
+
+func (h *RoleHandler) GetRoles(c *gin.Context) {
+	roles, err := h.roleService.GetRoles()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get roles"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"roles": roles})
+}
+
+func (h *RoleHandler) CreateRole(c *gin.Context) {
+	var req CreateRoleRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	if err := h.roleService.CreateRole(req.Name, req.Description, req.Permissions); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	recordAudit(c, h.auditService, "role.create", "role", req.Name, gin.H{"permissions": req.Permissions})
+	c.JSON(http.StatusCreated, gin.H{"message": "Role created successfully"})
+}
+
+func (h *RoleHandler) UpdateRole(c *gin.Context) {
+	name := c.Param("name")
+
+	var req UpdateRoleRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	previous, err := h.roleService.UpdateRole(name, req.Description, req.Permissions)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	recordAudit(c, h.auditService, "role.update", "role", name, gin.H{
+		"previous_permissions": previous,
+		"permissions":          req.Permissions,
+	})
+	c.JSON(http.StatusOK, gin.H{"message": "Role updated successfully"})
+}
+
+func (h *RoleHandler) DeleteRole(c *gin.Context) {
+	name := c.Param("name")
+
+	if err := h.roleService.DeleteRole(name); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	recordAudit(c, h.auditService, "role.delete", "role", name, nil)
+	c.JSON(http.StatusOK, gin.H{"message": "Role deleted successfully"})
+}
+
+// Assign a role to a user; it applies to the user's next request
+func (h *RoleHandler) AssignRole(c *gin.Context) {
+	userID, _ := c.Get("user_id")
+	targetID, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid user ID"})
+		return
+	}
+
+	var req AssignRoleRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	previous, err := h.roleService.AssignRole(userID.(int), targetID, req.Role)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	recordAudit(c, h.auditService, "user.role", "user", strconv.Itoa(targetID), gin.H{
+		"previous_role": previous,
+		"role":          req.Role,
+	})
+	c.JSON(http.StatusOK, gin.H{"message": "Role assigned successfully"})
+}
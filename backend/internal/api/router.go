@@ -2,15 +2,37 @@ package api
 
 import (
 	"database/sql"
+	"log"
+	"strings"
+	"time"
 	
 	"github.com/gin-gonic/gin"
 	"ai-doc-system/internal/auth"
+	"ai-doc-system/internal/config"
+	"ai-doc-system/internal/directory"
+	"ai-doc-system/internal/mail"
+	"ai-doc-system/internal/oidc"
+	"ai-doc-system/internal/realtime"
 	"ai-doc-system/internal/services"
+	"ai-doc-system/internal/utils"
 )
 
-func SetupRouter(db *sql.DB, jwtSecret string) *gin.Engine {
+func SetupRouter(db *sql.DB, cfg *config.Config, hub *realtime.Hub) *gin.Engine {
+	jwtSecret := cfg.JWTSecret
 	r := gin.Default()
 	
+	// c.ClientIP() only believes X-Forwarded-For from the configured proxies, or anyone
+	// could pick the address login throttling counts
+	var trustedProxies []string
+	for _, proxy := range strings.Split(cfg.TrustedProxies, ",") {
+		if proxy = strings.TrimSpace(proxy); proxy != "" {
+			trustedProxies = append(trustedProxies, proxy)
+		}
+	}
+	if err := r.SetTrustedProxies(trustedProxies); err != nil {
+		log.Fatalf("Invalid TRUSTED_PROXIES: %v", err)
+	}
+	
 	// Set file upload size limit
 	r.MaxMultipartMemory = 10 << 20 // 10MB
 	
@@ -37,58 +59,290 @@ func SetupRouter(db *sql.DB, jwtSecret string) *gin.Engine {
 	})
 	
 	// Initialize services and handlers
-	userService := services.NewUserService(db)
-	userHandler := NewUserHandler(userService, jwtSecret)
+	sessionService := services.NewSessionService(db, jwtSecret, hub)
+	sessionHandler := NewSessionHandler(sessionService)
+	apiTokenService := services.NewAPITokenService(db)
+	apiTokenHandler := NewAPITokenHandler(apiTokenService)
+	authenticator := auth.NewAuthenticator(jwtSecret, sessionService, apiTokenService)
+	
+	// Roles and their permissions are stored in the database and checked per admin route
+	roleService := services.NewRoleService(db)
+	auditService := services.NewAuditService(db)
+	roleHandler := NewRoleHandler(roleService, auditService)
+	auditHandler := NewAuditHandler(auditService)
+	
+	// Failed password logins lock out the username and the address for growing periods
+	loginThrottleService := services.NewLoginThrottleService(db, auditService)
+	lockoutHandler := NewLockoutHandler(loginThrottleService, auditService)
+	
+	// Directory login is optional, without LDAP_URL all passwords are checked locally
+	var ldapDirectory *directory.Directory
+	if cfg.LDAPURL != "" {
+		ldapDirectory = directory.New(directory.Config{
+			URL:                cfg.LDAPURL,
+			StartTLS:           cfg.LDAPStartTLS,
+			InsecureSkipVerify: cfg.LDAPInsecureSkipVerify,
+			BindDN:             cfg.LDAPBindDN,
+			BindPassword:       cfg.LDAPBindPassword,
+			BaseDN:             cfg.LDAPBaseDN,
+			UserFilter:         cfg.LDAPUserFilter,
+			UsernameAttribute:  cfg.LDAPUsernameAttribute,
+			EmailAttribute:     cfg.LDAPEmailAttribute,
+			GroupAttribute:     cfg.LDAPGroupAttribute,
+		})
+	}
+	ldapRoleRules, err := services.ParseGroupRoleRules(cfg.LDAPRoleMapping, ";")
+	if err != nil {
+		log.Printf("Ignoring LDAP_ROLE_MAPPING: %v", err)
+	}
+	ldapService := services.NewLDAPService(db, ldapDirectory, sessionService, services.LDAPOptions{
+		RoleRules:   ldapRoleRules,
+		DefaultRole: cfg.LDAPDefaultRole,
+	})
+	directoryHandler := NewDirectoryHandler(ldapService, auditService)
+	if ldapService.Enabled() && cfg.LDAPSyncInterval > 0 {
+		go ldapService.RunSync(time.Duration(cfg.LDAPSyncInterval) * time.Minute)
+	}
+	
+	userService := services.NewUserService(db, utils.PasswordPolicy{
+		MinLength:        cfg.PasswordMinLength,
+		RequireMixedCase: cfg.PasswordRequireMixedCase,
+		RequireDigit:     cfg.PasswordRequireDigit,
+		RequireSymbol:    cfg.PasswordRequireSymbol,
+	}, ldapService)
+	twoFactorService := services.NewTwoFactorService(db, userService, sessionService)
+	twoFactorHandler := NewTwoFactorHandler(twoFactorService, userService, sessionService, loginThrottleService, auditService)
+	userHandler := NewUserHandler(userService, sessionService, twoFactorService, loginThrottleService)
+	
+	// Single sign-on is optional, without OIDC_ISSUER_URL only local accounts log in
+	var oidcProvider *oidc.Provider
+	if cfg.OIDCIssuerURL != "" {
+		oidcProvider = oidc.NewProvider(cfg.OIDCIssuerURL, cfg.OIDCClientID, cfg.OIDCClientSecret, cfg.OIDCRedirectURL, strings.Fields(cfg.OIDCScopes))
+	}
+	oidcRoleRules, err := services.ParseGroupRoleRules(cfg.OIDCRoleMapping, ",")
+	if err != nil {
+		log.Printf("Ignoring OIDC_ROLE_MAPPING: %v", err)
+	}
+	oidcService := services.NewOIDCService(db, oidcProvider, services.OIDCOptions{
+		UsernameClaim: cfg.OIDCUsernameClaim,
+		GroupsClaim:   cfg.OIDCGroupsClaim,
+		RoleRules:     oidcRoleRules,
+		DefaultRole:   cfg.OIDCDefaultRole,
+		AutoProvision: cfg.OIDCAutoProvision,
+	})
+	oidcHandler := NewOIDCHandler(oidcService, userService, sessionService, twoFactorService, cfg.AppURL)
 	
 	fileService := services.NewFileService(db, "storage/files")
-	fileHandler := NewFileHandler(fileService, jwtSecret)
+	fileAccessService := services.NewFileAccessService(db, jwtSecret)
+	fileAccessHandler := NewFileAccessHandler(fileAccessService)
+	fileHandler := NewFileHandler(fileService, authenticator, fileAccessService)
+	quotaHandler := NewQuotaHandler(fileService, auditService)
+	
+	// Email is optional, without SMTP_HOST notifications stay in the app
+	var mailer *mail.Mailer
+	if cfg.SMTPHost != "" {
+		var err error
+		mailer, err = mail.NewMailer(cfg.SMTPHost, cfg.SMTPPort, cfg.SMTPUsername, cfg.SMTPPassword, cfg.SMTPFrom)
+		if err != nil {
+			log.Printf("Email disabled: %v", err)
+			mailer = nil
+		}
+	}
+	emailService := services.NewEmailService(db, mailer, cfg.AppURL)
+	emailHandler := NewEmailHandler(emailService, userService)
+	if emailService.Enabled() {
+		go emailService.RunQueue(30 * time.Second)
+		go emailService.RunDigest(time.Hour)
+	}
 	
-	friendService := services.NewFriendService(db)
+	passwordResetService := services.NewPasswordResetService(db, userService, emailService, sessionService)
+	passwordHandler := NewPasswordHandler(userService, sessionService, passwordResetService, apiTokenService)
+	
+	notificationService := services.NewNotificationService(db, hub, emailService)
+	streamTicketService := services.NewStreamTicketService(db, jwtSecret, sessionService)
+	notificationHandler := NewNotificationHandler(notificationService, hub, authenticator, streamTicketService)
+	
+	friendService := services.NewFriendService(db, notificationService)
 	friendHandler := NewFriendHandler(friendService)
 	
-	messageService := services.NewMessageService(db)
+	messageService := services.NewMessageService(db, hub, fileService, notificationService)
 	messageHandler := NewMessageHandler(messageService)
 	
-	fileShareService := services.NewFileShareService(db)
+	conversationService := services.NewConversationService(db, hub)
+	conversationHandler := NewConversationHandler(conversationService, messageService)
+	
+	fileShareService := services.NewFileShareService(db, notificationService)
 	fileShareHandler := NewFileShareHandler(fileShareService, fileService)
 	
-	onlyOfficeHandler := NewOnlyOfficeHandler(jwtSecret, fileService)
+	// Warn owners a day before their share links expire; the notified flag keeps instances from repeating it
+	go fileShareService.RunExpiryNotifier(time.Hour, 24*time.Hour)
+	
+	onlyOfficeHandler := NewOnlyOfficeHandler(authenticator, fileService, fileAccessService, userService)
+	
+	realtimeHandler := NewRealtimeHandler(hub, authenticator, streamTicketService, cfg.AppURL)
+	
+	fileRequestService := services.NewFileRequestService(db, fileService, notificationService)
+	fileRequestHandler := NewFileRequestHandler(fileRequestService, loginThrottleService)
+	
+	fileTransferService := services.NewFileTransferService(db, notificationService)
+	fileTransferHandler := NewFileTransferHandler(fileTransferService, roleService, auditService)
+	
+	// Personal data exports are built in the background, accounts are deleted once their grace period ends
+	dataExportService := services.NewDataExportService(db, "storage/exports", notificationService)
+	accountDeletionService := services.NewAccountDeletionService(db, userService, emailService, notificationService, auditService, cfg.AccountDeletionGraceDays)
+	accountHandler := NewAccountHandler(accountDeletionService, dataExportService, auditService)
+	go dataExportService.RunExports(time.Minute)
+	go accountDeletionService.RunDeletions(time.Hour)
+	
+	userAdminService := services.NewUserAdminService(db, sessionService)
+	adminUserHandler := NewAdminUserHandler(userAdminService, sessionService, fileShareService, passwordResetService, accountDeletionService, roleService, auditService)
 	
 	// User authentication routes (no authentication required)
 	authGroup := r.Group("/api/auth")
 	{
 		authGroup.POST("/register", userHandler.Register)
 		authGroup.POST("/login", userHandler.Login)
+		authGroup.POST("/refresh", sessionHandler.Refresh)
+		authGroup.POST("/2fa/verify", twoFactorHandler.VerifyLogin)
+		authGroup.POST("/2fa/setup", twoFactorHandler.BeginLoginSetup)
+		authGroup.POST("/2fa/setup/confirm", twoFactorHandler.CompleteLoginSetup)
+		authGroup.GET("/password-policy", passwordHandler.GetPolicy)
+		authGroup.POST("/password/forgot", passwordHandler.ForgotPassword)
+		authGroup.POST("/password/reset", passwordHandler.ResetPassword)
+		authGroup.GET("/oidc/config", oidcHandler.GetConfig)
+		authGroup.GET("/oidc/login", oidcHandler.Login)
+		authGroup.GET("/oidc/callback", oidcHandler.Callback)
+		authGroup.POST("/oidc/exchange", oidcHandler.Exchange)
 	}
 	
 	// Public shared file download (no authentication required)
 	r.GET("/api/share/:token", fileShareHandler.DownloadSharedFile)
 	
-	// File edit, preview and download endpoints with their own auth logic for iframe support
+	// Public upload-only file request links (no authentication required)
+	r.GET("/api/request/:token", fileRequestHandler.GetPublicFileRequest)
+	r.POST("/api/request/:token/upload", fileRequestHandler.UploadToFileRequest)
+	
+	// File edit, preview and download endpoints for iframes and links: session tokens and personal
+	// access tokens only in the Authorization header, query strings must carry a scoped file access token
 	r.GET("/api/files/:id/edit", fileHandler.EditFile)
 	r.GET("/api/files/:id/preview", fileHandler.PreviewFile)
 	r.GET("/api/files/:id/download", fileHandler.DownloadFile)
 	
+	// Real-time events over WebSocket, authenticates the upgrade request itself
+	r.GET("/api/ws", realtimeHandler.HandleWebSocket)
+	
+	// Notification stream over server-sent events, authenticates the request itself
+	r.GET("/api/notifications/stream", notificationHandler.Stream)
+	
 	// OnlyOffice integration endpoints
 	r.GET("/api/onlyoffice/config/:id", onlyOfficeHandler.GetOnlyOfficeConfig)
 	r.GET("/api/files/:id/onlyoffice/config", onlyOfficeHandler.GetOnlyOfficeConfig)
 	r.POST("/api/onlyoffice/callback", onlyOfficeHandler.HandleCallback)
 	
-	// Protected routes (authentication required)
+	// Routes personal access tokens can use with the matching scope, sessions can use all of them.
+	// Everything else only accepts sessions.
+	
+	// Files, read access
+	filesRead := r.Group("/api")
+	filesRead.Use(auth.ScopedAuthMiddleware(authenticator, auth.ScopeFilesRead))
+	{
+		filesRead.GET("/files", fileHandler.GetUserFiles)
+		filesRead.GET("/files/:id", fileHandler.GetFile)
+		filesRead.POST("/files/:id/access-token", fileAccessHandler.CreateAccessToken)
+		filesRead.GET("/storage/usage", fileHandler.GetStorageUsage)
+	}
+	
+	// Files, write access
+	filesWrite := r.Group("/api")
+	filesWrite.Use(auth.ScopedAuthMiddleware(authenticator, auth.ScopeFilesWrite))
+	{
+		filesWrite.POST("/files/upload", fileHandler.UploadFile)
+		filesWrite.DELETE("/files/:id", fileHandler.DeleteFile)
+		filesWrite.PUT("/files/:id/rename", fileHandler.RenameFile)
+	}
+	
+	// File sharing and file requests (upload-only links)
+	shares := r.Group("/api")
+	shares.Use(auth.ScopedAuthMiddleware(authenticator, auth.ScopeShares))
+	{
+		shares.POST("/shares/friend", fileShareHandler.ShareToFriend)
+		shares.POST("/shares/public", fileShareHandler.CreatePublicShare)
+		shares.GET("/shares/with-me", fileShareHandler.GetSharedWithMe)
+		shares.GET("/shares/my-shares", fileShareHandler.GetMyShares)
+		shares.GET("/shares/files/:id/download", fileShareHandler.DownloadFriendSharedFile)
+		shares.DELETE("/shares/:id", fileShareHandler.RemoveShare)
+		shares.POST("/file-requests", fileRequestHandler.CreateFileRequest)
+		shares.GET("/file-requests", fileRequestHandler.GetFileRequests)
+		shares.GET("/file-requests/:id/uploads", fileRequestHandler.GetFileRequestUploads)
+		shares.PUT("/file-requests/:id/close", fileRequestHandler.CloseFileRequest)
+		shares.DELETE("/file-requests/:id", fileRequestHandler.DeleteFileRequest)
+	}
+	
+	// Messages and conversations (direct and group)
+	messages := r.Group("/api")
+	messages.Use(auth.ScopedAuthMiddleware(authenticator, auth.ScopeMessages))
+	{
+		messages.POST("/messages", messageHandler.SendMessage)
+		messages.GET("/messages/search", messageHandler.SearchMessages)
+		messages.GET("/messages/:friend_id", messageHandler.GetChatHistory)
+		messages.GET("/chats", messageHandler.GetChatList)
+		messages.PUT("/chats/:friend_id/read", messageHandler.MarkConversationAsRead)
+		messages.PUT("/messages/:id/read", messageHandler.MarkAsRead)
+		messages.GET("/messages/unread/count", messageHandler.GetUnreadCount)
+		messages.PUT("/messages/:id", messageHandler.EditMessage)
+		messages.POST("/messages/:id/reactions", messageHandler.ToggleReaction)
+		messages.DELETE("/messages/:id", messageHandler.DeleteMessage)
+		messages.POST("/conversations", conversationHandler.CreateGroup)
+		messages.GET("/conversations", messageHandler.GetChatList)
+		messages.GET("/conversations/:id", conversationHandler.GetConversation)
+		messages.PUT("/conversations/:id", conversationHandler.RenameGroup)
+		messages.POST("/conversations/:id/members", conversationHandler.AddMembers)
+		messages.DELETE("/conversations/:id/members/:user_id", conversationHandler.RemoveMember)
+		messages.PUT("/conversations/:id/members/:user_id/role", conversationHandler.SetMemberRole)
+		messages.POST("/conversations/:id/leave", conversationHandler.LeaveConversation)
+		messages.GET("/conversations/:id/messages", conversationHandler.GetMessages)
+		messages.POST("/conversations/:id/messages", conversationHandler.SendMessage)
+		messages.POST("/conversations/:id/files", conversationHandler.UploadFile)
+		messages.GET("/conversations/:id/messages/:message_id/replies", conversationHandler.GetReplies)
+		messages.GET("/conversations/:id/messages/:message_id/edits", conversationHandler.GetMessageEdits)
+		messages.PUT("/conversations/:id/read", conversationHandler.MarkAsRead)
+	}
+	
+	// Protected routes (session authentication required)
 	protected := r.Group("/api")
-	protected.Use(auth.AuthMiddleware(jwtSecret))
+	protected.Use(auth.AuthMiddleware(authenticator))
 	{
+		// Sessions
+		protected.POST("/auth/logout", sessionHandler.Logout)
+		protected.POST("/auth/logout-all", sessionHandler.LogoutAll)
+		protected.GET("/sessions", sessionHandler.GetSessions)
+		protected.DELETE("/sessions/:id", sessionHandler.RevokeSession)
+		
 		// User related
 		protected.GET("/profile", userHandler.GetProfile)
 		protected.PUT("/profile", userHandler.UpdateProfile)
-		
-		// File related
-		protected.POST("/files/upload", fileHandler.UploadFile)
-		protected.GET("/files", fileHandler.GetUserFiles)
-		protected.GET("/files/:id", fileHandler.GetFile)
-		protected.DELETE("/files/:id", fileHandler.DeleteFile)
-		protected.PUT("/files/:id/rename", fileHandler.RenameFile)
-		protected.GET("/storage/usage", fileHandler.GetStorageUsage)
+		protected.GET("/profile/email", emailHandler.GetEmailSettings)
+		protected.PUT("/profile/email", emailHandler.UpdateEmailSettings)
+		protected.PUT("/profile/privacy", userHandler.UpdatePrivacy)
+		protected.PUT("/profile/password", passwordHandler.ChangePassword)
+		protected.GET("/profile/2fa", twoFactorHandler.GetStatus)
+		protected.POST("/profile/2fa/setup", twoFactorHandler.BeginSetup)
+		protected.POST("/profile/2fa/enable", twoFactorHandler.Enable)
+		protected.POST("/profile/2fa/disable", twoFactorHandler.Disable)
+		protected.POST("/profile/2fa/recovery-codes", twoFactorHandler.RegenerateRecoveryCodes)
+		protected.GET("/profile/identities", oidcHandler.GetIdentities)
+		protected.POST("/profile/identities/oidc", oidcHandler.StartLink)
+		protected.DELETE("/profile/identities/:id", oidcHandler.UnlinkIdentity)
+		protected.GET("/profile/tokens", apiTokenHandler.GetTokens)
+		protected.POST("/profile/tokens", apiTokenHandler.CreateToken)
+		protected.DELETE("/profile/tokens/:id", apiTokenHandler.RevokeToken)
+		protected.GET("/profile/permissions", roleHandler.GetMyPermissions)
+		protected.GET("/profile/exports", accountHandler.GetExports)
+		protected.POST("/profile/exports", accountHandler.RequestExport)
+		protected.GET("/profile/exports/:id/download", accountHandler.DownloadExport)
+		protected.GET("/profile/deletion", accountHandler.GetDeletionStatus)
+		protected.POST("/profile/deletion", accountHandler.ScheduleDeletion)
+		protected.DELETE("/profile/deletion", accountHandler.CancelDeletion)
 		
 		// Friend related
 		protected.POST("/friends/request", friendHandler.SendFriendRequest)
@@ -97,38 +351,85 @@ func SetupRouter(db *sql.DB, jwtSecret string) *gin.Engine {
 		protected.DELETE("/friends/:id", friendHandler.RemoveFriend)
 		protected.GET("/friends", friendHandler.GetFriends)
 		protected.GET("/friends/requests", friendHandler.GetPendingRequests)
+		protected.GET("/friends/suggestions", friendHandler.GetSuggestions)
+		protected.POST("/friends/suggestions/:id/dismiss", friendHandler.DismissSuggestion)
 		protected.GET("/users/search", friendHandler.SearchUsers)
+		protected.GET("/users/blocked", friendHandler.GetBlockedUsers)
+		protected.POST("/users/:id/block", friendHandler.BlockUser)
+		protected.DELETE("/users/:id/block", friendHandler.UnblockUser)
 		
 		// Friend groups
 		protected.POST("/friend-groups", friendHandler.CreateFriendGroup)
 		protected.GET("/friend-groups", friendHandler.GetFriendGroups)
+		protected.PUT("/friend-groups/order", friendHandler.ReorderFriendGroups)
+		protected.PUT("/friend-groups/:id", friendHandler.RenameFriendGroup)
+		protected.DELETE("/friend-groups/:id", friendHandler.DeleteFriendGroup)
 		protected.POST("/friend-groups/:id/add-friend", friendHandler.AddFriendToGroup)
+		protected.DELETE("/friend-groups/:id/friends/:friend_id", friendHandler.RemoveFriendFromGroup)
+		
+		// Real-time events long polling fallback
+		protected.GET("/realtime/poll", realtimeHandler.Poll)
+		protected.POST("/realtime/ticket", realtimeHandler.CreateTicket)
 		
-		// Message related
-		protected.POST("/messages", messageHandler.SendMessage)
-		protected.GET("/messages/:friend_id", messageHandler.GetChatHistory)
-		protected.GET("/chats", messageHandler.GetChatList)
-		protected.PUT("/messages/:id/read", messageHandler.MarkAsRead)
-		protected.GET("/messages/unread/count", messageHandler.GetUnreadCount)
-		protected.DELETE("/messages/:id", messageHandler.DeleteMessage)
+		// File ownership transfers
+		protected.POST("/transfers", fileTransferHandler.OfferTransfer)
+		protected.GET("/transfers", fileTransferHandler.GetTransfers)
+		protected.POST("/transfers/:id/accept", fileTransferHandler.AcceptTransfer)
+		protected.POST("/transfers/:id/decline", fileTransferHandler.DeclineTransfer)
+		protected.DELETE("/transfers/:id", fileTransferHandler.CancelTransfer)
 		
-		// File sharing
-		protected.POST("/shares/friend", fileShareHandler.ShareToFriend)
-		protected.POST("/shares/public", fileShareHandler.CreatePublicShare)
-		protected.GET("/shares/with-me", fileShareHandler.GetSharedWithMe)
-		protected.GET("/shares/my-shares", fileShareHandler.GetMyShares)
-		protected.GET("/shares/files/:id/download", fileShareHandler.DownloadFriendSharedFile)
-		protected.DELETE("/shares/:id", fileShareHandler.RemoveShare)
+		// Notifications
+		protected.GET("/notifications", notificationHandler.GetNotifications)
+		protected.GET("/notifications/unread-count", notificationHandler.GetUnreadCount)
+		protected.PUT("/notifications/:id/read", notificationHandler.MarkAsRead)
+		protected.PUT("/notifications/read-all", notificationHandler.MarkAllAsRead)
+		protected.DELETE("/notifications/:id", notificationHandler.DeleteNotification)
+		protected.DELETE("/notifications", notificationHandler.ClearNotifications)
+		protected.GET("/notifications/preferences", notificationHandler.GetPreferences)
+		protected.PUT("/notifications/preferences", notificationHandler.UpdatePreferences)
 	}
 	
-	// Admin routes
+	// Admin routes, each requires a permission of the user's role
 	admin := r.Group("/api/admin")
-	admin.Use(auth.AuthMiddleware(jwtSecret))
-	admin.Use(auth.AdminMiddleware())
+	admin.Use(auth.AuthMiddleware(authenticator))
 	{
-		admin.GET("/users", userHandler.GetAllUsers)
-		admin.GET("/users/:id", userHandler.GetUserByID)
-		admin.GET("/files", fileHandler.GetAllFiles)
+		// Users
+		admin.GET("/users", auth.RequirePermission(roleService, auth.PermUsersRead), adminUserHandler.GetUsers)
+		admin.GET("/users/:id", auth.RequirePermission(roleService, auth.PermUsersRead), adminUserHandler.GetUser)
+		admin.DELETE("/users/:id", auth.RequirePermission(roleService, auth.PermUsersManage), adminUserHandler.DeleteUser)
+		admin.POST("/users/:id/disable", auth.RequirePermission(roleService, auth.PermUsersManage), adminUserHandler.DisableUser)
+		admin.POST("/users/:id/enable", auth.RequirePermission(roleService, auth.PermUsersManage), adminUserHandler.EnableUser)
+		admin.POST("/users/:id/password-reset", auth.RequirePermission(roleService, auth.PermUsersManage), adminUserHandler.ForcePasswordReset)
+		admin.POST("/users/:id/unlock", auth.RequirePermission(roleService, auth.PermUsersManage), lockoutHandler.UnlockUser)
+		admin.GET("/users/:id/sessions", auth.RequirePermission(roleService, auth.PermUsersRead), adminUserHandler.GetSessions)
+		admin.DELETE("/users/:id/sessions", auth.RequirePermission(roleService, auth.PermUsersManage), adminUserHandler.RevokeSessions)
+		admin.GET("/users/:id/shares", auth.RequirePermission(roleService, auth.PermUsersRead), adminUserHandler.GetShares)
+		admin.PUT("/users/:id/role", auth.RequirePermission(roleService, auth.PermRolesManage), roleHandler.AssignRole)
+		admin.GET("/users/:id/storage", auth.RequirePermission(roleService, auth.PermUsersRead), quotaHandler.GetStorageQuota)
+		admin.PUT("/users/:id/storage", auth.RequirePermission(roleService, auth.PermQuotasManage), quotaHandler.SetStorageQuota)
+		
+		// Roles
+		admin.GET("/permissions", auth.RequirePermission(roleService, auth.PermRolesManage), roleHandler.GetPermissions)
+		admin.GET("/roles", auth.RequirePermission(roleService, auth.PermRolesManage), roleHandler.GetRoles)
+		admin.POST("/roles", auth.RequirePermission(roleService, auth.PermRolesManage), roleHandler.CreateRole)
+		admin.PUT("/roles/:name", auth.RequirePermission(roleService, auth.PermRolesManage), roleHandler.UpdateRole)
+		admin.DELETE("/roles/:name", auth.RequirePermission(roleService, auth.PermRolesManage), roleHandler.DeleteRole)
+		
+		// Files
+		admin.GET("/files", auth.RequirePermission(roleService, auth.PermFilesReadAll), fileHandler.GetAllFiles)
+		admin.POST("/users/:id/transfer", auth.RequirePermission(roleService, auth.PermFilesTransferAll), fileTransferHandler.BulkTransfer)
+		
+		// Audit log and login lockouts
+		admin.GET("/audit-log", auth.RequirePermission(roleService, auth.PermAuditRead), auditHandler.GetEntries)
+		admin.GET("/security/lockouts", auth.RequirePermission(roleService, auth.PermUsersManage), lockoutHandler.GetLockouts)
+		admin.DELETE("/security/lockouts/:id", auth.RequirePermission(roleService, auth.PermUsersManage), lockoutHandler.Unlock)
+		
+		// Settings
+		admin.POST("/email/test", auth.RequirePermission(roleService, auth.PermSettingsManage), emailHandler.SendTestEmail)
+		admin.GET("/security/2fa-policy", auth.RequirePermission(roleService, auth.PermSettingsManage), twoFactorHandler.GetPolicy)
+		admin.PUT("/security/2fa-policy", auth.RequirePermission(roleService, auth.PermSettingsManage), twoFactorHandler.UpdatePolicy)
+		admin.GET("/directory", auth.RequirePermission(roleService, auth.PermSettingsManage), directoryHandler.GetStatus)
+		admin.POST("/directory/sync", auth.RequirePermission(roleService, auth.PermSettingsManage), directoryHandler.Sync)
 	}
 	
 	return r
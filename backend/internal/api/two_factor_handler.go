@@ -0,0 +1,265 @@
+package api
+
+import (
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"ai-doc-system/internal/models"
+	"ai-doc-system/internal/services"
+)
+
+type TwoFactorHandler struct {
+	twoFactorService     *services.TwoFactorService
+	userService          *services.UserService
+	sessionService       *services.SessionService
+	loginThrottleService *services.LoginThrottleService
+	auditService         *services.AuditService
+}
+
+func NewTwoFactorHandler(twoFactorService *services.TwoFactorService, userService *services.UserService, sessionService *services.SessionService,
+	loginThrottleService *services.LoginThrottleService, auditService *services.AuditService) *TwoFactorHandler {
+	return &TwoFactorHandler{
+		twoFactorService:     twoFactorService,
+		userService:          userService,
+		sessionService:       sessionService,
+		loginThrottleService: loginThrottleService,
+		auditService:         auditService,
+	}
+}
+
+type TwoFactorCodeRequest struct {
+	Code string `json:"code" binding:"required,max=20"` // TOTP code, or a recovery code where accepted
+}
+
+type DisableTwoFactorRequest struct {
+	Password string `json:"password"` // Not needed for accounts without a password
+	Code     string `json:"code" binding:"required,max=20"`
+}
+
+type UpdateTwoFactorPolicyRequest struct {
+	RequireForAdmins *bool `json:"require_for_admins" binding:"required"`
+}
+
+type LoginChallengeRequest struct {
+	ChallengeToken string `json:"challenge_token" binding:"required"`
+}
+
+type VerifyLoginRequest struct {
+	ChallengeToken string `json:"challenge_token" binding:"required"`
+	Code           string `json:"code" binding:"required,max=20"`
+}
+
+// Issue the session of a login that passed the second factor
+func (h *TwoFactorHandler) createSession(c *gin.Context, userID int, extra gin.H) {
+	user, err := h.userService.GetUserByID(userID)
+	if err != nil {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "User not found"})
+		return
+	}
+
+	tokens, err := h.sessionService.CreateSession(user, c.Request.UserAgent(), c.ClientIP())
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to generate token"})
+		return
+	}
+
+	response := gin.H{
+		"user":          user,
+		"token":         tokens.AccessToken,
+		"refresh_token": tokens.RefreshToken,
+		"expires_in":    tokens.ExpiresIn,
+	}
+	for key, value := range extra {
+		response[key] = value
+	}
+	c.JSON(http.StatusOK, response)
+}
+
+// Second login step: a TOTP or recovery code for the challenge returned by login
+func (h *TwoFactorHandler) VerifyLogin(c *gin.Context) {
+	var req VerifyLoginRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	// Wrong codes count as failed logins, or one known password would allow guessing codes
+	// through any number of challenges
+	username, err := h.twoFactorService.ChallengeUsername(req.ChallengeToken, false)
+	if err != nil {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": err.Error()})
+		return
+	}
+	if refuseLockedOutLogin(c, h.loginThrottleService, username) {
+		return
+	}
+
+	userID, err := h.twoFactorService.VerifyLogin(req.ChallengeToken, req.Code)
+	if err != nil {
+		if err != services.ErrInvalidChallenge {
+			recordFailedLogin(c, h.loginThrottleService, username)
+		}
+		c.JSON(http.StatusUnauthorized, gin.H{"error": err.Error()})
+		return
+	}
+
+	recordSuccessfulLogin(h.loginThrottleService, username)
+	h.createSession(c, userID, nil)
+}
+
+// Enrollment during login when the policy requires 2FA for the user's role
+func (h *TwoFactorHandler) BeginLoginSetup(c *gin.Context) {
+	var req LoginChallengeRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	setup, err := h.twoFactorService.BeginLoginSetup(req.ChallengeToken)
+	if err != nil {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, setup)
+}
+
+func (h *TwoFactorHandler) CompleteLoginSetup(c *gin.Context) {
+	var req VerifyLoginRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	username, err := h.twoFactorService.ChallengeUsername(req.ChallengeToken, true)
+	if err != nil {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": err.Error()})
+		return
+	}
+	if refuseLockedOutLogin(c, h.loginThrottleService, username) {
+		return
+	}
+
+	userID, codes, err := h.twoFactorService.CompleteLoginSetup(req.ChallengeToken, req.Code)
+	if err != nil {
+		if err != services.ErrInvalidChallenge {
+			recordFailedLogin(c, h.loginThrottleService, username)
+		}
+		c.JSON(http.StatusUnauthorized, gin.H{"error": err.Error()})
+		return
+	}
+
+	recordSuccessfulLogin(h.loginThrottleService, username)
+	h.createSession(c, userID, gin.H{"recovery_codes": codes})
+}
+
+func (h *TwoFactorHandler) GetStatus(c *gin.Context) {
+	userID, _ := c.Get("user_id")
+
+	status, err := h.twoFactorService.GetStatus(userID.(int))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get two-factor status"})
+		return
+	}
+
+	c.JSON(http.StatusOK, status)
+}
+
+func (h *TwoFactorHandler) BeginSetup(c *gin.Context) {
+	userID, _ := c.Get("user_id")
+
+	setup, err := h.twoFactorService.BeginSetup(userID.(int))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, setup)
+}
+
+func (h *TwoFactorHandler) Enable(c *gin.Context) {
+	userID, _ := c.Get("user_id")
+
+	var req TwoFactorCodeRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	codes, err := h.twoFactorService.EnableTwoFactor(userID.(int), req.Code)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"message":        "Two-factor authentication enabled",
+		"recovery_codes": codes,
+	})
+}
+
+func (h *TwoFactorHandler) Disable(c *gin.Context) {
+	userID, _ := c.Get("user_id")
+
+	var req DisableTwoFactorRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	err := h.twoFactorService.DisableTwoFactor(userID.(int), req.Password, req.Code)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Two-factor authentication disabled"})
+}
+
+func (h *TwoFactorHandler) RegenerateRecoveryCodes(c *gin.Context) {
+	userID, _ := c.Get("user_id")
+
+	var req TwoFactorCodeRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	codes, err := h.twoFactorService.RegenerateRecoveryCodes(userID.(int), req.Code)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"recovery_codes": codes})
+}
+
+func (h *TwoFactorHandler) GetPolicy(c *gin.Context) {
+	policy, err := h.twoFactorService.GetPolicy()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get two-factor policy"})
+		return
+	}
+
+	c.JSON(http.StatusOK, policy)
+}
+
+func (h *TwoFactorHandler) UpdatePolicy(c *gin.Context) {
+	userID, _ := c.Get("user_id")
+
+	var req UpdateTwoFactorPolicyRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	policy := models.TwoFactorPolicy{RequireForAdmins: *req.RequireForAdmins}
+	if err := h.twoFactorService.SetPolicy(userID.(int), policy); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	recordAudit(c, h.auditService, "settings.2fa_policy", "setting", "require_2fa_admin", gin.H{"require_for_admins": policy.RequireForAdmins})
+
+	c.JSON(http.StatusOK, gin.H{"message": "Two-factor policy updated"})
+}
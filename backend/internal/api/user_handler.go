@@ -1,29 +1,33 @@
 package api
 
 import (
+	"log"
 	"net/http"
-	"strconv"
 	
 	"github.com/gin-gonic/gin"
-	"ai-doc-system/internal/auth"
 	"ai-doc-system/internal/services"
 )
 
 type UserHandler struct {
-	userService *services.UserService
-	jwtSecret   string
+	userService          *services.UserService
+	sessionService       *services.SessionService
+	twoFactorService     *services.TwoFactorService
+	loginThrottleService *services.LoginThrottleService
 }
 
-func NewUserHandler(userService *services.UserService, jwtSecret string) *UserHandler {
+func NewUserHandler(userService *services.UserService, sessionService *services.SessionService, twoFactorService *services.TwoFactorService, loginThrottleService *services.LoginThrottleService) *UserHandler {
 	return &UserHandler{
-		userService: userService,
-		jwtSecret:   jwtSecret,
+		userService:          userService,
+		sessionService:       sessionService,
+		twoFactorService:     twoFactorService,
+		loginThrottleService: loginThrottleService,
 	}
 }
 
 type RegisterRequest struct {
 	Username string `json:"username" binding:"required,min=3,max=20"`
-	Password string `json:"password" binding:"required,min=6"`
+	Password string `json:"password" binding:"required"` // Checked against the password policy
+	Email    string `json:"email" binding:"omitempty,email,max=255"`
 }
 
 type LoginRequest struct {
@@ -36,6 +40,11 @@ type UpdateProfileRequest struct {
 	Profile string `json:"profile"`
 }
 
+type UpdatePrivacyRequest struct {
+	Discoverable *bool `json:"discoverable" binding:"required"`
+}
+
+
 func (h *UserHandler) Register(c *gin.Context) {
 	var req RegisterRequest
 	if err := c.ShouldBindJSON(&req); err != nil {
@@ -43,21 +52,23 @@ func (h *UserHandler) Register(c *gin.Context) {
 		return
 	}
 	
-	user, err := h.userService.Register(req.Username, req.Password)
+	user, err := h.userService.Register(req.Username, req.Password, req.Email)
 	if err != nil {
 		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
 		return
 	}
 	
-	token, err := auth.GenerateToken(user.ID, user.Username, user.Role, h.jwtSecret)
+	tokens, err := h.sessionService.CreateSession(user, c.Request.UserAgent(), c.ClientIP())
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to generate token"})
 		return
 	}
 	
 	c.JSON(http.StatusCreated, gin.H{
-		"user":  user,
-		"token": token,
+		"user":          user,
+		"token":         tokens.AccessToken,
+		"refresh_token": tokens.RefreshToken,
+		"expires_in":    tokens.ExpiresIn,
 	})
 }
 
@@ -68,21 +79,53 @@ func (h *UserHandler) Login(c *gin.Context) {
 		return
 	}
 	
+	// Locked out usernames and addresses are refused before the password is checked
+	if refuseLockedOutLogin(c, h.loginThrottleService, req.Username) {
+		return
+	}
+	
 	user, err := h.userService.Login(req.Username, req.Password)
-	if err != nil {
+	if err == services.ErrInvalidCredentials {
+		recordFailedLogin(c, h.loginThrottleService, req.Username)
 		c.JSON(http.StatusUnauthorized, gin.H{"error": err.Error()})
 		return
 	}
+	if err != nil {
+		// Refusals the user can act on are explained, other failures are not sent to the client
+		switch err {
+		case services.ErrAccountDisabled, services.ErrPasswordResetRequired, services.ErrDirectoryUnavailable, services.ErrLocalUsernameTaken:
+			c.JSON(http.StatusUnauthorized, gin.H{"error": err.Error()})
+		default:
+			log.Printf("Failed to log in: %v", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to log in"})
+		}
+		return
+	}
 	
-	token, err := auth.GenerateToken(user.ID, user.Username, user.Role, h.jwtSecret)
+	// With 2FA the login continues at /auth/2fa/verify, tokens are only issued there and the
+	// failures are only cleared once the code is right
+	challenge, err := h.twoFactorService.StartLogin(user)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to log in"})
+		return
+	}
+	if challenge != nil {
+		c.JSON(http.StatusOK, challenge)
+		return
+	}
+	
+	tokens, err := h.sessionService.CreateSession(user, c.Request.UserAgent(), c.ClientIP())
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to generate token"})
 		return
 	}
+	recordSuccessfulLogin(h.loginThrottleService, req.Username)
 	
 	c.JSON(http.StatusOK, gin.H{
-		"user":  user,
-		"token": token,
+		"user":          user,
+		"token":         tokens.AccessToken,
+		"refresh_token": tokens.RefreshToken,
+		"expires_in":    tokens.ExpiresIn,
 	})
 }
 
@@ -116,29 +159,20 @@ func (h *UserHandler) UpdateProfile(c *gin.Context) {
 	c.JSON(http.StatusOK, gin.H{"message": "Profile updated successfully"})
 }
 
-func (h *UserHandler) GetAllUsers(c *gin.Context) {
-	users, err := h.userService.GetAllUsers()
-	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get users"})
-		return
-	}
+func (h *UserHandler) UpdatePrivacy(c *gin.Context) {
+	userID, _ := c.Get("user_id")
 	
-	c.JSON(http.StatusOK, gin.H{"users": users})
-}
-
-func (h *UserHandler) GetUserByID(c *gin.Context) {
-	userIDStr := c.Param("id")
-	userID, err := strconv.Atoi(userIDStr)
-	if err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid user ID"})
+	var req UpdatePrivacyRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
 		return
 	}
 	
-	user, err := h.userService.GetUserByID(userID)
+	err := h.userService.SetDiscoverable(userID.(int), *req.Discoverable)
 	if err != nil {
-		c.JSON(http.StatusNotFound, gin.H{"error": "User not found"})
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update privacy settings"})
 		return
 	}
 	
-	c.JSON(http.StatusOK, gin.H{"user": user})
+	c.JSON(http.StatusOK, gin.H{"message": "Privacy settings updated successfully"})
 }
\ No newline at end of file
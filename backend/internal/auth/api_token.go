@@ -0,0 +1,50 @@
+package auth
+
+import (
+	"strings"
+)
+
+// Personal access tokens start with this prefix, which tells them apart from session JWTs
+const APITokenPrefix = "adt_"
+
+// Scopes a personal access token can be granted
+const (
+	ScopeFilesRead  = "files:read"
+	ScopeFilesWrite = "files:write"
+	ScopeShares     = "shares"
+	ScopeMessages   = "messages"
+)
+
+var APITokenScopes = []string{ScopeFilesRead, ScopeFilesWrite, ScopeShares, ScopeMessages}
+
+// APITokenStore resolves personal access tokens to the user they act for
+type APITokenStore interface {
+	AuthenticateAPIToken(token string) (*Claims, error)
+}
+
+func IsAPIToken(token string) bool {
+	return strings.HasPrefix(token, APITokenPrefix)
+}
+
+// ValidScope reports whether scope is one a token can be granted
+func ValidScope(scope string) bool {
+	for _, s := range APITokenScopes {
+		if s == scope {
+			return true
+		}
+	}
+	return false
+}
+
+// HasScope is true for sessions, which may do everything, and for tokens granted the scope
+func (c *Claims) HasScope(scope string) bool {
+	if c.APITokenID == 0 {
+		return true
+	}
+	for _, s := range c.Scopes {
+		if s == scope {
+			return true
+		}
+	}
+	return false
+}
@@ -7,20 +7,33 @@ import (
 	"github.com/golang-jwt/jwt/v4"
 )
 
+// Access tokens are short-lived, clients renew them with their session's refresh token
+const AccessTokenTTL = 15 * time.Minute
+
+// Access tokens carry their own audience, so file tokens and stream tickets signed with the
+// same secret are not accepted in their place
+const accessTokenAudience = "access"
+
 type Claims struct {
-	UserID   int    `json:"user_id"`
-	Username string `json:"username"`
-	Role     string `json:"role"`
+	UserID    int    `json:"user_id"`
+	Username  string `json:"username"`
+	Role      string `json:"role"`
+	SessionID string `json:"sid"`
+	// Set for personal access tokens, which are not JWTs and are limited to their scopes
+	APITokenID int      `json:"-"`
+	Scopes     []string `json:"-"`
 	jwt.RegisteredClaims
 }
 
-func GenerateToken(userID int, username, role, secret string) (string, error) {
+func GenerateToken(userID int, username, role, sessionID, secret string) (string, error) {
 	claims := Claims{
-		UserID:   userID,
-		Username: username,
-		Role:     role,
+		UserID:    userID,
+		Username:  username,
+		Role:      role,
+		SessionID: sessionID,
 		RegisteredClaims: jwt.RegisteredClaims{
-			ExpiresAt: jwt.NewNumericDate(time.Now().Add(24 * time.Hour)),
+			Audience:  jwt.ClaimStrings{accessTokenAudience},
+			ExpiresAt: jwt.NewNumericDate(time.Now().Add(AccessTokenTTL)),
 			IssuedAt:  jwt.NewNumericDate(time.Now()),
 		},
 	}
@@ -38,7 +51,7 @@ func ValidateToken(tokenString, secret string) (*Claims, error) {
 		return nil, err
 	}
 	
-	if claims, ok := token.Claims.(*Claims); ok && token.Valid {
+	if claims, ok := token.Claims.(*Claims); ok && token.Valid && claims.VerifyAudience(accessTokenAudience, true) {
 		return claims, nil
 	}
 	
@@ -7,7 +7,17 @@ import (
 	"github.com/gin-gonic/gin"
 )
 
-func AuthMiddleware(secret string) gin.HandlerFunc {
+// AuthMiddleware accepts session access tokens only
+func AuthMiddleware(authenticator *Authenticator) gin.HandlerFunc {
+	return authMiddleware(authenticator, "")
+}
+
+// ScopedAuthMiddleware also accepts personal access tokens granted the scope
+func ScopedAuthMiddleware(authenticator *Authenticator, scope string) gin.HandlerFunc {
+	return authMiddleware(authenticator, scope)
+}
+
+func authMiddleware(authenticator *Authenticator, scope string) gin.HandlerFunc {
 	return func(c *gin.Context) {
 		authHeader := c.GetHeader("Authorization")
 		if authHeader == "" {
@@ -23,7 +33,18 @@ func AuthMiddleware(secret string) gin.HandlerFunc {
 			return
 		}
 		
-		claims, err := ValidateToken(tokenString, secret)
+		if IsAPIToken(tokenString) && scope == "" {
+			c.JSON(http.StatusForbidden, gin.H{"error": "Personal access tokens cannot be used for this endpoint"})
+			c.Abort()
+			return
+		}
+		
+		claims, err := authenticator.AuthenticateScoped(tokenString, scope)
+		if err == ErrMissingScope {
+			c.JSON(http.StatusForbidden, gin.H{"error": "Token requires the " + scope + " scope"})
+			c.Abort()
+			return
+		}
 		if err != nil {
 			c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid token"})
 			c.Abort()
@@ -33,18 +54,42 @@ func AuthMiddleware(secret string) gin.HandlerFunc {
 		c.Set("user_id", claims.UserID)
 		c.Set("username", claims.Username)
 		c.Set("role", claims.Role)
+		c.Set("session_id", claims.SessionID)
+		c.Set("api_token_id", claims.APITokenID)
+		c.Set("claims", claims)
 		c.Next()
 	}
 }
 
-func AdminMiddleware() gin.HandlerFunc {
+// RequirePermission runs after AuthMiddleware and allows users whose role grants the permission.
+// The role is read from the database, so role changes apply to existing sessions right away.
+func RequirePermission(checker PermissionChecker, permission string) gin.HandlerFunc {
 	return func(c *gin.Context) {
-		role, exists := c.Get("role")
-		if !exists || role != "admin" {
-			c.JSON(http.StatusForbidden, gin.H{"error": "Admin access required"})
+		userID, exists := c.Get("user_id")
+		if !exists {
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "Authentication required"})
+			c.Abort()
+			return
+		}
+		
+		allowed, err := checker.HasPermission(userID.(int), permission)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to check permissions"})
+			c.Abort()
+			return
+		}
+		if !allowed {
+			c.JSON(http.StatusForbidden, gin.H{"error": "Permission " + permission + " required"})
 			c.Abort()
 			return
 		}
 		c.Next()
 	}
-}
\ No newline at end of file
+}
+
+// HasScope reports whether the request may use the scope, for handlers that need more than their route's scope
+func HasScope(c *gin.Context, scope string) bool {
+	value, exists := c.Get("claims")
+	claims, ok := value.(*Claims)
+	return exists && ok && claims.HasScope(scope)
+}
@@ -0,0 +1,65 @@
+package auth
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+const testSecret = "test-secret"
+
+type activeSessions struct{}
+
+func (activeSessions) IsSessionActive(sessionID string, userID int) (bool, error) {
+	return true, nil
+}
+
+type noAPITokens struct{}
+
+func (noAPITokens) AuthenticateAPIToken(token string) (*Claims, error) {
+	return nil, errors.New("no personal access tokens")
+}
+
+func authStatus(t *testing.T, token string) int {
+	t.Helper()
+	gin.SetMode(gin.TestMode)
+	router := gin.New()
+	router.GET("/", AuthMiddleware(NewAuthenticator(testSecret, activeSessions{}, noAPITokens{})), func(c *gin.Context) {
+		c.Status(http.StatusOK)
+	})
+
+	req := httptest.NewRequest(http.MethodGet, "/", nil)
+	req.Header.Set("Authorization", "Bearer "+token)
+	rec := httptest.NewRecorder()
+	router.ServeHTTP(rec, req)
+	return rec.Code
+}
+
+func TestAuthMiddlewareAcceptsOnlyAccessTokens(t *testing.T) {
+	accessToken, err := GenerateToken(1, "alice", "user", "session-1", testSecret)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if code := authStatus(t, accessToken); code != http.StatusOK {
+		t.Fatalf("access token: status = %d, want %d", code, http.StatusOK)
+	}
+
+	// Both carry user_id, and the stream ticket a session ID too, but neither is an access token
+	streamTicket, _, err := GenerateStreamTicket(1, "session-1", 30*time.Second, testSecret)
+	if err != nil {
+		t.Fatal(err)
+	}
+	fileToken, _, err := GenerateFileToken(7, 1, FileActionDownload, 1, false, time.Minute, testSecret)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for name, token := range map[string]string{"stream ticket": streamTicket, "file token": fileToken} {
+		if code := authStatus(t, token); code != http.StatusUnauthorized {
+			t.Errorf("%s: status = %d, want %d", name, code, http.StatusUnauthorized)
+		}
+	}
+}
@@ -0,0 +1,46 @@
+package auth
+
+// Permissions a role can be granted. Roles and their permissions are stored in the
+// database; these are the names the routes check.
+const (
+	PermUsersRead        = "users:read"
+	PermUsersManage      = "users:manage"
+	PermRolesManage      = "roles:manage"
+	PermFilesReadAll     = "files:read_all"
+	PermFilesTransferAll = "files:transfer_all"
+	PermQuotasManage     = "quotas:manage"
+	PermAuditRead        = "audit:read"
+	PermSettingsManage   = "settings:manage"
+)
+
+// Permission describes a permission for the role editor
+type Permission struct {
+	Name        string `json:"name"`
+	Description string `json:"description"`
+}
+
+var Permissions = []Permission{
+	{PermUsersRead, "View users, their storage, shares and sessions"},
+	{PermUsersManage, "Disable, delete and log out users, force password resets and lift login lockouts"},
+	{PermRolesManage, "Create and edit roles and assign them to users"},
+	{PermFilesReadAll, "List the files of every user"},
+	{PermFilesTransferAll, "Transfer files of any user to another user"},
+	{PermQuotasManage, "Change users' storage quotas"},
+	{PermAuditRead, "Read the audit log"},
+	{PermSettingsManage, "Change the two-factor policy, sync the directory and test email delivery"},
+}
+
+// PermissionChecker looks up whether a user's current role grants a permission
+type PermissionChecker interface {
+	HasPermission(userID int, permission string) (bool, error)
+}
+
+// ValidPermission reports whether permission is one a role can be granted
+func ValidPermission(permission string) bool {
+	for _, p := range Permissions {
+		if p.Name == permission {
+			return true
+		}
+	}
+	return false
+}
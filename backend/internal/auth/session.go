@@ -0,0 +1,61 @@
+package auth
+
+import (
+	"errors"
+)
+
+// ErrMissingScope is returned for personal access tokens lacking the scope a route requires
+var ErrMissingScope = errors.New("token lacks the required scope")
+
+// SessionStore reports whether the server-side session of an access token is still active
+type SessionStore interface {
+	IsSessionActive(sessionID string, userID int) (bool, error)
+}
+
+// Authenticator validates access tokens and rejects those of revoked sessions
+type Authenticator struct {
+	secret    string
+	sessions  SessionStore
+	apiTokens APITokenStore
+}
+
+func NewAuthenticator(secret string, sessions SessionStore, apiTokens APITokenStore) *Authenticator {
+	return &Authenticator{secret: secret, sessions: sessions, apiTokens: apiTokens}
+}
+
+// Authenticate accepts session access tokens only
+func (a *Authenticator) Authenticate(tokenString string) (*Claims, error) {
+	claims, err := ValidateToken(tokenString, a.secret)
+	if err != nil {
+		return nil, err
+	}
+	if claims.SessionID == "" {
+		return nil, errors.New("token has no session")
+	}
+
+	active, err := a.sessions.IsSessionActive(claims.SessionID, claims.UserID)
+	if err != nil {
+		return nil, err
+	}
+	if !active {
+		return nil, errors.New("session revoked")
+	}
+
+	return claims, nil
+}
+
+// AuthenticateScoped accepts session access tokens, and personal access tokens granted the scope
+func (a *Authenticator) AuthenticateScoped(tokenString, scope string) (*Claims, error) {
+	if !IsAPIToken(tokenString) {
+		return a.Authenticate(tokenString)
+	}
+
+	claims, err := a.apiTokens.AuthenticateAPIToken(tokenString)
+	if err != nil {
+		return nil, err
+	}
+	if !claims.HasScope(scope) {
+		return nil, ErrMissingScope
+	}
+	return claims, nil
+}
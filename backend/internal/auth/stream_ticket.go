@@ -0,0 +1,55 @@
+package auth
+
+import (
+	"errors"
+	"time"
+
+	"github.com/golang-jwt/jwt/v4"
+	"github.com/google/uuid"
+)
+
+// Stream tickets carry their own audience so they are never mistaken for session tokens
+const streamTicketAudience = "stream"
+
+// StreamTicketClaims let one WebSocket or event stream of a session be opened. Browsers
+// cannot set headers on those requests, so the ticket goes in the URL in place of the
+// session's access token.
+type StreamTicketClaims struct {
+	UserID    int    `json:"user_id"`
+	SessionID string `json:"sid"`
+	jwt.RegisteredClaims
+}
+
+func GenerateStreamTicket(userID int, sessionID string, ttl time.Duration, secret string) (string, time.Time, error) {
+	expiresAt := time.Now().Add(ttl)
+	claims := StreamTicketClaims{
+		UserID:    userID,
+		SessionID: sessionID,
+		RegisteredClaims: jwt.RegisteredClaims{
+			ID:        uuid.New().String(),
+			Audience:  jwt.ClaimStrings{streamTicketAudience},
+			ExpiresAt: jwt.NewNumericDate(expiresAt),
+			IssuedAt:  jwt.NewNumericDate(time.Now()),
+		},
+	}
+
+	token := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
+	signed, err := token.SignedString([]byte(secret))
+	return signed, expiresAt, err
+}
+
+func ValidateStreamTicket(tokenString, secret string) (*StreamTicketClaims, error) {
+	token, err := jwt.ParseWithClaims(tokenString, &StreamTicketClaims{}, func(token *jwt.Token) (interface{}, error) {
+		return []byte(secret), nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	claims, ok := token.Claims.(*StreamTicketClaims)
+	if !ok || !token.Valid || !claims.VerifyAudience(streamTicketAudience, true) {
+		return nil, errors.New("invalid stream ticket")
+	}
+
+	return claims, nil
+}
@@ -2,6 +2,7 @@ package config
 
 import (
 	"os"
+	"strconv"
 )
 
 type Config struct {
@@ -15,9 +16,56 @@ type Config struct {
 	RedisPort    string
 	JWTSecret    string
 	UploadPath   string
+	AppURL       string // Public address of the frontend, used for links in emails
+	SMTPHost     string // Email is disabled when empty
+	SMTPPort     string
+	SMTPUsername string
+	SMTPPassword string
+	SMTPFrom     string
+
+	// Password policy for registration, password changes and resets
+	PasswordMinLength        int
+	PasswordRequireMixedCase bool
+	PasswordRequireDigit     bool
+	PasswordRequireSymbol    bool
+
+	AccountDeletionGraceDays int // Days before an account the user asked to delete is deleted
+
+	// Addresses or CIDR ranges of the reverse proxies in front of the backend, comma
+	// separated. Only they may set the client address with X-Forwarded-For; without any the
+	// header is ignored and the connection's address is used.
+	TrustedProxies string
+
+	// OpenID Connect single sign-on, disabled when OIDCIssuerURL is empty
+	OIDCIssuerURL     string
+	OIDCClientID      string
+	OIDCClientSecret  string
+	OIDCRedirectURL   string // Defaults to the callback route under AppURL
+	OIDCScopes        string
+	OIDCUsernameClaim string
+	OIDCGroupsClaim   string
+	OIDCRoleMapping   string // Comma separated group=role rules, first match wins
+	OIDCDefaultRole   string
+	OIDCAutoProvision bool
+
+	// LDAP / Active Directory login, disabled when LDAPURL is empty
+	LDAPURL                string
+	LDAPStartTLS           bool
+	LDAPInsecureSkipVerify bool
+	LDAPBindDN             string
+	LDAPBindPassword       string
+	LDAPBaseDN             string
+	LDAPUserFilter         string // %s is replaced with the escaped username
+	LDAPUsernameAttribute  string
+	LDAPEmailAttribute     string
+	LDAPGroupAttribute     string
+	LDAPRoleMapping        string // Semicolon separated groupDN=role rules, first match wins
+	LDAPDefaultRole        string
+	LDAPSyncInterval       int // Minutes between syncs, 0 turns the sync off
 }
 
 func Load() *Config {
+	appURL := getEnv("APP_URL", "http://localhost")
 	return &Config{
 		Port:         getEnv("PORT", "8080"),
 		DBHost:       getEnv("DB_HOST", "localhost"),
@@ -29,6 +77,46 @@ func Load() *Config {
 		RedisPort:    getEnv("REDIS_PORT", "6379"),
 		JWTSecret:    getEnv("JWT_SECRET", "your_jwt_secret_key_here"),
 		UploadPath:   getEnv("UPLOAD_PATH", "./storage/uploads"),
+		AppURL:       appURL,
+		SMTPHost:     getEnv("SMTP_HOST", ""),
+		SMTPPort:     getEnv("SMTP_PORT", "587"),
+		SMTPUsername: getEnv("SMTP_USERNAME", ""),
+		SMTPPassword: getEnv("SMTP_PASSWORD", ""),
+		SMTPFrom:     getEnv("SMTP_FROM", "AI Document System <noreply@localhost>"),
+
+		PasswordMinLength:        getEnvInt("PASSWORD_MIN_LENGTH", 8),
+		PasswordRequireMixedCase: getEnvBool("PASSWORD_REQUIRE_MIXED_CASE", false),
+		PasswordRequireDigit:     getEnvBool("PASSWORD_REQUIRE_DIGIT", true),
+		PasswordRequireSymbol:    getEnvBool("PASSWORD_REQUIRE_SYMBOL", false),
+
+		AccountDeletionGraceDays: getEnvInt("ACCOUNT_DELETION_GRACE_DAYS", 14),
+
+		TrustedProxies: getEnv("TRUSTED_PROXIES", ""),
+
+		OIDCIssuerURL:     getEnv("OIDC_ISSUER_URL", ""),
+		OIDCClientID:      getEnv("OIDC_CLIENT_ID", ""),
+		OIDCClientSecret:  getEnv("OIDC_CLIENT_SECRET", ""),
+		OIDCRedirectURL:   getEnv("OIDC_REDIRECT_URL", appURL+"/api/auth/oidc/callback"),
+		OIDCScopes:        getEnv("OIDC_SCOPES", "openid profile email groups"),
+		OIDCUsernameClaim: getEnv("OIDC_USERNAME_CLAIM", "preferred_username"),
+		OIDCGroupsClaim:   getEnv("OIDC_GROUPS_CLAIM", "groups"),
+		OIDCRoleMapping:   getEnv("OIDC_ROLE_MAPPING", ""),
+		OIDCDefaultRole:   getEnv("OIDC_DEFAULT_ROLE", "user"),
+		OIDCAutoProvision: getEnvBool("OIDC_AUTO_PROVISION", true),
+
+		LDAPURL:                getEnv("LDAP_URL", ""),
+		LDAPStartTLS:           getEnvBool("LDAP_START_TLS", false),
+		LDAPInsecureSkipVerify: getEnvBool("LDAP_INSECURE_SKIP_VERIFY", false),
+		LDAPBindDN:             getEnv("LDAP_BIND_DN", ""),
+		LDAPBindPassword:       getEnv("LDAP_BIND_PASSWORD", ""),
+		LDAPBaseDN:             getEnv("LDAP_BASE_DN", ""),
+		LDAPUserFilter:         getEnv("LDAP_USER_FILTER", "(&(objectClass=person)(uid=%s))"),
+		LDAPUsernameAttribute:  getEnv("LDAP_USERNAME_ATTRIBUTE", "uid"),
+		LDAPEmailAttribute:     getEnv("LDAP_EMAIL_ATTRIBUTE", "mail"),
+		LDAPGroupAttribute:     getEnv("LDAP_GROUP_ATTRIBUTE", "memberOf"),
+		LDAPRoleMapping:        getEnv("LDAP_ROLE_MAPPING", ""),
+		LDAPDefaultRole:        getEnv("LDAP_DEFAULT_ROLE", "user"),
+		LDAPSyncInterval:       getEnvInt("LDAP_SYNC_INTERVAL", 60),
 	}
 }
 
@@ -37,4 +125,17 @@ func getEnv(key, defaultValue string) string {
 		return value
 	}
 	return defaultValue
-}
\ No newline at end of file
+}
+func getEnvInt(key string, defaultValue int) int {
+	if value, err := strconv.Atoi(os.Getenv(key)); err == nil {
+		return value
+	}
+	return defaultValue
+}
+
+func getEnvBool(key string, defaultValue bool) bool {
+	if value, err := strconv.ParseBool(os.Getenv(key)); err == nil {
+		return value
+	}
+	return defaultValue
+}
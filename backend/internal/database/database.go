@@ -12,11 +12,14 @@ import (
 	"ai-doc-system/internal/config"
 )
 
-func Connect(cfg *config.Config) (*sql.DB, error) {
-	dsn := fmt.Sprintf("host=%s port=%s user=%s password=%s dbname=%s sslmode=disable",
+// DSN builds the Postgres connection string from configuration
+func DSN(cfg *config.Config) string {
+	return fmt.Sprintf("host=%s port=%s user=%s password=%s dbname=%s sslmode=disable",
 		cfg.DBHost, cfg.DBPort, cfg.DBUser, cfg.DBPassword, cfg.DBName)
-	
-	db, err := sql.Open("postgres", dsn)
+}
+
+func Connect(cfg *config.Config) (*sql.DB, error) {
+	db, err := sql.Open("postgres", DSN(cfg))
 	if err != nil {
 		return nil, err
 	}
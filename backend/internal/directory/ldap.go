@@ -0,0 +1,167 @@
+package directory
+
+import (
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"net"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/go-ldap/ldap/v3"
+)
+
+// ErrInvalidCredentials is returned for unknown users and wrong passwords alike
+var ErrInvalidCredentials = errors.New("invalid directory credentials")
+
+// Config describes how users are found in an LDAP or Active Directory server
+type Config struct {
+	URL                string // ldap://host:389 or ldaps://host:636
+	StartTLS           bool
+	InsecureSkipVerify bool
+	BindDN             string // Service account used for searches
+	BindPassword       string
+	BaseDN             string
+	UserFilter         string // %s is replaced with the escaped username, e.g. (&(objectClass=person)(uid=%s))
+	UsernameAttribute  string
+	EmailAttribute     string
+	GroupAttribute     string // Group DNs of the user, memberOf on most servers
+	Timeout            time.Duration
+}
+
+// Entry is a user as found in the directory
+type Entry struct {
+	DN       string
+	Username string
+	Email    string
+	Groups   []string
+}
+
+// Directory authenticates users with a search followed by a bind as the user's DN.
+// Every call opens its own connection, so there is no pool to keep healthy.
+type Directory struct {
+	config Config
+}
+
+func New(config Config) *Directory {
+	if config.Timeout == 0 {
+		config.Timeout = 10 * time.Second
+	}
+	return &Directory{config: config}
+}
+
+func (d *Directory) connect() (*ldap.Conn, error) {
+	tlsConfig := &tls.Config{InsecureSkipVerify: d.config.InsecureSkipVerify}
+	conn, err := ldap.DialURL(d.config.URL,
+		ldap.DialWithDialer(&net.Dialer{Timeout: d.config.Timeout}),
+		ldap.DialWithTLSConfig(tlsConfig))
+	if err != nil {
+		return nil, fmt.Errorf("ldap connect: %w", err)
+	}
+	conn.SetTimeout(d.config.Timeout)
+
+	if d.config.StartTLS {
+		if u, err := url.Parse(d.config.URL); err == nil {
+			tlsConfig.ServerName = u.Hostname()
+		}
+		if err := conn.StartTLS(tlsConfig); err != nil {
+			conn.Close()
+			return nil, fmt.Errorf("ldap starttls: %w", err)
+		}
+	}
+
+	if err := d.serviceBind(conn); err != nil {
+		conn.Close()
+		return nil, err
+	}
+	return conn, nil
+}
+
+func (d *Directory) serviceBind(conn *ldap.Conn) error {
+	if d.config.BindDN == "" {
+		return nil
+	}
+	if err := conn.Bind(d.config.BindDN, d.config.BindPassword); err != nil {
+		return fmt.Errorf("ldap service bind: %w", err)
+	}
+	return nil
+}
+
+func (d *Directory) attributes() []string {
+	return []string{d.config.UsernameAttribute, d.config.EmailAttribute, d.config.GroupAttribute}
+}
+
+func (d *Directory) entry(e *ldap.Entry) Entry {
+	return Entry{
+		DN:       e.DN,
+		Username: e.GetEqualFoldAttributeValue(d.config.UsernameAttribute),
+		Email:    e.GetEqualFoldAttributeValue(d.config.EmailAttribute),
+		Groups:   e.GetEqualFoldAttributeValues(d.config.GroupAttribute),
+	}
+}
+
+// Authenticate finds the user with the configured filter and binds with the password
+func (d *Directory) Authenticate(username, password string) (*Entry, error) {
+	// An empty password would be an unauthenticated bind, which many servers accept
+	if username == "" || password == "" {
+		return nil, ErrInvalidCredentials
+	}
+
+	conn, err := d.connect()
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+
+	filter := strings.ReplaceAll(d.config.UserFilter, "%s", ldap.EscapeFilter(username))
+	result, err := conn.Search(ldap.NewSearchRequest(d.config.BaseDN,
+		ldap.ScopeWholeSubtree, ldap.NeverDerefAliases, 2, int(d.config.Timeout.Seconds()), false,
+		filter, d.attributes(), nil))
+	if err != nil {
+		return nil, fmt.Errorf("ldap search: %w", err)
+	}
+	if len(result.Entries) != 1 {
+		return nil, ErrInvalidCredentials
+	}
+	found := result.Entries[0]
+
+	if err := conn.Bind(found.DN, password); err != nil {
+		if ldap.IsErrorWithCode(err, ldap.LDAPResultInvalidCredentials) {
+			return nil, ErrInvalidCredentials
+		}
+		return nil, fmt.Errorf("ldap bind: %w", err)
+	}
+
+	entry := d.entry(found)
+	if entry.Username == "" {
+		entry.Username = username
+	}
+	return &entry, nil
+}
+
+// Users lists everyone the user filter matches, for synchronizing accounts
+func (d *Directory) Users() ([]Entry, error) {
+	conn, err := d.connect()
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+
+	filter := strings.ReplaceAll(d.config.UserFilter, "%s", "*")
+	result, err := conn.SearchWithPaging(ldap.NewSearchRequest(d.config.BaseDN,
+		ldap.ScopeWholeSubtree, ldap.NeverDerefAliases, 0, 0, false,
+		filter, d.attributes(), nil), 500)
+	if err != nil {
+		return nil, fmt.Errorf("ldap search: %w", err)
+	}
+
+	entries := make([]Entry, 0, len(result.Entries))
+	for _, e := range result.Entries {
+		entry := d.entry(e)
+		if entry.Username != "" {
+			entries = append(entries, entry)
+		}
+	}
+	return entries, nil
+}
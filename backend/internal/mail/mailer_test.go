@@ -0,0 +1,98 @@
+package mail
+
+import (
+	"strings"
+	"testing"
+
+	"ai-doc-system/internal/mail/mailtest"
+)
+
+func newTestMailer(t *testing.T) (*Mailer, *mailtest.Server) {
+	t.Helper()
+	server, err := mailtest.NewServer()
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { server.Close() })
+
+	mailer, err := NewMailer(server.Host(), server.Port(), "", "", "AI Doc System <noreply@example.com>")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return mailer, server
+}
+
+func TestSendDeliversMessage(t *testing.T) {
+	mailer, server := newTestMailer(t)
+
+	long := strings.Repeat("0123456789", 12) // Longer than a quoted-printable line
+	body := "Grüße, Zoë\n\n" + long + "\nlast line = done\n"
+	if err := mailer.Send("Zoë Example <zoe@example.com>", "Neue Datei für Zoë", body); err != nil {
+		t.Fatal(err)
+	}
+
+	messages := server.Messages()
+	if len(messages) != 1 {
+		t.Fatalf("received %d messages, want 1", len(messages))
+	}
+	message := messages[0]
+
+	if message.From != "noreply@example.com" {
+		t.Errorf("envelope sender = %q, want noreply@example.com", message.From)
+	}
+	if len(message.To) != 1 || message.To[0] != "zoe@example.com" {
+		t.Errorf("envelope recipients = %v, want [zoe@example.com]", message.To)
+	}
+	if message.Subject != "Neue Datei für Zoë" {
+		t.Errorf("subject = %q", message.Subject)
+	}
+	if message.Body != body {
+		t.Errorf("body = %q, want %q", message.Body, body)
+	}
+	if from := message.Header.Get("From"); !strings.Contains(from, "noreply@example.com") || !strings.Contains(from, "AI Doc System") {
+		t.Errorf("From header = %q", from)
+	}
+	if got := message.Header.Get("Content-Type"); got != "text/plain; charset=utf-8" {
+		t.Errorf("Content-Type = %q", got)
+	}
+	if message.Header.Get("Date") == "" || message.Header.Get("Message-ID") == "" {
+		t.Error("Date or Message-ID header missing")
+	}
+	for _, line := range strings.Split(string(message.Raw), "\n") {
+		if len(line) > 78 {
+			t.Errorf("raw line longer than 78 characters: %q", line)
+		}
+	}
+}
+
+func TestSendRejectsInvalidRecipient(t *testing.T) {
+	mailer, server := newTestMailer(t)
+
+	if err := mailer.Send("not an address", "Subject", "Body"); err == nil {
+		t.Error("send to an invalid address succeeded")
+	}
+	if len(server.Messages()) != 0 {
+		t.Error("a message was delivered")
+	}
+}
+
+func TestSendReportsRefusal(t *testing.T) {
+	mailer, server := newTestMailer(t)
+	server.Refuse(1)
+
+	if err := mailer.Send("user@example.com", "Subject", "Body"); err == nil {
+		t.Fatal("send succeeded although the server refused the message")
+	}
+	if err := mailer.Send("user@example.com", "Subject", "Body"); err != nil {
+		t.Fatalf("second send: %v", err)
+	}
+	if len(server.Messages()) != 1 {
+		t.Errorf("received %d messages, want 1", len(server.Messages()))
+	}
+}
+
+func TestNewMailerRejectsInvalidSender(t *testing.T) {
+	if _, err := NewMailer("localhost", "25", "", "", "not an address"); err == nil {
+		t.Error("invalid sender address accepted")
+	}
+}
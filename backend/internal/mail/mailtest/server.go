@@ -0,0 +1,200 @@
+// Package mailtest is an in-process SMTP server for tests. It accepts every message without
+// authentication or TLS and keeps it in memory; it can also refuse messages to test retries.
+package mailtest
+
+import (
+	"io"
+	"mime"
+	"mime/quotedprintable"
+	"net"
+	"net/mail"
+	"net/textproto"
+	"strings"
+	"sync"
+)
+
+// Message is a received email with its subject and body decoded
+type Message struct {
+	From    string   // Envelope sender
+	To      []string // Envelope recipients
+	Header  mail.Header
+	Subject string
+	Body    string // Line endings are "\n"
+	Raw     []byte
+}
+
+type Server struct {
+	listener net.Listener
+
+	mu       sync.Mutex
+	messages []Message
+	refuse   int
+	wg       sync.WaitGroup
+}
+
+// NewServer listens on a free port on the loopback interface
+func NewServer() (*Server, error) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		return nil, err
+	}
+
+	s := &Server{listener: listener}
+	s.wg.Add(1)
+	go s.serve()
+	return s, nil
+}
+
+// Host and Port to configure the mailer with
+func (s *Server) Host() string {
+	host, _, _ := net.SplitHostPort(s.listener.Addr().String())
+	return host
+}
+
+func (s *Server) Port() string {
+	_, port, _ := net.SplitHostPort(s.listener.Addr().String())
+	return port
+}
+
+// Messages received so far, oldest first
+func (s *Server) Messages() []Message {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]Message(nil), s.messages...)
+}
+
+// Refuse the next n messages with a temporary failure
+func (s *Server) Refuse(n int) {
+	s.mu.Lock()
+	s.refuse = n
+	s.mu.Unlock()
+}
+
+// Close stops listening and waits for open connections to end
+func (s *Server) Close() error {
+	err := s.listener.Close()
+	s.wg.Wait()
+	return err
+}
+
+func (s *Server) serve() {
+	defer s.wg.Done()
+	for {
+		conn, err := s.listener.Accept()
+		if err != nil {
+			return
+		}
+		s.wg.Add(1)
+		go func() {
+			defer s.wg.Done()
+			defer conn.Close()
+			s.handle(textproto.NewConn(conn))
+		}()
+	}
+}
+
+// One SMTP session: just enough of RFC 5321 for net/smtp
+func (s *Server) handle(conn *textproto.Conn) {
+	conn.PrintfLine("220 mailtest ESMTP")
+
+	var from string
+	var to []string
+	for {
+		line, err := conn.ReadLine()
+		if err != nil {
+			return
+		}
+		verb, arg := line, ""
+		if i := strings.IndexByte(line, ' '); i >= 0 {
+			verb, arg = line[:i], line[i+1:]
+		}
+
+		switch strings.ToUpper(verb) {
+		case "EHLO", "HELO":
+			conn.PrintfLine("250 mailtest")
+		case "MAIL":
+			from, to = address(arg), nil
+			conn.PrintfLine("250 OK")
+		case "RCPT":
+			to = append(to, address(arg))
+			conn.PrintfLine("250 OK")
+		case "DATA":
+			conn.PrintfLine("354 End data with <CR><LF>.<CR><LF>")
+			raw, err := conn.ReadDotBytes()
+			if err != nil {
+				return
+			}
+			if s.refused() {
+				conn.PrintfLine("451 Try again later")
+				continue
+			}
+			message, err := parse(from, to, raw)
+			if err != nil {
+				conn.PrintfLine("554 %v", err)
+				continue
+			}
+			s.mu.Lock()
+			s.messages = append(s.messages, message)
+			s.mu.Unlock()
+			conn.PrintfLine("250 OK")
+		case "RSET", "NOOP":
+			conn.PrintfLine("250 OK")
+		case "QUIT":
+			conn.PrintfLine("221 Bye")
+			return
+		default:
+			conn.PrintfLine("502 Command not implemented")
+		}
+	}
+}
+
+func (s *Server) refused() bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.refuse > 0 {
+		s.refuse--
+		return true
+	}
+	return false
+}
+
+// Address of a "FROM:<a@b>" or "TO:<a@b>" argument
+func address(arg string) string {
+	if i := strings.IndexByte(arg, '<'); i >= 0 {
+		arg = arg[i+1:]
+	}
+	if i := strings.IndexByte(arg, '>'); i >= 0 {
+		arg = arg[:i]
+	}
+	return arg
+}
+
+func parse(from string, to []string, raw []byte) (Message, error) {
+	parsed, err := mail.ReadMessage(strings.NewReader(string(raw)))
+	if err != nil {
+		return Message{}, err
+	}
+
+	var body io.Reader = parsed.Body
+	if strings.EqualFold(parsed.Header.Get("Content-Transfer-Encoding"), "quoted-printable") {
+		body = quotedprintable.NewReader(body)
+	}
+	decoded, err := io.ReadAll(body)
+	if err != nil {
+		return Message{}, err
+	}
+
+	subject, err := new(mime.WordDecoder).DecodeHeader(parsed.Header.Get("Subject"))
+	if err != nil {
+		return Message{}, err
+	}
+
+	return Message{
+		From:    from,
+		To:      to,
+		Header:  parsed.Header,
+		Subject: subject,
+		Body:    strings.ReplaceAll(string(decoded), "\r\n", "\n"),
+		Raw:     raw,
+	}, nil
+}
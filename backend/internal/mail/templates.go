@@ -0,0 +1,36 @@
+package mail
+
+import (
+	"bytes"
+	"embed"
+	"strings"
+	"text/template"
+)
+
+//go:embed templates/*.tmpl
+var templateFiles embed.FS
+
+// Each template defines a "subject" and a "body" block
+var templates = template.Must(template.New("").Funcs(template.FuncMap{
+	"truncate": truncate,
+}).ParseFS(templateFiles, "templates/*.tmpl"))
+
+// Render the subject and body of the named template, e.g. "friend_request"
+func Render(name string, data interface{}) (string, string, error) {
+	var subject, body bytes.Buffer
+	if err := templates.ExecuteTemplate(&subject, name+"_subject", data); err != nil {
+		return "", "", err
+	}
+	if err := templates.ExecuteTemplate(&body, name+"_body", data); err != nil {
+		return "", "", err
+	}
+	return strings.TrimSpace(subject.String()), strings.TrimLeft(body.String(), "\n"), nil
+}
+
+func truncate(length int, value string) string {
+	runes := []rune(value)
+	if len(runes) <= length {
+		return value
+	}
+	return string(runes[:length]) + "..."
+}
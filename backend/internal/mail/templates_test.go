@@ -0,0 +1,71 @@
+package mail
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestRenderTrimsSubjectAndBody(t *testing.T) {
+	subject, body, err := Render("password_reset", map[string]string{
+		"Username":  "alice",
+		"Link":      "https://app.example/reset-password?token=abc",
+		"ExpiresIn": "60 minutes",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if subject != "Reset your password" {
+		t.Errorf("subject = %q", subject)
+	}
+	if !strings.HasPrefix(body, "Hi alice,\n") {
+		t.Errorf("body does not start with the greeting: %q", body)
+	}
+	if !strings.Contains(body, "\nhttps://app.example/reset-password?token=abc\n") {
+		t.Errorf("body does not contain the link on its own line: %q", body)
+	}
+	if !strings.Contains(body, "expires in 60 minutes") {
+		t.Errorf("body does not contain the expiry: %q", body)
+	}
+}
+
+func TestRenderUnknownTemplate(t *testing.T) {
+	if _, _, err := Render("missing", nil); err == nil {
+		t.Error("rendering an unknown template succeeded")
+	}
+}
+
+func TestEveryTemplateHasSubjectAndBody(t *testing.T) {
+	names := map[string]bool{}
+	for _, tmpl := range templates.Templates() {
+		name := tmpl.Name()
+		if strings.HasSuffix(name, "_subject") {
+			names[strings.TrimSuffix(name, "_subject")] = true
+		}
+	}
+	if len(names) == 0 {
+		t.Fatal("no templates found")
+	}
+	for name := range names {
+		if templates.Lookup(name+"_body") == nil {
+			t.Errorf("template %q has a subject but no body", name)
+		}
+	}
+}
+
+func TestTruncate(t *testing.T) {
+	tests := []struct {
+		length int
+		value  string
+		want   string
+	}{
+		{5, "short", "short"},
+		{5, "longer text", "longe..."},
+		{3, "äöüß", "äöü..."}, // Counts runes, not bytes
+		{3, "", ""},
+	}
+	for _, tt := range tests {
+		if got := truncate(tt.length, tt.value); got != tt.want {
+			t.Errorf("truncate(%d, %q) = %q, want %q", tt.length, tt.value, got, tt.want)
+		}
+	}
+}
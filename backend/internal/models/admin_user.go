@@ -0,0 +1,24 @@
+package models
+
+import (
+	"time"
+)
+
+// AdminUser is a user with the account details admins manage
+type AdminUser struct {
+	User
+	AuthSource            string     `json:"auth_source"` // local, ldap
+	TOTPEnabled           bool       `json:"totp_enabled"`
+	DisabledAt            *time.Time `json:"disabled_at"`
+	DisabledReason        string     `json:"disabled_reason,omitempty"` // admin, directory
+	PasswordResetRequired bool       `json:"password_reset_required"`
+	DeletionScheduledAt   *time.Time `json:"deletion_scheduled_at"`
+	StorageUsed           int64      `json:"storage_used"`
+	LastActiveAt          *time.Time `json:"last_active_at"` // Last use of any of the user's sessions
+}
+
+// AdminUserPage is a page of users matching the admin's filters
+type AdminUserPage struct {
+	Users []AdminUser `json:"users"`
+	Total int         `json:"total"`
+}
@@ -0,0 +1,43 @@
+package models
+
+import (
+	"time"
+)
+
+type Conversation struct {
+	ID          int                  `json:"id" db:"id"`
+	Type        string               `json:"type" db:"type"` // direct, group
+	Name        string               `json:"name" db:"name"`
+	CreatedBy   *int                 `json:"created_by" db:"created_by"`
+	Members     []ConversationMember `json:"members,omitempty" db:"-"`
+	UnreadCount int                  `json:"unread_count" db:"-"` // For the requesting member
+	CreatedAt   time.Time            `json:"created_at" db:"created_at"`
+	UpdatedAt   time.Time            `json:"updated_at" db:"updated_at"`
+}
+
+type ConversationMember struct {
+	ConversationID    int        `json:"conversation_id" db:"conversation_id"`
+	UserID            int        `json:"user_id" db:"user_id"`
+	Username          string     `json:"username" db:"username"`
+	Avatar            NullString `json:"avatar" db:"avatar"`
+	Role              string     `json:"role" db:"role"` // owner, admin, member
+	LastReadMessageID int        `json:"last_read_message_id" db:"last_read_message_id"` // Read receipts in group conversations
+	JoinedAt          time.Time  `json:"joined_at" db:"joined_at"`
+}
+
+type MessageSearchHit struct {
+	Message          Message   `json:"message"`
+	ConversationType string    `json:"conversation_type"`
+	ConversationName string    `json:"conversation_name"`
+	FriendID         *int      `json:"friend_id"`      // Other member of a direct conversation
+	Highlight        string    `json:"highlight"` // Matching fragment, HTML-escaped, with <mark> tags
+	Before           []Message `json:"before"`    // Surrounding messages, oldest first
+	After            []Message `json:"after"`
+}
+
+// Page of message history, newest first
+type MessagePage struct {
+	Messages   []Message `json:"messages"`
+	NextCursor string    `json:"next_cursor,omitempty"` // Older messages
+	PrevCursor string    `json:"prev_cursor,omitempty"` // Newer messages
+}
@@ -0,0 +1,23 @@
+package models
+
+import (
+	"time"
+)
+
+// DataExport is an archive of everything stored about a user, generated in the background
+type DataExport struct {
+	ID          int        `json:"id" db:"id"`
+	Status      string     `json:"status" db:"status"` // pending, running, ready, failed, expired
+	FileSize    *int64     `json:"file_size" db:"file_size"`
+	Error       string     `json:"error,omitempty" db:"error"`
+	CompletedAt *time.Time `json:"completed_at" db:"completed_at"`
+	ExpiresAt   *time.Time `json:"expires_at" db:"expires_at"` // Download is removed after this
+	CreatedAt   time.Time  `json:"created_at" db:"created_at"`
+}
+
+// AccountDeletionStatus tells whether the user's account is scheduled for deletion
+type AccountDeletionStatus struct {
+	Scheduled   bool       `json:"scheduled"`
+	ScheduledAt *time.Time `json:"scheduled_at"` // Account is deleted once this has passed
+	GraceDays   int        `json:"grace_days"`
+}
@@ -12,10 +12,18 @@ type File struct {
 	FilePath     string    `json:"file_path" db:"file_path"`
 	FileSize     int64     `json:"file_size" db:"file_size"`
 	MimeType     string    `json:"mime_type" db:"mime_type"`
+	Folder       string    `json:"folder" db:"folder"` // Slash separated path, empty for root
+	OwnerName    string    `json:"owner_username,omitempty" db:"-"` // Filled in for admin listings
 	CreatedAt    time.Time `json:"created_at" db:"created_at"`
 	UpdatedAt    time.Time `json:"updated_at" db:"updated_at"`
 }
 
+type FilePage struct {
+	Files      []File `json:"files"`
+	NextCursor string `json:"next_cursor,omitempty"` // Pass as after
+	PrevCursor string `json:"prev_cursor,omitempty"` // Pass as before
+}
+
 type FileVersion struct {
 	ID           int       `json:"id" db:"id"`
 	FileID       int       `json:"file_id" db:"file_id"`
@@ -36,6 +44,47 @@ type FileShare struct {
 	CreatedAt time.Time `json:"created_at" db:"created_at"`
 }
 
+type FileRequest struct {
+	ID                int        `json:"id" db:"id"`
+	UserID            int        `json:"user_id" db:"user_id"`
+	Title             string     `json:"title" db:"title"`
+	Description       string     `json:"description" db:"description"`
+	Folder            string     `json:"folder" db:"folder"` // Destination folder of the owner
+	RequestToken      string     `json:"request_token" db:"request_token"`
+	PasswordHash      string     `json:"-" db:"password_hash"`
+	HasPassword       bool       `json:"has_password" db:"-"`
+	MaxFileSize       *int64     `json:"max_file_size" db:"max_file_size"`           // Bytes per file, null for default limit
+	MaxFiles          *int       `json:"max_files" db:"max_files"`                   // Total uploads allowed, null for unlimited
+	AllowedExtensions []string   `json:"allowed_extensions" db:"allowed_extensions"` // Empty allows any extension
+	UploadCount       int        `json:"upload_count" db:"upload_count"`
+	IsActive          bool       `json:"is_active" db:"is_active"`
+	ExpiresAt         *time.Time `json:"expires_at" db:"expires_at"`
+	CreatedAt         time.Time  `json:"created_at" db:"created_at"`
+}
+
+type FileRequestUpload struct {
+	ID           int       `json:"id" db:"id"`
+	RequestID    int       `json:"request_id" db:"request_id"`
+	FileID       *int      `json:"file_id" db:"file_id"`
+	UploaderName string    `json:"uploader_name" db:"uploader_name"`
+	UploaderIP   string    `json:"uploader_ip" db:"uploader_ip"`
+	CreatedAt    time.Time `json:"created_at" db:"created_at"`
+}
+
+type FileTransfer struct {
+	ID          int        `json:"id" db:"id"`
+	FileID      *int       `json:"file_id" db:"file_id"` // Single file transfer
+	Folder      *string    `json:"folder" db:"folder"`   // Folder subtree transfer, both null transfers everything
+	FromUserID  int        `json:"from_user_id" db:"from_user_id"`
+	ToUserID    int        `json:"to_user_id" db:"to_user_id"`
+	OfferedBy   *int       `json:"offered_by" db:"offered_by"`
+	Status      string     `json:"status" db:"status"` // pending, accepted, declined, cancelled
+	FileCount   int        `json:"file_count" db:"file_count"`
+	TotalSize   int64      `json:"total_size" db:"total_size"`
+	CreatedAt   time.Time  `json:"created_at" db:"created_at"`
+	RespondedAt *time.Time `json:"responded_at" db:"responded_at"`
+}
+
 type CollaborationSession struct {
 	ID           int       `json:"id" db:"id"`
 	FileID       int       `json:"file_id" db:"file_id"`
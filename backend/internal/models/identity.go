@@ -0,0 +1,23 @@
+package models
+
+import (
+	"time"
+)
+
+// UserIdentity links a user to an account at an external identity provider
+type UserIdentity struct {
+	ID          int        `json:"id" db:"id"`
+	UserID      int        `json:"user_id" db:"user_id"`
+	Issuer      string     `json:"issuer" db:"issuer"`
+	Subject     string     `json:"subject" db:"subject"`
+	Email       string     `json:"email" db:"email"`
+	CreatedAt   time.Time  `json:"created_at" db:"created_at"`
+	LastLoginAt *time.Time `json:"last_login_at" db:"last_login_at"`
+}
+
+// DirectorySyncResult summarizes a sync of directory users
+type DirectorySyncResult struct {
+	DirectoryUsers int `json:"directory_users"` // Users the directory returned
+	Updated        int `json:"updated"`         // Local users still in the directory
+	Disabled       int `json:"disabled"`        // Local users disabled because they left the directory
+}
@@ -0,0 +1,15 @@
+package models
+
+import (
+	"time"
+)
+
+// LoginLockout is an account, file request or IP address refused password attempts after repeated failures
+type LoginLockout struct {
+	ID            int       `json:"id" db:"id"`
+	Scope         string    `json:"scope" db:"scope"`           // account, ip, request, request_ip
+	Identifier    string    `json:"identifier" db:"identifier"` // Username tried, file request ID or IP address
+	Failures      int       `json:"failures" db:"failures"`
+	LastFailureAt time.Time `json:"last_failure_at" db:"last_failure_at"`
+	LockedUntil   time.Time `json:"locked_until" db:"locked_until"`
+}
@@ -0,0 +1,22 @@
+package models
+
+import (
+	"encoding/json"
+	"time"
+)
+
+type Notification struct {
+	ID        int             `json:"id" db:"id"`
+	UserID    int             `json:"user_id" db:"user_id"`
+	Type      string          `json:"type" db:"type"` // friend_request, file_shared, message, share_expiring, ...
+	Title     string          `json:"title" db:"title"`
+	Content   string          `json:"content" db:"content"`
+	Data      json.RawMessage `json:"data,omitempty" db:"data"` // Related object IDs
+	IsRead    bool            `json:"is_read" db:"is_read"`
+	CreatedAt time.Time       `json:"created_at" db:"created_at"`
+}
+
+type NotificationPreference struct {
+	Type    string `json:"type" db:"type"`
+	Enabled bool   `json:"enabled" db:"enabled"`
+}
@@ -0,0 +1,35 @@
+package models
+
+import (
+	"encoding/json"
+	"time"
+)
+
+// Role groups the permissions users with the role have
+type Role struct {
+	Name        string    `json:"name" db:"name"`
+	Description string    `json:"description" db:"description"`
+	Builtin     bool      `json:"builtin" db:"builtin"`
+	Permissions []string  `json:"permissions" db:"-"`
+	UserCount   int       `json:"user_count" db:"-"`
+	CreatedAt   time.Time `json:"created_at" db:"created_at"`
+}
+
+// AuditEntry records an administrative action
+type AuditEntry struct {
+	ID            int             `json:"id" db:"id"`
+	ActorID       *int            `json:"actor_id" db:"actor_id"` // Null once the actor's account is deleted
+	ActorUsername string          `json:"actor_username" db:"actor_username"`
+	Action        string          `json:"action" db:"action"`
+	TargetType    string          `json:"target_type" db:"target_type"`
+	TargetID      string          `json:"target_id" db:"target_id"`
+	Details       json.RawMessage `json:"details" db:"details"`
+	IPAddress     string          `json:"ip_address" db:"ip_address"`
+	CreatedAt     time.Time       `json:"created_at" db:"created_at"`
+}
+
+// AuditPage is a page of audit entries, newest first
+type AuditPage struct {
+	Entries []AuditEntry `json:"entries"`
+	Total   int          `json:"total"`
+}
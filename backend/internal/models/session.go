@@ -0,0 +1,24 @@
+package models
+
+import (
+	"time"
+)
+
+type Session struct {
+	ID         string    `json:"id" db:"id"`
+	UserID     int       `json:"user_id" db:"user_id"`
+	Device     string    `json:"device" db:"device"`
+	IPAddress  string    `json:"ip_address" db:"ip_address"`
+	Current    bool      `json:"current" db:"-"` // Session of the requesting access token
+	CreatedAt  time.Time `json:"created_at" db:"created_at"`
+	LastUsedAt time.Time `json:"last_used_at" db:"last_used_at"`
+	ExpiresAt  time.Time `json:"expires_at" db:"expires_at"`
+}
+
+// TokenPair is returned on login and refresh
+type TokenPair struct {
+	AccessToken  string `json:"token"`
+	RefreshToken string `json:"refresh_token"`
+	ExpiresIn    int    `json:"expires_in"` // Access token lifetime in seconds
+	SessionID    string `json:"session_id"`
+}
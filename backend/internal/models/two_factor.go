@@ -0,0 +1,26 @@
+package models
+
+// TwoFactorStatus is shown in the user's security settings
+type TwoFactorStatus struct {
+	Enabled                bool `json:"enabled"`
+	Required               bool `json:"required"` // Required by the admin policy for the user's role
+	RecoveryCodesRemaining int  `json:"recovery_codes_remaining"`
+}
+
+// TwoFactorSetup holds a new TOTP secret until the user confirms it with a code
+type TwoFactorSetup struct {
+	Secret          string `json:"secret"`
+	ProvisioningURI string `json:"provisioning_uri"` // otpauth:// URI to show as QR code
+}
+
+// TwoFactorChallenge is returned by login instead of tokens while the second factor is pending
+type TwoFactorChallenge struct {
+	TwoFactorRequired bool   `json:"two_factor_required"`
+	SetupRequired     bool   `json:"setup_required"` // The policy requires 2FA but the user has not enrolled yet
+	ChallengeToken    string `json:"challenge_token"`
+	ExpiresIn         int    `json:"expires_in"` // Seconds
+}
+
+type TwoFactorPolicy struct {
+	RequireForAdmins bool `json:"require_for_admins"` // Every role granting administration permissions
+}
@@ -48,6 +48,9 @@ type User struct {
 	Username  string     `json:"username" db:"username"`
 	Password  string     `json:"-" db:"password_hash"`
 	Role      string     `json:"role" db:"role"`
+	Email     NullString `json:"email" db:"email"`
+	EmailNotifications string `json:"email_notifications" db:"email_notifications"` // instant, digest, off
+	Discoverable bool    `json:"discoverable" db:"discoverable"` // Shown in friend suggestions and fuzzy search
 	Avatar    NullString `json:"avatar" db:"avatar"`
 	Profile   NullString `json:"profile" db:"profile"`
 	CreatedAt time.Time  `json:"created_at" db:"created_at"`
@@ -63,18 +66,68 @@ type Friendship struct {
 	CreatedAt time.Time `json:"created_at" db:"created_at"`
 }
 
+// FriendSuggestion is a non-friend ranked by how connected they are to the user
+type FriendSuggestion struct {
+	User               User    `json:"user"`
+	MutualFriends      int     `json:"mutual_friends"`
+	SharedFiles        int     `json:"shared_files"` // Files both users own or have been shared
+	RecentInteractions int     `json:"recent_interactions"` // Messages in common conversations lately
+	Score              float64 `json:"score"`
+}
+
 type FriendGroup struct {
 	ID        int       `json:"id" db:"id"`
 	UserID    int       `json:"user_id" db:"user_id"`
 	GroupName string    `json:"group_name" db:"group_name"`
+	Position  int       `json:"position" db:"position"` // Display order, ascending
+	Friends   []User    `json:"friends,omitempty" db:"-"`
 	CreatedAt time.Time `json:"created_at" db:"created_at"`
 }
 
 type Message struct {
 	ID         int       `json:"id" db:"id"`
+	ConversationID int   `json:"conversation_id" db:"conversation_id"`
 	SenderID   int       `json:"sender_id" db:"sender_id"`
-	ReceiverID int       `json:"receiver_id" db:"receiver_id"`
+	ReceiverID *int      `json:"receiver_id" db:"receiver_id"` // Null for group conversations
 	Content    string    `json:"content" db:"content"`
 	MessageType string   `json:"message_type" db:"message_type"` // text, file, system
+	FileID     *int      `json:"file_id" db:"file_id"` // Attached file for file messages, null once the file is deleted
+	File       *MessageFile `json:"file,omitempty" db:"-"`
+	ReplyToID  *int      `json:"reply_to_id" db:"reply_to_id"` // Parent message of a threaded reply
+	ReplyTo    *MessagePreview `json:"reply_to,omitempty" db:"-"`
+	ReplyCount int       `json:"reply_count" db:"-"`
+	Reactions  []MessageReaction `json:"reactions" db:"-"`
+	EditedAt   *time.Time `json:"edited_at" db:"edited_at"`
+	ReadAt     *time.Time `json:"read_at" db:"read_at"` // Set when the receiver of a direct message has read it
 	CreatedAt  time.Time `json:"created_at" db:"created_at"`
+}
+
+// Short form of the parent message shown above a reply
+type MessagePreview struct {
+	ID          int    `json:"id"`
+	SenderID    int    `json:"sender_id"`
+	Content     string `json:"content"`
+	MessageType string `json:"message_type"`
+}
+
+type MessageReaction struct {
+	Emoji   string `json:"emoji"`
+	Count   int    `json:"count"`
+	UserIDs []int  `json:"user_ids"`
+}
+
+type MessageEdit struct {
+	ID        int       `json:"id" db:"id"`
+	MessageID int       `json:"message_id" db:"message_id"`
+	Content   string    `json:"content" db:"content"` // Text before the edit
+	EditedAt  time.Time `json:"edited_at" db:"edited_at"`
+}
+
+// File card rendered for file messages
+type MessageFile struct {
+	ID         int    `json:"id"`
+	Name       string `json:"name"`
+	FileSize   int64  `json:"file_size"`
+	MimeType   string `json:"mime_type"`
+	PreviewURL string `json:"preview_url"`
 }
\ No newline at end of file
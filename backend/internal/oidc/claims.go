@@ -0,0 +1,51 @@
+package oidc
+
+import (
+	"strings"
+)
+
+// Claims of a verified ID token
+type Claims map[string]interface{}
+
+func (c Claims) Subject() string {
+	return c.String("sub")
+}
+
+// String claim, or empty when missing or of another type
+func (c Claims) String(name string) string {
+	value, _ := c[name].(string)
+	return value
+}
+
+// Strings reads list claims such as groups, which some providers send as a single string
+func (c Claims) Strings(name string) []string {
+	switch value := c[name].(type) {
+	case string:
+		if value == "" {
+			return nil
+		}
+		return strings.Fields(strings.ReplaceAll(value, ",", " "))
+	case []interface{}:
+		var values []string
+		for _, item := range value {
+			if s, ok := item.(string); ok {
+				values = append(values, s)
+			}
+		}
+		return values
+	}
+	return nil
+}
+
+// Email is only returned when the provider marked it verified
+func (c Claims) VerifiedEmail() string {
+	verified, ok := c["email_verified"].(bool)
+	if !ok {
+		// Sent as a string by some providers
+		verified = c.String("email_verified") == "true"
+	}
+	if !verified {
+		return ""
+	}
+	return c.String("email")
+}
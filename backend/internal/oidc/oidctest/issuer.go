@@ -0,0 +1,354 @@
+// Package oidctest is a minimal OpenID Connect provider for trying out and testing single
+// sign-on. It runs the authorization code flow with PKCE and logs in whoever the login form,
+// or LogIn, names. Never expose it: it authenticates nobody.
+package oidctest
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"html/template"
+	"math/big"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/golang-jwt/jwt/v4"
+)
+
+const keyID = "dev-1"
+
+// Issuer serves the discovery document, login form, token endpoint and signing keys
+type Issuer struct {
+	URL          string // Issuer URL as clients reach it, may be set after the server starts
+	ClientID     string
+	ClientSecret string
+
+	// Tamper, when set, changes the claims of each ID token before it is signed, so tests
+	// can check that clients reject tokens for another issuer, audience or nonce
+	Tamper func(claims jwt.MapClaims)
+
+	key *rsa.PrivateKey
+
+	mu    sync.Mutex
+	codes map[string]grant
+}
+
+// An issued authorization code and what it was issued for
+type grant struct {
+	redirectURI   string
+	challenge     string
+	nonce         string
+	subject       string
+	username      string
+	email         string
+	groups        []string
+	expiresAt     time.Time
+	emailVerified bool
+}
+
+// Login is the identity LogIn submits on the login form
+type Login struct {
+	Subject       string
+	Username      string
+	Email         string
+	EmailVerified bool
+	Groups        []string
+}
+
+func NewIssuer(issuerURL, clientID, clientSecret string) (*Issuer, error) {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Issuer{
+		URL:          strings.TrimRight(issuerURL, "/"),
+		ClientID:     clientID,
+		ClientSecret: clientSecret,
+		key:          key,
+		codes:        make(map[string]grant),
+	}, nil
+}
+
+// Handler serves the issuer's endpoints
+func (s *Issuer) Handler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/.well-known/openid-configuration", s.discovery)
+	mux.HandleFunc("/authorize", s.authorize)
+	mux.HandleFunc("/token", s.token)
+	mux.HandleFunc("/jwks", s.jwks)
+	return mux
+}
+
+// LogIn submits the login form for an authorization URL the way a browser would and
+// returns the redirect back to the client, which carries the code and state
+func (s *Issuer) LogIn(authURL string, login Login) (*url.URL, error) {
+	target, err := url.Parse(authURL)
+	if err != nil {
+		return nil, err
+	}
+	form := target.Query()
+	form.Set("decision", "allow")
+	form.Set("sub", login.Subject)
+	form.Set("preferred_username", login.Username)
+	form.Set("email", login.Email)
+	if login.EmailVerified {
+		form.Set("email_verified", "true")
+	}
+	form.Set("groups", strings.Join(login.Groups, ","))
+	target.RawQuery = ""
+
+	client := &http.Client{
+		Timeout: 10 * time.Second,
+		CheckRedirect: func(req *http.Request, via []*http.Request) error {
+			return http.ErrUseLastResponse
+		},
+	}
+	resp, err := client.PostForm(target.String(), form)
+	if err != nil {
+		return nil, err
+	}
+	resp.Body.Close()
+
+	if resp.StatusCode != http.StatusFound {
+		return nil, fmt.Errorf("authorize returned %s", resp.Status)
+	}
+	location, err := resp.Location()
+	if err != nil {
+		return nil, err
+	}
+	if description := location.Query().Get("error_description"); description != "" {
+		return nil, errors.New(description)
+	}
+	return location, nil
+}
+
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
+
+func tokenError(w http.ResponseWriter, status int, code, description string) {
+	writeJSON(w, status, map[string]string{"error": code, "error_description": description})
+}
+
+func (s *Issuer) discovery(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"issuer":                                s.URL,
+		"authorization_endpoint":                s.URL + "/authorize",
+		"token_endpoint":                        s.URL + "/token",
+		"jwks_uri":                              s.URL + "/jwks",
+		"response_types_supported":              []string{"code"},
+		"subject_types_supported":               []string{"public"},
+		"id_token_signing_alg_values_supported": []string{"RS256"},
+		"code_challenge_methods_supported":      []string{"S256"},
+		"token_endpoint_auth_methods_supported": []string{"client_secret_basic", "client_secret_post"},
+	})
+}
+
+func (s *Issuer) jwks(w http.ResponseWriter, r *http.Request) {
+	pub := s.key.PublicKey
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"keys": []map[string]string{{
+			"kty": "RSA",
+			"use": "sig",
+			"alg": "RS256",
+			"kid": keyID,
+			"n":   base64.RawURLEncoding.EncodeToString(pub.N.Bytes()),
+			"e":   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(pub.E)).Bytes()),
+		}},
+	})
+}
+
+var loginPage = template.Must(template.New("login").Parse(`<!DOCTYPE html>
+<html><head><title>OIDC dev issuer</title></head>
+<body style="font-family: sans-serif; max-width: 420px; margin: 40px auto">
+<h2>OIDC dev issuer</h2>
+<p>Log in to <b>{{.ClientID}}</b> as:</p>
+<form method="post">
+{{range $name, $value := .Params}}<input type="hidden" name="{{$name}}" value="{{$value}}">
+{{end}}<p><label>Subject<br><input name="sub" value="dev-user-1" required></label></p>
+<p><label>Username<br><input name="preferred_username" value="devuser"></label></p>
+<p><label>Email<br><input name="email" value="devuser@example.com"></label></p>
+<p><label><input type="checkbox" name="email_verified" value="true" checked> Email verified</label></p>
+<p><label>Groups (comma separated)<br><input name="groups" value=""></label></p>
+<p><button type="submit" name="decision" value="allow">Log in</button>
+<button type="submit" name="decision" value="deny">Deny</button></p>
+</form>
+</body></html>`))
+
+// Shows the login form on GET and issues the authorization code on POST
+func (s *Issuer) authorize(w http.ResponseWriter, r *http.Request) {
+	if err := r.ParseForm(); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	redirectURI := r.Form.Get("redirect_uri")
+	if r.Form.Get("client_id") != s.ClientID || redirectURI == "" {
+		http.Error(w, "unknown client or missing redirect_uri", http.StatusBadRequest)
+		return
+	}
+	target, err := url.Parse(redirectURI)
+	if err != nil {
+		http.Error(w, "invalid redirect_uri", http.StatusBadRequest)
+		return
+	}
+	query := target.Query()
+	query.Set("state", r.Form.Get("state"))
+
+	if r.Form.Get("response_type") != "code" || r.Form.Get("code_challenge_method") != "S256" || r.Form.Get("code_challenge") == "" {
+		query.Set("error", "invalid_request")
+		query.Set("error_description", "authorization code flow with S256 PKCE is required")
+		target.RawQuery = query.Encode()
+		http.Redirect(w, r, target.String(), http.StatusFound)
+		return
+	}
+
+	if r.Method == http.MethodGet {
+		params := map[string]string{}
+		for _, name := range []string{"client_id", "redirect_uri", "response_type", "scope", "state", "nonce", "code_challenge", "code_challenge_method"} {
+			params[name] = r.Form.Get(name)
+		}
+		loginPage.Execute(w, map[string]interface{}{"ClientID": s.ClientID, "Params": params})
+		return
+	}
+
+	if r.PostForm.Get("decision") != "allow" {
+		query.Set("error", "access_denied")
+		query.Set("error_description", "the login was denied")
+		target.RawQuery = query.Encode()
+		http.Redirect(w, r, target.String(), http.StatusFound)
+		return
+	}
+
+	var groups []string
+	for _, group := range strings.Split(r.PostForm.Get("groups"), ",") {
+		if group = strings.TrimSpace(group); group != "" {
+			groups = append(groups, group)
+		}
+	}
+
+	buf := make([]byte, 24)
+	if _, err := rand.Read(buf); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	code := base64.RawURLEncoding.EncodeToString(buf)
+
+	s.mu.Lock()
+	s.codes[code] = grant{
+		redirectURI:   redirectURI,
+		challenge:     r.Form.Get("code_challenge"),
+		nonce:         r.Form.Get("nonce"),
+		subject:       r.PostForm.Get("sub"),
+		username:      r.PostForm.Get("preferred_username"),
+		email:         r.PostForm.Get("email"),
+		emailVerified: r.PostForm.Get("email_verified") == "true",
+		groups:        groups,
+		expiresAt:     time.Now().Add(time.Minute),
+	}
+	s.mu.Unlock()
+
+	query.Set("code", code)
+	target.RawQuery = query.Encode()
+	http.Redirect(w, r, target.String(), http.StatusFound)
+}
+
+// Redeems an authorization code once, checking the client, redirect URI and PKCE verifier
+func (s *Issuer) token(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		tokenError(w, http.StatusMethodNotAllowed, "invalid_request", "POST required")
+		return
+	}
+	if err := r.ParseForm(); err != nil {
+		tokenError(w, http.StatusBadRequest, "invalid_request", err.Error())
+		return
+	}
+
+	clientID, clientSecret, ok := r.BasicAuth()
+	if ok {
+		clientID, _ = url.QueryUnescape(clientID)
+		clientSecret, _ = url.QueryUnescape(clientSecret)
+	} else {
+		clientID, clientSecret = r.PostForm.Get("client_id"), r.PostForm.Get("client_secret")
+	}
+	if clientID != s.ClientID || subtle.ConstantTimeCompare([]byte(clientSecret), []byte(s.ClientSecret)) != 1 {
+		tokenError(w, http.StatusUnauthorized, "invalid_client", "client authentication failed")
+		return
+	}
+	if r.PostForm.Get("grant_type") != "authorization_code" {
+		tokenError(w, http.StatusBadRequest, "unsupported_grant_type", "only authorization_code is supported")
+		return
+	}
+
+	code := r.PostForm.Get("code")
+	s.mu.Lock()
+	g, found := s.codes[code]
+	delete(s.codes, code)
+	s.mu.Unlock()
+
+	if !found || time.Now().After(g.expiresAt) {
+		tokenError(w, http.StatusBadRequest, "invalid_grant", "unknown or expired code")
+		return
+	}
+	if r.PostForm.Get("redirect_uri") != g.redirectURI {
+		tokenError(w, http.StatusBadRequest, "invalid_grant", "redirect_uri mismatch")
+		return
+	}
+	sum := sha256.Sum256([]byte(r.PostForm.Get("code_verifier")))
+	if base64.RawURLEncoding.EncodeToString(sum[:]) != g.challenge {
+		tokenError(w, http.StatusBadRequest, "invalid_grant", "PKCE verification failed")
+		return
+	}
+
+	now := time.Now()
+	claims := jwt.MapClaims{
+		"iss":            s.URL,
+		"aud":            s.ClientID,
+		"sub":            g.subject,
+		"iat":            now.Unix(),
+		"exp":            now.Add(5 * time.Minute).Unix(),
+		"nonce":          g.nonce,
+		"email_verified": g.emailVerified,
+	}
+	if g.username != "" {
+		claims["preferred_username"] = g.username
+	}
+	if g.email != "" {
+		claims["email"] = g.email
+	}
+	if len(g.groups) > 0 {
+		claims["groups"] = g.groups
+	}
+
+	if s.Tamper != nil {
+		s.Tamper(claims)
+	}
+
+	idToken := jwt.NewWithClaims(jwt.SigningMethodRS256, claims)
+	idToken.Header["kid"] = keyID
+	signed, err := idToken.SignedString(s.key)
+	if err != nil {
+		tokenError(w, http.StatusInternalServerError, "server_error", err.Error())
+		return
+	}
+
+	accessToken := make([]byte, 24)
+	rand.Read(accessToken)
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"access_token": base64.RawURLEncoding.EncodeToString(accessToken),
+		"token_type":   "Bearer",
+		"expires_in":   300,
+		"id_token":     signed,
+	})
+}
@@ -0,0 +1,158 @@
+package oidc
+
+import (
+	"context"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v4"
+	"ai-doc-system/internal/oidc/oidctest"
+)
+
+const testRedirectURL = "http://app.example/api/auth/oidc/callback"
+
+func newTestIssuer(t *testing.T) (*oidctest.Issuer, *Provider) {
+	t.Helper()
+	issuer, err := oidctest.NewIssuer("", "test-client", "test-secret")
+	if err != nil {
+		t.Fatal(err)
+	}
+	server := httptest.NewServer(issuer.Handler())
+	t.Cleanup(server.Close)
+	issuer.URL = server.URL
+
+	provider := NewProvider(server.URL, "test-client", "test-secret", testRedirectURL, []string{"openid", "profile", "email"})
+	return issuer, provider
+}
+
+// Runs the browser part of a login and returns the authorization code
+func logIn(t *testing.T, issuer *oidctest.Issuer, provider *Provider, state, nonce, verifier string, login oidctest.Login) string {
+	t.Helper()
+	authURL, err := provider.AuthCodeURL(context.Background(), state, nonce, verifier)
+	if err != nil {
+		t.Fatal(err)
+	}
+	callback, err := issuer.LogIn(authURL, login)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := callback.Query().Get("state"); got != state {
+		t.Fatalf("callback state = %q, want %q", got, state)
+	}
+	code := callback.Query().Get("code")
+	if code == "" {
+		t.Fatalf("callback %s has no code", callback)
+	}
+	return code
+}
+
+func TestAuthCodeURLUsesS256Challenge(t *testing.T) {
+	_, provider := newTestIssuer(t)
+	verifier, err := NewCodeVerifier()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	authURL, err := provider.AuthCodeURL(context.Background(), "state", "nonce", verifier)
+	if err != nil {
+		t.Fatal(err)
+	}
+	parsed, err := url.Parse(authURL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	query := parsed.Query()
+	if query.Get("code_challenge_method") != "S256" {
+		t.Errorf("code_challenge_method = %q, want S256", query.Get("code_challenge_method"))
+	}
+	if query.Get("code_challenge") != codeChallenge(verifier) {
+		t.Errorf("code_challenge = %q, want the S256 hash of the verifier", query.Get("code_challenge"))
+	}
+	if strings.Contains(authURL, verifier) {
+		t.Error("authorization URL contains the code verifier")
+	}
+}
+
+func TestExchangeReturnsVerifiedClaims(t *testing.T) {
+	issuer, provider := newTestIssuer(t)
+	code := logIn(t, issuer, provider, "state", "nonce", "verifier-1", oidctest.Login{
+		Subject:       "user-1",
+		Username:      "alice",
+		Email:         "alice@example.com",
+		EmailVerified: true,
+		Groups:        []string{"staff", "admins"},
+	})
+
+	claims, err := provider.Exchange(context.Background(), code, "verifier-1", "nonce")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if claims.Subject() != "user-1" {
+		t.Errorf("subject = %q, want user-1", claims.Subject())
+	}
+	if claims.String("preferred_username") != "alice" {
+		t.Errorf("preferred_username = %q, want alice", claims.String("preferred_username"))
+	}
+	if claims.VerifiedEmail() != "alice@example.com" {
+		t.Errorf("verified email = %q, want alice@example.com", claims.VerifiedEmail())
+	}
+	if groups := claims.Strings("groups"); len(groups) != 2 || groups[0] != "staff" || groups[1] != "admins" {
+		t.Errorf("groups = %v, want [staff admins]", groups)
+	}
+
+	// Codes are single use
+	if _, err := provider.Exchange(context.Background(), code, "verifier-1", "nonce"); err == nil {
+		t.Error("second exchange of the same code succeeded")
+	}
+}
+
+func TestExchangeRejectsWrongVerifier(t *testing.T) {
+	issuer, provider := newTestIssuer(t)
+	code := logIn(t, issuer, provider, "state", "nonce", "verifier-1", oidctest.Login{Subject: "user-1"})
+
+	_, err := provider.Exchange(context.Background(), code, "verifier-2", "nonce")
+	if err == nil || !strings.Contains(err.Error(), "PKCE") {
+		t.Fatalf("exchange with the wrong verifier: err = %v, want a PKCE failure", err)
+	}
+}
+
+func TestExchangeRejectsInvalidIDTokens(t *testing.T) {
+	tests := []struct {
+		name   string
+		nonce  string // Nonce the client expects, the issuer signs "nonce"
+		tamper func(claims jwt.MapClaims)
+		want   string
+	}{
+		{name: "nonce mismatch", nonce: "other-nonce", want: "nonce mismatch"},
+		{name: "missing nonce", nonce: "nonce", tamper: func(claims jwt.MapClaims) { delete(claims, "nonce") }, want: "nonce mismatch"},
+		{name: "wrong issuer", nonce: "nonce", tamper: func(claims jwt.MapClaims) { claims["iss"] = "https://evil.example" }, want: "wrong issuer"},
+		{name: "wrong audience", nonce: "nonce", tamper: func(claims jwt.MapClaims) { claims["aud"] = "other-client" }, want: "wrong audience"},
+		{name: "expired", nonce: "nonce", tamper: func(claims jwt.MapClaims) { claims["exp"] = time.Now().Add(-time.Minute).Unix() }, want: "expired"},
+		{name: "no subject", nonce: "nonce", tamper: func(claims jwt.MapClaims) { delete(claims, "sub") }, want: "no subject"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			issuer, provider := newTestIssuer(t)
+			issuer.Tamper = tt.tamper
+			code := logIn(t, issuer, provider, "state", "nonce", "verifier", oidctest.Login{Subject: "user-1"})
+
+			_, err := provider.Exchange(context.Background(), code, "verifier", tt.nonce)
+			if err == nil || !strings.Contains(err.Error(), tt.want) {
+				t.Fatalf("err = %v, want %q", err, tt.want)
+			}
+		})
+	}
+}
+
+func TestDiscoveryRejectsIssuerMismatch(t *testing.T) {
+	issuer, provider := newTestIssuer(t)
+	issuer.URL = "https://other.example"
+
+	if _, err := provider.AuthCodeURL(context.Background(), "state", "nonce", "verifier"); err == nil {
+		t.Fatal("discovery accepted a document for another issuer")
+	}
+}
@@ -0,0 +1,246 @@
+package realtime
+
+import (
+	"encoding/json"
+	"log"
+	"sync"
+	"time"
+)
+
+const (
+	clientBuffer  = 64              // Events buffered per connection before dropping
+	backlogSize   = 100             // Events kept per user for long polling
+	backlogMaxAge = 2 * time.Minute // Events older than this are not replayed
+)
+
+// Event is pushed to every connection of the recipients
+type Event struct {
+	// Assigned by the broker in publishing order across instances and used as replay
+	// cursor. Events the broker could not take are delivered on this instance only with
+	// ID 0; they are not replayed and do not move cursors.
+	ID        int64           `json:"id"`
+	Type      string          `json:"type"`
+	Data      json.RawMessage `json:"data"`
+	CreatedAt time.Time       `json:"created_at"`
+}
+
+// Broker stores published events, assigning their IDs, and fans them out to every backend
+// instance, including this one. Instances receiving an event hand it to Hub.Deliver.
+type Broker interface {
+	Publish(userIDs []int, event Event) error
+}
+
+// EventSessionsEnded goes to the streams of sessions that were revoked, which then close
+const EventSessionsEnded = "session.ended"
+
+type sessionsEnded struct {
+	SessionIDs []string `json:"session_ids"`
+}
+
+// Subscription is one live connection of a user's session
+type Subscription struct {
+	sessionID string
+	events    chan Event
+	done      chan struct{}
+}
+
+// Events delivered to the connection
+func (s *Subscription) Events() <-chan Event {
+	return s.events
+}
+
+// Done is closed once the connection's session has ended, the connection should close
+func (s *Subscription) Done() <-chan struct{} {
+	return s.done
+}
+
+type backlogEntry struct {
+	event      Event
+	receivedAt time.Time
+}
+
+// Hub tracks the live connections of each user on this instance
+type Hub struct {
+	mu          sync.Mutex
+	subscribers map[int]map[*Subscription]struct{}
+	backlog     map[int][]backlogEntry
+	broker      Broker
+	lastID      int64 // Without a broker IDs are assigned here
+}
+
+func NewHub() *Hub {
+	return &Hub{
+		subscribers: make(map[int]map[*Subscription]struct{}),
+		backlog:     make(map[int][]backlogEntry),
+	}
+}
+
+// SetBroker enables delivery across backend instances
+func (h *Hub) SetBroker(broker Broker) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.broker = broker
+}
+
+// Publish sends an event to all connections of the given users on every instance
+func (h *Hub) Publish(userIDs []int, eventType string, data interface{}) error {
+	payload, err := json.Marshal(data)
+	if err != nil {
+		return err
+	}
+	event := Event{Type: eventType, Data: payload, CreatedAt: time.Now()}
+
+	h.mu.Lock()
+	broker := h.broker
+	if broker == nil {
+		h.lastID++
+		event.ID = h.lastID
+	}
+	h.mu.Unlock()
+
+	if broker != nil {
+		err := broker.Publish(userIDs, event)
+		if err == nil {
+			return nil
+		}
+		log.Printf("realtime: broker publish failed, delivering locally: %v", err)
+	}
+
+	h.Deliver(userIDs, event)
+	return nil
+}
+
+// Deliver hands an event to the local connections of the given users
+func (h *Hub) Deliver(userIDs []int, event Event) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	var ended map[string]bool
+	if event.Type == EventSessionsEnded {
+		var data sessionsEnded
+		if err := json.Unmarshal(event.Data, &data); err != nil {
+			log.Printf("realtime: invalid %s event: %v", event.Type, err)
+			return
+		}
+		ended = make(map[string]bool)
+		for _, sessionID := range data.SessionIDs {
+			ended[sessionID] = true
+		}
+	}
+
+	for _, userID := range userIDs {
+		if event.ID != 0 && ended == nil {
+			h.appendBacklog(userID, event)
+		}
+		for sub := range h.subscribers[userID] {
+			if ended != nil && !ended[sub.sessionID] {
+				continue
+			}
+			select {
+			case sub.events <- event:
+			default:
+				// Slow consumer, drop event rather than block the hub
+			}
+			if ended != nil {
+				close(sub.done)
+				delete(h.subscribers[userID], sub)
+			}
+		}
+		if len(h.subscribers[userID]) == 0 {
+			delete(h.subscribers, userID)
+		}
+	}
+}
+
+// EndSessions closes the connections of the user's sessions on every instance
+func (h *Hub) EndSessions(userID int, sessionIDs []string) error {
+	return h.Publish([]int{userID}, EventSessionsEnded, sessionsEnded{SessionIDs: sessionIDs})
+}
+
+func (h *Hub) appendBacklog(userID int, event Event) {
+	entries := append(h.backlog[userID], backlogEntry{event: event, receivedAt: time.Now()})
+	if len(entries) > backlogSize {
+		entries = append([]backlogEntry(nil), entries[len(entries)-backlogSize:]...)
+	}
+	h.backlog[userID] = entries
+}
+
+// Prune drops buffered events too old to be replayed, and users left without any
+func (h *Hub) Prune() {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	cutoff := time.Now().Add(-backlogMaxAge)
+	for userID, entries := range h.backlog {
+		start := 0
+		for start < len(entries) && entries[start].receivedAt.Before(cutoff) {
+			start++
+		}
+		if start == len(entries) {
+			delete(h.backlog, userID)
+		} else if start > 0 {
+			h.backlog[userID] = append([]backlogEntry(nil), entries[start:]...)
+		}
+	}
+}
+
+// RunPruning prunes the backlog until the process exits
+func (h *Hub) RunPruning(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for range ticker.C {
+		h.Prune()
+	}
+}
+
+// Subscribe registers a new connection of the user's session
+func (h *Hub) Subscribe(userID int, sessionID string) *Subscription {
+	sub := &Subscription{
+		sessionID: sessionID,
+		events:    make(chan Event, clientBuffer),
+		done:      make(chan struct{}),
+	}
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if h.subscribers[userID] == nil {
+		h.subscribers[userID] = make(map[*Subscription]struct{})
+	}
+	h.subscribers[userID][sub] = struct{}{}
+
+	return sub
+}
+
+// Unsubscribe removes a connection of the user
+func (h *Hub) Unsubscribe(userID int, sub *Subscription) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	delete(h.subscribers[userID], sub)
+	if len(h.subscribers[userID]) == 0 {
+		delete(h.subscribers, userID)
+	}
+}
+
+// EventsSince returns the buffered events of the user newer than the given event ID
+func (h *Hub) EventsSince(userID int, since int64) []Event {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	cutoff := time.Now().Add(-backlogMaxAge)
+	var events []Event
+	for _, entry := range h.backlog[userID] {
+		if entry.event.ID > since && !entry.receivedAt.Before(cutoff) {
+			events = append(events, entry.event)
+		}
+	}
+	return events
+}
+
+// IsOnline reports whether the user has a live connection on this instance
+func (h *Hub) IsOnline(userID int) bool {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return len(h.subscribers[userID]) > 0
+}
@@ -0,0 +1,157 @@
+package realtime
+
+import (
+	"database/sql"
+	"log"
+	"strconv"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+const (
+	notifyChannel  = "realtime_events"
+	eventRetention = 10 * time.Minute // Stored events are only needed until every listener has loaded them
+	publishLockKey = 7268423          // Advisory lock that makes publishers take event IDs one at a time
+)
+
+// PGBroker stores events in the realtime_events table and fans them out to every backend
+// instance through Postgres LISTEN/NOTIFY. Notifications carry only the event ID, so
+// events of any size reach all instances, and the table's sequence orders them.
+type PGBroker struct {
+	db       *sql.DB
+	listener *pq.Listener
+	lastID   int64 // Newest event delivered, listening resumes after it on reconnect
+}
+
+// NewPGBroker listens for events published by any instance and delivers them to hub
+func NewPGBroker(db *sql.DB, dsn string, hub *Hub) (*PGBroker, error) {
+	var lastID int64
+	if err := db.QueryRow("SELECT COALESCE(MAX(id), 0) FROM realtime_events").Scan(&lastID); err != nil {
+		return nil, err
+	}
+
+	listener := pq.NewListener(dsn, 10*time.Second, time.Minute, func(event pq.ListenerEventType, err error) {
+		if err != nil {
+			log.Printf("realtime: listener event %d: %v", event, err)
+		}
+	})
+	if err := listener.Listen(notifyChannel); err != nil {
+		listener.Close()
+		return nil, err
+	}
+
+	broker := &PGBroker{db: db, listener: listener, lastID: lastID}
+	go broker.run(hub)
+
+	return broker, nil
+}
+
+func (b *PGBroker) run(hub *Hub) {
+	for {
+		select {
+		case notification, ok := <-b.listener.Notify:
+			if !ok {
+				return
+			}
+			// nil notification signals a reconnect, events sent meanwhile are loaded from the table
+			if notification == nil {
+				b.catchUp(hub)
+				continue
+			}
+			id, err := strconv.ParseInt(notification.Extra, 10, 64)
+			if err != nil {
+				log.Printf("realtime: invalid notification %q", notification.Extra)
+				continue
+			}
+			b.load(hub, "WHERE id = $1", id)
+		case <-time.After(90 * time.Second):
+			go b.listener.Ping()
+		}
+	}
+}
+
+func (b *PGBroker) catchUp(hub *Hub) {
+	b.load(hub, "WHERE id > $1 ORDER BY id", b.lastID)
+}
+
+// Load the matching events and deliver them
+func (b *PGBroker) load(hub *Hub, where string, arg int64) {
+	rows, err := b.db.Query("SELECT id, user_ids, type, data, created_at FROM realtime_events "+where, arg)
+	if err != nil {
+		log.Printf("realtime: failed to load events: %v", err)
+		return
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var event Event
+		var userIDs pq.Int64Array
+		var data []byte
+		if err := rows.Scan(&event.ID, &userIDs, &event.Type, &data, &event.CreatedAt); err != nil {
+			log.Printf("realtime: failed to load events: %v", err)
+			return
+		}
+		event.Data = data
+
+		recipients := make([]int, len(userIDs))
+		for i, userID := range userIDs {
+			recipients[i] = int(userID)
+		}
+		hub.Deliver(recipients, event)
+
+		if event.ID > b.lastID {
+			b.lastID = event.ID
+		}
+	}
+	if err := rows.Err(); err != nil {
+		log.Printf("realtime: failed to load events: %v", err)
+	}
+}
+
+// Publish stores the event and notifies all listening instances of its ID. IDs are taken
+// at insert but notifications arrive at commit, so publishers hold a lock until they commit;
+// otherwise a later ID could be seen first and clients resuming after it would miss the earlier one.
+func (b *PGBroker) Publish(userIDs []int, event Event) error {
+	tx, err := b.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.Exec("SELECT pg_advisory_xact_lock($1)", publishLockKey); err != nil {
+		return err
+	}
+
+	_, err = tx.Exec(`
+		WITH stored AS (
+			INSERT INTO realtime_events (user_ids, type, data, created_at)
+			VALUES ($1, $2, $3, $4)
+			RETURNING id
+		)
+		SELECT pg_notify($5, id::TEXT) FROM stored`,
+		pq.Array(userIDs), event.Type, []byte(event.Data), event.CreatedAt, notifyChannel)
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// RunCleanup deletes stored events every listener has had time to load until the process exits
+func (b *PGBroker) RunCleanup(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for range ticker.C {
+		_, err := b.db.Exec("DELETE FROM realtime_events WHERE created_at < $1", time.Now().Add(-eventRetention))
+		if err != nil {
+			log.Printf("realtime: failed to delete old events: %v", err)
+		}
+	}
+}
+
+// Close stops listening
+func (b *PGBroker) Close() error {
+	return b.listener.Close()
+}
@@ -0,0 +1,313 @@
+package services
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"time"
+	"ai-doc-system/internal/models"
+	"ai-doc-system/internal/utils"
+)
+
+// AccountDeletionService deletes accounts the users asked to delete once their grace
+// period has passed. Everything the user owns goes with the account: files and their
+// stored content, shares, messages, friendships and exports. What others keep is
+// detached from the account instead, such as versions the user saved of others' files
+// and groups the user owned.
+type AccountDeletionService struct {
+	db                  *sql.DB
+	userService         *UserService
+	emailService        *EmailService
+	notificationService *NotificationService
+	auditService        *AuditService
+	gracePeriod         time.Duration
+}
+
+func NewAccountDeletionService(db *sql.DB, userService *UserService, emailService *EmailService,
+	notificationService *NotificationService, auditService *AuditService, graceDays int) *AccountDeletionService {
+	if graceDays < 0 {
+		graceDays = 0
+	}
+	return &AccountDeletionService{
+		db:                  db,
+		userService:         userService,
+		emailService:        emailService,
+		notificationService: notificationService,
+		auditService:        auditService,
+		gracePeriod:         time.Duration(graceDays) * 24 * time.Hour,
+	}
+}
+
+func (s *AccountDeletionService) graceDays() int {
+	return int(s.gracePeriod / (24 * time.Hour))
+}
+
+// Whether the user's account is scheduled for deletion
+func (s *AccountDeletionService) GetDeletionStatus(userID int) (*models.AccountDeletionStatus, error) {
+	status := models.AccountDeletionStatus{GraceDays: s.graceDays()}
+	err := s.db.QueryRow("SELECT deletion_scheduled_at FROM users WHERE id = $1", userID).Scan(&status.ScheduledAt)
+	if err == sql.ErrNoRows {
+		return nil, errors.New("user not found")
+	}
+	if err != nil {
+		return nil, err
+	}
+	status.Scheduled = status.ScheduledAt != nil
+	return &status, nil
+}
+
+// Schedule the user's account for deletion after the grace period. The user confirms
+// with their username and, when the account has one, their password.
+func (s *AccountDeletionService) ScheduleDeletion(userID int, username, password string) (time.Time, error) {
+	var currentUsername string
+	var email sql.NullString
+	err := s.db.QueryRow("SELECT username, email FROM users WHERE id = $1", userID).Scan(&currentUsername, &email)
+	if err == sql.ErrNoRows {
+		return time.Time{}, errors.New("user not found")
+	}
+	if err != nil {
+		return time.Time{}, err
+	}
+	if username != currentUsername {
+		return time.Time{}, errors.New("username does not match")
+	}
+	if err := s.userService.VerifyPassword(userID, password); err != nil {
+		return time.Time{}, err
+	}
+
+	var deleteAt time.Time
+	err = s.db.QueryRow(`
+		UPDATE users SET deletion_scheduled_at = $1
+		WHERE id = $2 AND deletion_scheduled_at IS NULL
+		RETURNING deletion_scheduled_at`,
+		time.Now().Add(s.gracePeriod), userID).Scan(&deleteAt)
+	if err == sql.ErrNoRows {
+		return time.Time{}, errors.New("account deletion is already scheduled")
+	}
+	if err != nil {
+		return time.Time{}, err
+	}
+
+	content := fmt.Sprintf("Your account and all of its data will be deleted on %s. Cancel the deletion in your account settings to keep it.",
+		deleteAt.Format("January 2, 2006"))
+	err = s.notificationService.Notify(userID, "account_deletion", "Your account is scheduled for deletion", content,
+		map[string]interface{}{"scheduled_at": deleteAt})
+	if err != nil {
+		log.Printf("Failed to notify user %d about account deletion: %v", userID, err)
+	}
+
+	// Emailed as well, someone with the password alone should not delete the account unnoticed
+	if s.emailService.Enabled() && email.Valid && email.String != "" {
+		go func() {
+			if err := s.emailService.SendAccountDeletionScheduled(email.String, currentUsername, s.emailService.AppLink("/login"), s.gracePeriod); err != nil {
+				log.Printf("Failed to send account deletion email to user %d: %v", userID, err)
+			}
+		}()
+	}
+
+	return deleteAt, nil
+}
+
+// Keep the account after all
+func (s *AccountDeletionService) CancelDeletion(userID int) error {
+	result, err := s.db.Exec(`
+		UPDATE users SET deletion_scheduled_at = NULL
+		WHERE id = $1 AND deletion_scheduled_at IS NOT NULL`,
+		userID)
+	if err != nil {
+		return err
+	}
+	if affected, _ := result.RowsAffected(); affected == 0 {
+		return errors.New("account deletion is not scheduled")
+	}
+	return nil
+}
+
+// Returned for scheduled deletions cancelled since they were picked up
+var errDeletionNotDue = errors.New("account deletion is not due")
+
+// Delete the account and everything it owns right away for an admin, returns the deleted
+// username. Users whose role has permissions the admin's lacks and the last admin are refused.
+func (s *AccountDeletionService) DeleteAccount(actorID, userID int) (string, error) {
+	return s.deleteAccount(actorID, userID, false)
+}
+
+func (s *AccountDeletionService) deleteAccount(actorID, userID int, scheduledOnly bool) (string, error) {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return "", err
+	}
+	defer tx.Rollback()
+
+	if !scheduledOnly {
+		if err := checkCanManageUser(tx, actorID, userID); err != nil {
+			return "", err
+		}
+	}
+
+	var username string
+	var due bool
+	err = tx.QueryRow(`
+		SELECT username, COALESCE(deletion_scheduled_at <= CURRENT_TIMESTAMP, FALSE)
+		FROM users WHERE id = $1 FOR UPDATE`,
+		userID).Scan(&username, &due)
+	if err == sql.ErrNoRows {
+		return "", errors.New("user not found")
+	}
+	if err != nil {
+		return "", err
+	}
+	if scheduledOnly && !due {
+		return "", errDeletionNotDue
+	}
+
+	// Stored content is collected before the rows pointing to it cascade away
+	rows, err := tx.Query(`
+		SELECT file_path FROM files WHERE user_id = $1
+		UNION
+		SELECT v.file_path FROM file_versions v JOIN files f ON f.id = v.file_id WHERE f.user_id = $1
+		UNION
+		SELECT file_path FROM data_exports WHERE user_id = $1 AND file_path IS NOT NULL`,
+		userID)
+	if err != nil {
+		return "", err
+	}
+	var paths []string
+	for rows.Next() {
+		var path string
+		if err := rows.Scan(&path); err != nil {
+			rows.Close()
+			return "", err
+		}
+		paths = append(paths, path)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return "", err
+	}
+
+	// Versions the user saved of others' files stay with those files
+	if _, err := tx.Exec("UPDATE file_versions SET created_by = NULL WHERE created_by = $1", userID); err != nil {
+		return "", err
+	}
+
+	// Groups the user owned are handed to the next member in line, as when an owner leaves
+	_, err = tx.Exec(`
+		UPDATE conversation_members m SET role = 'owner'
+		FROM (
+			SELECT DISTINCT ON (cm.conversation_id) cm.conversation_id, cm.user_id
+			FROM conversation_members cm
+			JOIN conversation_members o ON o.conversation_id = cm.conversation_id AND o.user_id = $1 AND o.role = 'owner'
+			WHERE cm.user_id <> $1
+			ORDER BY cm.conversation_id, cm.role = 'admin' DESC, cm.joined_at, cm.user_id
+		) heir
+		WHERE m.conversation_id = heir.conversation_id AND m.user_id = heir.user_id`,
+		userID)
+	if err != nil {
+		return "", err
+	}
+
+	// Direct conversations lose their other side and groups left empty have no one to read them
+	_, err = tx.Exec(`
+		DELETE FROM conversations c
+		WHERE c.id IN (SELECT conversation_id FROM conversation_members WHERE user_id = $1)
+			AND (c.type = 'direct' OR NOT EXISTS (
+				SELECT 1 FROM conversation_members o WHERE o.conversation_id = c.id AND o.user_id <> $1
+			))`,
+		userID)
+	if err != nil {
+		return "", err
+	}
+
+	if _, err := tx.Exec("DELETE FROM login_throttles WHERE scope = 'account' AND identifier = $1", throttleIdentifier("account", username)); err != nil {
+		return "", err
+	}
+
+	// Files, versions, shares, messages, friendships, sessions and the rest cascade from here
+	if _, err := tx.Exec("DELETE FROM users WHERE id = $1", userID); err != nil {
+		return "", err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return "", err
+	}
+
+	for _, path := range paths {
+		s.removeStoredFile(path)
+	}
+
+	return username, nil
+}
+
+// Remove stored content unless another file still points to it
+func (s *AccountDeletionService) removeStoredFile(path string) {
+	var referenced bool
+	err := s.db.QueryRow(`
+		SELECT EXISTS (SELECT 1 FROM files WHERE file_path = $1)
+			OR EXISTS (SELECT 1 FROM file_versions WHERE file_path = $1)`,
+		path).Scan(&referenced)
+	if err != nil || referenced {
+		return
+	}
+	if utils.FileExists(path) {
+		if err := os.Remove(path); err != nil {
+			log.Printf("Failed to remove %s of a deleted account: %v", path, err)
+		}
+	}
+}
+
+// Delete the accounts whose grace period has passed, returns the number deleted
+func (s *AccountDeletionService) ProcessDeletions() (int, error) {
+	rows, err := s.db.Query(`
+		SELECT id FROM users
+		WHERE deletion_scheduled_at <= CURRENT_TIMESTAMP
+		ORDER BY deletion_scheduled_at`)
+	if err != nil {
+		return 0, err
+	}
+	var userIDs []int
+	for rows.Next() {
+		var userID int
+		if err := rows.Scan(&userID); err != nil {
+			rows.Close()
+			return 0, err
+		}
+		userIDs = append(userIDs, userID)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return 0, err
+	}
+
+	deleted := 0
+	for _, userID := range userIDs {
+		if _, err := s.deleteAccount(0, userID, true); err != nil {
+			if err != errDeletionNotDue {
+				log.Printf("Failed to delete account %d: %v", userID, err)
+			}
+			continue
+		}
+		deleted++
+
+		// Only the ID is kept, the account is gone
+		err := s.auditService.Record(0, "", "account.delete", "user", fmt.Sprint(userID), map[string]interface{}{"scheduled": true})
+		if err != nil {
+			log.Printf("Failed to record audit entry account.delete: %v", err)
+		}
+	}
+	return deleted, nil
+}
+
+// Delete accounts as their grace period ends until the process exits
+func (s *AccountDeletionService) RunDeletions(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for range ticker.C {
+		if _, err := s.ProcessDeletions(); err != nil {
+			log.Printf("Failed to process account deletions: %v", err)
+		}
+	}
+}
@@ -0,0 +1,178 @@
+package services
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+	"ai-doc-system/internal/auth"
+	"ai-doc-system/internal/models"
+	"github.com/lib/pq"
+)
+
+const maxAPITokensPerUser = 50
+
+type APITokenService struct {
+	db *sql.DB
+}
+
+func NewAPITokenService(db *sql.DB) *APITokenService {
+	return &APITokenService{db: db}
+}
+
+// Create a named token with the scopes; the returned token is not stored and cannot be shown again
+func (s *APITokenService) CreateToken(userID int, name string, scopes []string, expiresAt *time.Time) (string, *models.APIToken, error) {
+	name = strings.TrimSpace(name)
+	if name == "" {
+		return "", nil, errors.New("token name is required")
+	}
+	if len(scopes) == 0 {
+		return "", nil, errors.New("at least one scope is required")
+	}
+	seen := make(map[string]bool)
+	var uniqueScopes []string
+	for _, scope := range scopes {
+		if !auth.ValidScope(scope) {
+			return "", nil, fmt.Errorf("unknown scope %q", scope)
+		}
+		if !seen[scope] {
+			seen[scope] = true
+			uniqueScopes = append(uniqueScopes, scope)
+		}
+	}
+	if expiresAt != nil && !expiresAt.After(time.Now()) {
+		return "", nil, errors.New("expiry must be in the future")
+	}
+
+	var count int
+	err := s.db.QueryRow(`
+		SELECT COUNT(*) FROM api_tokens
+		WHERE user_id = $1 AND revoked_at IS NULL AND (expires_at IS NULL OR expires_at > CURRENT_TIMESTAMP)`,
+		userID).Scan(&count)
+	if err != nil {
+		return "", nil, err
+	}
+	if count >= maxAPITokensPerUser {
+		return "", nil, fmt.Errorf("you can have at most %d active tokens", maxAPITokensPerUser)
+	}
+
+	secret, err := generateToken()
+	if err != nil {
+		return "", nil, err
+	}
+	token := auth.APITokenPrefix + secret
+
+	apiToken := models.APIToken{
+		Name:        name,
+		TokenPrefix: token[:len(auth.APITokenPrefix)+6],
+		Scopes:      uniqueScopes,
+		ExpiresAt:   expiresAt,
+	}
+	err = s.db.QueryRow(`
+		INSERT INTO api_tokens (user_id, name, token_hash, token_prefix, scopes, expires_at)
+		VALUES ($1, $2, $3, $4, $5, $6)
+		RETURNING id, created_at`,
+		userID, name, hashToken(token), apiToken.TokenPrefix, pq.Array(uniqueScopes), expiresAt).Scan(&apiToken.ID, &apiToken.CreatedAt)
+	if err != nil {
+		return "", nil, err
+	}
+
+	return token, &apiToken, nil
+}
+
+// Get user's tokens that were not revoked, expired ones included so they can be told apart
+func (s *APITokenService) GetTokens(userID int) ([]models.APIToken, error) {
+	rows, err := s.db.Query(`
+		SELECT id, name, token_prefix, scopes, expires_at, last_used_at, created_at
+		FROM api_tokens
+		WHERE user_id = $1 AND revoked_at IS NULL
+		ORDER BY created_at DESC`,
+		userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	tokens := []models.APIToken{}
+	for rows.Next() {
+		var token models.APIToken
+		err := rows.Scan(&token.ID, &token.Name, &token.TokenPrefix, pq.Array(&token.Scopes),
+			&token.ExpiresAt, &token.LastUsedAt, &token.CreatedAt)
+		if err != nil {
+			return nil, err
+		}
+		tokens = append(tokens, token)
+	}
+
+	return tokens, rows.Err()
+}
+
+// Revoke one of the user's tokens, it stops working immediately
+func (s *APITokenService) RevokeToken(tokenID, userID int) error {
+	result, err := s.db.Exec(`
+		UPDATE api_tokens SET revoked_at = CURRENT_TIMESTAMP
+		WHERE id = $1 AND user_id = $2 AND revoked_at IS NULL`,
+		tokenID, userID)
+	if err != nil {
+		return err
+	}
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if rowsAffected == 0 {
+		return errors.New("token not found or permission denied")
+	}
+	return nil
+}
+
+// Revoke all of the user's tokens, returns how many were revoked
+func (s *APITokenService) RevokeAllTokens(userID int) (int, error) {
+	return revokeAPITokens(s.db, userID)
+}
+
+type execer interface {
+	Exec(query string, args ...interface{}) (sql.Result, error)
+}
+
+// Revoked along with the sessions when the password is reset, tokens someone created with a
+// stolen password must not outlive it
+func revokeAPITokens(e execer, userID int) (int, error) {
+	result, err := e.Exec("UPDATE api_tokens SET revoked_at = CURRENT_TIMESTAMP WHERE user_id = $1 AND revoked_at IS NULL", userID)
+	if err != nil {
+		return 0, err
+	}
+	rowsAffected, err := result.RowsAffected()
+	return int(rowsAffected), err
+}
+
+// AuthenticateAPIToken is checked for every request made with a personal access token
+func (s *APITokenService) AuthenticateAPIToken(token string) (*auth.Claims, error) {
+	var claims auth.Claims
+	err := s.db.QueryRow(`
+		SELECT t.id, t.scopes, u.id, u.username, u.role
+		FROM api_tokens t
+		JOIN users u ON u.id = t.user_id
+		WHERE t.token_hash = $1 AND t.revoked_at IS NULL
+			AND (t.expires_at IS NULL OR t.expires_at > CURRENT_TIMESTAMP)
+			AND u.disabled_at IS NULL`,
+		hashToken(token)).Scan(&claims.APITokenID, pq.Array(&claims.Scopes), &claims.UserID, &claims.Username, &claims.Role)
+	if err == sql.ErrNoRows {
+		return nil, errors.New("invalid token")
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	// Last use is tracked to the minute, so busy scripts do not write on every request
+	_, err = s.db.Exec(`
+		UPDATE api_tokens SET last_used_at = CURRENT_TIMESTAMP
+		WHERE id = $1 AND (last_used_at IS NULL OR last_used_at < CURRENT_TIMESTAMP - INTERVAL '1 minute')`,
+		claims.APITokenID)
+	if err != nil {
+		return nil, err
+	}
+
+	return &claims, nil
+}
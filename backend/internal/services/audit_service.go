@@ -0,0 +1,108 @@
+package services
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"ai-doc-system/internal/models"
+)
+
+type AuditService struct {
+	db *sql.DB
+}
+
+func NewAuditService(db *sql.DB) *AuditService {
+	return &AuditService{db: db}
+}
+
+// AuditQueryOptions filter the audit log, zero values match everything
+type AuditQueryOptions struct {
+	Action     string // Exact action, or a prefix ending in "." such as "role."
+	ActorID    int
+	TargetType string
+	TargetID   string
+	Limit      int
+	Offset     int
+}
+
+// Record an action by actorID, 0 for actions the system takes itself; details are stored as JSON
+func (s *AuditService) Record(actorID int, ipAddress, action, targetType, targetID string, details map[string]interface{}) error {
+	// Sent as text, the driver would send bytes in binary format
+	var detailsJSON interface{}
+	if details != nil {
+		encoded, err := json.Marshal(details)
+		if err != nil {
+			return err
+		}
+		detailsJSON = string(encoded)
+	}
+
+	_, err := s.db.Exec(`
+		INSERT INTO audit_log (actor_id, actor_username, action, target_type, target_id, details, ip_address)
+		SELECT NULLIF($1, 0), COALESCE((SELECT username FROM users WHERE id = $1), ''), $2, $3, $4, $5::jsonb, NULLIF($6, '')`,
+		actorID, action, targetType, targetID, detailsJSON, ipAddress)
+	return err
+}
+
+// Get a page of audit entries, newest first
+func (s *AuditService) GetEntries(opts AuditQueryOptions) (*models.AuditPage, error) {
+	var conditions []string
+	var args []interface{}
+	addCondition := func(condition string, value interface{}) {
+		args = append(args, value)
+		conditions = append(conditions, fmt.Sprintf(condition, len(args)))
+	}
+	if strings.HasSuffix(opts.Action, ".") {
+		addCondition("action LIKE $%d || '%%'", opts.Action)
+	} else if opts.Action != "" {
+		addCondition("action = $%d", opts.Action)
+	}
+	if opts.ActorID != 0 {
+		addCondition("actor_id = $%d", opts.ActorID)
+	}
+	if opts.TargetType != "" {
+		addCondition("target_type = $%d", opts.TargetType)
+	}
+	if opts.TargetID != "" {
+		addCondition("target_id = $%d", opts.TargetID)
+	}
+	where := ""
+	if len(conditions) > 0 {
+		where = "WHERE " + strings.Join(conditions, " AND ")
+	}
+
+	page := &models.AuditPage{Entries: []models.AuditEntry{}}
+	err := s.db.QueryRow("SELECT COUNT(*) FROM audit_log "+where, args...).Scan(&page.Total)
+	if err != nil {
+		return nil, err
+	}
+
+	args = append(args, opts.Limit, opts.Offset)
+	rows, err := s.db.Query(fmt.Sprintf(`
+		SELECT id, actor_id, actor_username, action, target_type, target_id, details, COALESCE(ip_address, ''), created_at
+		FROM audit_log %s
+		ORDER BY created_at DESC, id DESC
+		LIMIT $%d OFFSET $%d`,
+		where, len(args)-1, len(args)), args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var entry models.AuditEntry
+		var details []byte
+		err := rows.Scan(&entry.ID, &entry.ActorID, &entry.ActorUsername, &entry.Action, &entry.TargetType,
+			&entry.TargetID, &details, &entry.IPAddress, &entry.CreatedAt)
+		if err != nil {
+			return nil, err
+		}
+		if details != nil {
+			entry.Details = json.RawMessage(details)
+		}
+		page.Entries = append(page.Entries, entry)
+	}
+
+	return page, rows.Err()
+}
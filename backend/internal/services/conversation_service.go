@@ -0,0 +1,401 @@
+package services
+
+import (
+	"database/sql"
+	"errors"
+	"log"
+
+	"ai-doc-system/internal/models"
+	"ai-doc-system/internal/realtime"
+	"github.com/lib/pq"
+)
+
+type ConversationService struct {
+	db  *sql.DB
+	hub *realtime.Hub
+}
+
+func NewConversationService(db *sql.DB, hub *realtime.Hub) *ConversationService {
+	return &ConversationService{db: db, hub: hub}
+}
+
+// Push event to every current member of a conversation plus any extra users (e.g. a removed member)
+func (s *ConversationService) publish(conversationID int, eventType string, data interface{}, extraUserIDs ...int) {
+	if s.hub == nil {
+		return
+	}
+	memberIDs, err := conversationMemberIDs(s.db, conversationID)
+	if err != nil {
+		log.Printf("Failed to get members of conversation %d: %v", conversationID, err)
+		return
+	}
+	if err := s.hub.Publish(append(memberIDs, extraUserIDs...), eventType, data); err != nil {
+		log.Printf("Failed to publish %s event: %v", eventType, err)
+	}
+}
+
+// memberRole returns the role of the user in a group conversation, "" if not a member
+func (s *ConversationService) memberRole(conversationID, userID int) (string, error) {
+	var conversationType, role string
+	err := s.db.QueryRow(`
+		SELECT c.type, cm.role FROM conversations c
+		JOIN conversation_members cm ON cm.conversation_id = c.id
+		WHERE c.id = $1 AND cm.user_id = $2`,
+		conversationID, userID).Scan(&conversationType, &role)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return "", errors.New("conversation not found")
+		}
+		return "", err
+	}
+	if conversationType != "group" {
+		return "", errors.New("only group conversations can be managed")
+	}
+	return role, nil
+}
+
+// Check that every user is an accepted friend of userID
+func (s *ConversationService) checkFriends(userID int, friendIDs []int) error {
+	var count int
+	err := s.db.QueryRow(`
+		SELECT COUNT(DISTINCT friend_id) FROM friendships
+		WHERE user_id = $1 AND friend_id = ANY($2) AND status = 'accepted'`,
+		userID, pq.Array(friendIDs)).Scan(&count)
+	if err != nil {
+		return err
+	}
+	if count != len(friendIDs) {
+		return errors.New("can only add friends to a group")
+	}
+	return nil
+}
+
+func uniqueIDs(ids []int, exclude int) []int {
+	seen := map[int]bool{exclude: true}
+	var result []int
+	for _, id := range ids {
+		if !seen[id] {
+			seen[id] = true
+			result = append(result, id)
+		}
+	}
+	return result
+}
+
+// Create group conversation with several friends
+func (s *ConversationService) CreateGroup(ownerID int, name string, memberIDs []int) (*models.Conversation, error) {
+	memberIDs = uniqueIDs(memberIDs, ownerID)
+	if len(memberIDs) == 0 {
+		return nil, errors.New("a group needs at least one other member")
+	}
+	if err := s.checkFriends(ownerID, memberIDs); err != nil {
+		return nil, err
+	}
+
+	tx, err := s.db.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	var conversation models.Conversation
+	err = tx.QueryRow(`
+		INSERT INTO conversations (type, name, created_by)
+		VALUES ('group', $1, $2)
+		RETURNING id, type, name, created_by, created_at, updated_at`,
+		name, ownerID).Scan(&conversation.ID, &conversation.Type, &conversation.Name,
+		&conversation.CreatedBy, &conversation.CreatedAt, &conversation.UpdatedAt)
+	if err != nil {
+		return nil, err
+	}
+
+	_, err = tx.Exec(`
+		INSERT INTO conversation_members (conversation_id, user_id, role)
+		VALUES ($1, $2, 'owner')`,
+		conversation.ID, ownerID)
+	if err != nil {
+		return nil, err
+	}
+
+	_, err = tx.Exec(`
+		INSERT INTO conversation_members (conversation_id, user_id, role)
+		SELECT $1, unnest($2::INTEGER[]), 'member'`,
+		conversation.ID, pq.Array(memberIDs))
+	if err != nil {
+		return nil, err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, err
+	}
+
+	members, err := s.getMembers(conversation.ID)
+	if err != nil {
+		return nil, err
+	}
+	conversation.Members = members
+
+	s.publish(conversation.ID, "conversation.created", conversation)
+
+	return &conversation, nil
+}
+
+func (s *ConversationService) getMembers(conversationID int) ([]models.ConversationMember, error) {
+	rows, err := s.db.Query(`
+		SELECT cm.conversation_id, cm.user_id, u.username, u.avatar, cm.role,
+		       cm.last_read_message_id, cm.joined_at
+		FROM conversation_members cm
+		JOIN users u ON u.id = cm.user_id
+		WHERE cm.conversation_id = $1
+		ORDER BY cm.joined_at, u.username`,
+		conversationID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var members []models.ConversationMember
+	for rows.Next() {
+		var member models.ConversationMember
+		err := rows.Scan(&member.ConversationID, &member.UserID, &member.Username, &member.Avatar,
+			&member.Role, &member.LastReadMessageID, &member.JoinedAt)
+		if err != nil {
+			return nil, err
+		}
+		members = append(members, member)
+	}
+
+	return members, nil
+}
+
+// Get conversation with its members (read receipts) and the user's unread count
+func (s *ConversationService) GetConversation(conversationID, userID int) (*models.Conversation, error) {
+	var conversation models.Conversation
+	err := s.db.QueryRow(`
+		SELECT c.id, c.type, COALESCE(c.name, ''), c.created_by, c.created_at, c.updated_at,
+		       (SELECT COUNT(*) FROM messages m
+		        WHERE m.conversation_id = c.id AND m.id > cm.last_read_message_id AND m.sender_id != $2)
+		FROM conversations c
+		JOIN conversation_members cm ON cm.conversation_id = c.id
+		WHERE c.id = $1 AND cm.user_id = $2`,
+		conversationID, userID).Scan(&conversation.ID, &conversation.Type, &conversation.Name,
+		&conversation.CreatedBy, &conversation.CreatedAt, &conversation.UpdatedAt, &conversation.UnreadCount)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, errors.New("conversation not found")
+		}
+		return nil, err
+	}
+
+	members, err := s.getMembers(conversationID)
+	if err != nil {
+		return nil, err
+	}
+	conversation.Members = members
+
+	return &conversation, nil
+}
+
+// Rename group (owner and admins)
+func (s *ConversationService) RenameGroup(conversationID, userID int, name string) error {
+	role, err := s.memberRole(conversationID, userID)
+	if err != nil {
+		return err
+	}
+	if role != "owner" && role != "admin" {
+		return errors.New("permission denied")
+	}
+
+	_, err = s.db.Exec(`
+		UPDATE conversations SET name = $1, updated_at = CURRENT_TIMESTAMP
+		WHERE id = $2`,
+		name, conversationID)
+	if err != nil {
+		return err
+	}
+
+	s.publish(conversationID, "conversation.updated", map[string]interface{}{
+		"conversation_id": conversationID,
+		"name":            name,
+	})
+
+	return nil
+}
+
+// Add friends of the acting owner/admin to a group
+func (s *ConversationService) AddMembers(conversationID, userID int, memberIDs []int) error {
+	role, err := s.memberRole(conversationID, userID)
+	if err != nil {
+		return err
+	}
+	if role != "owner" && role != "admin" {
+		return errors.New("permission denied")
+	}
+
+	memberIDs = uniqueIDs(memberIDs, userID)
+	if len(memberIDs) == 0 {
+		return errors.New("no members to add")
+	}
+	if err := s.checkFriends(userID, memberIDs); err != nil {
+		return err
+	}
+
+	// New members start with the existing history already read
+	_, err = s.db.Exec(`
+		INSERT INTO conversation_members (conversation_id, user_id, role, last_read_message_id)
+		SELECT $1, unnest($2::INTEGER[]), 'member',
+		       COALESCE((SELECT MAX(id) FROM messages WHERE conversation_id = $1), 0)
+		ON CONFLICT (conversation_id, user_id) DO NOTHING`,
+		conversationID, pq.Array(memberIDs))
+	if err != nil {
+		return err
+	}
+
+	s.publish(conversationID, "conversation.members_added", map[string]interface{}{
+		"conversation_id": conversationID,
+		"user_ids":        memberIDs,
+		"added_by":        userID,
+	})
+
+	return nil
+}
+
+// Remove a member from a group. Admins may remove members, the owner may remove anyone.
+func (s *ConversationService) RemoveMember(conversationID, userID, memberID int) error {
+	if userID == memberID {
+		return errors.New("use leave to exit a group")
+	}
+
+	role, err := s.memberRole(conversationID, userID)
+	if err != nil {
+		return err
+	}
+	memberRole, err := s.memberRole(conversationID, memberID)
+	if err != nil {
+		return errors.New("member not found")
+	}
+
+	if !(role == "owner" || (role == "admin" && memberRole == "member")) {
+		return errors.New("permission denied")
+	}
+
+	_, err = s.db.Exec(`
+		DELETE FROM conversation_members
+		WHERE conversation_id = $1 AND user_id = $2`,
+		conversationID, memberID)
+	if err != nil {
+		return err
+	}
+
+	s.publish(conversationID, "conversation.member_removed", map[string]interface{}{
+		"conversation_id": conversationID,
+		"user_id":         memberID,
+		"removed_by":      userID,
+	}, memberID)
+
+	return nil
+}
+
+// Promote or demote a member (owner only)
+func (s *ConversationService) SetMemberRole(conversationID, userID, memberID int, newRole string) error {
+	if newRole != "admin" && newRole != "member" {
+		return errors.New("role must be admin or member")
+	}
+
+	role, err := s.memberRole(conversationID, userID)
+	if err != nil {
+		return err
+	}
+	if role != "owner" {
+		return errors.New("only the group owner can change roles")
+	}
+	if userID == memberID {
+		return errors.New("cannot change your own role")
+	}
+
+	result, err := s.db.Exec(`
+		UPDATE conversation_members SET role = $3
+		WHERE conversation_id = $1 AND user_id = $2`,
+		conversationID, memberID, newRole)
+	if err != nil {
+		return err
+	}
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if rowsAffected == 0 {
+		return errors.New("member not found")
+	}
+
+	s.publish(conversationID, "conversation.updated", map[string]interface{}{
+		"conversation_id": conversationID,
+		"user_id":         memberID,
+		"role":            newRole,
+	})
+
+	return nil
+}
+
+// Leave a group. An owner leaving hands the group over to the longest-standing admin, or member.
+// The group is deleted when its last member leaves.
+func (s *ConversationService) LeaveConversation(conversationID, userID int) error {
+	role, err := s.memberRole(conversationID, userID)
+	if err != nil {
+		return err
+	}
+
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	_, err = tx.Exec(`
+		DELETE FROM conversation_members
+		WHERE conversation_id = $1 AND user_id = $2`,
+		conversationID, userID)
+	if err != nil {
+		return err
+	}
+
+	var remaining int
+	err = tx.QueryRow("SELECT COUNT(*) FROM conversation_members WHERE conversation_id = $1", conversationID).Scan(&remaining)
+	if err != nil {
+		return err
+	}
+
+	if remaining == 0 {
+		if _, err := tx.Exec("DELETE FROM conversations WHERE id = $1", conversationID); err != nil {
+			return err
+		}
+		return tx.Commit()
+	}
+
+	if role == "owner" {
+		_, err = tx.Exec(`
+			UPDATE conversation_members SET role = 'owner'
+			WHERE conversation_id = $1 AND user_id = (
+				SELECT user_id FROM conversation_members
+				WHERE conversation_id = $1
+				ORDER BY role = 'admin' DESC, joined_at, user_id
+				LIMIT 1
+			)`,
+			conversationID)
+		if err != nil {
+			return err
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return err
+	}
+
+	s.publish(conversationID, "conversation.member_removed", map[string]interface{}{
+		"conversation_id": conversationID,
+		"user_id":         userID,
+		"removed_by":      userID,
+	}, userID)
+
+	return nil
+}
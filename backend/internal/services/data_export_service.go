@@ -0,0 +1,532 @@
+package services
+
+import (
+	"archive/zip"
+	"bytes"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+	"ai-doc-system/internal/models"
+)
+
+const (
+	dataExportTTL          = 7 * 24 * time.Hour // Archives can be downloaded this long
+	dataExportCooldown     = time.Hour          // Minimum time between exports of one user
+	dataExportClaimTimeout = time.Hour          // Running exports are started over after this if the worker died
+	dataExportHistory      = 30 * 24 * time.Hour
+)
+
+// JSON documents of the archive besides files.json, each query builds one for the user $1.
+// Password hashes, TOTP secrets and token hashes are left out.
+var dataExportSections = []struct {
+	name  string
+	query string
+}{
+	{"profile.json", `
+		SELECT json_build_object(
+			'user', (SELECT row_to_json(u) FROM (
+				SELECT id, username, email, role, avatar, profile, email_notifications, discoverable, auth_source,
+					totp_enabled, storage_quota, password_changed_at, deletion_scheduled_at, created_at, updated_at
+				FROM users WHERE id = $1) u),
+			'identities', (SELECT COALESCE(json_agg(i ORDER BY i.created_at), '[]') FROM (
+				SELECT issuer, subject, email, created_at, last_login_at
+				FROM user_identities WHERE user_id = $1) i),
+			'sessions', (SELECT COALESCE(json_agg(s ORDER BY s.created_at), '[]') FROM (
+				SELECT device, ip_address, created_at, last_used_at, expires_at, revoked_at, revoke_reason
+				FROM sessions WHERE user_id = $1) s),
+			'api_tokens', (SELECT COALESCE(json_agg(t ORDER BY t.created_at), '[]') FROM (
+				SELECT name, token_prefix, scopes, expires_at, last_used_at, revoked_at, created_at
+				FROM api_tokens WHERE user_id = $1) t),
+			'notification_preferences', (SELECT COALESCE(json_agg(p ORDER BY p.type), '[]') FROM (
+				SELECT type, enabled FROM notification_preferences WHERE user_id = $1) p),
+			'notifications', (SELECT COALESCE(json_agg(n ORDER BY n.created_at), '[]') FROM (
+				SELECT type, title, content, data, is_read, created_at
+				FROM notifications WHERE user_id = $1) n)
+		)`},
+	{"messages.json", `
+		SELECT json_build_object(
+			'conversations', (SELECT COALESCE(json_agg(c ORDER BY c.id), '[]') FROM (
+				SELECT c.id, c.type, c.name, m.role, m.joined_at,
+					(SELECT array_agg(u.username ORDER BY u.username)
+					 FROM conversation_members cm JOIN users u ON u.id = cm.user_id
+					 WHERE cm.conversation_id = c.id) AS members
+				FROM conversations c
+				JOIN conversation_members m ON m.conversation_id = c.id AND m.user_id = $1) c),
+			'messages', (SELECT COALESCE(json_agg(m ORDER BY m.id), '[]') FROM (
+				SELECT m.id, m.conversation_id, s.username AS sender, m.content, m.message_type, m.file_id,
+					m.reply_to_id, m.edited_at, m.read_at, m.created_at,
+					(SELECT COALESCE(json_agg(json_build_object('content', e.content, 'edited_at', e.edited_at) ORDER BY e.edited_at), '[]')
+					 FROM message_edits e WHERE e.message_id = m.id AND m.sender_id = $1) AS edits
+				FROM messages m
+				LEFT JOIN users s ON s.id = m.sender_id
+				WHERE m.sender_id = $1 OR m.receiver_id = $1
+					OR m.conversation_id IN (SELECT conversation_id FROM conversation_members WHERE user_id = $1)) m),
+			'reactions', (SELECT COALESCE(json_agg(r ORDER BY r.created_at), '[]') FROM (
+				SELECT message_id, emoji, created_at FROM message_reactions WHERE user_id = $1) r)
+		)`},
+	{"shares.json", `
+		SELECT json_build_object(
+			'shared_by_me', (SELECT COALESCE(json_agg(s ORDER BY s.id), '[]') FROM (
+				SELECT fs.id, fs.file_id, f.original_name AS file_name, fs.share_type, w.username AS shared_with,
+					fs.expires_at, fs.created_at
+				FROM file_shares fs
+				JOIN files f ON f.id = fs.file_id
+				LEFT JOIN users w ON w.id = fs.shared_with
+				WHERE fs.created_by = $1) s),
+			'shared_with_me', (SELECT COALESCE(json_agg(s ORDER BY s.id), '[]') FROM (
+				SELECT fs.id, f.original_name AS file_name, o.username AS owner, fs.expires_at, fs.created_at
+				FROM file_shares fs
+				JOIN files f ON f.id = fs.file_id
+				JOIN users o ON o.id = f.user_id
+				WHERE fs.shared_with = $1) s),
+			'file_requests', (SELECT COALESCE(json_agg(r ORDER BY r.id), '[]') FROM (
+				SELECT r.id, r.title, r.description, r.folder, r.max_file_size, r.max_files, r.allowed_extensions,
+					r.upload_count, r.is_active, r.expires_at, r.created_at,
+					(SELECT COALESCE(json_agg(json_build_object('file_id', u.file_id, 'uploader_name', u.uploader_name, 'created_at', u.created_at) ORDER BY u.id), '[]')
+					 FROM file_request_uploads u WHERE u.request_id = r.id) AS uploads
+				FROM file_requests r WHERE r.user_id = $1) r),
+			'file_transfers', (SELECT COALESCE(json_agg(t ORDER BY t.id), '[]') FROM (
+				SELECT t.id, t.file_id, t.folder, fu.username AS from_user, tu.username AS to_user, t.status,
+					t.file_count, t.total_size, t.created_at, t.responded_at
+				FROM file_transfers t
+				LEFT JOIN users fu ON fu.id = t.from_user_id
+				LEFT JOIN users tu ON tu.id = t.to_user_id
+				WHERE t.from_user_id = $1 OR t.to_user_id = $1) t)
+		)`},
+	{"friends.json", `
+		SELECT json_build_object(
+			'friends', (SELECT COALESCE(json_agg(f ORDER BY f.username), '[]') FROM (
+				SELECT u.username, f.status, g.group_name, f.created_at
+				FROM friendships f
+				JOIN users u ON u.id = f.friend_id
+				LEFT JOIN friend_groups g ON g.id = f.group_id
+				WHERE f.user_id = $1) f),
+			'incoming_requests', (SELECT COALESCE(json_agg(f ORDER BY f.created_at), '[]') FROM (
+				SELECT u.username, f.created_at
+				FROM friendships f
+				JOIN users u ON u.id = f.user_id
+				WHERE f.friend_id = $1 AND f.status = 'pending') f),
+			'groups', (SELECT COALESCE(json_agg(g ORDER BY g.position, g.id), '[]') FROM (
+				SELECT id, group_name, position, created_at FROM friend_groups WHERE user_id = $1) g)
+		)`},
+}
+
+// Entry of files.json, path points to the content inside the archive
+type exportedFile struct {
+	ID        int                   `json:"id"`
+	Name      string                `json:"name"`
+	Folder    string                `json:"folder"`
+	Size      int64                 `json:"size"`
+	MimeType  string                `json:"mime_type"`
+	Path      string                `json:"path"` // Current content, empty when it is missing from storage
+	Versions  []exportedFileVersion `json:"versions"`
+	CreatedAt time.Time             `json:"created_at"`
+	UpdatedAt time.Time             `json:"updated_at"`
+	filePath  string
+}
+
+type exportedFileVersion struct {
+	VersionNumber int       `json:"version_number"`
+	Path          string    `json:"path"`
+	CreatedAt     time.Time `json:"created_at"`
+	filePath      string
+}
+
+// DataExportService builds zip archives of everything stored about a user: profile,
+// files with all their versions, messages, shares and friends.
+type DataExportService struct {
+	db                  *sql.DB
+	exportPath          string
+	notificationService *NotificationService
+}
+
+func NewDataExportService(db *sql.DB, exportPath string, notificationService *NotificationService) *DataExportService {
+	return &DataExportService{db: db, exportPath: exportPath, notificationService: notificationService}
+}
+
+// Queue an export of the user's data, the worker picks it up
+func (s *DataExportService) RequestExport(userID int) (*models.DataExport, error) {
+	var active, recent int
+	err := s.db.QueryRow(`
+		SELECT COUNT(*) FILTER (WHERE status IN ('pending', 'running')),
+			COUNT(*) FILTER (WHERE created_at > CURRENT_TIMESTAMP - $2 * INTERVAL '1 second')
+		FROM data_exports WHERE user_id = $1`,
+		userID, int(dataExportCooldown.Seconds())).Scan(&active, &recent)
+	if err != nil {
+		return nil, err
+	}
+	if active > 0 {
+		return nil, errors.New("an export is already being prepared")
+	}
+	if recent > 0 {
+		return nil, errors.New("an export was requested less than an hour ago")
+	}
+
+	var export models.DataExport
+	err = s.db.QueryRow(`
+		INSERT INTO data_exports (user_id) VALUES ($1)
+		RETURNING id, status, created_at`,
+		userID).Scan(&export.ID, &export.Status, &export.CreatedAt)
+	if err != nil {
+		return nil, err
+	}
+	return &export, nil
+}
+
+// The user's recent exports, newest first
+func (s *DataExportService) GetExports(userID int) ([]models.DataExport, error) {
+	rows, err := s.db.Query(`
+		SELECT id, status, file_size, COALESCE(error, ''), completed_at, expires_at, created_at
+		FROM data_exports WHERE user_id = $1
+		ORDER BY created_at DESC
+		LIMIT 20`,
+		userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	exports := []models.DataExport{}
+	for rows.Next() {
+		var export models.DataExport
+		err := rows.Scan(&export.ID, &export.Status, &export.FileSize, &export.Error,
+			&export.CompletedAt, &export.ExpiresAt, &export.CreatedAt)
+		if err != nil {
+			return nil, err
+		}
+		exports = append(exports, export)
+	}
+	return exports, rows.Err()
+}
+
+// Archive of a finished export of the user and when it was requested
+func (s *DataExportService) GetExportFile(exportID, userID int) (string, time.Time, error) {
+	var status string
+	var filePath sql.NullString
+	var expiresAt sql.NullTime
+	var createdAt time.Time
+	err := s.db.QueryRow(`
+		SELECT status, file_path, expires_at, created_at
+		FROM data_exports WHERE id = $1 AND user_id = $2`,
+		exportID, userID).Scan(&status, &filePath, &expiresAt, &createdAt)
+	if err == sql.ErrNoRows {
+		return "", time.Time{}, errors.New("export not found")
+	}
+	if err != nil {
+		return "", time.Time{}, err
+	}
+	if status != "ready" || !filePath.Valid {
+		return "", time.Time{}, errors.New("export is not available for download")
+	}
+	if expiresAt.Valid && expiresAt.Time.Before(time.Now()) {
+		return "", time.Time{}, errors.New("export has expired")
+	}
+	return filePath.String, createdAt, nil
+}
+
+// Generate pending exports one at a time, returns the number handled
+func (s *DataExportService) ProcessExports() (int, error) {
+	s.expireExports()
+
+	handled := 0
+	for {
+		// SKIP LOCKED lets several instances work the exports side by side
+		var exportID, userID int
+		err := s.db.QueryRow(`
+			UPDATE data_exports SET status = 'running', started_at = CURRENT_TIMESTAMP
+			WHERE id = (
+				SELECT id FROM data_exports
+				WHERE status = 'pending'
+					OR (status = 'running' AND started_at < CURRENT_TIMESTAMP - $1 * INTERVAL '1 second')
+				ORDER BY created_at
+				LIMIT 1
+				FOR UPDATE SKIP LOCKED
+			)
+			RETURNING id, user_id`,
+			int(dataExportClaimTimeout.Seconds())).Scan(&exportID, &userID)
+		if err == sql.ErrNoRows {
+			return handled, nil
+		}
+		if err != nil {
+			return handled, err
+		}
+
+		s.generate(exportID, userID)
+		handled++
+	}
+}
+
+func (s *DataExportService) generate(exportID, userID int) {
+	archivePath, size, err := s.writeArchive(exportID, userID)
+	if err != nil {
+		log.Printf("Failed to export data of user %d: %v", userID, err)
+		_, err = s.db.Exec(`
+			UPDATE data_exports SET status = 'failed', error = $1, completed_at = CURRENT_TIMESTAMP
+			WHERE id = $2`,
+			"The export could not be generated, please request a new one", exportID)
+		if err != nil {
+			log.Printf("Failed to record export %d failure: %v", exportID, err)
+		}
+		return
+	}
+
+	expiresAt := time.Now().Add(dataExportTTL)
+	result, err := s.db.Exec(`
+		UPDATE data_exports SET status = 'ready', file_path = $1, file_size = $2, completed_at = CURRENT_TIMESTAMP, expires_at = $3
+		WHERE id = $4 AND status = 'running'`,
+		archivePath, size, expiresAt, exportID)
+	if err == nil {
+		if affected, _ := result.RowsAffected(); affected == 0 {
+			err = errors.New("export no longer exists")
+		}
+	}
+	if err != nil {
+		// The account may have been deleted in the meantime
+		log.Printf("Failed to complete export %d: %v", exportID, err)
+		os.Remove(archivePath)
+		return
+	}
+
+	err = s.notificationService.Notify(userID, "data_export_ready", "Your data export is ready",
+		fmt.Sprintf("Download it before %s, after that it is deleted.", expiresAt.Format("January 2, 2006")),
+		map[string]interface{}{"export_id": exportID})
+	if err != nil {
+		log.Printf("Failed to notify user %d about export %d: %v", userID, exportID, err)
+	}
+}
+
+// Write the archive of the user's data, returns its path and size
+func (s *DataExportService) writeArchive(exportID, userID int) (string, int64, error) {
+	if err := os.MkdirAll(s.exportPath, 0755); err != nil {
+		return "", 0, err
+	}
+	token, err := generateToken()
+	if err != nil {
+		return "", 0, err
+	}
+	archivePath := filepath.Join(s.exportPath, fmt.Sprintf("export_%d_%s.zip", exportID, token[:16]))
+
+	out, err := os.Create(archivePath)
+	if err != nil {
+		return "", 0, err
+	}
+	zw := zip.NewWriter(out)
+
+	err = s.writeSections(zw, userID)
+	if err == nil {
+		err = s.writeFiles(zw, userID)
+	}
+	if closeErr := zw.Close(); err == nil {
+		err = closeErr
+	}
+	if closeErr := out.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		os.Remove(archivePath)
+		return "", 0, err
+	}
+
+	info, err := os.Stat(archivePath)
+	if err != nil {
+		return "", 0, err
+	}
+	return archivePath, info.Size(), nil
+}
+
+func (s *DataExportService) writeSections(zw *zip.Writer, userID int) error {
+	for _, section := range dataExportSections {
+		var document []byte
+		if err := s.db.QueryRow(section.query, userID).Scan(&document); err != nil {
+			return fmt.Errorf("%s: %w", section.name, err)
+		}
+		if err := writeJSONEntry(zw, section.name, json.RawMessage(document)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Add the content of every version of the user's files and describe them in files.json
+func (s *DataExportService) writeFiles(zw *zip.Writer, userID int) error {
+	files, err := s.exportedFiles(userID)
+	if err != nil {
+		return err
+	}
+
+	for i := range files {
+		file := &files[i]
+		dir := fmt.Sprintf("files/%d/", file.ID)
+		name := exportFileName(file.Name)
+
+		for j := range file.Versions {
+			version := &file.Versions[j]
+			archiveName := fmt.Sprintf("%sv%d-%s", dir, version.VersionNumber, name)
+			if ok, err := writeFileEntry(zw, archiveName, version.filePath); err != nil {
+				return err
+			} else if ok {
+				version.Path = archiveName
+			}
+			if version.filePath == file.filePath {
+				file.Path = version.Path
+			}
+		}
+
+		// Content saved without a version record
+		if file.Path == "" {
+			archiveName := dir + name
+			if ok, err := writeFileEntry(zw, archiveName, file.filePath); err != nil {
+				return err
+			} else if ok {
+				file.Path = archiveName
+			}
+		}
+	}
+
+	return writeJSONEntry(zw, "files.json", files)
+}
+
+func (s *DataExportService) exportedFiles(userID int) ([]exportedFile, error) {
+	rows, err := s.db.Query(`
+		SELECT id, original_name, folder, file_size, COALESCE(mime_type, ''), file_path, created_at, updated_at
+		FROM files WHERE user_id = $1
+		ORDER BY id`,
+		userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	files := []exportedFile{}
+	index := map[int]int{}
+	for rows.Next() {
+		file := exportedFile{Versions: []exportedFileVersion{}}
+		err := rows.Scan(&file.ID, &file.Name, &file.Folder, &file.Size, &file.MimeType, &file.filePath,
+			&file.CreatedAt, &file.UpdatedAt)
+		if err != nil {
+			return nil, err
+		}
+		index[file.ID] = len(files)
+		files = append(files, file)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	versionRows, err := s.db.Query(`
+		SELECT v.file_id, v.version_number, v.file_path, v.created_at
+		FROM file_versions v
+		JOIN files f ON f.id = v.file_id
+		WHERE f.user_id = $1
+		ORDER BY v.file_id, v.version_number`,
+		userID)
+	if err != nil {
+		return nil, err
+	}
+	defer versionRows.Close()
+
+	for versionRows.Next() {
+		var fileID int
+		var version exportedFileVersion
+		if err := versionRows.Scan(&fileID, &version.VersionNumber, &version.filePath, &version.CreatedAt); err != nil {
+			return nil, err
+		}
+		if i, ok := index[fileID]; ok {
+			files[i].Versions = append(files[i].Versions, version)
+		}
+	}
+	return files, versionRows.Err()
+}
+
+// Remove archives past their expiry and forget old exports
+func (s *DataExportService) expireExports() {
+	rows, err := s.db.Query(`
+		WITH expired AS (
+			SELECT id, file_path FROM data_exports
+			WHERE status = 'ready' AND expires_at <= CURRENT_TIMESTAMP
+			FOR UPDATE SKIP LOCKED
+		)
+		UPDATE data_exports d SET status = 'expired', file_path = NULL
+		FROM expired
+		WHERE d.id = expired.id
+		RETURNING expired.file_path`)
+	if err != nil {
+		log.Printf("Failed to expire data exports: %v", err)
+		return
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var archivePath sql.NullString
+		if err := rows.Scan(&archivePath); err == nil && archivePath.Valid {
+			os.Remove(archivePath.String)
+		}
+	}
+
+	s.db.Exec(`
+		DELETE FROM data_exports
+		WHERE status IN ('failed', 'expired') AND created_at < CURRENT_TIMESTAMP - $1 * INTERVAL '1 second'`,
+		int(dataExportHistory.Seconds()))
+}
+
+// Generate exports until the process exits
+func (s *DataExportService) RunExports(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for range ticker.C {
+		if _, err := s.ProcessExports(); err != nil {
+			log.Printf("Failed to process data exports: %v", err)
+		}
+	}
+}
+
+func writeJSONEntry(zw *zip.Writer, name string, value interface{}) error {
+	data, err := json.MarshalIndent(value, "", "  ")
+	if err != nil {
+		return err
+	}
+	w, err := zw.Create(name)
+	if err != nil {
+		return err
+	}
+	_, err = io.Copy(w, bytes.NewReader(data))
+	return err
+}
+
+// Copy a stored file into the archive, reports false when it is missing from storage
+func writeFileEntry(zw *zip.Writer, name, filePath string) (bool, error) {
+	in, err := os.Open(filePath)
+	if os.IsNotExist(err) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	defer in.Close()
+
+	w, err := zw.Create(name)
+	if err != nil {
+		return false, err
+	}
+	if _, err := io.Copy(w, in); err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+// Display name made safe to use as a name inside the archive
+func exportFileName(name string) string {
+	name = filepath.Base(strings.ReplaceAll(name, "\\", "/"))
+	if name == "." || name == "/" || name == ".." {
+		return "file"
+	}
+	return name
+}
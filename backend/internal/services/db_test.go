@@ -0,0 +1,69 @@
+package services
+
+import (
+	"database/sql"
+	"os"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+	"ai-doc-system/internal/database"
+)
+
+var migrateOnce sync.Once
+var migrateErr error
+
+// openTestDB connects to the Postgres database named by TEST_DATABASE_URL and applies the
+// migrations, the test is skipped when the variable is not set. Tests share the database,
+// so they create their own rows with names from uniqueSuffix.
+func openTestDB(t *testing.T) *sql.DB {
+	t.Helper()
+	dsn := os.Getenv("TEST_DATABASE_URL")
+	if dsn == "" {
+		t.Skip("TEST_DATABASE_URL is not set")
+	}
+
+	db, err := sql.Open("postgres", dsn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { db.Close() })
+
+	// Migrate reads the migrations relative to the backend directory
+	migrateOnce.Do(func() {
+		wd, err := os.Getwd()
+		if err != nil {
+			migrateErr = err
+			return
+		}
+		if err := os.Chdir("../.."); err != nil {
+			migrateErr = err
+			return
+		}
+		defer os.Chdir(wd)
+		migrateErr = database.Migrate(db)
+	})
+	if migrateErr != nil {
+		t.Fatal(migrateErr)
+	}
+	return db
+}
+
+// uniqueSuffix keeps names of rows created by different test runs apart
+func uniqueSuffix() string {
+	return strconv.FormatInt(time.Now().UnixNano()%1e9, 36)
+}
+
+func createTestUser(t *testing.T, db *sql.DB, username, email string) int {
+	t.Helper()
+	var userID int
+	err := db.QueryRow(`
+		INSERT INTO users (username, password_hash, email) VALUES ($1, '', NULLIF($2, ''))
+		RETURNING id`,
+		username, email).Scan(&userID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { db.Exec("DELETE FROM users WHERE id = $1", userID) })
+	return userID
+}
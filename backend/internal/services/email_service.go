@@ -0,0 +1,404 @@
+package services
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+	"ai-doc-system/internal/mail"
+	"ai-doc-system/internal/models"
+)
+
+const (
+	emailBatchSize      = 20
+	emailMaxAttempts    = 5
+	emailRetryBaseDelay = time.Minute
+	emailClaimTimeout   = 5 * time.Minute // Claimed emails are retried after this if the worker died
+	digestInterval      = 24 * time.Hour
+	digestMaxItems      = 50
+)
+
+// Email templates of the notification types that are also sent by email
+var notificationEmailTemplates = map[string]string{
+	"friend_request": "friend_request",
+	"file_shared":    "file_shared",
+	"message":        "unread_messages",
+}
+
+// Unread message emails wait so users who are reading along are not emailed
+var notificationEmailDelays = map[string]time.Duration{
+	"message": 10 * time.Minute,
+}
+
+var EmailNotificationModes = []string{"instant", "digest", "off"}
+
+type EmailService struct {
+	db     *sql.DB
+	mailer *mail.Mailer // Nil when SMTP is not configured
+	appURL string
+}
+
+func NewEmailService(db *sql.DB, mailer *mail.Mailer, appURL string) *EmailService {
+	return &EmailService{
+		db:     db,
+		mailer: mailer,
+		appURL: strings.TrimRight(appURL, "/"),
+	}
+}
+
+// Data available to the email templates
+type emailTemplateData struct {
+	Username      string
+	AppURL        string
+	Notification  *models.Notification
+	Notifications []models.Notification
+	Link          string // Action link of account emails such as password resets
+	ExpiresIn     string
+}
+
+func (s *EmailService) Enabled() bool {
+	return s.mailer != nil
+}
+
+// Queue the email for a new notification if its type has a template and the user wants instant emails
+func (s *EmailService) QueueNotification(notification *models.Notification) error {
+	templateName, ok := notificationEmailTemplates[notification.Type]
+	if !ok || !s.Enabled() {
+		return nil
+	}
+
+	var username, mode string
+	var email sql.NullString
+	err := s.db.QueryRow("SELECT username, email, email_notifications FROM users WHERE id = $1",
+		notification.UserID).Scan(&username, &email, &mode)
+	if err != nil {
+		return err
+	}
+	if !email.Valid || email.String == "" || mode != "instant" {
+		return nil
+	}
+
+	subject, body, err := mail.Render(templateName, emailTemplateData{
+		Username:     username,
+		AppURL:       s.appURL,
+		Notification: notification,
+	})
+	if err != nil {
+		return err
+	}
+
+	_, err = s.db.Exec(`
+		INSERT INTO email_queue (user_id, notification_id, to_address, subject, body, next_attempt_at)
+		VALUES ($1, $2, $3, $4, $5, $6)`,
+		notification.UserID, notification.ID, email.String, subject, body,
+		time.Now().Add(notificationEmailDelays[notification.Type]))
+	return err
+}
+
+type queuedEmail struct {
+	id             int
+	notificationID sql.NullInt64
+	to             string
+	subject        string
+	body           string
+	attempts       int
+}
+
+// Send due emails from the queue, returns the number delivered
+func (s *EmailService) ProcessQueue() (int, error) {
+	if !s.Enabled() {
+		return 0, nil
+	}
+
+	// Claim a batch; SKIP LOCKED lets several instances work the queue side by side
+	rows, err := s.db.Query(`
+		UPDATE email_queue SET attempts = attempts + 1, next_attempt_at = $1
+		WHERE id IN (
+			SELECT id FROM email_queue
+			WHERE sent_at IS NULL AND failed_at IS NULL AND next_attempt_at <= CURRENT_TIMESTAMP
+			ORDER BY next_attempt_at
+			LIMIT $2
+			FOR UPDATE SKIP LOCKED
+		)
+		RETURNING id, notification_id, to_address, subject, body, attempts`,
+		time.Now().Add(emailClaimTimeout), emailBatchSize)
+	if err != nil {
+		return 0, err
+	}
+
+	var emails []queuedEmail
+	for rows.Next() {
+		var email queuedEmail
+		if err := rows.Scan(&email.id, &email.notificationID, &email.to, &email.subject, &email.body, &email.attempts); err != nil {
+			rows.Close()
+			return 0, err
+		}
+		emails = append(emails, email)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return 0, err
+	}
+
+	sent := 0
+	for _, email := range emails {
+		if email.notificationID.Valid {
+			// Nothing to tell if the user has seen the notification in the meantime
+			var isRead bool
+			err := s.db.QueryRow("SELECT is_read FROM notifications WHERE id = $1", email.notificationID.Int64).Scan(&isRead)
+			if err == nil && isRead {
+				s.db.Exec("DELETE FROM email_queue WHERE id = $1", email.id)
+				continue
+			}
+		}
+
+		if err := s.mailer.Send(email.to, email.subject, email.body); err != nil {
+			s.recordFailure(email, err)
+			continue
+		}
+
+		if _, err := s.db.Exec("UPDATE email_queue SET sent_at = CURRENT_TIMESTAMP, last_error = NULL WHERE id = $1", email.id); err != nil {
+			log.Printf("Failed to mark email %d as sent: %v", email.id, err)
+		}
+		sent++
+	}
+
+	return sent, nil
+}
+
+// Schedule the next attempt with exponential backoff, or give up after the last one
+func (s *EmailService) recordFailure(email queuedEmail, sendErr error) {
+	log.Printf("Failed to send email %d (attempt %d): %v", email.id, email.attempts, sendErr)
+
+	var err error
+	if email.attempts >= emailMaxAttempts {
+		_, err = s.db.Exec(`
+			UPDATE email_queue SET failed_at = CURRENT_TIMESTAMP, last_error = $1
+			WHERE id = $2`,
+			sendErr.Error(), email.id)
+	} else {
+		delay := emailRetryBaseDelay * time.Duration(1<<uint(email.attempts-1))
+		_, err = s.db.Exec(`
+			UPDATE email_queue SET next_attempt_at = $1, last_error = $2
+			WHERE id = $3`,
+			time.Now().Add(delay), sendErr.Error(), email.id)
+	}
+	if err != nil {
+		log.Printf("Failed to record email %d failure: %v", email.id, err)
+	}
+}
+
+// Work the email queue until the process exits
+func (s *EmailService) RunQueue(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for range ticker.C {
+		if _, err := s.ProcessQueue(); err != nil {
+			log.Printf("Failed to process email queue: %v", err)
+		}
+	}
+}
+
+type digestRecipient struct {
+	userID   int
+	username string
+	email    string
+	since    time.Time
+}
+
+// Queue a summary of unread notifications for digest users whose last one is a day old
+func (s *EmailService) SendDigests() (int, error) {
+	if !s.Enabled() {
+		return 0, nil
+	}
+
+	// Moving last_digest_at first makes sure each digest is only queued by one instance
+	rows, err := s.db.Query(`
+		WITH due AS (
+			SELECT id, last_digest_at FROM users
+			WHERE email_notifications = 'digest' AND email IS NOT NULL AND email <> ''
+				AND (last_digest_at IS NULL OR last_digest_at <= $1)
+			FOR UPDATE SKIP LOCKED
+		)
+		UPDATE users u SET last_digest_at = CURRENT_TIMESTAMP
+		FROM due
+		WHERE u.id = due.id
+		RETURNING u.id, u.username, u.email, COALESCE(due.last_digest_at, $1)`,
+		time.Now().Add(-digestInterval))
+	if err != nil {
+		return 0, err
+	}
+
+	var recipients []digestRecipient
+	for rows.Next() {
+		var recipient digestRecipient
+		if err := rows.Scan(&recipient.userID, &recipient.username, &recipient.email, &recipient.since); err != nil {
+			rows.Close()
+			return 0, err
+		}
+		recipients = append(recipients, recipient)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return 0, err
+	}
+
+	queued := 0
+	for _, recipient := range recipients {
+		notifications, err := s.unreadNotificationsSince(recipient.userID, recipient.since)
+		if err != nil {
+			log.Printf("Failed to collect digest for user %d: %v", recipient.userID, err)
+			continue
+		}
+		if len(notifications) == 0 {
+			continue
+		}
+
+		subject, body, err := mail.Render("digest", emailTemplateData{
+			Username:      recipient.username,
+			AppURL:        s.appURL,
+			Notifications: notifications,
+		})
+		if err != nil {
+			return queued, err
+		}
+
+		_, err = s.db.Exec(`
+			INSERT INTO email_queue (user_id, to_address, subject, body)
+			VALUES ($1, $2, $3, $4)`,
+			recipient.userID, recipient.email, subject, body)
+		if err != nil {
+			return queued, err
+		}
+		queued++
+	}
+
+	return queued, nil
+}
+
+func (s *EmailService) unreadNotificationsSince(userID int, since time.Time) ([]models.Notification, error) {
+	rows, err := s.db.Query(`
+		SELECT `+notificationColumns+`
+		FROM notifications
+		WHERE user_id = $1 AND is_read = FALSE AND created_at > $2
+		ORDER BY id DESC
+		LIMIT $3`,
+		userID, since, digestMaxItems)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var notifications []models.Notification
+	for rows.Next() {
+		notification, err := scanNotification(rows)
+		if err != nil {
+			return nil, err
+		}
+		notifications = append(notifications, *notification)
+	}
+
+	return notifications, rows.Err()
+}
+
+// Queue daily digests until the process exits
+func (s *EmailService) RunDigest(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for range ticker.C {
+		if _, err := s.SendDigests(); err != nil {
+			log.Printf("Failed to send email digests: %v", err)
+		}
+	}
+}
+
+// Set the user's email address and how they want to be notified by email
+func (s *EmailService) UpdateEmailSettings(userID int, email, mode string) error {
+	if !isEmailNotificationMode(mode) {
+		return errors.New("invalid email notification mode")
+	}
+
+	email = strings.TrimSpace(email)
+	if email != "" {
+		var count int
+		err := s.db.QueryRow("SELECT COUNT(*) FROM users WHERE LOWER(email) = LOWER($1) AND id <> $2", email, userID).Scan(&count)
+		if err != nil {
+			return err
+		}
+		if count > 0 {
+			return errors.New("email already in use")
+		}
+	}
+
+	_, err := s.db.Exec(`
+		UPDATE users SET email = NULLIF($1, ''), email_notifications = $2, updated_at = CURRENT_TIMESTAMP
+		WHERE id = $3`,
+		email, mode, userID)
+	return err
+}
+
+// Send a test email straight away so admins can check the SMTP settings
+func (s *EmailService) SendTestEmail(to string) error {
+	if !s.Enabled() {
+		return errors.New("email is not configured")
+	}
+	return s.mailer.Send(to, "Test email", "This is a test email from AI Document System.\n"+s.appURL+"\n")
+}
+
+// Send a password reset link right away, account emails do not go through the notification queue
+func (s *EmailService) SendPasswordReset(to, username, link string, expiresIn time.Duration) error {
+	if !s.Enabled() {
+		return errors.New("email is not configured")
+	}
+
+	expires := fmt.Sprintf("%d minutes", int(expiresIn.Minutes()))
+	if expiresIn >= 2*time.Hour {
+		expires = fmt.Sprintf("%d hours", int(expiresIn.Hours()))
+	}
+	subject, body, err := mail.Render("password_reset", emailTemplateData{
+		Username:  username,
+		AppURL:    s.appURL,
+		Link:      link,
+		ExpiresIn: expires,
+	})
+	if err != nil {
+		return err
+	}
+	return s.mailer.Send(to, subject, body)
+}
+
+// Tell the user their account will be deleted, with a link to log in and cancel it
+func (s *EmailService) SendAccountDeletionScheduled(to, username, link string, gracePeriod time.Duration) error {
+	if !s.Enabled() {
+		return errors.New("email is not configured")
+	}
+
+	subject, body, err := mail.Render("account_deletion", emailTemplateData{
+		Username:  username,
+		AppURL:    s.appURL,
+		Link:      link,
+		ExpiresIn: fmt.Sprintf("%d days", int(gracePeriod.Hours()/24)),
+	})
+	if err != nil {
+		return err
+	}
+	return s.mailer.Send(to, subject, body)
+}
+
+// Address of a frontend page, e.g. "/reset-password"
+func (s *EmailService) AppLink(path string) string {
+	return s.appURL + path
+}
+
+func isEmailNotificationMode(mode string) bool {
+	for _, m := range EmailNotificationModes {
+		if m == mode {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,448 @@
+package services
+
+import (
+	"database/sql"
+	"strings"
+	"testing"
+	"time"
+	"ai-doc-system/internal/mail"
+	"ai-doc-system/internal/mail/mailtest"
+	"ai-doc-system/internal/models"
+)
+
+const testAppURL = "https://app.example"
+
+func newTestEmailService(t *testing.T, db *sql.DB) (*EmailService, *mailtest.Server) {
+	t.Helper()
+	server, err := mailtest.NewServer()
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { server.Close() })
+
+	mailer, err := mail.NewMailer(server.Host(), server.Port(), "", "", "noreply@example.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return NewEmailService(db, mailer, testAppURL+"/"), server
+}
+
+// Messages the server received for one address, other tests share the queue
+func messagesTo(server *mailtest.Server, address string) []mailtest.Message {
+	var messages []mailtest.Message
+	for _, message := range server.Messages() {
+		if len(message.To) == 1 && message.To[0] == address {
+			messages = append(messages, message)
+		}
+	}
+	return messages
+}
+
+func TestNotificationTemplates(t *testing.T) {
+	long := strings.Repeat("ä", 250)
+	for notificationType, templateName := range notificationEmailTemplates {
+		t.Run(notificationType, func(t *testing.T) {
+			notification := &models.Notification{Type: notificationType, Title: "Title of " + notificationType, Content: long}
+			subject, body, err := mail.Render(templateName, emailTemplateData{
+				Username:     "alice",
+				AppURL:       testAppURL,
+				Notification: notification,
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if subject != notification.Title {
+				t.Errorf("subject = %q, want the notification title", subject)
+			}
+			if !strings.HasPrefix(body, "Hi alice,\n") {
+				t.Errorf("body does not start with the greeting: %q", body)
+			}
+			if !strings.Contains(body, testAppURL+"/dashboard") {
+				t.Errorf("body does not link to the app: %q", body)
+			}
+			if !strings.Contains(body, strings.Repeat("ä", 200)) {
+				t.Errorf("body does not contain the notification content: %q", body)
+			}
+		})
+	}
+
+	// Message previews are cut short, other notifications are sent in full
+	_, body, err := mail.Render("unread_messages", emailTemplateData{
+		Username:     "alice",
+		AppURL:       testAppURL,
+		Notification: &models.Notification{Title: "New message", Content: long},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(body, strings.Repeat("ä", 201)) || !strings.Contains(body, strings.Repeat("ä", 200)+"...") {
+		t.Errorf("message preview is not truncated to 200 characters: %q", body)
+	}
+}
+
+func TestDigestTemplate(t *testing.T) {
+	notifications := []models.Notification{
+		{Title: "bob sent you a friend request", Content: "Say hi"},
+		{Title: "carol shared a file", Content: ""},
+	}
+
+	subject, body, err := mail.Render("digest", emailTemplateData{Username: "alice", AppURL: testAppURL, Notifications: notifications})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if subject != "Your daily summary: 2 unread notifications" {
+		t.Errorf("subject = %q", subject)
+	}
+	if !strings.Contains(body, "- bob sent you a friend request\n  Say hi\n") {
+		t.Errorf("body does not list the first notification with its content: %q", body)
+	}
+	if !strings.Contains(body, "- carol shared a file\n") {
+		t.Errorf("body does not list the second notification: %q", body)
+	}
+
+	subject, _, err = mail.Render("digest", emailTemplateData{Username: "alice", AppURL: testAppURL, Notifications: notifications[:1]})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if subject != "Your daily summary: 1 unread notification" {
+		t.Errorf("subject = %q", subject)
+	}
+}
+
+func TestSendAccountEmails(t *testing.T) {
+	s, server := newTestEmailService(t, nil)
+
+	if err := s.SendPasswordReset("alice@example.com", "alice", s.AppLink("/reset-password?token=abc"), time.Hour); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.SendPasswordReset("bob@example.com", "bob", s.AppLink("/reset-password?token=def"), 24*time.Hour); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.SendAccountDeletionScheduled("carol@example.com", "carol", s.AppLink("/login"), 30*24*time.Hour); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		to      string
+		subject string
+		want    []string
+	}{
+		{"alice@example.com", "Reset your password", []string{"Hi alice,", testAppURL + "/reset-password?token=abc\n", "expires in 60 minutes"}},
+		{"bob@example.com", "Reset your password", []string{"Hi bob,", testAppURL + "/reset-password?token=def\n", "expires in 24 hours"}},
+		{"carol@example.com", "Your account is scheduled for deletion", []string{"Hi carol,", "deleted in 30 days", testAppURL + "/login\n"}},
+	}
+	for _, tt := range tests {
+		messages := messagesTo(server, tt.to)
+		if len(messages) != 1 {
+			t.Errorf("%s received %d messages, want 1", tt.to, len(messages))
+			continue
+		}
+		if messages[0].Subject != tt.subject {
+			t.Errorf("%s: subject = %q, want %q", tt.to, messages[0].Subject, tt.subject)
+		}
+		for _, want := range tt.want {
+			if !strings.Contains(messages[0].Body, want) {
+				t.Errorf("%s: body does not contain %q: %q", tt.to, want, messages[0].Body)
+			}
+		}
+	}
+}
+
+func TestSendTestEmail(t *testing.T) {
+	s, server := newTestEmailService(t, nil)
+
+	if err := s.SendTestEmail("admin@example.com"); err != nil {
+		t.Fatal(err)
+	}
+	messages := messagesTo(server, "admin@example.com")
+	if len(messages) != 1 || !strings.Contains(messages[0].Body, testAppURL+"\n") {
+		t.Fatalf("messages = %+v, want one test email linking to the app", messages)
+	}
+}
+
+func TestEmailServiceWithoutMailer(t *testing.T) {
+	s := NewEmailService(nil, nil, testAppURL)
+
+	if s.Enabled() {
+		t.Error("service without a mailer is enabled")
+	}
+	if err := s.SendPasswordReset("alice@example.com", "alice", "link", time.Hour); err == nil {
+		t.Error("password reset email sent without a mailer")
+	}
+	if err := s.QueueNotification(&models.Notification{Type: "friend_request"}); err != nil {
+		t.Errorf("QueueNotification: %v", err)
+	}
+	if sent, err := s.ProcessQueue(); sent != 0 || err != nil {
+		t.Errorf("ProcessQueue() = %d, %v", sent, err)
+	}
+	if queued, err := s.SendDigests(); queued != 0 || err != nil {
+		t.Errorf("SendDigests() = %d, %v", queued, err)
+	}
+}
+
+func createTestEmailUser(t *testing.T, db *sql.DB, name, mode string) (int, string) {
+	t.Helper()
+	email := name + "@example.com"
+	userID := createTestUser(t, db, name, email)
+	if _, err := db.Exec("UPDATE users SET email_notifications = $1 WHERE id = $2", mode, userID); err != nil {
+		t.Fatal(err)
+	}
+	return userID, email
+}
+
+func insertTestNotification(t *testing.T, db *sql.DB, userID int, notificationType, title, content string) *models.Notification {
+	t.Helper()
+	notification, err := scanNotification(db.QueryRow(`
+		INSERT INTO notifications (user_id, type, title, content)
+		VALUES ($1, $2, $3, $4)
+		RETURNING `+notificationColumns,
+		userID, notificationType, title, content))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return notification
+}
+
+type testQueuedEmail struct {
+	subject       string
+	body          string
+	attempts      int
+	nextAttemptAt time.Time
+	lastError     sql.NullString
+	sent          bool
+	failed        bool
+}
+
+func queuedEmailsFor(t *testing.T, db *sql.DB, userID int) []testQueuedEmail {
+	t.Helper()
+	rows, err := db.Query(`
+		SELECT subject, body, attempts, next_attempt_at, last_error, sent_at IS NOT NULL, failed_at IS NOT NULL
+		FROM email_queue WHERE user_id = $1 ORDER BY id`,
+		userID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rows.Close()
+
+	var emails []testQueuedEmail
+	for rows.Next() {
+		var email testQueuedEmail
+		if err := rows.Scan(&email.subject, &email.body, &email.attempts, &email.nextAttemptAt, &email.lastError, &email.sent, &email.failed); err != nil {
+			t.Fatal(err)
+		}
+		emails = append(emails, email)
+	}
+	if err := rows.Err(); err != nil {
+		t.Fatal(err)
+	}
+	return emails
+}
+
+func TestQueueNotification(t *testing.T) {
+	db := openTestDB(t)
+	s, _ := newTestEmailService(t, db)
+	suffix := uniqueSuffix()
+
+	instant, _ := createTestEmailUser(t, db, "instant"+suffix, "instant")
+	digest, _ := createTestEmailUser(t, db, "digest"+suffix, "digest")
+	off, _ := createTestEmailUser(t, db, "off"+suffix, "off")
+	noAddress := createTestUser(t, db, "noaddress"+suffix, "")
+
+	for _, userID := range []int{instant, digest, off, noAddress} {
+		for _, notificationType := range []string{"friend_request", "message", "share_expiring"} {
+			notification := insertTestNotification(t, db, userID, notificationType, "Title "+notificationType, "Content")
+			if err := s.QueueNotification(notification); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+
+	for _, userID := range []int{digest, off, noAddress} {
+		if emails := queuedEmailsFor(t, db, userID); len(emails) != 0 {
+			t.Errorf("user %d has %d queued emails, want none", userID, len(emails))
+		}
+	}
+
+	// Only types with a template are emailed, unread message emails wait
+	emails := queuedEmailsFor(t, db, instant)
+	if len(emails) != 2 {
+		t.Fatalf("instant user has %d queued emails, want 2", len(emails))
+	}
+	if emails[0].subject != "Title friend_request" || !strings.Contains(emails[0].body, "Hi instant"+suffix) {
+		t.Errorf("friend request email = %q: %q", emails[0].subject, emails[0].body)
+	}
+	if emails[1].subject != "Title message" {
+		t.Errorf("message email subject = %q", emails[1].subject)
+	}
+	if delay := emails[1].nextAttemptAt.Sub(emails[0].nextAttemptAt); delay < 9*time.Minute {
+		t.Errorf("message email is due %s after the friend request email, want about 10 minutes", delay)
+	}
+}
+
+func TestProcessQueue(t *testing.T) {
+	db := openTestDB(t)
+	s, server := newTestEmailService(t, db)
+	suffix := uniqueSuffix()
+	userID, address := createTestEmailUser(t, db, "queue"+suffix, "instant")
+
+	due := insertTestNotification(t, db, userID, "friend_request", "Due", "Content")
+	if err := s.QueueNotification(due); err != nil {
+		t.Fatal(err)
+	}
+	delayed := insertTestNotification(t, db, userID, "message", "Delayed", "Content")
+	if err := s.QueueNotification(delayed); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := s.ProcessQueue(); err != nil {
+		t.Fatal(err)
+	}
+	messages := messagesTo(server, address)
+	if len(messages) != 1 || messages[0].Subject != "Due" {
+		t.Fatalf("messages = %+v, want only the due email", messages)
+	}
+	emails := queuedEmailsFor(t, db, userID)
+	if !emails[0].sent || emails[0].attempts != 1 || emails[1].sent || emails[1].attempts != 0 {
+		t.Fatalf("queue = %+v, want the first email sent and the second waiting", emails)
+	}
+
+	// The delayed email is dropped when its notification was read before it was due
+	if _, err := db.Exec("UPDATE notifications SET is_read = TRUE WHERE id = $1", delayed.ID); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := db.Exec("UPDATE email_queue SET next_attempt_at = CURRENT_TIMESTAMP - INTERVAL '1 second' WHERE user_id = $1 AND sent_at IS NULL", userID); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.ProcessQueue(); err != nil {
+		t.Fatal(err)
+	}
+	if messages := messagesTo(server, address); len(messages) != 1 {
+		t.Errorf("received %d messages, want the read notification not emailed", len(messages))
+	}
+	if emails := queuedEmailsFor(t, db, userID); len(emails) != 1 {
+		t.Errorf("queue has %d emails, want the dropped one deleted", len(emails))
+	}
+}
+
+func TestProcessQueueRetries(t *testing.T) {
+	db := openTestDB(t)
+	s, server := newTestEmailService(t, db)
+	suffix := uniqueSuffix()
+	userID, address := createTestEmailUser(t, db, "retry"+suffix, "instant")
+
+	if err := s.QueueNotification(insertTestNotification(t, db, userID, "file_shared", "Shared", "Content")); err != nil {
+		t.Fatal(err)
+	}
+
+	// A refused email is retried after a backoff
+	server.Refuse(1)
+	before := time.Now()
+	if _, err := s.ProcessQueue(); err != nil {
+		t.Fatal(err)
+	}
+	emails := queuedEmailsFor(t, db, userID)
+	if emails[0].sent || emails[0].failed || emails[0].attempts != 1 || !emails[0].lastError.Valid {
+		t.Fatalf("queue after a refusal = %+v, want a recorded failure", emails[0])
+	}
+	if emails[0].nextAttemptAt.Before(before.Add(emailRetryBaseDelay / 2)) {
+		t.Errorf("next attempt at %s, want about %s from now", emails[0].nextAttemptAt, emailRetryBaseDelay)
+	}
+
+	// Not retried before the backoff has passed
+	if _, err := s.ProcessQueue(); err != nil {
+		t.Fatal(err)
+	}
+	if emails := queuedEmailsFor(t, db, userID); emails[0].attempts != 1 {
+		t.Errorf("attempts = %d, want no retry during the backoff", emails[0].attempts)
+	}
+
+	// The last attempt failing gives up on the email
+	_, err := db.Exec(`
+		UPDATE email_queue SET attempts = $1, next_attempt_at = CURRENT_TIMESTAMP - INTERVAL '1 second'
+		WHERE user_id = $2`,
+		emailMaxAttempts-1, userID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	server.Refuse(1)
+	if _, err := s.ProcessQueue(); err != nil {
+		t.Fatal(err)
+	}
+	emails = queuedEmailsFor(t, db, userID)
+	if !emails[0].failed || emails[0].sent {
+		t.Errorf("queue after the last attempt = %+v, want the email failed", emails[0])
+	}
+	if messages := messagesTo(server, address); len(messages) != 0 {
+		t.Errorf("received %d messages, want none", len(messages))
+	}
+}
+
+func TestSendDigests(t *testing.T) {
+	db := openTestDB(t)
+	s, server := newTestEmailService(t, db)
+	suffix := uniqueSuffix()
+
+	userID, address := createTestEmailUser(t, db, "digest"+suffix, "digest")
+	idle, _ := createTestEmailUser(t, db, "idle"+suffix, "digest")
+	insertTestNotification(t, db, userID, "friend_request", "bob sent you a friend request", "Say hi")
+	insertTestNotification(t, db, userID, "share_expiring", "Your share expires tomorrow", "")
+	read := insertTestNotification(t, db, userID, "file_shared", "Already read", "")
+	if _, err := db.Exec("UPDATE notifications SET is_read = TRUE WHERE id = $1", read.ID); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := s.SendDigests(); err != nil {
+		t.Fatal(err)
+	}
+
+	if emails := queuedEmailsFor(t, db, idle); len(emails) != 0 {
+		t.Errorf("user without unread notifications has %d queued digests, want none", len(emails))
+	}
+	emails := queuedEmailsFor(t, db, userID)
+	if len(emails) != 1 {
+		t.Fatalf("%d digests queued, want 1", len(emails))
+	}
+	if emails[0].subject != "Your daily summary: 2 unread notifications" {
+		t.Errorf("subject = %q", emails[0].subject)
+	}
+	if strings.Contains(emails[0].body, "Already read") || !strings.Contains(emails[0].body, "- bob sent you a friend request\n  Say hi") {
+		t.Errorf("body = %q, want only the unread notifications", emails[0].body)
+	}
+
+	// One digest per interval, even with new notifications
+	insertTestNotification(t, db, userID, "friend_request", "carol sent you a friend request", "")
+	if _, err := s.SendDigests(); err != nil {
+		t.Fatal(err)
+	}
+	if emails := queuedEmailsFor(t, db, userID); len(emails) != 1 {
+		t.Errorf("%d digests queued, want no second digest within a day", len(emails))
+	}
+
+	// Digests go out through the queue like other emails
+	if _, err := s.ProcessQueue(); err != nil {
+		t.Fatal(err)
+	}
+	messages := messagesTo(server, address)
+	if len(messages) != 1 || messages[0].Subject != emails[0].subject {
+		t.Errorf("messages = %+v, want the digest delivered", messages)
+	}
+
+	// The next digest only covers notifications since the last one
+	if _, err := db.Exec("UPDATE users SET last_digest_at = CURRENT_TIMESTAMP - INTERVAL '25 hours' WHERE id = $1", userID); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := db.Exec("UPDATE notifications SET created_at = CURRENT_TIMESTAMP - INTERVAL '26 hours' WHERE user_id = $1 AND title <> $2", userID, "carol sent you a friend request"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.SendDigests(); err != nil {
+		t.Fatal(err)
+	}
+	emails = queuedEmailsFor(t, db, userID)
+	if len(emails) != 2 || emails[1].subject != "Your daily summary: 1 unread notification" {
+		t.Fatalf("queue = %+v, want a second digest with the new notification", emails)
+	}
+	if !strings.Contains(emails[1].body, "carol sent you a friend request") || strings.Contains(emails[1].body, "bob sent you") {
+		t.Errorf("second digest body = %q", emails[1].body)
+	}
+}
@@ -0,0 +1,125 @@
+package services
+
+import (
+	"database/sql"
+	"errors"
+	"time"
+	"ai-doc-system/internal/auth"
+)
+
+// Lifetime of file access tokens unless the caller asks for another one
+const (
+	DefaultFileTokenTTL = 5 * time.Minute
+	MaxFileTokenTTL     = time.Hour
+)
+
+// FileAccessService issues and checks the scoped tokens that download, preview and edit URLs carry
+// instead of the user's session token
+type FileAccessService struct {
+	db        *sql.DB
+	jwtSecret string
+}
+
+func NewFileAccessService(db *sql.DB, jwtSecret string) *FileAccessService {
+	return &FileAccessService{db: db, jwtSecret: jwtSecret}
+}
+
+func isFileAction(action string) bool {
+	switch action {
+	case auth.FileActionDownload, auth.FileActionPreview, auth.FileActionEdit:
+		return true
+	}
+	return false
+}
+
+// Latest version number of a file the user owns. Files of disabled users and users who
+// must reset their password are not found, so their tokens stop working right away.
+func (s *FileAccessService) currentVersion(fileID, userID int) (int, error) {
+	var version int
+	err := s.db.QueryRow(`
+		SELECT COALESCE(MAX(v.version_number), 0)
+		FROM files f
+		JOIN users u ON u.id = f.user_id
+		LEFT JOIN file_versions v ON v.file_id = f.id
+		WHERE f.id = $1 AND f.user_id = $2
+		AND u.disabled_at IS NULL AND NOT u.password_reset_required
+		GROUP BY f.id`,
+		fileID, userID).Scan(&version)
+	if err == sql.ErrNoRows {
+		return 0, errors.New("file not found or permission denied")
+	}
+	return version, err
+}
+
+// Issue a token for one action on the current version of a file, oneTime tokens can be redeemed once
+func (s *FileAccessService) IssueToken(fileID, userID int, action string, oneTime bool, ttl time.Duration) (string, time.Time, error) {
+	if !isFileAction(action) {
+		return "", time.Time{}, errors.New("invalid file action")
+	}
+	if ttl <= 0 {
+		ttl = DefaultFileTokenTTL
+	}
+	if ttl > MaxFileTokenTTL {
+		ttl = MaxFileTokenTTL
+	}
+
+	version, err := s.currentVersion(fileID, userID)
+	if err != nil {
+		return "", time.Time{}, err
+	}
+
+	return auth.GenerateFileToken(fileID, version, action, userID, oneTime, ttl, s.jwtSecret)
+}
+
+// Check a token grants the action on the file and redeem it if it is single-use.
+// Tokens stop working once the file gets a new version or changes owner.
+func (s *FileAccessService) ValidateToken(token string, fileID int, action string) (*auth.FileTokenClaims, error) {
+	claims, err := auth.ValidateFileToken(token, s.jwtSecret)
+	if err != nil {
+		return nil, errors.New("invalid file token")
+	}
+	if claims.FileID != fileID || claims.Action != action {
+		return nil, errors.New("token is not valid for this file")
+	}
+
+	version, err := s.currentVersion(fileID, claims.UserID)
+	if err != nil {
+		return nil, err
+	}
+	if version != claims.Version {
+		return nil, errors.New("file has changed since the token was issued")
+	}
+
+	if claims.OneTime {
+		if err := s.redeem(claims); err != nil {
+			return nil, err
+		}
+	}
+
+	return claims, nil
+}
+
+func (s *FileAccessService) redeem(claims *auth.FileTokenClaims) error {
+	// Expired tokens are rejected by their signature check already, their IDs are no longer needed
+	if _, err := s.db.Exec("DELETE FROM used_file_tokens WHERE expires_at < NOW()"); err != nil {
+		return err
+	}
+
+	result, err := s.db.Exec(`
+		INSERT INTO used_file_tokens (jti, expires_at) VALUES ($1, $2)
+		ON CONFLICT (jti) DO NOTHING`,
+		claims.ID, claims.ExpiresAt.Time)
+	if err != nil {
+		return err
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if rowsAffected == 0 {
+		return errors.New("token has already been used")
+	}
+
+	return nil
+}
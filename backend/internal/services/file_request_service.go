@@ -0,0 +1,301 @@
+package services
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"log"
+	"mime/multipart"
+	"strings"
+
+	"ai-doc-system/internal/models"
+	"ai-doc-system/internal/utils"
+	"github.com/google/uuid"
+)
+
+// ErrInvalidFileRequestPassword is counted against the request and the uploader's address
+var ErrInvalidFileRequestPassword = errors.New("invalid password")
+
+type FileRequestService struct {
+	db                  *sql.DB
+	fileService         *FileService
+	notificationService *NotificationService
+}
+
+func NewFileRequestService(db *sql.DB, fileService *FileService, notificationService *NotificationService) *FileRequestService {
+	return &FileRequestService{
+		db:                  db,
+		fileService:         fileService,
+		notificationService: notificationService,
+	}
+}
+
+const fileRequestColumns = `id, user_id, title, COALESCE(description, ''), folder, request_token,
+	COALESCE(password_hash, ''), max_file_size, max_files, allowed_extensions,
+	upload_count, is_active, expires_at, created_at`
+
+type rowScanner interface {
+	Scan(dest ...interface{}) error
+}
+
+func scanFileRequest(row rowScanner) (*models.FileRequest, error) {
+	var request models.FileRequest
+	var allowedExtensions string
+	err := row.Scan(&request.ID, &request.UserID, &request.Title, &request.Description,
+		&request.Folder, &request.RequestToken, &request.PasswordHash, &request.MaxFileSize,
+		&request.MaxFiles, &allowedExtensions, &request.UploadCount, &request.IsActive,
+		&request.ExpiresAt, &request.CreatedAt)
+	if err != nil {
+		return nil, err
+	}
+
+	request.HasPassword = request.PasswordHash != ""
+	request.AllowedExtensions = []string{}
+	if allowedExtensions != "" {
+		request.AllowedExtensions = strings.Split(allowedExtensions, ",")
+	}
+
+	return &request, nil
+}
+
+// Create upload-only link into one of the owner's folders
+func (s *FileRequestService) CreateFileRequest(userID int, request *models.FileRequest, password string) (*models.FileRequest, error) {
+	folder, err := utils.CleanFolderPath(request.Folder)
+	if err != nil {
+		return nil, err
+	}
+
+	var extensions []string
+	for _, ext := range request.AllowedExtensions {
+		ext = strings.ToLower(strings.TrimPrefix(strings.TrimSpace(ext), "."))
+		if ext != "" {
+			extensions = append(extensions, ext)
+		}
+	}
+
+	var passwordHash *string
+	if password != "" {
+		hashed, err := utils.HashPassword(password)
+		if err != nil {
+			return nil, err
+		}
+		passwordHash = &hashed
+	}
+
+	row := s.db.QueryRow(`
+		INSERT INTO file_requests (user_id, title, description, folder, request_token, password_hash,
+			max_file_size, max_files, allowed_extensions, expires_at)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10)
+		RETURNING `+fileRequestColumns,
+		userID, request.Title, request.Description, folder, uuid.New().String(), passwordHash,
+		request.MaxFileSize, request.MaxFiles, strings.Join(extensions, ","), request.ExpiresAt)
+
+	return scanFileRequest(row)
+}
+
+// Get user's file requests
+func (s *FileRequestService) GetFileRequests(userID int) ([]models.FileRequest, error) {
+	rows, err := s.db.Query(`
+		SELECT `+fileRequestColumns+`
+		FROM file_requests
+		WHERE user_id = $1
+		ORDER BY created_at DESC`,
+		userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var requests []models.FileRequest
+	for rows.Next() {
+		request, err := scanFileRequest(rows)
+		if err != nil {
+			return nil, err
+		}
+		requests = append(requests, *request)
+	}
+
+	return requests, nil
+}
+
+// Get uploads received through a file request
+func (s *FileRequestService) GetFileRequestUploads(requestID, userID int) ([]models.FileRequestUpload, error) {
+	var count int
+	err := s.db.QueryRow("SELECT COUNT(*) FROM file_requests WHERE id = $1 AND user_id = $2", requestID, userID).Scan(&count)
+	if err != nil {
+		return nil, err
+	}
+	if count == 0 {
+		return nil, errors.New("file request not found or permission denied")
+	}
+
+	rows, err := s.db.Query(`
+		SELECT id, request_id, file_id, COALESCE(uploader_name, ''), COALESCE(uploader_ip, ''), created_at
+		FROM file_request_uploads
+		WHERE request_id = $1
+		ORDER BY created_at DESC`,
+		requestID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var uploads []models.FileRequestUpload
+	for rows.Next() {
+		var upload models.FileRequestUpload
+		err := rows.Scan(&upload.ID, &upload.RequestID, &upload.FileID,
+			&upload.UploaderName, &upload.UploaderIP, &upload.CreatedAt)
+		if err != nil {
+			return nil, err
+		}
+		uploads = append(uploads, upload)
+	}
+
+	return uploads, nil
+}
+
+// Close file request so the link stops accepting uploads
+func (s *FileRequestService) CloseFileRequest(requestID, userID int) error {
+	result, err := s.db.Exec(`
+		UPDATE file_requests SET is_active = FALSE
+		WHERE id = $1 AND user_id = $2`,
+		requestID, userID)
+	if err != nil {
+		return err
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if rowsAffected == 0 {
+		return errors.New("file request not found or permission denied")
+	}
+
+	return nil
+}
+
+// Delete file request (files already uploaded are kept)
+func (s *FileRequestService) DeleteFileRequest(requestID, userID int) error {
+	result, err := s.db.Exec(`
+		DELETE FROM file_requests
+		WHERE id = $1 AND user_id = $2`,
+		requestID, userID)
+	if err != nil {
+		return err
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if rowsAffected == 0 {
+		return errors.New("file request not found or permission denied")
+	}
+
+	return nil
+}
+
+// Get active file request by token
+func (s *FileRequestService) GetActiveFileRequest(requestToken string) (*models.FileRequest, error) {
+	row := s.db.QueryRow(`
+		SELECT `+fileRequestColumns+`
+		FROM file_requests
+		WHERE request_token = $1 AND is_active = TRUE
+		AND (expires_at IS NULL OR expires_at > CURRENT_TIMESTAMP)`,
+		requestToken)
+
+	request, err := scanFileRequest(row)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, errors.New("file request not found or expired")
+		}
+		return nil, err
+	}
+
+	return request, nil
+}
+
+// Upload file anonymously through a file request link
+func (s *FileRequestService) UploadToFileRequest(requestToken, password string, file *multipart.FileHeader, uploaderName, uploaderIP string) (*models.File, error) {
+	request, err := s.GetActiveFileRequest(requestToken)
+	if err != nil {
+		return nil, err
+	}
+
+	if request.HasPassword && !utils.CheckPassword(password, request.PasswordHash) {
+		return nil, ErrInvalidFileRequestPassword
+	}
+
+	if request.MaxFileSize != nil && file.Size > *request.MaxFileSize {
+		return nil, fmt.Errorf("file size exceeds the %d bytes limit of this request", *request.MaxFileSize)
+	}
+
+	if len(request.AllowedExtensions) > 0 {
+		ext := utils.FileExtension(file.Filename)
+		allowed := false
+		for _, allowedExt := range request.AllowedExtensions {
+			if ext == allowedExt {
+				allowed = true
+				break
+			}
+		}
+		if !allowed {
+			return nil, errors.New("file type not allowed, accepted: " + strings.Join(request.AllowedExtensions, ", "))
+		}
+	}
+
+	// Reserve an upload slot so concurrent uploads cannot exceed the count limit, and a
+	// request closed or expired since it was loaded takes no more uploads
+	result, err := s.db.Exec(`
+		UPDATE file_requests SET upload_count = upload_count + 1
+		WHERE id = $1 AND (max_files IS NULL OR upload_count < max_files)
+		AND is_active = TRUE AND (expires_at IS NULL OR expires_at > CURRENT_TIMESTAMP)`,
+		request.ID)
+	if err != nil {
+		return nil, err
+	}
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return nil, err
+	}
+	if rowsAffected == 0 {
+		return nil, errors.New("this file request is closed, expired or has reached its upload limit")
+	}
+
+	// The slot is given back when the upload fails
+	releaseSlot := func() {
+		s.db.Exec("UPDATE file_requests SET upload_count = upload_count - 1 WHERE id = $1", request.ID)
+	}
+
+	// Uploads count against the owner's storage quota
+	uploadedFile, err := s.fileService.UploadFileToFolder(request.UserID, file, request.Folder)
+	if err != nil {
+		releaseSlot()
+		return nil, err
+	}
+
+	_, err = s.db.Exec(`
+		INSERT INTO file_request_uploads (request_id, file_id, uploader_name, uploader_ip)
+		VALUES ($1, $2, $3, $4)`,
+		request.ID, uploadedFile.ID, uploaderName, uploaderIP)
+	if err != nil {
+		// Without its upload record the file would sit in the owner's storage unaccounted for
+		if deleteErr := s.fileService.DeleteFile(uploadedFile.ID, request.UserID); deleteErr != nil {
+			log.Printf("Failed to delete file %d of a failed file request upload: %v", uploadedFile.ID, deleteErr)
+		}
+		releaseSlot()
+		return nil, err
+	}
+
+	from := uploaderName
+	if from == "" {
+		from = "An anonymous visitor"
+	}
+	s.notificationService.Notify(request.UserID, "file_request_upload",
+		fmt.Sprintf("New upload for \"%s\"", request.Title),
+		fmt.Sprintf("%s uploaded %s into /%s", from, uploadedFile.OriginalName, uploadedFile.Folder),
+		map[string]interface{}{"request_id": request.ID, "file_id": uploadedFile.ID})
+
+	return uploadedFile, nil
+}
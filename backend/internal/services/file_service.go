@@ -3,13 +3,22 @@ package services
 import (
 	"database/sql"
 	"errors"
+	"fmt"
 	"mime/multipart"
 	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
 	
 	"ai-doc-system/internal/models"
 	"ai-doc-system/internal/utils"
 )
 
+const (
+	maxUploadSize    = 10 * 1024 * 1024  // 10MB per file
+	userStorageLimit = 100 * 1024 * 1024 // 100MB per user unless users.storage_quota sets another
+)
+
 type FileService struct {
 	db         *sql.DB
 	uploadPath string
@@ -23,20 +32,29 @@ func NewFileService(db *sql.DB, uploadPath string) *FileService {
 }
 
 func (s *FileService) UploadFile(userID int, file *multipart.FileHeader) (*models.File, error) {
+	return s.UploadFileToFolder(userID, file, "")
+}
+
+// Upload file into a folder of the user, counting against the user's storage quota
+func (s *FileService) UploadFileToFolder(userID int, file *multipart.FileHeader, folder string) (*models.File, error) {
+	folder, err := utils.CleanFolderPath(folder)
+	if err != nil {
+		return nil, err
+	}
+	
 	// Check file size (10MB limit)
-	if file.Size > 10*1024*1024 {
+	if file.Size > maxUploadSize {
 		return nil, errors.New("file size exceeds 10MB limit")
 	}
 	
-	// Check user storage space (100MB limit)
-	var totalSize int64
-	err := s.db.QueryRow("SELECT COALESCE(SUM(file_size), 0) FROM files WHERE user_id = $1", userID).Scan(&totalSize)
+	// Check user storage space
+	totalSize, limit, err := storageUsage(s.db, userID)
 	if err != nil {
 		return nil, err
 	}
 	
-	if totalSize+file.Size > 100*1024*1024 {
-		return nil, errors.New("storage limit exceeded (100MB)")
+	if totalSize+file.Size > limit {
+		return nil, storageLimitError(limit)
 	}
 	
 	// Save file to disk
@@ -50,12 +68,12 @@ func (s *FileService) UploadFile(userID int, file *multipart.FileHeader) (*model
 	// Save file information to database
 	var fileModel models.File
 	err = s.db.QueryRow(`
-		INSERT INTO files (filename, original_name, file_path, file_size, mime_type, user_id) 
-		VALUES ($1, $2, $3, $4, $5, $6) 
-		RETURNING id, filename, original_name, file_path, file_size, mime_type, user_id, created_at, updated_at`,
-		fileName, file.Filename, filePath, file.Size, file.Header.Get("Content-Type"), userID).Scan(
+		INSERT INTO files (filename, original_name, file_path, file_size, mime_type, user_id, folder) 
+		VALUES ($1, $2, $3, $4, $5, $6, $7) 
+		RETURNING id, filename, original_name, file_path, file_size, mime_type, user_id, folder, created_at, updated_at`,
+		fileName, file.Filename, filePath, file.Size, file.Header.Get("Content-Type"), userID, folder).Scan(
 		&fileModel.ID, &fileModel.Filename, &fileModel.OriginalName, &fileModel.FilePath,
-		&fileModel.FileSize, &fileModel.MimeType, &fileModel.UserID,
+		&fileModel.FileSize, &fileModel.MimeType, &fileModel.UserID, &fileModel.Folder,
 		&fileModel.CreatedAt, &fileModel.UpdatedAt)
 	
 	if err != nil {
@@ -77,37 +95,13 @@ func (s *FileService) UploadFile(userID int, file *multipart.FileHeader) (*model
 	return &fileModel, nil
 }
 
-func (s *FileService) GetUserFiles(userID int) ([]models.File, error) {
-	rows, err := s.db.Query(`
-		SELECT id, filename, original_name, file_path, file_size, mime_type, user_id, created_at, updated_at 
-		FROM files WHERE user_id = $1 ORDER BY created_at DESC`, userID)
-	if err != nil {
-		return nil, err
-	}
-	defer rows.Close()
-	
-	var files []models.File
-	for rows.Next() {
-		var file models.File
-		err := rows.Scan(&file.ID, &file.Filename, &file.OriginalName, &file.FilePath,
-			&file.FileSize, &file.MimeType, &file.UserID,
-			&file.CreatedAt, &file.UpdatedAt)
-		if err != nil {
-			return nil, err
-		}
-		files = append(files, file)
-	}
-	
-	return files, nil
-}
-
 func (s *FileService) GetFileByID(fileID int) (*models.File, error) {
 	var file models.File
 	err := s.db.QueryRow(`
-		SELECT id, filename, original_name, file_path, file_size, mime_type, user_id, created_at, updated_at 
+		SELECT id, filename, original_name, file_path, file_size, mime_type, user_id, folder, created_at, updated_at 
 		FROM files WHERE id = $1`, fileID).Scan(
 		&file.ID, &file.Filename, &file.OriginalName, &file.FilePath,
-		&file.FileSize, &file.MimeType, &file.UserID,
+		&file.FileSize, &file.MimeType, &file.UserID, &file.Folder,
 		&file.CreatedAt, &file.UpdatedAt)
 	
 	if err != nil {
@@ -163,37 +157,211 @@ func (s *FileService) RenameFile(fileID, userID int, newName string) error {
 	return err
 }
 
-func (s *FileService) GetAllFiles() ([]models.File, error) {
-	rows, err := s.db.Query(`
-		SELECT f.id, f.filename, f.original_name, f.file_path, f.file_size, f.mime_type, 
-		       f.user_id, f.created_at, f.updated_at, u.username
-		FROM files f 
-		JOIN users u ON f.user_id = u.id 
-		ORDER BY f.created_at DESC`)
+type FileListOptions struct {
+	UserID     int        // Owner, 0 lists every user's files
+	Sort       string     // created_at, updated_at, name, size
+	Order      string     // asc, desc
+	MimeType   string     // Exact type, or a whole family such as image/*
+	From       *time.Time // Created at or after, inclusive
+	To         *time.Time // Created before, exclusive
+	NamePrefix string
+	Before     string // Cursor, return the page before it
+	After      string // Cursor, return the page after it
+	Limit      int
+}
+
+// Sort options and the SQL expression and type used for their keyset comparison
+var fileSortColumns = map[string][2]string{
+	"created_at": {"f.created_at", "timestamp"},
+	"updated_at": {"f.updated_at", "timestamp"},
+	"name":       {"f.original_name", "text"},
+	"size":       {"f.file_size", "bigint"},
+}
+
+const cursorTimeLayout = "2006-01-02T15:04:05.999999"
+
+func fileSortValue(file *models.File, sort string) string {
+	switch sort {
+	case "updated_at":
+		return file.UpdatedAt.Format(cursorTimeLayout)
+	case "name":
+		return file.OriginalName
+	case "size":
+		return strconv.FormatInt(file.FileSize, 10)
+	default:
+		return file.CreatedAt.Format(cursorTimeLayout)
+	}
+}
+
+// List files with keyset pagination, sorting and filters
+func (s *FileService) ListFiles(opts FileListOptions) (*models.FilePage, error) {
+	if opts.Sort == "" {
+		opts.Sort = "created_at"
+	}
+	column, ok := fileSortColumns[opts.Sort]
+	if !ok {
+		return nil, errors.New("invalid sort option")
+	}
+	if opts.Order == "" {
+		opts.Order = "desc"
+		if opts.Sort == "name" {
+			opts.Order = "asc"
+		}
+	}
+	if opts.Order != "asc" && opts.Order != "desc" {
+		return nil, errors.New("invalid sort order")
+	}
+	if opts.Before != "" && opts.After != "" {
+		return nil, errors.New("only one of before and after may be given")
+	}
+	// Cursors are bound to the sort column and direction they were created for
+	cursorSort := opts.Sort + ":" + opts.Order
+
+	var conditions []string
+	var args []interface{}
+	arg := func(value interface{}) string {
+		args = append(args, value)
+		return fmt.Sprintf("$%d", len(args))
+	}
+
+	if opts.UserID != 0 {
+		conditions = append(conditions, "f.user_id = "+arg(opts.UserID))
+	}
+	if opts.MimeType != "" {
+		if strings.HasSuffix(opts.MimeType, "/*") {
+			family := strings.TrimSuffix(opts.MimeType, "*")
+			conditions = append(conditions, "f.mime_type LIKE "+arg(likeEscaper.Replace(family)+"%"))
+		} else {
+			conditions = append(conditions, "f.mime_type = "+arg(opts.MimeType))
+		}
+	}
+	if opts.From != nil {
+		conditions = append(conditions, "f.created_at >= "+arg(*opts.From))
+	}
+	if opts.To != nil {
+		conditions = append(conditions, "f.created_at < "+arg(*opts.To))
+	}
+	if opts.NamePrefix != "" {
+		conditions = append(conditions, "f.original_name ILIKE "+arg(likeEscaper.Replace(opts.NamePrefix)+"%"))
+	}
+
+	// Walking backwards flips both the comparison and the order, the rows are reversed afterwards
+	backwards := opts.Before != ""
+	descending := (opts.Order == "desc") != backwards
+	comparison, direction := ">", "ASC"
+	if descending {
+		comparison, direction = "<", "DESC"
+	}
+
+	encoded := opts.After
+	if backwards {
+		encoded = opts.Before
+	}
+	if encoded != "" {
+		cursor, err := utils.DecodeCursor(encoded, cursorSort)
+		if err != nil {
+			return nil, err
+		}
+		conditions = append(conditions, fmt.Sprintf("(%s, f.id) %s (%s::%s, %s)",
+			column[0], comparison, arg(cursor.Value), column[1], arg(cursor.ID)))
+	}
+
+	where := ""
+	if len(conditions) > 0 {
+		where = "WHERE " + strings.Join(conditions, " AND ")
+	}
+
+	rows, err := s.db.Query(fmt.Sprintf(`
+		SELECT f.id, f.filename, f.original_name, f.file_path, f.file_size, COALESCE(f.mime_type, ''),
+		       f.user_id, f.folder, f.created_at, f.updated_at, u.username
+		FROM files f
+		JOIN users u ON f.user_id = u.id
+		%s
+		ORDER BY %s %s, f.id %s
+		LIMIT %s`,
+		where, column[0], direction, direction, arg(opts.Limit+1)), args...)
 	if err != nil {
 		return nil, err
 	}
 	defer rows.Close()
-	
-	var files []models.File
+
+	files := []models.File{}
 	for rows.Next() {
 		var file models.File
-		var username string
 		err := rows.Scan(&file.ID, &file.Filename, &file.OriginalName, &file.FilePath,
-			&file.FileSize, &file.MimeType, &file.UserID,
-			&file.CreatedAt, &file.UpdatedAt, &username)
+			&file.FileSize, &file.MimeType, &file.UserID, &file.Folder,
+			&file.CreatedAt, &file.UpdatedAt, &file.OwnerName)
 		if err != nil {
 			return nil, err
 		}
-		// Can add username information to file structure here
 		files = append(files, file)
 	}
-	
-	return files, nil
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	hasMore := len(files) > opts.Limit
+	if hasMore {
+		files = files[:opts.Limit]
+	}
+	if backwards {
+		for i, j := 0, len(files)-1; i < j; i, j = i+1, j-1 {
+			files[i], files[j] = files[j], files[i]
+		}
+	}
+
+	page := &models.FilePage{Files: files}
+	if len(files) > 0 {
+		hasNext, hasPrev := hasMore, encoded != ""
+		if backwards {
+			hasNext, hasPrev = true, hasMore
+		}
+		if hasNext {
+			last := &files[len(files)-1]
+			page.NextCursor = utils.EncodeCursor(utils.Cursor{Sort: cursorSort, Value: fileSortValue(last, opts.Sort), ID: last.ID})
+		}
+		if hasPrev {
+			first := &files[0]
+			page.PrevCursor = utils.EncodeCursor(utils.Cursor{Sort: cursorSort, Value: fileSortValue(first, opts.Sort), ID: first.ID})
+		}
+	}
+
+	return page, nil
+}
+
+// Get bytes the user stores and the user's limit
+func (s *FileService) GetUserStorageUsage(userID int) (int64, int64, error) {
+	return storageUsage(s.db, userID)
+}
+
+// Set the user's storage quota in bytes, nil restores the default limit
+func (s *FileService) SetStorageQuota(userID int, quota *int64) error {
+	if quota != nil && *quota < 0 {
+		return errors.New("storage quota cannot be negative")
+	}
+	result, err := s.db.Exec("UPDATE users SET storage_quota = $1, updated_at = CURRENT_TIMESTAMP WHERE id = $2", quota, userID)
+	if err != nil {
+		return err
+	}
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if rowsAffected == 0 {
+		return errors.New("user not found")
+	}
+	return nil
+}
+
+func storageUsage(q queryer, userID int) (int64, int64, error) {
+	var used, limit int64
+	err := q.QueryRow(`
+		SELECT COALESCE((SELECT SUM(file_size) FROM files WHERE user_id = $1), 0),
+			COALESCE((SELECT storage_quota FROM users WHERE id = $1), $2)`,
+		userID, userStorageLimit).Scan(&used, &limit)
+	return used, limit, err
 }
 
-func (s *FileService) GetUserStorageUsage(userID int) (int64, error) {
-	var totalSize int64
-	err := s.db.QueryRow("SELECT COALESCE(SUM(file_size), 0) FROM files WHERE user_id = $1", userID).Scan(&totalSize)
-	return totalSize, err
+func storageLimitError(limit int64) error {
+	return fmt.Errorf("storage limit exceeded (%dMB)", limit/(1024*1024))
 }
\ No newline at end of file
@@ -3,17 +3,20 @@ package services
 import (
 	"database/sql"
 	"errors"
+	"fmt"
+	"log"
 	"time"
 	"ai-doc-system/internal/models"
 	"github.com/google/uuid"
 )
 
 type FileShareService struct {
-	db *sql.DB
+	db                  *sql.DB
+	notificationService *NotificationService
 }
 
-func NewFileShareService(db *sql.DB) *FileShareService {
-	return &FileShareService{db: db}
+func NewFileShareService(db *sql.DB, notificationService *NotificationService) *FileShareService {
+	return &FileShareService{db: db, notificationService: notificationService}
 }
 
 // Share file to friend
@@ -43,7 +46,7 @@ func (s *FileShareService) ShareFileToFriend(fileID, ownerID, friendID int) erro
 	// Check if already shared
 	err = s.db.QueryRow(`
 		SELECT COUNT(*) FROM file_shares 
-		WHERE file_id = $1 AND shared_with_user_id = $2 AND share_type = 'friend'`,
+		WHERE file_id = $1 AND shared_with = $2 AND share_type = 'friend'`,
 		fileID, friendID).Scan(&count)
 	if err != nil {
 		return err
@@ -53,12 +56,23 @@ func (s *FileShareService) ShareFileToFriend(fileID, ownerID, friendID int) erro
 	}
 	
 	// Create share record
-	_, err = s.db.Exec(`
-		INSERT INTO file_shares (file_id, shared_by_user_id, shared_with_user_id, share_type, permissions) 
-		VALUES ($1, $2, $3, 'friend', 'read')`,
-		fileID, ownerID, friendID)
+	var shareID int
+	err = s.db.QueryRow(`
+		INSERT INTO file_shares (file_id, created_by, shared_with, share_type, share_token) 
+		VALUES ($1, $2, $3, 'friend', $4)
+		RETURNING id`,
+		fileID, ownerID, friendID, uuid.New().String()).Scan(&shareID)
+	if err != nil {
+		return err
+	}
 	
-	return err
+	var fileName string
+	s.db.QueryRow("SELECT original_name FROM files WHERE id = $1", fileID).Scan(&fileName)
+	s.notificationService.Notify(friendID, "file_shared", "File shared with you",
+		fmt.Sprintf("%s shared %s with you", usernameOf(s.db, ownerID), fileName),
+		map[string]interface{}{"file_id": fileID, "share_id": shareID, "user_id": ownerID})
+	
+	return nil
 }
 
 // Create public share link
@@ -100,8 +114,8 @@ func (s *FileShareService) GetSharedWithMeFiles(userID int) ([]map[string]interf
 		       u.username as shared_by, fs.created_at as shared_at
 		FROM file_shares fs
 		JOIN files f ON fs.file_id = f.id
-		JOIN users u ON fs.shared_by_user_id = u.id
-		WHERE fs.shared_with_user_id = $1
+		JOIN users u ON fs.created_by = u.id
+		WHERE fs.shared_with = $1 AND fs.share_type = 'friend'
 		ORDER BY fs.created_at DESC`,
 		userID)
 	if err != nil {
@@ -139,13 +153,13 @@ func (s *FileShareService) GetSharedWithMeFiles(userID int) ([]map[string]interf
 // Get my shared files
 func (s *FileShareService) GetMySharedFiles(userID int) ([]map[string]interface{}, error) {
 	rows, err := s.db.Query(`
-		SELECT f.id, f.filename, f.file_size, f.mime_type,
+		SELECT fs.id, f.id, f.filename, f.file_size, f.mime_type,
 		       fs.share_type, fs.share_token, fs.expires_at, fs.created_at as shared_at,
 		       u.username as shared_with
 		FROM file_shares fs
 		JOIN files f ON fs.file_id = f.id
-		LEFT JOIN users u ON fs.shared_with_user_id = u.id
-		WHERE fs.shared_by_user_id = $1
+		LEFT JOIN users u ON fs.shared_with = u.id
+		WHERE fs.created_by = $1
 		ORDER BY fs.created_at DESC`,
 		userID)
 	if err != nil {
@@ -155,7 +169,7 @@ func (s *FileShareService) GetMySharedFiles(userID int) ([]map[string]interface{
 	
 	var files []map[string]interface{}
 	for rows.Next() {
-		var fileID int
+		var shareID, fileID int
 		var filename, mimeType, shareType string
 		var shareToken sql.NullString
 		var sharedWith sql.NullString
@@ -163,13 +177,14 @@ func (s *FileShareService) GetMySharedFiles(userID int) ([]map[string]interface{
 		var expiresAt sql.NullTime
 		var sharedAt time.Time
 		
-		err := rows.Scan(&fileID, &filename, &size, &mimeType, &shareType, 
+		err := rows.Scan(&shareID, &fileID, &filename, &size, &mimeType, &shareType, 
 			&shareToken, &expiresAt, &sharedAt, &sharedWith)
 		if err != nil {
 			return nil, err
 		}
 		
 		file := map[string]interface{}{
+			"share_id":      shareID,
 			"id":            fileID,
 			"filename":      filename,
 			"size":          size,
@@ -219,7 +234,7 @@ func (s *FileShareService) GetFileByShareToken(shareToken string) (*models.File,
 func (s *FileShareService) RemoveShare(shareID, userID int) error {
 	result, err := s.db.Exec(`
 		DELETE FROM file_shares 
-		WHERE id = $1 AND shared_by_user_id = $2`,
+		WHERE id = $1 AND created_by = $2`,
 		shareID, userID)
 	if err != nil {
 		return err
@@ -240,7 +255,7 @@ func (s *FileShareService) RemoveShare(shareID, userID int) error {
 func (s *FileShareService) CheckFileAccess(fileID, userID int) (bool, error) {
 	// Check if user is file owner
 	var count int
-	err := s.db.QueryRow("SELECT COUNT(*) FROM files WHERE id = $1 AND owner_id = $2", fileID, userID).Scan(&count)
+	err := s.db.QueryRow("SELECT COUNT(*) FROM files WHERE id = $1 AND user_id = $2", fileID, userID).Scan(&count)
 	if err != nil {
 		return false, err
 	}
@@ -251,11 +266,54 @@ func (s *FileShareService) CheckFileAccess(fileID, userID int) (bool, error) {
 	// Check if user has share permission
 	err = s.db.QueryRow(`
 		SELECT COUNT(*) FROM file_shares 
-		WHERE file_id = $1 AND shared_with_user_id = $2 AND share_type = 'friend'`,
+		WHERE file_id = $1 AND shared_with = $2 AND share_type = 'friend'`,
 		fileID, userID).Scan(&count)
 	if err != nil {
 		return false, err
 	}
 	
 	return count > 0, nil
-}
\ No newline at end of file
+}
+
+// Tell owners about shares expiring within the given window, once per share
+func (s *FileShareService) NotifyExpiringShares(within time.Duration) (int, error) {
+	rows, err := s.db.Query(`
+		UPDATE file_shares fs SET expiry_notified_at = CURRENT_TIMESTAMP
+		FROM files f
+		WHERE f.id = fs.file_id AND fs.expiry_notified_at IS NULL
+		AND fs.expires_at > CURRENT_TIMESTAMP AND fs.expires_at <= $1
+		RETURNING fs.id, fs.file_id, fs.created_by, fs.share_type, fs.expires_at, f.original_name`,
+		time.Now().Add(within))
+	if err != nil {
+		return 0, err
+	}
+	defer rows.Close()
+
+	count := 0
+	for rows.Next() {
+		var shareID, fileID, ownerID int
+		var shareType, fileName string
+		var expiresAt time.Time
+		if err := rows.Scan(&shareID, &fileID, &ownerID, &shareType, &expiresAt, &fileName); err != nil {
+			return count, err
+		}
+		s.notificationService.Notify(ownerID, "share_expiring", "Share link expiring soon",
+			fmt.Sprintf("Your %s share of %s expires at %s", shareType, fileName, expiresAt.Format("2006-01-02 15:04")),
+			map[string]interface{}{"file_id": fileID, "share_id": shareID})
+		count++
+	}
+
+	return count, rows.Err()
+}
+
+// Periodically notify owners of expiring shares, runs until the process exits
+func (s *FileShareService) RunExpiryNotifier(interval, within time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for range ticker.C {
+		if _, err := s.NotifyExpiringShares(within); err != nil {
+			log.Printf("Failed to notify expiring shares: %v", err)
+		}
+	}
+}
@@ -0,0 +1,384 @@
+package services
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+
+	"ai-doc-system/internal/models"
+	"ai-doc-system/internal/utils"
+	"github.com/lib/pq"
+)
+
+type FileTransferService struct {
+	db                  *sql.DB
+	notificationService *NotificationService
+}
+
+func NewFileTransferService(db *sql.DB, notificationService *NotificationService) *FileTransferService {
+	return &FileTransferService{
+		db:                  db,
+		notificationService: notificationService,
+	}
+}
+
+const fileTransferColumns = `id, file_id, folder, from_user_id, to_user_id, offered_by, status,
+	file_count, total_size, created_at, responded_at`
+
+func scanFileTransfer(row rowScanner) (*models.FileTransfer, error) {
+	var transfer models.FileTransfer
+	err := row.Scan(&transfer.ID, &transfer.FileID, &transfer.Folder, &transfer.FromUserID,
+		&transfer.ToUserID, &transfer.OfferedBy, &transfer.Status, &transfer.FileCount,
+		&transfer.TotalSize, &transfer.CreatedAt, &transfer.RespondedAt)
+	if err != nil {
+		return nil, err
+	}
+	return &transfer, nil
+}
+
+// transferScope builds the WHERE clause selecting the files covered by a transfer
+func transferScope(fromUserID int, fileID *int, folder *string) (string, []interface{}) {
+	where := "user_id = $1"
+	args := []interface{}{fromUserID}
+	if fileID != nil {
+		where += " AND id = $2"
+		args = append(args, *fileID)
+	} else if folder != nil && *folder != "" {
+		where += " AND (folder = $2::text OR LEFT(folder, LENGTH($2::text) + 1) = $2::text || '/')"
+		args = append(args, *folder)
+	}
+	return where, args
+}
+
+type queryer interface {
+	QueryRow(query string, args ...interface{}) *sql.Row
+}
+
+func transferSize(q queryer, fromUserID int, fileID *int, folder *string) (int, int64, error) {
+	where, args := transferScope(fromUserID, fileID, folder)
+	var count int
+	var size int64
+	err := q.QueryRow("SELECT COUNT(*), COALESCE(SUM(file_size), 0) FROM files WHERE "+where, args...).Scan(&count, &size)
+	return count, size, err
+}
+
+// Offer file, folder or (with the files:transfer_all permission) all files of a user to another user
+func (s *FileTransferService) OfferTransfer(offeredBy int, transferAll bool, fromUserID int, fileID *int, folder *string, toUserID int) (*models.FileTransfer, error) {
+	if fileID != nil {
+		var ownerID int
+		err := s.db.QueryRow("SELECT user_id FROM files WHERE id = $1", *fileID).Scan(&ownerID)
+		if err != nil {
+			if err == sql.ErrNoRows {
+				return nil, errors.New("file not found")
+			}
+			return nil, err
+		}
+		fromUserID = ownerID
+		folder = nil
+	} else if folder != nil {
+		cleaned, err := utils.CleanFolderPath(*folder)
+		if err != nil {
+			return nil, err
+		}
+		folder = &cleaned
+	} else if !transferAll {
+		return nil, errors.New("file_id or folder is required")
+	}
+
+	if !transferAll {
+		if fileID == nil {
+			fromUserID = offeredBy
+		}
+		if fromUserID != offeredBy {
+			return nil, errors.New("permission denied")
+		}
+	}
+
+	if fromUserID == toUserID {
+		return nil, errors.New("cannot transfer files to their current owner")
+	}
+
+	var count int
+	err := s.db.QueryRow("SELECT COUNT(*) FROM users WHERE id = $1", toUserID).Scan(&count)
+	if err != nil {
+		return nil, err
+	}
+	if count == 0 {
+		return nil, errors.New("recipient not found")
+	}
+
+	blocked, err := isBlocked(s.db, fromUserID, toUserID)
+	if err != nil {
+		return nil, err
+	}
+	if blocked {
+		return nil, errors.New("cannot transfer files to this user")
+	}
+
+	fileCount, totalSize, err := transferSize(s.db, fromUserID, fileID, folder)
+	if err != nil {
+		return nil, err
+	}
+	if fileCount == 0 {
+		return nil, errors.New("no files to transfer")
+	}
+
+	// Check for a pending offer of the same files
+	err = s.db.QueryRow(`
+		SELECT COUNT(*) FROM file_transfers
+		WHERE from_user_id = $1 AND status = 'pending'
+		AND file_id IS NOT DISTINCT FROM $2 AND folder IS NOT DISTINCT FROM $3`,
+		fromUserID, fileID, folder).Scan(&count)
+	if err != nil {
+		return nil, err
+	}
+	if count > 0 {
+		return nil, errors.New("a transfer of these files is already pending")
+	}
+
+	row := s.db.QueryRow(`
+		INSERT INTO file_transfers (file_id, folder, from_user_id, to_user_id, offered_by, file_count, total_size)
+		VALUES ($1, $2, $3, $4, $5, $6, $7)
+		RETURNING `+fileTransferColumns,
+		fileID, folder, fromUserID, toUserID, offeredBy, fileCount, totalSize)
+	transfer, err := scanFileTransfer(row)
+	if err != nil {
+		return nil, err
+	}
+
+	s.notificationService.Notify(toUserID, "file_transfer_offer", "File ownership transfer offered",
+		fmt.Sprintf("You have been offered ownership of %d file(s) (%d bytes)", fileCount, totalSize),
+		map[string]interface{}{"transfer_id": transfer.ID})
+
+	return transfer, nil
+}
+
+// Get transfers offered to or by the user
+func (s *FileTransferService) GetTransfers(userID int) ([]models.FileTransfer, error) {
+	rows, err := s.db.Query(`
+		SELECT `+fileTransferColumns+`
+		FROM file_transfers
+		WHERE from_user_id = $1 OR to_user_id = $1 OR offered_by = $1
+		ORDER BY created_at DESC`,
+		userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var transfers []models.FileTransfer
+	for rows.Next() {
+		transfer, err := scanFileTransfer(rows)
+		if err != nil {
+			return nil, err
+		}
+		transfers = append(transfers, *transfer)
+	}
+
+	return transfers, nil
+}
+
+// Accept a pending transfer, moving the files and their storage usage to the recipient
+func (s *FileTransferService) AcceptTransfer(transferID, userID int) (*models.FileTransfer, error) {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	row := tx.QueryRow(`
+		SELECT `+fileTransferColumns+`
+		FROM file_transfers
+		WHERE id = $1 AND to_user_id = $2 AND status = 'pending'
+		FOR UPDATE`,
+		transferID, userID)
+	transfer, err := scanFileTransfer(row)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, errors.New("transfer not found")
+		}
+		return nil, err
+	}
+
+	if err := executeTransfer(tx, transfer, true); err != nil {
+		return nil, err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, err
+	}
+
+	s.notificationService.Notify(transfer.FromUserID, "file_transfer_accepted", "File ownership transfer accepted",
+		fmt.Sprintf("%d file(s) now belong to their new owner", transfer.FileCount),
+		map[string]interface{}{"transfer_id": transfer.ID})
+
+	return transfer, nil
+}
+
+// Transfer every file of a user immediately (files:transfer_all only, not limited by the recipient's quota)
+func (s *FileTransferService) BulkTransfer(adminID, fromUserID, toUserID int) (*models.FileTransfer, error) {
+	if fromUserID == toUserID {
+		return nil, errors.New("cannot transfer files to their current owner")
+	}
+
+	var count int
+	err := s.db.QueryRow("SELECT COUNT(*) FROM users WHERE id = ANY($1)", pq.Array([]int{fromUserID, toUserID})).Scan(&count)
+	if err != nil {
+		return nil, err
+	}
+	if count != 2 {
+		return nil, errors.New("user not found")
+	}
+
+	tx, err := s.db.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	row := tx.QueryRow(`
+		INSERT INTO file_transfers (from_user_id, to_user_id, offered_by)
+		VALUES ($1, $2, $3)
+		RETURNING `+fileTransferColumns,
+		fromUserID, toUserID, adminID)
+	transfer, err := scanFileTransfer(row)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := executeTransfer(tx, transfer, false); err != nil {
+		return nil, err
+	}
+
+	// Offers of files that no longer belong to the old owner are void
+	_, err = tx.Exec(`
+		UPDATE file_transfers SET status = 'cancelled', responded_at = CURRENT_TIMESTAMP
+		WHERE from_user_id = $1 AND status = 'pending'`,
+		fromUserID)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, err
+	}
+
+	s.notificationService.Notify(toUserID, "file_transfer_accepted", "Files transferred to you",
+		fmt.Sprintf("An administrator transferred %d file(s) (%d bytes) to you", transfer.FileCount, transfer.TotalSize),
+		map[string]interface{}{"transfer_id": transfer.ID})
+
+	return transfer, nil
+}
+
+// executeTransfer moves ownership of the files covered by the transfer inside tx.
+// Shares created by the previous owner are handed over to the new owner, shares with the
+// new owner become redundant and are removed. Version history is left untouched so
+// file_versions.created_by keeps the original authorship.
+func executeTransfer(tx *sql.Tx, transfer *models.FileTransfer, enforceQuota bool) error {
+	fileCount, totalSize, err := transferSize(tx, transfer.FromUserID, transfer.FileID, transfer.Folder)
+	if err != nil {
+		return err
+	}
+	if fileCount == 0 {
+		return errors.New("no files to transfer")
+	}
+
+	if enforceQuota {
+		usage, limit, err := storageUsage(tx, transfer.ToUserID)
+		if err != nil {
+			return err
+		}
+		if usage+totalSize > limit {
+			return storageLimitError(limit)
+		}
+	}
+
+	where, args := transferScope(transfer.FromUserID, transfer.FileID, transfer.Folder)
+	args = append(args, transfer.ToUserID)
+	rows, err := tx.Query(fmt.Sprintf(`
+		UPDATE files SET user_id = $%d, updated_at = CURRENT_TIMESTAMP
+		WHERE %s
+		RETURNING id`, len(args), where), args...)
+	if err != nil {
+		return err
+	}
+	var fileIDs []int
+	for rows.Next() {
+		var id int
+		if err := rows.Scan(&id); err != nil {
+			rows.Close()
+			return err
+		}
+		fileIDs = append(fileIDs, id)
+	}
+	rows.Close()
+
+	_, err = tx.Exec(`
+		DELETE FROM file_shares
+		WHERE file_id = ANY($1) AND shared_with = $2`,
+		pq.Array(fileIDs), transfer.ToUserID)
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.Exec(`
+		UPDATE file_shares SET created_by = $2
+		WHERE file_id = ANY($1) AND created_by = $3`,
+		pq.Array(fileIDs), transfer.ToUserID, transfer.FromUserID)
+	if err != nil {
+		return err
+	}
+
+	transfer.Status = "accepted"
+	transfer.FileCount = len(fileIDs)
+	transfer.TotalSize = totalSize
+	return tx.QueryRow(`
+		UPDATE file_transfers
+		SET status = 'accepted', file_count = $2, total_size = $3, responded_at = CURRENT_TIMESTAMP
+		WHERE id = $1
+		RETURNING responded_at`,
+		transfer.ID, transfer.FileCount, transfer.TotalSize).Scan(&transfer.RespondedAt)
+}
+
+// Decline a transfer offered to the user
+func (s *FileTransferService) DeclineTransfer(transferID, userID int) error {
+	var fromUserID int
+	err := s.db.QueryRow(`
+		UPDATE file_transfers SET status = 'declined', responded_at = CURRENT_TIMESTAMP
+		WHERE id = $1 AND to_user_id = $2 AND status = 'pending'
+		RETURNING from_user_id`,
+		transferID, userID).Scan(&fromUserID)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return errors.New("transfer not found")
+		}
+		return err
+	}
+
+	s.notificationService.Notify(fromUserID, "file_transfer_declined", "File ownership transfer declined",
+		"The recipient declined your file ownership transfer",
+		map[string]interface{}{"transfer_id": transferID})
+
+	return nil
+}
+
+// Cancel a pending transfer (owner or the user who offered it)
+func (s *FileTransferService) CancelTransfer(transferID, userID int) error {
+	result, err := s.db.Exec(`
+		UPDATE file_transfers SET status = 'cancelled', responded_at = CURRENT_TIMESTAMP
+		WHERE id = $1 AND (from_user_id = $2 OR offered_by = $2) AND status = 'pending'`,
+		transferID, userID)
+	if err != nil {
+		return err
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if rowsAffected == 0 {
+		return errors.New("transfer not found or permission denied")
+	}
+
+	return nil
+}
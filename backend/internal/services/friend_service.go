@@ -3,15 +3,38 @@ package services
 import (
 	"database/sql"
 	"errors"
+	"fmt"
+	"time"
 	"ai-doc-system/internal/models"
+	"github.com/lib/pq"
 )
 
 type FriendService struct {
-	db *sql.DB
+	db                  *sql.DB
+	notificationService *NotificationService
 }
 
-func NewFriendService(db *sql.DB) *FriendService {
-	return &FriendService{db: db}
+func NewFriendService(db *sql.DB, notificationService *NotificationService) *FriendService {
+	return &FriendService{db: db, notificationService: notificationService}
+}
+
+func usernameOf(db *sql.DB, userID int) string {
+	var username string
+	if err := db.QueryRow("SELECT username FROM users WHERE id = $1", userID).Scan(&username); err != nil {
+		return "Someone"
+	}
+	return username
+}
+
+// Check whether either user has blocked the other
+func isBlocked(db *sql.DB, userID, otherUserID int) (bool, error) {
+	var count int
+	err := db.QueryRow(`
+		SELECT COUNT(*) FROM friendships
+		WHERE status = 'blocked'
+		AND ((user_id = $1 AND friend_id = $2) OR (user_id = $2 AND friend_id = $1))`,
+		userID, otherUserID).Scan(&count)
+	return count > 0, err
 }
 
 // Send friend request
@@ -20,9 +43,18 @@ func (s *FriendService) SendFriendRequest(fromUserID, toUserID int) error {
 		return errors.New("cannot send friend request to yourself")
 	}
 	
+	// Blocks are not revealed to the blocked user
+	blocked, err := isBlocked(s.db, fromUserID, toUserID)
+	if err != nil {
+		return err
+	}
+	if blocked {
+		return errors.New("cannot send friend request to this user")
+	}
+	
 	// Check if already friends
 	var count int
-	err := s.db.QueryRow(`
+	err = s.db.QueryRow(`
 		SELECT COUNT(*) FROM friendships 
 		WHERE (user_id = $1 AND friend_id = $2) OR (user_id = $2 AND friend_id = $1)`,
 		fromUserID, toUserID).Scan(&count)
@@ -38,15 +70,22 @@ func (s *FriendService) SendFriendRequest(fromUserID, toUserID int) error {
 		INSERT INTO friendships (user_id, friend_id, status) 
 		VALUES ($1, $2, 'pending')`,
 		fromUserID, toUserID)
+	if err != nil {
+		return err
+	}
 	
-	return err
+	s.notificationService.Notify(toUserID, "friend_request", "New friend request",
+		fmt.Sprintf("%s wants to be your friend", usernameOf(s.db, fromUserID)),
+		map[string]interface{}{"user_id": fromUserID})
+	
+	return nil
 }
 
 // Accept friend request
 func (s *FriendService) AcceptFriendRequest(userID, friendID int) error {
 	// Update request status to accepted
 	result, err := s.db.Exec(`
-		UPDATE friendships SET status = 'accepted', updated_at = CURRENT_TIMESTAMP 
+		UPDATE friendships SET status = 'accepted' 
 		WHERE user_id = $1 AND friend_id = $2 AND status = 'pending'`,
 		friendID, userID)
 	if err != nil {
@@ -66,8 +105,15 @@ func (s *FriendService) AcceptFriendRequest(userID, friendID int) error {
 		INSERT INTO friendships (user_id, friend_id, status) 
 		VALUES ($1, $2, 'accepted')`,
 		userID, friendID)
+	if err != nil {
+		return err
+	}
 	
-	return err
+	s.notificationService.Notify(friendID, "friend_accepted", "Friend request accepted",
+		fmt.Sprintf("%s accepted your friend request", usernameOf(s.db, userID)),
+		map[string]interface{}{"user_id": userID})
+	
+	return nil
 }
 
 // Reject friend request
@@ -93,15 +139,117 @@ func (s *FriendService) RejectFriendRequest(userID, friendID int) error {
 
 // Remove friend
 func (s *FriendService) RemoveFriend(userID, friendID int) error {
-	// Delete bidirectional relationship
+	// Delete bidirectional relationship, blocks stay in place
 	_, err := s.db.Exec(`
 		DELETE FROM friendships 
-		WHERE (user_id = $1 AND friend_id = $2) OR (user_id = $2 AND friend_id = $1)`,
+		WHERE ((user_id = $1 AND friend_id = $2) OR (user_id = $2 AND friend_id = $1))
+		AND status != 'blocked'`,
 		userID, friendID)
 	
 	return err
 }
 
+// Block user: ends the friendship or pending requests and revokes the friend shares between both users
+func (s *FriendService) BlockUser(userID, blockedID int) error {
+	if userID == blockedID {
+		return errors.New("cannot block yourself")
+	}
+	
+	var count int
+	err := s.db.QueryRow("SELECT COUNT(*) FROM users WHERE id = $1", blockedID).Scan(&count)
+	if err != nil {
+		return err
+	}
+	if count == 0 {
+		return errors.New("user not found")
+	}
+	
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	
+	// A block by the other user is kept, both can block each other
+	_, err = tx.Exec(`
+		DELETE FROM friendships
+		WHERE ((user_id = $1 AND friend_id = $2) OR (user_id = $2 AND friend_id = $1))
+		AND status != 'blocked'`,
+		userID, blockedID)
+	if err != nil {
+		return err
+	}
+	
+	_, err = tx.Exec(`
+		INSERT INTO friendships (user_id, friend_id, status)
+		VALUES ($1, $2, 'blocked')
+		ON CONFLICT (user_id, friend_id) DO NOTHING`,
+		userID, blockedID)
+	if err != nil {
+		return err
+	}
+	
+	_, err = tx.Exec(`
+		DELETE FROM file_shares
+		WHERE share_type = 'friend'
+		AND ((created_by = $1 AND shared_with = $2) OR (created_by = $2 AND shared_with = $1))`,
+		userID, blockedID)
+	if err != nil {
+		return err
+	}
+	
+	return tx.Commit()
+}
+
+// Unblock user, they can send friend requests again
+func (s *FriendService) UnblockUser(userID, blockedID int) error {
+	result, err := s.db.Exec(`
+		DELETE FROM friendships
+		WHERE user_id = $1 AND friend_id = $2 AND status = 'blocked'`,
+		userID, blockedID)
+	if err != nil {
+		return err
+	}
+	
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if rowsAffected == 0 {
+		return errors.New("user is not blocked")
+	}
+	
+	return nil
+}
+
+// Get users blocked by user
+func (s *FriendService) GetBlockedUsers(userID int) ([]models.User, error) {
+	rows, err := s.db.Query(`
+		SELECT u.id, u.username, u.role, u.avatar, u.profile, u.created_at, u.updated_at
+		FROM users u
+		JOIN friendships f ON u.id = f.friend_id
+		WHERE f.user_id = $1 AND f.status = 'blocked'
+		ORDER BY f.created_at DESC`,
+		userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	
+	users := []models.User{}
+	for rows.Next() {
+		var user models.User
+		err := rows.Scan(&user.ID, &user.Username, &user.Role,
+			&user.Avatar, &user.Profile, &user.CreatedAt, &user.UpdatedAt)
+		if err != nil {
+			return nil, err
+		}
+		users = append(users, user)
+	}
+	
+	return users, rows.Err()
+}
+
 // Get friends list
 func (s *FriendService) GetFriends(userID int) ([]models.User, error) {
 	rows, err := s.db.Query(`
@@ -158,12 +306,14 @@ func (s *FriendService) GetPendingRequests(userID int) ([]models.User, error) {
 	return requests, nil
 }
 
-// Search users (for adding friends)
+// Search users (for adding friends), skipping friends, pending requests and blocks in either direction.
+// Users who are not discoverable are only found by their exact username.
 func (s *FriendService) SearchUsers(currentUserID int, keyword string) ([]models.User, error) {
 	rows, err := s.db.Query(`
 		SELECT u.id, u.username, u.role, u.avatar, u.profile, u.created_at, u.updated_at
 		FROM users u
 		WHERE u.id != $1 AND u.username ILIKE $2
+		AND (u.discoverable OR LOWER(u.username) = LOWER($3))
 		AND NOT EXISTS (
 			SELECT 1 FROM friendships f 
 			WHERE (f.user_id = $1 AND f.friend_id = u.id) 
@@ -171,7 +321,7 @@ func (s *FriendService) SearchUsers(currentUserID int, keyword string) ([]models
 		)
 		ORDER BY u.username
 		LIMIT 20`,
-		currentUserID, "%"+keyword+"%")
+		currentUserID, "%"+keyword+"%", keyword)
 	if err != nil {
 		return nil, err
 	}
@@ -191,15 +341,174 @@ func (s *FriendService) SearchUsers(currentUserID int, keyword string) ([]models
 	return users, nil
 }
 
-// Create friend group
+// Weights of the friend suggestion signals
+const (
+	suggestionMutualWeight      = 3.0
+	suggestionSharedFileWeight  = 2.0
+	suggestionInteractionWeight = 1.0
+	suggestionInteractionCap    = 10 // A chatty group member should not outrank mutual friends
+	suggestionInteractionWindow = 30 * 24 * time.Hour
+)
+
+// Suggest discoverable non-friends ranked by mutual friends, files both can access and
+// recent messages in common conversations. Blocked and dismissed users are left out.
+func (s *FriendService) GetSuggestions(userID, limit int) ([]models.FriendSuggestion, error) {
+	rows, err := s.db.Query(`
+		WITH mutual AS (
+			SELECT f2.friend_id AS candidate_id, COUNT(*) AS score
+			FROM friendships f1
+			JOIN friendships f2 ON f2.user_id = f1.friend_id AND f2.status = 'accepted'
+			WHERE f1.user_id = $1 AND f1.status = 'accepted'
+			GROUP BY f2.friend_id
+		),
+		my_files AS (
+			SELECT id AS file_id FROM files WHERE user_id = $1
+			UNION
+			SELECT file_id FROM file_shares WHERE shared_with = $1 AND share_type = 'friend'
+		),
+		file_members AS (
+			SELECT f.id AS file_id, f.user_id AS member_id FROM files f
+			WHERE f.id IN (SELECT file_id FROM my_files)
+			UNION
+			SELECT fs.file_id, fs.shared_with FROM file_shares fs
+			WHERE fs.share_type = 'friend' AND fs.file_id IN (SELECT file_id FROM my_files)
+		),
+		shared AS (
+			SELECT member_id AS candidate_id, COUNT(DISTINCT file_id) AS score
+			FROM file_members
+			WHERE member_id != $1
+			GROUP BY member_id
+		),
+		interactions AS (
+			SELECT m.sender_id AS candidate_id, COUNT(*) AS score
+			FROM messages m
+			JOIN conversation_members cm ON cm.conversation_id = m.conversation_id AND cm.user_id = $1
+			WHERE m.sender_id != $1 AND m.created_at > $2
+			GROUP BY m.sender_id
+		),
+		candidates AS (
+			SELECT candidate_id FROM mutual
+			UNION SELECT candidate_id FROM shared
+			UNION SELECT candidate_id FROM interactions
+		),
+		ranked AS (
+			SELECT c.candidate_id,
+				COALESCE(mu.score, 0) AS mutual_friends,
+				COALESCE(sh.score, 0) AS shared_files,
+				COALESCE(it.score, 0) AS recent_interactions,
+				COALESCE(mu.score, 0) * $3::float8 + COALESCE(sh.score, 0) * $4::float8
+					+ LEAST(COALESCE(it.score, 0), $5) * $6::float8 AS score
+			FROM candidates c
+			LEFT JOIN mutual mu ON mu.candidate_id = c.candidate_id
+			LEFT JOIN shared sh ON sh.candidate_id = c.candidate_id
+			LEFT JOIN interactions it ON it.candidate_id = c.candidate_id
+		)
+		SELECT u.id, u.username, u.role, u.avatar, u.profile, u.created_at, u.updated_at,
+			r.mutual_friends, r.shared_files, r.recent_interactions, r.score
+		FROM ranked r
+		JOIN users u ON u.id = r.candidate_id
+		WHERE u.id != $1 AND u.discoverable
+		AND NOT EXISTS (
+			SELECT 1 FROM friendships f
+			WHERE (f.user_id = $1 AND f.friend_id = u.id)
+			   OR (f.user_id = u.id AND f.friend_id = $1)
+		)
+		AND NOT EXISTS (
+			SELECT 1 FROM dismissed_suggestions d
+			WHERE d.user_id = $1 AND d.suggested_user_id = u.id
+		)
+		ORDER BY r.score DESC, r.mutual_friends DESC, u.username
+		LIMIT $7`,
+		userID, time.Now().Add(-suggestionInteractionWindow),
+		suggestionMutualWeight, suggestionSharedFileWeight, suggestionInteractionCap, suggestionInteractionWeight,
+		limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	
+	suggestions := []models.FriendSuggestion{}
+	for rows.Next() {
+		var suggestion models.FriendSuggestion
+		user := &suggestion.User
+		err := rows.Scan(&user.ID, &user.Username, &user.Role, &user.Avatar, &user.Profile,
+			&user.CreatedAt, &user.UpdatedAt, &suggestion.MutualFriends, &suggestion.SharedFiles,
+			&suggestion.RecentInteractions, &suggestion.Score)
+		if err != nil {
+			return nil, err
+		}
+		user.Discoverable = true
+		suggestions = append(suggestions, suggestion)
+	}
+	
+	return suggestions, rows.Err()
+}
+
+// Dismiss a friend suggestion, the user is not suggested again
+func (s *FriendService) DismissSuggestion(userID, suggestedUserID int) error {
+	if userID == suggestedUserID {
+		return errors.New("cannot dismiss yourself")
+	}
+	
+	var count int
+	err := s.db.QueryRow("SELECT COUNT(*) FROM users WHERE id = $1", suggestedUserID).Scan(&count)
+	if err != nil {
+		return err
+	}
+	if count == 0 {
+		return errors.New("user not found")
+	}
+	
+	_, err = s.db.Exec(`
+		INSERT INTO dismissed_suggestions (user_id, suggested_user_id)
+		VALUES ($1, $2)
+		ON CONFLICT (user_id, suggested_user_id) DO NOTHING`,
+		userID, suggestedUserID)
+	return err
+}
+
+// Check that the group exists and belongs to the user
+func (s *FriendService) checkGroupOwner(userID, groupID int) error {
+	var count int
+	err := s.db.QueryRow("SELECT COUNT(*) FROM friend_groups WHERE id = $1 AND user_id = $2", groupID, userID).Scan(&count)
+	if err != nil {
+		return err
+	}
+	if count == 0 {
+		return errors.New("friend group not found or permission denied")
+	}
+	return nil
+}
+
+// Check that the user has no other group with this name
+func (s *FriendService) checkGroupName(userID, groupID int, name string) error {
+	var count int
+	err := s.db.QueryRow(`
+		SELECT COUNT(*) FROM friend_groups
+		WHERE user_id = $1 AND LOWER(group_name) = LOWER($2) AND id != $3`,
+		userID, name, groupID).Scan(&count)
+	if err != nil {
+		return err
+	}
+	if count > 0 {
+		return errors.New("friend group already exists")
+	}
+	return nil
+}
+
+// Create friend group, it is placed after the existing ones
 func (s *FriendService) CreateFriendGroup(userID int, name string) (*models.FriendGroup, error) {
+	if err := s.checkGroupName(userID, 0, name); err != nil {
+		return nil, err
+	}
+	
 	var group models.FriendGroup
 	err := s.db.QueryRow(`
-		INSERT INTO friend_groups (user_id, group_name) 
-		VALUES ($1, $2) 
-		RETURNING id, user_id, group_name, created_at`,
+		INSERT INTO friend_groups (user_id, group_name, position) 
+		VALUES ($1, $2, (SELECT COALESCE(MAX(position) + 1, 0) FROM friend_groups WHERE user_id = $1)) 
+		RETURNING id, user_id, group_name, position, created_at`,
 		userID, name).Scan(
-		&group.ID, &group.UserID, &group.GroupName, &group.CreatedAt)
+		&group.ID, &group.UserID, &group.GroupName, &group.Position, &group.CreatedAt)
 	
 	if err != nil {
 		return nil, err
@@ -208,13 +517,13 @@ func (s *FriendService) CreateFriendGroup(userID int, name string) (*models.Frie
 	return &group, nil
 }
 
-// Get user's friend groups
+// Get user's friend groups in display order
 func (s *FriendService) GetFriendGroups(userID int) ([]models.FriendGroup, error) {
 	rows, err := s.db.Query(`
-		SELECT id, user_id, group_name, created_at 
+		SELECT id, user_id, group_name, position, created_at 
 		FROM friend_groups 
 		WHERE user_id = $1 
-		ORDER BY group_name`,
+		ORDER BY position, group_name`,
 		userID)
 	if err != nil {
 		return nil, err
@@ -225,7 +534,7 @@ func (s *FriendService) GetFriendGroups(userID int) ([]models.FriendGroup, error
 	for rows.Next() {
 		var group models.FriendGroup
 		err := rows.Scan(&group.ID, &group.UserID, &group.GroupName,
-			&group.CreatedAt)
+			&group.Position, &group.CreatedAt)
 		if err != nil {
 			return nil, err
 		}
@@ -235,8 +544,119 @@ func (s *FriendService) GetFriendGroups(userID int) ([]models.FriendGroup, error
 	return groups, nil
 }
 
-// Add friend to group
+// Get friends sorted into the user's groups, plus the friends without a group
+func (s *FriendService) GetGroupedFriends(userID int) ([]models.FriendGroup, []models.User, error) {
+	groups, err := s.GetFriendGroups(userID)
+	if err != nil {
+		return nil, nil, err
+	}
+	
+	groupIndex := make(map[int]int)
+	for i := range groups {
+		groups[i].Friends = []models.User{}
+		groupIndex[groups[i].ID] = i
+	}
+	
+	rows, err := s.db.Query(`
+		SELECT u.id, u.username, u.role, u.avatar, u.profile, u.created_at, u.updated_at, f.group_id
+		FROM users u
+		JOIN friendships f ON u.id = f.friend_id
+		WHERE f.user_id = $1 AND f.status = 'accepted'
+		ORDER BY u.username`,
+		userID)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer rows.Close()
+	
+	ungrouped := []models.User{}
+	for rows.Next() {
+		var friend models.User
+		var groupID sql.NullInt64
+		err := rows.Scan(&friend.ID, &friend.Username, &friend.Role,
+			&friend.Avatar, &friend.Profile, &friend.CreatedAt, &friend.UpdatedAt, &groupID)
+		if err != nil {
+			return nil, nil, err
+		}
+		if i, ok := groupIndex[int(groupID.Int64)]; groupID.Valid && ok {
+			groups[i].Friends = append(groups[i].Friends, friend)
+		} else {
+			ungrouped = append(ungrouped, friend)
+		}
+	}
+	
+	return groups, ungrouped, rows.Err()
+}
+
+// Rename friend group
+func (s *FriendService) RenameFriendGroup(userID, groupID int, name string) error {
+	if err := s.checkGroupOwner(userID, groupID); err != nil {
+		return err
+	}
+	if err := s.checkGroupName(userID, groupID, name); err != nil {
+		return err
+	}
+	
+	_, err := s.db.Exec("UPDATE friend_groups SET group_name = $1 WHERE id = $2 AND user_id = $3", name, groupID, userID)
+	return err
+}
+
+// Delete friend group, its friends become ungrouped
+func (s *FriendService) DeleteFriendGroup(userID, groupID int) error {
+	result, err := s.db.Exec("DELETE FROM friend_groups WHERE id = $1 AND user_id = $2", groupID, userID)
+	if err != nil {
+		return err
+	}
+	
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if rowsAffected == 0 {
+		return errors.New("friend group not found or permission denied")
+	}
+	
+	return nil
+}
+
+// Reorder the user's friend groups, groupIDs must list every group once
+func (s *FriendService) ReorderFriendGroups(userID int, groupIDs []int) error {
+	groupIDs = uniqueIDs(groupIDs, 0)
+	
+	var owned, total int
+	err := s.db.QueryRow(`
+		SELECT COUNT(*) FILTER (WHERE id = ANY($2)), COUNT(*)
+		FROM friend_groups WHERE user_id = $1`,
+		userID, pq.Array(groupIDs)).Scan(&owned, &total)
+	if err != nil {
+		return err
+	}
+	if owned != len(groupIDs) || total != len(groupIDs) {
+		return errors.New("group order must list each of your friend groups once")
+	}
+	
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	
+	for position, groupID := range groupIDs {
+		_, err := tx.Exec("UPDATE friend_groups SET position = $1 WHERE id = $2 AND user_id = $3", position, groupID, userID)
+		if err != nil {
+			return err
+		}
+	}
+	
+	return tx.Commit()
+}
+
+// Add friend to group, a friend belongs to at most one group
 func (s *FriendService) AddFriendToGroup(userID, friendID, groupID int) error {
+	if err := s.checkGroupOwner(userID, groupID); err != nil {
+		return err
+	}
+	
 	// Check if friendship exists
 	var count int
 	err := s.db.QueryRow(`
@@ -257,4 +677,29 @@ func (s *FriendService) AddFriendToGroup(userID, friendID, groupID int) error {
 		groupID, userID, friendID)
 	
 	return err
-}
\ No newline at end of file
+}
+
+// Remove friend from group, the friendship stays
+func (s *FriendService) RemoveFriendFromGroup(userID, friendID, groupID int) error {
+	if err := s.checkGroupOwner(userID, groupID); err != nil {
+		return err
+	}
+	
+	result, err := s.db.Exec(`
+		UPDATE friendships SET group_id = NULL
+		WHERE user_id = $1 AND friend_id = $2 AND group_id = $3`,
+		userID, friendID, groupID)
+	if err != nil {
+		return err
+	}
+	
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if rowsAffected == 0 {
+		return errors.New("friend is not in this group")
+	}
+	
+	return nil
+}
@@ -0,0 +1,270 @@
+package services
+
+import (
+	"database/sql"
+	"errors"
+	"log"
+	"strings"
+	"time"
+	"ai-doc-system/internal/directory"
+	"ai-doc-system/internal/models"
+)
+
+// Directory logins refused for reasons the user should know
+var (
+	ErrDirectoryUnavailable = errors.New("directory is unavailable, please try again later")
+	ErrLocalUsernameTaken   = errors.New("username is already used by a local account")
+)
+
+// LDAPOptions control how directory users map to local users
+type LDAPOptions struct {
+	RoleRules   []GroupRoleRule // Matched against the user's group DNs, first matching rule wins
+	DefaultRole string          // Role of users no rule matches
+}
+
+// LDAPService logs in users whose passwords live in an LDAP or Active Directory server.
+// Their users rows are created on first login and kept in line with the directory by a
+// periodic sync; local accounts are untouched.
+type LDAPService struct {
+	db             *sql.DB
+	directory      *directory.Directory // Nil when no directory is configured
+	sessionService *SessionService
+	options        LDAPOptions
+}
+
+func NewLDAPService(db *sql.DB, dir *directory.Directory, sessionService *SessionService, options LDAPOptions) *LDAPService {
+	return &LDAPService{db: db, directory: dir, sessionService: sessionService, options: options}
+}
+
+func (s *LDAPService) Enabled() bool {
+	return s != nil && s.directory != nil
+}
+
+// Check the password against the directory and return the matching local user,
+// creating it on the first login
+func (s *LDAPService) Login(username, password string) (int, error) {
+	if !s.Enabled() {
+		return 0, errors.New("directory login is not configured")
+	}
+
+	entry, err := s.directory.Authenticate(username, password)
+	if err == directory.ErrInvalidCredentials {
+		return 0, ErrInvalidCredentials
+	}
+	if err != nil {
+		log.Printf("Directory login failed: %v", err)
+		return 0, ErrDirectoryUnavailable
+	}
+
+	tx, err := s.db.Begin()
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	var userID int
+	var authSource string
+	var disabledReason sql.NullString
+	err = tx.QueryRow(`
+		SELECT id, auth_source, CASE WHEN disabled_at IS NULL THEN NULL ELSE COALESCE(disabled_reason, '') END
+		FROM users WHERE LOWER(username) = LOWER($1)
+		FOR UPDATE`,
+		entry.Username).Scan(&userID, &authSource, &disabledReason)
+	if err == sql.ErrNoRows {
+		userID, err = s.createUser(tx, entry)
+		if err != nil {
+			return 0, err
+		}
+		return userID, tx.Commit()
+	}
+	if err != nil {
+		return 0, err
+	}
+
+	// A directory user never takes over a local account of the same name
+	if authSource != "ldap" {
+		return 0, ErrLocalUsernameTaken
+	}
+	if disabledReason.Valid && disabledReason.String != "directory" {
+		return 0, ErrAccountDisabled
+	}
+
+	if err := s.updateUser(tx, userID, entry); err != nil {
+		return 0, err
+	}
+	return userID, tx.Commit()
+}
+
+func (s *LDAPService) createUser(tx *sql.Tx, entry *directory.Entry) (int, error) {
+	role := mapGroupRole(s.options.RoleRules, entry.Groups)
+	if role == "" {
+		role = s.options.DefaultRole
+	}
+
+	email, err := freeEmail(tx, entry.Email, 0)
+	if err != nil {
+		return 0, err
+	}
+
+	// The password stays in the directory, the empty hash never matches
+	var userID int
+	err = tx.QueryRow(`
+		INSERT INTO users (username, password_hash, role, email, auth_source, external_id, directory_synced_at)
+		VALUES ($1, '', $2, NULLIF($3, ''), 'ldap', $4, CURRENT_TIMESTAMP)
+		RETURNING id`,
+		truncateRunes(entry.Username, 50), role, email, entry.DN).Scan(&userID)
+	return userID, err
+}
+
+// Role the user's groups map to. With role rules, users no rule matches get the default role,
+// so leaving a mapped group demotes them; without rules, "" keeps roles assigned locally.
+func (s *LDAPService) existingUserRole(groups []string) string {
+	if len(s.options.RoleRules) == 0 {
+		return ""
+	}
+	if role := mapGroupRole(s.options.RoleRules, groups); role != "" {
+		return role
+	}
+	return s.options.DefaultRole
+}
+
+// Copy email, DN and mapped role from the directory; accounts the sync disabled are enabled again
+func (s *LDAPService) updateUser(tx *sql.Tx, userID int, entry *directory.Entry) error {
+	email, err := freeEmail(tx, entry.Email, userID)
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.Exec(`
+		UPDATE users SET
+			email = COALESCE(NULLIF($1, ''), email),
+			external_id = $2,
+			role = COALESCE(NULLIF($3, ''), role),
+			directory_synced_at = CURRENT_TIMESTAMP,
+			disabled_at = CASE WHEN disabled_reason = 'directory' THEN NULL ELSE disabled_at END,
+			disabled_reason = CASE WHEN disabled_reason = 'directory' THEN NULL ELSE disabled_reason END,
+			updated_at = CURRENT_TIMESTAMP
+		WHERE id = $4`,
+		email, entry.DN, s.existingUserRole(entry.Groups), userID)
+	return err
+}
+
+// The directory's email unless another user already has it
+func freeEmail(tx *sql.Tx, email string, userID int) (string, error) {
+	if email == "" {
+		return "", nil
+	}
+	var count int
+	err := tx.QueryRow("SELECT COUNT(*) FROM users WHERE LOWER(email) = LOWER($1) AND id != $2", email, userID).Scan(&count)
+	if err != nil || count > 0 {
+		return "", err
+	}
+	return email, nil
+}
+
+// Sync directory users: update the ones still in the directory, disable and log out the
+// ones removed from it
+func (s *LDAPService) Sync() (*models.DirectorySyncResult, error) {
+	if !s.Enabled() {
+		return nil, errors.New("directory login is not configured")
+	}
+
+	entries, err := s.directory.Users()
+	if err != nil {
+		return nil, err
+	}
+	// An empty result is far more likely a broken filter than an empty directory
+	if len(entries) == 0 {
+		return nil, errors.New("directory returned no users, nobody was disabled")
+	}
+	byUsername := make(map[string]*directory.Entry, len(entries))
+	for i := range entries {
+		byUsername[strings.ToLower(entries[i].Username)] = &entries[i]
+	}
+
+	rows, err := s.db.Query(`
+		SELECT id, username, disabled_at IS NOT NULL
+		FROM users WHERE auth_source = 'ldap'`)
+	if err != nil {
+		return nil, err
+	}
+	type ldapUser struct {
+		id       int
+		username string
+		disabled bool
+	}
+	var users []ldapUser
+	for rows.Next() {
+		var user ldapUser
+		if err := rows.Scan(&user.id, &user.username, &user.disabled); err != nil {
+			rows.Close()
+			return nil, err
+		}
+		users = append(users, user)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	result := &models.DirectorySyncResult{DirectoryUsers: len(entries)}
+	for _, user := range users {
+		entry, found := byUsername[strings.ToLower(user.username)]
+		if !found {
+			if user.disabled {
+				continue
+			}
+			if err := s.disableUser(user.id); err != nil {
+				return result, err
+			}
+			result.Disabled++
+			continue
+		}
+
+		tx, err := s.db.Begin()
+		if err != nil {
+			return result, err
+		}
+		err = s.updateUser(tx, user.id, entry)
+		if err == nil {
+			err = tx.Commit()
+		}
+		tx.Rollback()
+		if err != nil {
+			return result, err
+		}
+		result.Updated++
+	}
+
+	return result, nil
+}
+
+// Disable a user removed from the directory; ending the sessions rejects their tokens right away
+func (s *LDAPService) disableUser(userID int) error {
+	_, err := s.db.Exec(`
+		UPDATE users SET disabled_at = CURRENT_TIMESTAMP, disabled_reason = 'directory', updated_at = CURRENT_TIMESTAMP
+		WHERE id = $1 AND disabled_at IS NULL`,
+		userID)
+	if err != nil {
+		return err
+	}
+	_, err = s.sessionService.RevokeAllSessions(userID, "", "directory_removed")
+	return err
+}
+
+// Sync every interval until the process exits
+func (s *LDAPService) RunSync(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for range ticker.C {
+		result, err := s.Sync()
+		if err != nil {
+			log.Printf("Directory sync failed: %v", err)
+			continue
+		}
+		if result.Disabled > 0 {
+			log.Printf("Directory sync disabled %d users", result.Disabled)
+		}
+	}
+}
@@ -0,0 +1,263 @@
+package services
+
+import (
+	"database/sql"
+	"errors"
+	"log"
+	"strconv"
+	"strings"
+	"time"
+	"ai-doc-system/internal/models"
+)
+
+const (
+	loginThrottleBaseDelay = 30 * time.Second // Lockout after the first failure past the free ones, doubling with each further one
+	loginThrottleMaxDelay  = time.Hour
+)
+
+type loginThrottlePolicy struct {
+	freeFailures int           // Failures allowed before logins are refused for a while
+	window       time.Duration // Failures are forgotten after this long without another one
+}
+
+// Accounts lock out quickly; addresses get more room since offices share one. Passwords
+// of file request links are throttled the same way, per request and per address.
+var loginThrottlePolicies = map[string]loginThrottlePolicy{
+	"account":    {freeFailures: 5, window: 24 * time.Hour},
+	"ip":         {freeFailures: 20, window: time.Hour},
+	"request":    {freeFailures: 10, window: time.Hour},
+	"request_ip": {freeFailures: 20, window: time.Hour},
+}
+
+// LoginThrottleService slows down password guessing. Failed logins are counted per
+// username and per IP address; past the free failures each one locks the username or
+// address out for twice as long as the previous one, until a successful login, the
+// lock expiring or an admin unlocking it.
+type LoginThrottleService struct {
+	db           *sql.DB
+	auditService *AuditService
+}
+
+func NewLoginThrottleService(db *sql.DB, auditService *AuditService) *LoginThrottleService {
+	return &LoginThrottleService{db: db, auditService: auditService}
+}
+
+func throttleIdentifier(scope, value string) string {
+	if scope == "account" {
+		value = strings.ToLower(strings.TrimSpace(value))
+	}
+	return truncateRunes(value, 100)
+}
+
+func throttleDelay(policy loginThrottlePolicy, failures int) time.Duration {
+	if failures <= policy.freeFailures {
+		return 0
+	}
+	delay := loginThrottleBaseDelay
+	for i := policy.freeFailures + 1; i < failures && delay < loginThrottleMaxDelay; i++ {
+		delay *= 2
+	}
+	if delay > loginThrottleMaxDelay {
+		delay = loginThrottleMaxDelay
+	}
+	return delay
+}
+
+// How long logins for the username from the address are refused, 0 when they are allowed
+func (s *LoginThrottleService) Check(username, ipAddress string) (time.Duration, error) {
+	return s.lockedFor("account", throttleIdentifier("account", username), "ip", throttleIdentifier("ip", ipAddress))
+}
+
+// How long password attempts for the file request from the address are refused, 0 when they are allowed
+func (s *LoginThrottleService) CheckFileRequest(requestID int, ipAddress string) (time.Duration, error) {
+	return s.lockedFor("request", strconv.Itoa(requestID), "request_ip", throttleIdentifier("request_ip", ipAddress))
+}
+
+// How long until neither identifier is locked out any more
+func (s *LoginThrottleService) lockedFor(scope1, identifier1, scope2, identifier2 string) (time.Duration, error) {
+	var seconds int
+	err := s.db.QueryRow(`
+		SELECT COALESCE(CEIL(EXTRACT(EPOCH FROM MAX(locked_until) - CURRENT_TIMESTAMP))::INTEGER, 0)
+		FROM login_throttles
+		WHERE locked_until > CURRENT_TIMESTAMP
+			AND ((scope = $1 AND identifier = $2) OR (scope = $3 AND identifier = $4))`,
+		scope1, identifier1, scope2, identifier2).Scan(&seconds)
+	if err != nil {
+		return 0, err
+	}
+	return time.Duration(seconds) * time.Second, nil
+}
+
+// Count a failed login against the username and the address, locking them out when
+// they are past their free failures
+func (s *LoginThrottleService) RecordFailure(username, ipAddress string) error {
+	username = throttleIdentifier("account", username)
+	s.record(ipAddress, "login.failed", "account", username, nil)
+
+	for _, scope := range []string{"account", "ip"} {
+		identifier := username
+		if scope == "ip" {
+			identifier = throttleIdentifier(scope, ipAddress)
+		}
+
+		failures, delay, err := s.countFailure(scope, identifier)
+		if err != nil {
+			return err
+		}
+		if delay > 0 {
+			s.record(ipAddress, "login.lockout", "account", username, map[string]interface{}{
+				"scope":      scope,
+				"identifier": identifier,
+				"failures":   failures,
+				"seconds":    int(delay.Seconds()),
+			})
+		}
+	}
+
+	s.forgetOldFailures()
+	return nil
+}
+
+// Count a wrong password for the file request against the request and the address
+func (s *LoginThrottleService) RecordFileRequestFailure(requestID int, ipAddress string) error {
+	request := strconv.Itoa(requestID)
+	for _, scope := range []string{"request", "request_ip"} {
+		identifier := request
+		if scope == "request_ip" {
+			identifier = throttleIdentifier(scope, ipAddress)
+		}
+
+		failures, delay, err := s.countFailure(scope, identifier)
+		if err != nil {
+			return err
+		}
+		if delay > 0 {
+			s.record(ipAddress, "file_request.lockout", "file_request", request, map[string]interface{}{
+				"scope":      scope,
+				"identifier": identifier,
+				"failures":   failures,
+				"seconds":    int(delay.Seconds()),
+			})
+		}
+	}
+
+	s.forgetOldFailures()
+	return nil
+}
+
+// Count a failure against the identifier and lock it out when it is past its free failures,
+// returns the failures counted and how long it is locked out
+func (s *LoginThrottleService) countFailure(scope, identifier string) (int, time.Duration, error) {
+	policy := loginThrottlePolicies[scope]
+
+	var id, failures int
+	err := s.db.QueryRow(`
+		INSERT INTO login_throttles (scope, identifier, failures, last_failure_at)
+		VALUES ($1, $2, 1, CURRENT_TIMESTAMP)
+		ON CONFLICT (scope, identifier) DO UPDATE SET
+			failures = CASE
+				WHEN login_throttles.last_failure_at < CURRENT_TIMESTAMP - $3 * INTERVAL '1 second' THEN 1
+				ELSE login_throttles.failures + 1
+			END,
+			last_failure_at = CURRENT_TIMESTAMP
+		RETURNING id, failures`,
+		scope, identifier, int(policy.window.Seconds())).Scan(&id, &failures)
+	if err != nil {
+		return 0, 0, err
+	}
+
+	delay := throttleDelay(policy, failures)
+	if delay == 0 {
+		return failures, 0, nil
+	}
+	_, err = s.db.Exec("UPDATE login_throttles SET locked_until = CURRENT_TIMESTAMP + $1 * INTERVAL '1 second' WHERE id = $2",
+		int(delay.Seconds()), id)
+	if err != nil {
+		return 0, 0, err
+	}
+	return failures, delay, nil
+}
+
+// Forgotten failures are cleaned up as new ones come in
+func (s *LoginThrottleService) forgetOldFailures() {
+	s.db.Exec(`
+		DELETE FROM login_throttles
+		WHERE last_failure_at < CURRENT_TIMESTAMP - INTERVAL '1 day'
+			AND (locked_until IS NULL OR locked_until < CURRENT_TIMESTAMP)`)
+}
+
+// A successful login clears the username's failures; the address keeps its count, or one
+// valid account would let an attacker reset it
+func (s *LoginThrottleService) RecordSuccess(username string) error {
+	_, err := s.db.Exec("DELETE FROM login_throttles WHERE scope = 'account' AND identifier = $1",
+		throttleIdentifier("account", username))
+	return err
+}
+
+// The right password clears the file request's failures; like logins, the address keeps its count
+func (s *LoginThrottleService) RecordFileRequestSuccess(requestID int) error {
+	_, err := s.db.Exec("DELETE FROM login_throttles WHERE scope = 'request' AND identifier = $1",
+		strconv.Itoa(requestID))
+	return err
+}
+
+// Usernames, file requests and addresses locked out right now
+func (s *LoginThrottleService) GetLockouts() ([]models.LoginLockout, error) {
+	rows, err := s.db.Query(`
+		SELECT id, scope, identifier, failures, last_failure_at, locked_until
+		FROM login_throttles
+		WHERE locked_until > CURRENT_TIMESTAMP
+		ORDER BY locked_until DESC`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	lockouts := []models.LoginLockout{}
+	for rows.Next() {
+		var lockout models.LoginLockout
+		err := rows.Scan(&lockout.ID, &lockout.Scope, &lockout.Identifier, &lockout.Failures,
+			&lockout.LastFailureAt, &lockout.LockedUntil)
+		if err != nil {
+			return nil, err
+		}
+		lockouts = append(lockouts, lockout)
+	}
+	return lockouts, rows.Err()
+}
+
+// Lift a lockout and forget its failures, returns what was unlocked
+func (s *LoginThrottleService) Unlock(id int) (*models.LoginLockout, error) {
+	var lockout models.LoginLockout
+	err := s.db.QueryRow(`
+		DELETE FROM login_throttles WHERE id = $1
+		RETURNING id, scope, identifier, failures`,
+		id).Scan(&lockout.ID, &lockout.Scope, &lockout.Identifier, &lockout.Failures)
+	if err == sql.ErrNoRows {
+		return nil, errors.New("lockout not found")
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &lockout, nil
+}
+
+// Lift the lockout of a user's account; unlocking an account that is not locked succeeds
+func (s *LoginThrottleService) UnlockUser(userID int) error {
+	var username string
+	err := s.db.QueryRow("SELECT username FROM users WHERE id = $1", userID).Scan(&username)
+	if err == sql.ErrNoRows {
+		return errors.New("user not found")
+	}
+	if err != nil {
+		return err
+	}
+	return s.RecordSuccess(username)
+}
+
+// Security events have no actor, the target is the username tried or the file request
+func (s *LoginThrottleService) record(ipAddress, action, targetType, targetID string, details map[string]interface{}) {
+	if err := s.auditService.Record(0, ipAddress, action, targetType, targetID, details); err != nil {
+		log.Printf("Failed to record audit entry %s: %v", action, err)
+	}
+}
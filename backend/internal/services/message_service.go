@@ -3,185 +3,1300 @@ package services
 import (
 	"database/sql"
 	"errors"
+	"fmt"
+	"log"
+	"mime/multipart"
+	"strings"
+	"time"
 	"ai-doc-system/internal/models"
+	"ai-doc-system/internal/realtime"
+	"ai-doc-system/internal/utils"
+	"github.com/lib/pq"
+)
+
+var (
+	ErrConversationNotFound = errors.New("conversation not found")
+	ErrMessageNotFound      = errors.New("message not found")
 )
 
 type MessageService struct {
-	db *sql.DB
+	db                  *sql.DB
+	hub                 *realtime.Hub
+	fileService         *FileService
+	notificationService *NotificationService
 }
 
-func NewMessageService(db *sql.DB) *MessageService {
-	return &MessageService{db: db}
+func NewMessageService(db *sql.DB, hub *realtime.Hub, fileService *FileService, notificationService *NotificationService) *MessageService {
+	return &MessageService{db: db, hub: hub, fileService: fileService, notificationService: notificationService}
 }
 
-// Send message
-func (s *MessageService) SendMessage(fromUserID, toUserID int, content string) (*models.Message, error) {
-	// Check if they are friends
+const messageColumns = `id, conversation_id, sender_id, receiver_id, content, message_type, file_id, reply_to_id, edited_at, read_at, created_at`
+
+func scanMessage(row rowScanner) (*models.Message, error) {
+	var message models.Message
+	err := row.Scan(&message.ID, &message.ConversationID, &message.SenderID, &message.ReceiverID,
+		&message.Content, &message.MessageType, &message.FileID, &message.ReplyToID, &message.EditedAt,
+		&message.ReadAt, &message.CreatedAt)
+	if err != nil {
+		return nil, err
+	}
+	return &message, nil
+}
+
+// Fill in file cards, reply previews, reply counts and reactions
+func (s *MessageService) loadDetails(messages []models.Message) error {
+	if len(messages) == 0 {
+		return nil
+	}
+	if err := s.loadFileCards(messages); err != nil {
+		return err
+	}
+	if err := s.loadReplies(messages); err != nil {
+		return err
+	}
+	return s.loadReactions(messages)
+}
+
+// Fill in the parent previews of replies and the number of replies to each message
+func (s *MessageService) loadReplies(messages []models.Message) error {
+	var ids, parentIDs []int
+	for _, message := range messages {
+		ids = append(ids, message.ID)
+		if message.ReplyToID != nil {
+			parentIDs = append(parentIDs, *message.ReplyToID)
+		}
+	}
+
+	previews := make(map[int]*models.MessagePreview)
+	if len(parentIDs) > 0 {
+		rows, err := s.db.Query(`
+			SELECT id, sender_id, LEFT(content, 200), message_type
+			FROM messages WHERE id = ANY($1)`,
+			pq.Array(parentIDs))
+		if err != nil {
+			return err
+		}
+		for rows.Next() {
+			var preview models.MessagePreview
+			if err := rows.Scan(&preview.ID, &preview.SenderID, &preview.Content, &preview.MessageType); err != nil {
+				rows.Close()
+				return err
+			}
+			previews[preview.ID] = &preview
+		}
+		rows.Close()
+		if err := rows.Err(); err != nil {
+			return err
+		}
+	}
+
+	counts := make(map[int]int)
+	rows, err := s.db.Query(`
+		SELECT reply_to_id, COUNT(*) FROM messages
+		WHERE reply_to_id = ANY($1)
+		GROUP BY reply_to_id`,
+		pq.Array(ids))
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var id, count int
+		if err := rows.Scan(&id, &count); err != nil {
+			return err
+		}
+		counts[id] = count
+	}
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	for i := range messages {
+		if messages[i].ReplyToID != nil {
+			messages[i].ReplyTo = previews[*messages[i].ReplyToID]
+		}
+		messages[i].ReplyCount = counts[messages[i].ID]
+	}
+
+	return nil
+}
+
+// Fill in the reactions of each message, grouped by emoji in order of first use
+func (s *MessageService) loadReactions(messages []models.Message) error {
+	var ids []int
+	for _, message := range messages {
+		ids = append(ids, message.ID)
+	}
+
+	rows, err := s.db.Query(`
+		SELECT message_id, emoji, array_agg(user_id ORDER BY created_at)
+		FROM message_reactions
+		WHERE message_id = ANY($1)
+		GROUP BY message_id, emoji
+		ORDER BY message_id, MIN(created_at)`,
+		pq.Array(ids))
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	reactions := make(map[int][]models.MessageReaction)
+	for rows.Next() {
+		var messageID int
+		var reaction models.MessageReaction
+		var userIDs pq.Int64Array
+		if err := rows.Scan(&messageID, &reaction.Emoji, &userIDs); err != nil {
+			return err
+		}
+		for _, userID := range userIDs {
+			reaction.UserIDs = append(reaction.UserIDs, int(userID))
+		}
+		reaction.Count = len(reaction.UserIDs)
+		reactions[messageID] = append(reactions[messageID], reaction)
+	}
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	for i := range messages {
+		messages[i].Reactions = reactions[messages[i].ID]
+		if messages[i].Reactions == nil {
+			messages[i].Reactions = []models.MessageReaction{}
+		}
+	}
+
+	return nil
+}
+
+// Fill in the file cards of file messages
+func (s *MessageService) loadFileCards(messages []models.Message) error {
+	var fileIDs []int
+	for _, message := range messages {
+		if message.FileID != nil {
+			fileIDs = append(fileIDs, *message.FileID)
+		}
+	}
+	if len(fileIDs) == 0 {
+		return nil
+	}
+
+	rows, err := s.db.Query(`
+		SELECT id, original_name, file_size, COALESCE(mime_type, '')
+		FROM files WHERE id = ANY($1)`,
+		pq.Array(fileIDs))
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	cards := make(map[int]*models.MessageFile)
+	for rows.Next() {
+		var card models.MessageFile
+		if err := rows.Scan(&card.ID, &card.Name, &card.FileSize, &card.MimeType); err != nil {
+			return err
+		}
+		// Works for the owner and for every member the file was shared with
+		card.PreviewURL = fmt.Sprintf("/api/shares/files/%d/download", card.ID)
+		cards[card.ID] = &card
+	}
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	for i := range messages {
+		if messages[i].FileID != nil {
+			messages[i].File = cards[*messages[i].FileID]
+		}
+	}
+
+	return nil
+}
+
+// Push event to the connected clients of the given users
+func (s *MessageService) publish(userIDs []int, eventType string, data interface{}) {
+	if s.hub == nil {
+		return
+	}
+	if err := s.hub.Publish(userIDs, eventType, data); err != nil {
+		log.Printf("Failed to publish %s event: %v", eventType, err)
+	}
+}
+
+// Push event to every member of a conversation
+func (s *MessageService) publishToConversation(conversationID int, eventType string, data interface{}) {
+	memberIDs, err := conversationMemberIDs(s.db, conversationID)
+	if err != nil {
+		log.Printf("Failed to get members of conversation %d: %v", conversationID, err)
+		return
+	}
+	s.publish(memberIDs, eventType, data)
+}
+
+func conversationMemberIDs(db *sql.DB, conversationID int) ([]int, error) {
+	rows, err := db.Query("SELECT user_id FROM conversation_members WHERE conversation_id = $1", conversationID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var userIDs []int
+	for rows.Next() {
+		var userID int
+		if err := rows.Scan(&userID); err != nil {
+			return nil, err
+		}
+		userIDs = append(userIDs, userID)
+	}
+
+	return userIDs, rows.Err()
+}
+
+func directKey(userID, otherUserID int) string {
+	if userID > otherUserID {
+		userID, otherUserID = otherUserID, userID
+	}
+	return fmt.Sprintf("%d:%d", userID, otherUserID)
+}
+
+// Get the direct conversation between two users, 0 if they never talked
+func (s *MessageService) findDirectConversation(userID, otherUserID int) (int, error) {
+	var conversationID int
+	err := s.db.QueryRow("SELECT id FROM conversations WHERE direct_key = $1", directKey(userID, otherUserID)).Scan(&conversationID)
+	if err == sql.ErrNoRows {
+		return 0, nil
+	}
+	return conversationID, err
+}
+
+// Get or create the direct conversation between two users
+func (s *MessageService) GetOrCreateDirectConversation(userID, otherUserID int) (int, error) {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	var conversationID int
+	err = tx.QueryRow(`
+		INSERT INTO conversations (type, direct_key, created_by)
+		VALUES ('direct', $1, $2)
+		ON CONFLICT (direct_key) DO UPDATE SET direct_key = EXCLUDED.direct_key
+		RETURNING id`,
+		directKey(userID, otherUserID), userID).Scan(&conversationID)
+	if err != nil {
+		return 0, err
+	}
+
+	_, err = tx.Exec(`
+		INSERT INTO conversation_members (conversation_id, user_id)
+		VALUES ($1, $2), ($1, $3)
+		ON CONFLICT (conversation_id, user_id) DO NOTHING`,
+		conversationID, userID, otherUserID)
+	if err != nil {
+		return 0, err
+	}
+
+	return conversationID, tx.Commit()
+}
+
+func (s *MessageService) checkFriendship(userID, friendID int) error {
 	var count int
 	err := s.db.QueryRow(`
-		SELECT COUNT(*) FROM friendships 
+		SELECT COUNT(*) FROM friendships
 		WHERE user_id = $1 AND friend_id = $2 AND status = 'accepted'`,
-		fromUserID, toUserID).Scan(&count)
+		userID, friendID).Scan(&count)
 	if err != nil {
-		return nil, err
+		return err
 	}
 	if count == 0 {
-		return nil, errors.New("can only send messages to friends")
+		return errors.New("can only send messages to friends")
 	}
-	
-	// Create message
-	var message models.Message
-	err = s.db.QueryRow(`
-		INSERT INTO messages (sender_id, receiver_id, content) 
-		VALUES ($1, $2, $3) 
-		RETURNING id, sender_id, receiver_id, content, message_type, created_at`,
-		fromUserID, toUserID, content).Scan(
-		&message.ID, &message.SenderID, &message.ReceiverID, 
-		&message.Content, &message.MessageType, &message.CreatedAt)
-	
+	return nil
+}
+
+// Send message to a friend, optionally as a reply to a message of their conversation
+func (s *MessageService) SendMessage(fromUserID, toUserID int, content string, replyToID *int) (*models.Message, error) {
+	// Check if they are friends
+	if err := s.checkFriendship(fromUserID, toUserID); err != nil {
+		return nil, err
+	}
+
+	conversationID, err := s.GetOrCreateDirectConversation(fromUserID, toUserID)
 	if err != nil {
 		return nil, err
 	}
-	
-	return &message, nil
+
+	return s.createMessage(conversationID, fromUserID, &toUserID, content, "text", nil, replyToID)
+}
+
+// Send message to a conversation the user belongs to, optionally as a reply
+func (s *MessageService) SendConversationMessage(conversationID, fromUserID int, content string, replyToID *int) (*models.Message, error) {
+	receiverID, err := s.resolveReceiver(conversationID, fromUserID)
+	if err != nil {
+		return nil, err
+	}
+
+	return s.createMessage(conversationID, fromUserID, receiverID, content, "text", nil, replyToID)
+}
+
+// Check the sender may post to the conversation, returns the receiver for direct conversations
+func (s *MessageService) resolveReceiver(conversationID, fromUserID int) (*int, error) {
+	var conversationType string
+	err := s.db.QueryRow(`
+		SELECT c.type FROM conversations c
+		JOIN conversation_members cm ON cm.conversation_id = c.id
+		WHERE c.id = $1 AND cm.user_id = $2`,
+		conversationID, fromUserID).Scan(&conversationType)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, errors.New("conversation not found")
+		}
+		return nil, err
+	}
+
+	var receiverID *int
+	if conversationType == "direct" {
+		var otherUserID int
+		err := s.db.QueryRow(`
+			SELECT user_id FROM conversation_members
+			WHERE conversation_id = $1 AND user_id != $2`,
+			conversationID, fromUserID).Scan(&otherUserID)
+		if err != nil {
+			return nil, err
+		}
+		if err := s.checkFriendship(fromUserID, otherUserID); err != nil {
+			return nil, err
+		}
+		receiverID = &otherUserID
+	}
+
+	return receiverID, nil
+}
+
+// Send one of the user's files to a friend
+func (s *MessageService) SendFileMessage(fromUserID, toUserID, fileID int, caption string, replyToID *int) (*models.Message, error) {
+	if err := s.checkFriendship(fromUserID, toUserID); err != nil {
+		return nil, err
+	}
+
+	conversationID, err := s.GetOrCreateDirectConversation(fromUserID, toUserID)
+	if err != nil {
+		return nil, err
+	}
+
+	return s.SendConversationFile(conversationID, fromUserID, fileID, caption, replyToID)
+}
+
+// Send one of the user's files to a conversation, sharing it with every other member
+func (s *MessageService) SendConversationFile(conversationID, fromUserID, fileID int, caption string, replyToID *int) (*models.Message, error) {
+	receiverID, err := s.resolveReceiver(conversationID, fromUserID)
+	if err != nil {
+		return nil, err
+	}
+
+	var fileName string
+	err = s.db.QueryRow("SELECT original_name FROM files WHERE id = $1 AND user_id = $2", fileID, fromUserID).Scan(&fileName)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, errors.New("file not found or permission denied")
+		}
+		return nil, err
+	}
+
+	content := caption
+	if content == "" {
+		content = fileName
+	}
+
+	return s.createMessage(conversationID, fromUserID, receiverID, content, "file", &fileID, replyToID)
+}
+
+// Upload a new file of the user directly into a conversation
+func (s *MessageService) UploadConversationFile(conversationID, fromUserID int, file *multipart.FileHeader, caption string, replyToID *int) (*models.Message, error) {
+	// Check before storing anything
+	if _, err := s.resolveReceiver(conversationID, fromUserID); err != nil {
+		return nil, err
+	}
+
+	uploaded, err := s.fileService.UploadFile(fromUserID, file)
+	if err != nil {
+		return nil, err
+	}
+
+	message, err := s.SendConversationFile(conversationID, fromUserID, uploaded.ID, caption, replyToID)
+	if err != nil {
+		// Without its message the file would sit in the sender's storage unnoticed
+		if deleteErr := s.fileService.DeleteFile(uploaded.ID, fromUserID); deleteErr != nil {
+			log.Printf("Failed to delete file %d of a failed conversation upload: %v", uploaded.ID, deleteErr)
+		}
+		return nil, err
+	}
+
+	return message, nil
+}
+
+func (s *MessageService) createMessage(conversationID, senderID int, receiverID *int, content, messageType string, fileID, replyToID *int) (*models.Message, error) {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	// Replies must stay within the parent's conversation
+	if replyToID != nil {
+		var count int
+		err := tx.QueryRow("SELECT COUNT(*) FROM messages WHERE id = $1 AND conversation_id = $2", *replyToID, conversationID).Scan(&count)
+		if err != nil {
+			return nil, err
+		}
+		if count == 0 {
+			return nil, errors.New("parent message not found")
+		}
+	}
+
+	row := tx.QueryRow(`
+		INSERT INTO messages (conversation_id, sender_id, receiver_id, content, message_type, file_id, reply_to_id)
+		VALUES ($1, $2, $3, $4, $5, $6, $7)
+		RETURNING `+messageColumns,
+		conversationID, senderID, receiverID, content, messageType, fileID, replyToID)
+	message, err := scanMessage(row)
+	if err != nil {
+		return nil, err
+	}
+
+	// Share attached file with members who cannot access it yet, except where a block exists,
+	// remembering the message that created the share
+	if fileID != nil {
+		_, err = tx.Exec(`
+			INSERT INTO file_shares (file_id, share_type, share_token, shared_with, created_by, message_id)
+			SELECT $1, 'friend', gen_random_uuid()::text, cm.user_id, $2, $3
+			FROM conversation_members cm
+			WHERE cm.conversation_id = $4 AND cm.user_id != $2
+			AND NOT EXISTS (
+				SELECT 1 FROM file_shares fs
+				WHERE fs.file_id = $1 AND fs.shared_with = cm.user_id AND fs.share_type = 'friend'
+			)
+			AND NOT EXISTS (
+				SELECT 1 FROM friendships b
+				WHERE b.status = 'blocked'
+				AND ((b.user_id = cm.user_id AND b.friend_id = $2) OR (b.user_id = $2 AND b.friend_id = cm.user_id))
+			)`,
+			*fileID, senderID, message.ID, conversationID)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	// The sender has read their own message
+	_, err = tx.Exec(`
+		UPDATE conversation_members SET last_read_message_id = $3
+		WHERE conversation_id = $1 AND user_id = $2`,
+		conversationID, senderID, message.ID)
+	if err != nil {
+		return nil, err
+	}
+	_, err = tx.Exec("UPDATE conversations SET updated_at = CURRENT_TIMESTAMP WHERE id = $1", conversationID)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, err
+	}
+
+	messages := []models.Message{*message}
+	if err := s.loadDetails(messages); err != nil {
+		return nil, err
+	}
+	message = &messages[0]
+
+	// Sender's other devices receive the message too
+	s.publishToConversation(conversationID, "message.new", message)
+	s.notifyRecipients(message)
+
+	return message, nil
+}
+
+// Leave a notification for the other members, at most one unread notification per conversation
+func (s *MessageService) notifyRecipients(message *models.Message) {
+	memberIDs, err := conversationMemberIDs(s.db, message.ConversationID)
+	if err != nil {
+		log.Printf("Failed to get members of conversation %d: %v", message.ConversationID, err)
+		return
+	}
+
+	senderName := usernameOf(s.db, message.SenderID)
+	for _, memberID := range memberIDs {
+		if memberID == message.SenderID {
+			continue
+		}
+		// Group members who blocked the sender still see the message, but are not notified
+		if blocked, err := isBlocked(s.db, memberID, message.SenderID); err != nil || blocked {
+			continue
+		}
+		exists, err := s.notificationService.HasUnread(memberID, "message", "conversation_id", message.ConversationID)
+		if err != nil || exists {
+			continue
+		}
+		content := message.Content
+		if runes := []rune(content); len(runes) > 100 {
+			content = string(runes[:100]) + "..."
+		}
+		s.notificationService.Notify(memberID, "message", "New message from "+senderName, content,
+			map[string]interface{}{"conversation_id": message.ConversationID, "message_id": message.ID, "user_id": message.SenderID})
+	}
 }
 
 // Get chat history with specific user
-func (s *MessageService) GetChatHistory(userID, friendID int, limit, offset int) ([]models.Message, error) {
+func (s *MessageService) GetChatHistory(userID, friendID int, opts HistoryOptions) (*models.MessagePage, error) {
+	conversationID, err := s.findDirectConversation(userID, friendID)
+	if err != nil {
+		return nil, err
+	}
+	if conversationID == 0 {
+		return &models.MessagePage{Messages: []models.Message{}}, nil
+	}
+
+	return s.GetConversationHistory(conversationID, userID, opts)
+}
+
+type HistoryOptions struct {
+	Before          string // Cursor, return messages older than it
+	After           string // Cursor, return messages newer than it
+	AroundMessageID int    // Return the page centered on this message
+	Limit           int
+}
+
+const messageCursorSort = "message"
+
+// Get a page of message history of a conversation the user belongs to, newest first.
+// NextCursor pages to older messages (pass as Before), PrevCursor to newer ones (pass as After).
+func (s *MessageService) GetConversationHistory(conversationID, userID int, opts HistoryOptions) (*models.MessagePage, error) {
+	var count int
+	err := s.db.QueryRow(`
+		SELECT COUNT(*) FROM conversation_members
+		WHERE conversation_id = $1 AND user_id = $2`,
+		conversationID, userID).Scan(&count)
+	if err != nil {
+		return nil, err
+	}
+	if count == 0 {
+		return nil, ErrConversationNotFound
+	}
+
+	var older, newer []models.Message
+	var hasOlder, hasNewer bool
+	switch {
+	case opts.AroundMessageID > 0:
+		count = 0
+		err := s.db.QueryRow("SELECT COUNT(*) FROM messages WHERE id = $1 AND conversation_id = $2",
+			opts.AroundMessageID, conversationID).Scan(&count)
+		if err != nil {
+			return nil, err
+		}
+		if count == 0 {
+			return nil, ErrMessageNotFound
+		}
+		half := opts.Limit / 2
+		if newer, err = s.historyNewer(conversationID, opts.AroundMessageID, half+1); err != nil {
+			return nil, err
+		}
+		if older, err = s.historyOlder(conversationID, opts.AroundMessageID+1, opts.Limit-half+1); err != nil {
+			return nil, err
+		}
+		hasNewer, hasOlder = len(newer) > half, len(older) > opts.Limit-half
+		if hasNewer {
+			newer = newer[:half]
+		}
+		if hasOlder {
+			older = older[:opts.Limit-half]
+		}
+	case opts.After != "":
+		cursor, err := utils.DecodeCursor(opts.After, messageCursorSort)
+		if err != nil {
+			return nil, err
+		}
+		if newer, err = s.historyNewer(conversationID, cursor.ID, opts.Limit+1); err != nil {
+			return nil, err
+		}
+		hasNewer, hasOlder = len(newer) > opts.Limit, true
+		if hasNewer {
+			newer = newer[:opts.Limit]
+		}
+	default:
+		beforeID := 0
+		if opts.Before != "" {
+			cursor, err := utils.DecodeCursor(opts.Before, messageCursorSort)
+			if err != nil {
+				return nil, err
+			}
+			beforeID = cursor.ID
+		}
+		if older, err = s.historyOlder(conversationID, beforeID, opts.Limit+1); err != nil {
+			return nil, err
+		}
+		hasOlder, hasNewer = len(older) > opts.Limit, opts.Before != ""
+		if hasOlder {
+			older = older[:opts.Limit]
+		}
+	}
+
+	// Newer messages were fetched oldest first
+	messages := make([]models.Message, 0, len(newer)+len(older))
+	for i := len(newer) - 1; i >= 0; i-- {
+		messages = append(messages, newer[i])
+	}
+	messages = append(messages, older...)
+
+	if err := s.loadDetails(messages); err != nil {
+		return nil, err
+	}
+
+	page := &models.MessagePage{Messages: messages}
+	if len(messages) > 0 {
+		if hasOlder {
+			page.NextCursor = utils.EncodeCursor(utils.Cursor{Sort: messageCursorSort, ID: messages[len(messages)-1].ID})
+		}
+		if hasNewer {
+			page.PrevCursor = utils.EncodeCursor(utils.Cursor{Sort: messageCursorSort, ID: messages[0].ID})
+		}
+	}
+
+	return page, nil
+}
+
+// Messages older than beforeID (0 for the latest), newest first
+func (s *MessageService) historyOlder(conversationID, beforeID, limit int) ([]models.Message, error) {
+	return s.queryMessages(`
+		SELECT `+messageColumns+` FROM messages
+		WHERE conversation_id = $1 AND ($2 = 0 OR id < $2)
+		ORDER BY id DESC
+		LIMIT $3`,
+		conversationID, beforeID, limit)
+}
+
+// Messages newer than afterID, oldest first
+func (s *MessageService) historyNewer(conversationID, afterID, limit int) ([]models.Message, error) {
+	return s.queryMessages(`
+		SELECT `+messageColumns+` FROM messages
+		WHERE conversation_id = $1 AND id > $2
+		ORDER BY id
+		LIMIT $3`,
+		conversationID, afterID, limit)
+}
+
+type MessageSearchOptions struct {
+	Query          string
+	FriendID       int        // Direct conversation with the friend, or messages the friend sent in groups
+	ConversationID int
+	From           *time.Time // Inclusive
+	To             *time.Time // Exclusive
+	MessageType    string
+	Context        int // Surrounding messages returned on each side of a hit
+	Limit          int
+	Offset         int
+}
+
+var likeEscaper = strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`)
+
+// Search the messages of every conversation the user belongs to, newest first
+func (s *MessageService) SearchMessages(userID int, opts MessageSearchOptions) ([]models.MessageSearchHit, error) {
+	friendKey := ""
+	if opts.FriendID != 0 {
+		friendKey = directKey(userID, opts.FriendID)
+	}
+
+	// Full-text match on words, substring match catches links and partial words. The
+	// content is HTML-escaped before highlighting so the only markup in the fragment is <mark>.
 	rows, err := s.db.Query(`
-		SELECT id, sender_id, receiver_id, content, message_type, created_at
-		FROM messages 
-		WHERE (sender_id = $1 AND receiver_id = $2) 
-		   OR (sender_id = $2 AND receiver_id = $1)
-		ORDER BY created_at DESC
-		LIMIT $3 OFFSET $4`,
-		userID, friendID, limit, offset)
+		WITH hits AS (
+			SELECT m.id AS hit_id, c.type AS hit_type, COALESCE(c.name, '') AS hit_name,
+			       (SELECT pm.user_id FROM conversation_members pm
+			        WHERE c.type = 'direct' AND pm.conversation_id = c.id AND pm.user_id != $1 LIMIT 1) AS hit_friend_id
+			FROM messages m
+			JOIN conversation_members cm ON cm.conversation_id = m.conversation_id AND cm.user_id = $1
+			JOIN conversations c ON c.id = m.conversation_id
+			LEFT JOIN files f ON f.id = m.file_id
+			WHERE (m.search_vector @@ websearch_to_tsquery('simple', $2)
+			       OR m.content ILIKE $3 OR f.original_name ILIKE $3)
+			AND ($4 = 0 OR m.sender_id = $4 OR c.direct_key = $5)
+			AND ($6 = 0 OR m.conversation_id = $6)
+			AND ($7::timestamp IS NULL OR m.created_at >= $7)
+			AND ($8::timestamp IS NULL OR m.created_at < $8)
+			AND ($9 = '' OR m.message_type = $9)
+			ORDER BY m.id DESC
+			LIMIT $10 OFFSET $11
+		)
+		SELECT `+messageColumns+`, hit_type, hit_name, hit_friend_id,
+		       ts_headline('simple', replace(replace(replace(content, '&', '&amp;'), '<', '&lt;'), '>', '&gt;'),
+		                   websearch_to_tsquery('simple', $2),
+		                   'StartSel=<mark>, StopSel=</mark>, MaxWords=20, MinWords=5')
+		FROM messages
+		JOIN hits ON hits.hit_id = messages.id
+		ORDER BY messages.id DESC`,
+		userID, opts.Query, "%"+likeEscaper.Replace(opts.Query)+"%",
+		opts.FriendID, friendKey, opts.ConversationID, opts.From, opts.To, opts.MessageType,
+		opts.Limit, opts.Offset)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var hits []models.MessageSearchHit
+	for rows.Next() {
+		var hit models.MessageSearchHit
+		m := &hit.Message
+		err := rows.Scan(&m.ID, &m.ConversationID, &m.SenderID, &m.ReceiverID,
+			&m.Content, &m.MessageType, &m.FileID, &m.ReplyToID, &m.EditedAt, &m.ReadAt, &m.CreatedAt,
+			&hit.ConversationType, &hit.ConversationName, &hit.FriendID,
+			&hit.Highlight)
+		if err != nil {
+			return nil, err
+		}
+		hits = append(hits, hit)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	for i := range hits {
+		if err := s.loadSearchContext(&hits[i], opts.Context); err != nil {
+			return nil, err
+		}
+	}
+
+	return hits, nil
+}
+
+// Load the messages right before and after a search hit, and every file card involved
+func (s *MessageService) loadSearchContext(hit *models.MessageSearchHit, size int) error {
+	messages := []models.Message{hit.Message}
+
+	if size > 0 {
+		var err error
+		hit.Before, err = s.queryMessages(`
+			SELECT `+messageColumns+` FROM (
+				SELECT `+messageColumns+` FROM messages
+				WHERE conversation_id = $1 AND id < $2
+				ORDER BY id DESC
+				LIMIT $3
+			) earlier ORDER BY id`,
+			hit.Message.ConversationID, hit.Message.ID, size)
+		if err != nil {
+			return err
+		}
+		hit.After, err = s.queryMessages(`
+			SELECT `+messageColumns+` FROM messages
+			WHERE conversation_id = $1 AND id > $2
+			ORDER BY id
+			LIMIT $3`,
+			hit.Message.ConversationID, hit.Message.ID, size)
+		if err != nil {
+			return err
+		}
+	}
+
+	messages = append(messages, hit.Before...)
+	messages = append(messages, hit.After...)
+	if err := s.loadDetails(messages); err != nil {
+		return err
+	}
+
+	hit.Message = messages[0]
+	copy(hit.Before, messages[1:1+len(hit.Before)])
+	copy(hit.After, messages[1+len(hit.Before):])
+
+	return nil
+}
+
+func (s *MessageService) queryMessages(query string, args ...interface{}) ([]models.Message, error) {
+	rows, err := s.db.Query(query, args...)
 	if err != nil {
 		return nil, err
 	}
 	defer rows.Close()
-	
+
 	var messages []models.Message
 	for rows.Next() {
-		var message models.Message
-		err := rows.Scan(&message.ID, &message.SenderID, &message.ReceiverID,
-			&message.Content, &message.MessageType, &message.CreatedAt)
+		message, err := scanMessage(rows)
 		if err != nil {
 			return nil, err
 		}
-		messages = append(messages, message)
+		messages = append(messages, *message)
 	}
-	
-	return messages, nil
+
+	return messages, rows.Err()
 }
 
-// Get user's all chat list
+// Get user's all chat list, direct and group conversations
 func (s *MessageService) GetChatList(userID int) ([]map[string]interface{}, error) {
 	rows, err := s.db.Query(`
-		WITH latest_messages AS (
-			SELECT 
-				CASE 
-					WHEN sender_id = $1 THEN receiver_id 
-					ELSE sender_id 
-				END as friend_id,
-				content,
-				created_at,
-				ROW_NUMBER() OVER (
-					PARTITION BY CASE 
-						WHEN sender_id = $1 THEN receiver_id 
-						ELSE sender_id 
-					END 
-					ORDER BY created_at DESC
-				) as rn
-			FROM messages 
-			WHERE sender_id = $1 OR receiver_id = $1
-		)
-		SELECT 
-			u.id, u.username, u.avatar,
-			lm.content, lm.created_at,
-			COALESCE(unread.count, 0) as unread_count
-		FROM latest_messages lm
-		JOIN users u ON u.id = lm.friend_id
-		LEFT JOIN (
-			SELECT sender_id, COUNT(*) as count
-			FROM messages 
-			WHERE receiver_id = $1
-			GROUP BY sender_id
-		) unread ON unread.sender_id = u.id
-		WHERE lm.rn = 1
-		ORDER BY lm.created_at DESC`,
+		SELECT
+			c.id, c.type, COALESCE(c.name, ''), c.created_at,
+			lm.id, lm.sender_id, lm.content, lm.message_type, lm.reply_to_id, lm.edited_at, lm.read_at, lm.created_at,
+			lr.message_id, lr.user_id, lr.emoji, lr.created_at,
+			(
+				SELECT COUNT(*) FROM messages m
+				WHERE m.conversation_id = c.id AND m.id > cm.last_read_message_id
+				AND m.sender_id != $1
+			) as unread_count,
+			peer.id, peer.username, peer.avatar
+		FROM conversation_members cm
+		JOIN conversations c ON c.id = cm.conversation_id
+		LEFT JOIN LATERAL (
+			SELECT id, sender_id, content, message_type, reply_to_id, edited_at, read_at, created_at
+			FROM messages
+			WHERE conversation_id = c.id
+			ORDER BY id DESC
+			LIMIT 1
+		) lm ON TRUE
+		LEFT JOIN LATERAL (
+			SELECT r.message_id, r.user_id, r.emoji, r.created_at
+			FROM message_reactions r
+			JOIN messages rm ON rm.id = r.message_id
+			WHERE rm.conversation_id = c.id
+			ORDER BY r.created_at DESC
+			LIMIT 1
+		) lr ON TRUE
+		LEFT JOIN LATERAL (
+			SELECT u.id, u.username, COALESCE(u.avatar, '') as avatar
+			FROM conversation_members pm
+			JOIN users u ON u.id = pm.user_id
+			WHERE c.type = 'direct' AND pm.conversation_id = c.id AND pm.user_id != $1
+			LIMIT 1
+		) peer ON TRUE
+		WHERE cm.user_id = $1 AND (c.type = 'group' OR lm.id IS NOT NULL)
+		ORDER BY COALESCE(lm.created_at, c.created_at) DESC`,
 		userID)
 	if err != nil {
 		return nil, err
 	}
 	defer rows.Close()
-	
+
 	var chatList []map[string]interface{}
 	for rows.Next() {
-		var friendID int
-		var username, avatar, content string
-		var createdAt string
-		var unreadCount int
-		
-		err := rows.Scan(&friendID, &username, &avatar, &content, &createdAt, &unreadCount)
+		var conversationID, unreadCount int
+		var conversationType, name string
+		var createdAt time.Time
+		var lastMessageID, lastSenderID, lastReplyToID, friendID *int
+		var content, messageType, username, avatar *string
+		var lastEditedAt, lastReadAt, lastTime *time.Time
+		var reactionMessageID, reactionUserID *int
+		var reactionEmoji *string
+		var reactionTime *time.Time
+
+		err := rows.Scan(&conversationID, &conversationType, &name, &createdAt,
+			&lastMessageID, &lastSenderID, &content, &messageType, &lastReplyToID, &lastEditedAt, &lastReadAt, &lastTime,
+			&reactionMessageID, &reactionUserID, &reactionEmoji, &reactionTime,
+			&unreadCount, &friendID, &username, &avatar)
 		if err != nil {
 			return nil, err
 		}
-		
+
 		chat := map[string]interface{}{
-			"friend_id":     friendID,
-			"username":      username,
-			"avatar":        avatar,
-			"last_message":  content,
-			"last_time":     createdAt,
-			"unread_count":  unreadCount,
+			"conversation_id":   conversationID,
+			"type":              conversationType,
+			"name":              name,
+			"last_message_id":   lastMessageID,
+			"last_sender_id":    lastSenderID,
+			"last_message":      content,
+			"last_message_type": messageType,
+			"last_reply_to_id":  lastReplyToID,
+			"last_edited_at":    lastEditedAt,
+			"last_read_at":      lastReadAt,
+			"last_time":         lastTime,
+			"unread_count":      unreadCount,
+		}
+		if reactionMessageID != nil {
+			chat["last_reaction"] = map[string]interface{}{
+				"message_id": *reactionMessageID,
+				"user_id":    *reactionUserID,
+				"emoji":      *reactionEmoji,
+				"created_at": *reactionTime,
+			}
+		}
+		if friendID != nil {
+			chat["friend_id"] = *friendID
+			chat["username"] = *username
+			chat["avatar"] = *avatar
 		}
 		chatList = append(chatList, chat)
 	}
-	
+
 	return chatList, nil
 }
 
-// Mark messages as read
-func (s *MessageService) MarkMessagesAsRead(userID, fromUserID int) error {
-	_, err := s.db.Exec(`
-		UPDATE messages 
-		SET message_type = 'text' 
-		WHERE receiver_id = $1 AND sender_id = $2`,
-		userID, fromUserID)
-	
-	return err
+// Mark a conversation as read up to and including upToMessageID (0 marks all).
+// Returns the number of messages newly marked as read.
+func (s *MessageService) MarkConversationAsRead(conversationID, userID, upToMessageID int) (int, error) {
+	var lastRead, latest int
+	err := s.db.QueryRow(`
+		SELECT cm.last_read_message_id,
+		       COALESCE((SELECT MAX(id) FROM messages WHERE conversation_id = $1), 0)
+		FROM conversation_members cm
+		WHERE cm.conversation_id = $1 AND cm.user_id = $2`,
+		conversationID, userID).Scan(&lastRead, &latest)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return 0, errors.New("conversation not found")
+		}
+		return 0, err
+	}
+
+	target := latest
+	if upToMessageID > 0 && upToMessageID < latest {
+		target = upToMessageID
+	}
+	if target <= lastRead {
+		return 0, nil
+	}
+
+	var count int
+	err = s.db.QueryRow(`
+		SELECT COUNT(*) FROM messages
+		WHERE conversation_id = $1 AND id > $2 AND id <= $3 AND sender_id != $4`,
+		conversationID, lastRead, target, userID).Scan(&count)
+	if err != nil {
+		return 0, err
+	}
+
+	_, err = s.db.Exec(`
+		UPDATE conversation_members SET last_read_message_id = $3
+		WHERE conversation_id = $1 AND user_id = $2 AND last_read_message_id < $3`,
+		conversationID, userID, target)
+	if err != nil {
+		return 0, err
+	}
+
+	// Per-message receipts for direct messages
+	var readAt time.Time
+	err = s.db.QueryRow(`
+		WITH marked AS (
+			UPDATE messages SET read_at = CURRENT_TIMESTAMP
+			WHERE conversation_id = $1 AND receiver_id = $2 AND read_at IS NULL AND id <= $3
+			RETURNING read_at
+		)
+		SELECT COALESCE(MAX(read_at), CURRENT_TIMESTAMP) FROM marked`,
+		conversationID, userID, target).Scan(&readAt)
+	if err != nil {
+		return 0, err
+	}
+
+	// The conversation's message notification is settled too
+	if err := s.notificationService.MarkReadFor(userID, "message", "conversation_id", conversationID); err != nil {
+		log.Printf("Failed to mark message notifications as read: %v", err)
+	}
+
+	// Read receipt for the other members, and sync the reader's other devices
+	s.publishToConversation(conversationID, "message.read", map[string]interface{}{
+		"conversation_id":      conversationID,
+		"reader_id":            userID,
+		"last_read_message_id": target,
+		"read_at":              readAt,
+	})
+
+	return count, nil
+}
+
+// Mark messages from a friend as read, up to and including upToMessageID (0 marks all)
+func (s *MessageService) MarkMessagesAsRead(userID, fromUserID, upToMessageID int) (int, error) {
+	conversationID, err := s.findDirectConversation(userID, fromUserID)
+	if err != nil || conversationID == 0 {
+		return 0, err
+	}
+
+	return s.MarkConversationAsRead(conversationID, userID, upToMessageID)
+}
+
+// Mark the conversation containing a message as read up to that message
+func (s *MessageService) MarkAsReadUpTo(userID, messageID int) (int, error) {
+	var conversationID int
+	err := s.db.QueryRow(`
+		SELECT m.conversation_id FROM messages m
+		JOIN conversation_members cm ON cm.conversation_id = m.conversation_id
+		WHERE m.id = $1 AND cm.user_id = $2`,
+		messageID, userID).Scan(&conversationID)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return 0, errors.New("message not found")
+		}
+		return 0, err
+	}
+
+	return s.MarkConversationAsRead(conversationID, userID, messageID)
 }
 
 // Get unread message count
 func (s *MessageService) GetUnreadCount(userID int) (int, error) {
 	var count int
 	err := s.db.QueryRow(`
-		SELECT COUNT(*) FROM messages 
-		WHERE receiver_id = $1`,
+		SELECT COUNT(*) FROM messages m
+		JOIN conversation_members cm ON cm.conversation_id = m.conversation_id
+		WHERE cm.user_id = $1 AND m.id > cm.last_read_message_id AND m.sender_id != $1`,
 		userID).Scan(&count)
-	
+
 	return count, err
 }
 
-// Delete message
-func (s *MessageService) DeleteMessage(messageID, userID int) error {
-	// Only allow sender to delete message
-	result, err := s.db.Exec(`
-		DELETE FROM messages 
-		WHERE id = $1 AND sender_id = $2`,
-		messageID, userID)
+// Get unread message count per conversation, and per friend for direct conversations
+func (s *MessageService) GetUnreadCountsByConversation(userID int) (map[int]int, map[int]int, error) {
+	rows, err := s.db.Query(`
+		SELECT cm.conversation_id,
+		       (SELECT user_id FROM conversation_members pm
+		        WHERE c.type = 'direct' AND pm.conversation_id = c.id AND pm.user_id != $1 LIMIT 1),
+		       COUNT(*)
+		FROM messages m
+		JOIN conversation_members cm ON cm.conversation_id = m.conversation_id
+		JOIN conversations c ON c.id = cm.conversation_id
+		WHERE cm.user_id = $1 AND m.id > cm.last_read_message_id AND m.sender_id != $1
+		GROUP BY cm.conversation_id, c.id`,
+		userID)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer rows.Close()
+
+	byConversation := make(map[int]int)
+	byFriend := make(map[int]int)
+	for rows.Next() {
+		var conversationID, count int
+		var friendID *int
+		if err := rows.Scan(&conversationID, &friendID, &count); err != nil {
+			return nil, nil, err
+		}
+		byConversation[conversationID] = count
+		if friendID != nil {
+			byFriend[*friendID] = count
+		}
+	}
+
+	return byConversation, byFriend, nil
+}
+
+// Delete message, optionally revoking the file shares it created
+func (s *MessageService) DeleteMessage(messageID, userID int, revokeShares bool) error {
+	tx, err := s.db.Begin()
 	if err != nil {
 		return err
 	}
-	
-	rowsAffected, err := result.RowsAffected()
+	defer tx.Rollback()
+
+	// Only allow sender to delete message
+	var conversationID int
+	err = tx.QueryRow(`
+		SELECT conversation_id FROM messages
+		WHERE id = $1 AND sender_id = $2
+		FOR UPDATE`,
+		messageID, userID).Scan(&conversationID)
 	if err != nil {
+		if err == sql.ErrNoRows {
+			return errors.New("message not found or permission denied")
+		}
+		return err
+	}
+
+	if revokeShares {
+		_, err = tx.Exec("DELETE FROM file_shares WHERE message_id = $1 AND created_by = $2", messageID, userID)
+		if err != nil {
+			return err
+		}
+	}
+
+	if _, err := tx.Exec("DELETE FROM messages WHERE id = $1", messageID); err != nil {
 		return err
 	}
-	if rowsAffected == 0 {
-		return errors.New("message not found or permission denied")
+
+	if err := tx.Commit(); err != nil {
+		return err
 	}
-	
+
+	s.publishToConversation(conversationID, "message.deleted", map[string]interface{}{
+		"id":              messageID,
+		"conversation_id": conversationID,
+		"sender_id":       userID,
+		"shares_revoked":  revokeShares,
+	})
+
 	return nil
-}
\ No newline at end of file
+}
+
+// Get a message from a conversation the user belongs to
+func (s *MessageService) getMemberMessage(messageID, userID int) (*models.Message, error) {
+	row := s.db.QueryRow(`
+		SELECT `+messageColumns+` FROM messages
+		WHERE id = $1 AND conversation_id IN (
+			SELECT conversation_id FROM conversation_members WHERE user_id = $2
+		)`,
+		messageID, userID)
+	message, err := scanMessage(row)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, errors.New("message not found")
+		}
+		return nil, err
+	}
+	return message, nil
+}
+
+// Edit own message, keeping the previous content in the edit history. Like sending, this
+// needs current membership: users who left or were removed cannot edit what they wrote.
+func (s *MessageService) EditMessage(messageID, userID int, content string) (*models.Message, error) {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	var oldContent, messageType string
+	err = tx.QueryRow(`
+		SELECT content, message_type FROM messages
+		WHERE id = $1 AND sender_id = $2 AND conversation_id IN (
+			SELECT conversation_id FROM conversation_members WHERE user_id = $2
+		)
+		FOR UPDATE`,
+		messageID, userID).Scan(&oldContent, &messageType)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, errors.New("message not found or permission denied")
+		}
+		return nil, err
+	}
+	if messageType == "system" {
+		return nil, errors.New("system messages cannot be edited")
+	}
+	if oldContent == content {
+		return nil, errors.New("content is unchanged")
+	}
+
+	_, err = tx.Exec("INSERT INTO message_edits (message_id, content) VALUES ($1, $2)", messageID, oldContent)
+	if err != nil {
+		return nil, err
+	}
+
+	row := tx.QueryRow(`
+		UPDATE messages SET content = $2, edited_at = CURRENT_TIMESTAMP
+		WHERE id = $1
+		RETURNING `+messageColumns,
+		messageID, content)
+	message, err := scanMessage(row)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, err
+	}
+
+	messages := []models.Message{*message}
+	if err := s.loadDetails(messages); err != nil {
+		return nil, err
+	}
+	message = &messages[0]
+
+	s.publishToConversation(message.ConversationID, "message.updated", message)
+
+	return message, nil
+}
+
+// Get the edit history of a message, oldest first
+func (s *MessageService) GetMessageEdits(conversationID, messageID, userID int) ([]models.MessageEdit, error) {
+	message, err := s.getMemberMessage(messageID, userID)
+	if err != nil {
+		return nil, err
+	}
+	if message.ConversationID != conversationID {
+		return nil, errors.New("message not found")
+	}
+
+	rows, err := s.db.Query(`
+		SELECT id, message_id, content, edited_at
+		FROM message_edits
+		WHERE message_id = $1
+		ORDER BY id`,
+		messageID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var edits []models.MessageEdit
+	for rows.Next() {
+		var edit models.MessageEdit
+		if err := rows.Scan(&edit.ID, &edit.MessageID, &edit.Content, &edit.EditedAt); err != nil {
+			return nil, err
+		}
+		edits = append(edits, edit)
+	}
+
+	return edits, rows.Err()
+}
+
+// Get the replies to a message, oldest first
+func (s *MessageService) GetReplies(conversationID, messageID, userID int) ([]models.Message, error) {
+	message, err := s.getMemberMessage(messageID, userID)
+	if err != nil {
+		return nil, err
+	}
+	if message.ConversationID != conversationID {
+		return nil, errors.New("message not found")
+	}
+
+	messages, err := s.queryMessages(`
+		SELECT `+messageColumns+` FROM messages
+		WHERE reply_to_id = $1
+		ORDER BY id
+		LIMIT 200`,
+		messageID)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := s.loadDetails(messages); err != nil {
+		return nil, err
+	}
+
+	return messages, nil
+}
+
+// Add the user's reaction to a message, or remove it when already present.
+// Returns whether the reaction was added.
+func (s *MessageService) ToggleReaction(messageID, userID int, emoji string) (bool, error) {
+	message, err := s.getMemberMessage(messageID, userID)
+	if err != nil {
+		return false, err
+	}
+
+	result, err := s.db.Exec(`
+		DELETE FROM message_reactions
+		WHERE message_id = $1 AND user_id = $2 AND emoji = $3`,
+		messageID, userID, emoji)
+	if err != nil {
+		return false, err
+	}
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return false, err
+	}
+
+	added := rowsAffected == 0
+	if added {
+		_, err = s.db.Exec(`
+			INSERT INTO message_reactions (message_id, user_id, emoji)
+			VALUES ($1, $2, $3)
+			ON CONFLICT (message_id, user_id, emoji) DO NOTHING`,
+			messageID, userID, emoji)
+		if err != nil {
+			return false, err
+		}
+	}
+
+	s.publishToConversation(message.ConversationID, "message.reaction", map[string]interface{}{
+		"message_id":      messageID,
+		"conversation_id": message.ConversationID,
+		"user_id":         userID,
+		"emoji":           emoji,
+		"added":           added,
+	})
+
+	return added, nil
+}
@@ -0,0 +1,300 @@
+package services
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"ai-doc-system/internal/models"
+	"ai-doc-system/internal/realtime"
+)
+
+// Notification types users can switch off in their preferences
+var NotificationTypes = []string{
+	"friend_request",
+	"friend_accepted",
+	"file_shared",
+	"message",
+	"share_expiring",
+	"file_request_upload",
+	"file_transfer_offer",
+	"file_transfer_accepted",
+	"file_transfer_declined",
+}
+
+func isNotificationType(notificationType string) bool {
+	for _, t := range NotificationTypes {
+		if t == notificationType {
+			return true
+		}
+	}
+	return false
+}
+
+type NotificationService struct {
+	db           *sql.DB
+	hub          *realtime.Hub
+	emailService *EmailService
+}
+
+func NewNotificationService(db *sql.DB, hub *realtime.Hub, emailService *EmailService) *NotificationService {
+	return &NotificationService{db: db, hub: hub, emailService: emailService}
+}
+
+const notificationColumns = `id, user_id, type, title, COALESCE(content, ''), data, is_read, created_at`
+
+func scanNotification(row rowScanner) (*models.Notification, error) {
+	var notification models.Notification
+	var data []byte
+	err := row.Scan(&notification.ID, &notification.UserID, &notification.Type,
+		&notification.Title, &notification.Content, &data, &notification.IsRead, &notification.CreatedAt)
+	if err != nil {
+		return nil, err
+	}
+	if data != nil {
+		notification.Data = json.RawMessage(data)
+	}
+	return &notification, nil
+}
+
+func (s *NotificationService) publish(userID int, eventType string, data interface{}) {
+	if s.hub == nil {
+		return
+	}
+	if err := s.hub.Publish([]int{userID}, eventType, data); err != nil {
+		log.Printf("Failed to publish %s event: %v", eventType, err)
+	}
+}
+
+// Create notification for user unless they switched the type off, push it to their open streams
+// and queue the email for it.
+// data holds related object IDs and may be nil.
+func (s *NotificationService) Notify(userID int, notificationType, title, content string, data map[string]interface{}) error {
+	var enabled bool
+	err := s.db.QueryRow(`
+		SELECT COALESCE((
+			SELECT enabled FROM notification_preferences WHERE user_id = $1 AND type = $2
+		), TRUE)`,
+		userID, notificationType).Scan(&enabled)
+	if err != nil {
+		return err
+	}
+	if !enabled {
+		return nil
+	}
+
+	// Passed as text, lib/pq would send []byte as bytea
+	var encoded sql.NullString
+	if data != nil {
+		raw, err := json.Marshal(data)
+		if err != nil {
+			return err
+		}
+		encoded = sql.NullString{String: string(raw), Valid: true}
+	}
+
+	row := s.db.QueryRow(`
+		INSERT INTO notifications (user_id, type, title, content, data)
+		VALUES ($1, $2, $3, $4, $5)
+		RETURNING `+notificationColumns,
+		userID, notificationType, title, content, encoded)
+	notification, err := scanNotification(row)
+	if err != nil {
+		return err
+	}
+
+	s.publish(userID, "notification.new", notification)
+
+	if s.emailService != nil {
+		if err := s.emailService.QueueNotification(notification); err != nil {
+			log.Printf("Failed to queue email for notification %d: %v", notification.ID, err)
+		}
+	}
+
+	return nil
+}
+
+// Check for an unread notification of a type about the same object, e.g. the same conversation
+func (s *NotificationService) HasUnread(userID int, notificationType, dataKey string, dataValue int) (bool, error) {
+	var count int
+	err := s.db.QueryRow(`
+		SELECT COUNT(*) FROM notifications
+		WHERE user_id = $1 AND type = $2 AND is_read = FALSE AND data->>$3 = $4`,
+		userID, notificationType, dataKey, fmt.Sprint(dataValue)).Scan(&count)
+	return count > 0, err
+}
+
+// Mark the unread notifications of a type about the same object as read
+func (s *NotificationService) MarkReadFor(userID int, notificationType, dataKey string, dataValue int) error {
+	_, err := s.db.Exec(`
+		UPDATE notifications SET is_read = TRUE
+		WHERE user_id = $1 AND type = $2 AND is_read = FALSE AND data->>$3 = $4`,
+		userID, notificationType, dataKey, fmt.Sprint(dataValue))
+	return err
+}
+
+// Get user's notifications newest first, beforeID pages to older ones (0 for the latest)
+func (s *NotificationService) GetNotifications(userID int, unreadOnly bool, beforeID, limit int) ([]models.Notification, error) {
+	rows, err := s.db.Query(`
+		SELECT `+notificationColumns+`
+		FROM notifications
+		WHERE user_id = $1 AND ($2 = FALSE OR is_read = FALSE) AND ($3 = 0 OR id < $3)
+		ORDER BY id DESC
+		LIMIT $4`,
+		userID, unreadOnly, beforeID, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	notifications := []models.Notification{}
+	for rows.Next() {
+		notification, err := scanNotification(rows)
+		if err != nil {
+			return nil, err
+		}
+		notifications = append(notifications, *notification)
+	}
+
+	return notifications, rows.Err()
+}
+
+// Get unread notification count
+func (s *NotificationService) GetUnreadCount(userID int) (int, error) {
+	var count int
+	err := s.db.QueryRow("SELECT COUNT(*) FROM notifications WHERE user_id = $1 AND is_read = FALSE", userID).Scan(&count)
+	return count, err
+}
+
+// Mark notification as read
+func (s *NotificationService) MarkAsRead(notificationID, userID int) error {
+	result, err := s.db.Exec(`
+		UPDATE notifications SET is_read = TRUE
+		WHERE id = $1 AND user_id = $2`,
+		notificationID, userID)
+	if err != nil {
+		return err
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if rowsAffected == 0 {
+		return errors.New("notification not found")
+	}
+
+	s.publish(userID, "notification.read", map[string]interface{}{"id": notificationID})
+
+	return nil
+}
+
+// Mark all of the user's notifications as read
+func (s *NotificationService) MarkAllAsRead(userID int) (int, error) {
+	result, err := s.db.Exec("UPDATE notifications SET is_read = TRUE WHERE user_id = $1 AND is_read = FALSE", userID)
+	if err != nil {
+		return 0, err
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return 0, err
+	}
+
+	s.publish(userID, "notification.read", map[string]interface{}{"all": true})
+
+	return int(rowsAffected), nil
+}
+
+// Delete a notification
+func (s *NotificationService) DeleteNotification(notificationID, userID int) error {
+	result, err := s.db.Exec("DELETE FROM notifications WHERE id = $1 AND user_id = $2", notificationID, userID)
+	if err != nil {
+		return err
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if rowsAffected == 0 {
+		return errors.New("notification not found")
+	}
+
+	return nil
+}
+
+// Clear the user's notifications, only the read ones when readOnly is set
+func (s *NotificationService) ClearNotifications(userID int, readOnly bool) (int, error) {
+	result, err := s.db.Exec(`
+		DELETE FROM notifications
+		WHERE user_id = $1 AND ($2 = FALSE OR is_read = TRUE)`,
+		userID, readOnly)
+	if err != nil {
+		return 0, err
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	return int(rowsAffected), err
+}
+
+// Get the user's preference for every notification type
+func (s *NotificationService) GetPreferences(userID int) ([]models.NotificationPreference, error) {
+	rows, err := s.db.Query("SELECT type, enabled FROM notification_preferences WHERE user_id = $1", userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	stored := make(map[string]bool)
+	for rows.Next() {
+		var preference models.NotificationPreference
+		if err := rows.Scan(&preference.Type, &preference.Enabled); err != nil {
+			return nil, err
+		}
+		stored[preference.Type] = preference.Enabled
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	var preferences []models.NotificationPreference
+	for _, notificationType := range NotificationTypes {
+		enabled, ok := stored[notificationType]
+		preferences = append(preferences, models.NotificationPreference{
+			Type:    notificationType,
+			Enabled: enabled || !ok,
+		})
+	}
+
+	return preferences, nil
+}
+
+// Enable or disable notification types for the user
+func (s *NotificationService) UpdatePreferences(userID int, preferences map[string]bool) error {
+	for notificationType := range preferences {
+		if !isNotificationType(notificationType) {
+			return fmt.Errorf("unknown notification type: %s", notificationType)
+		}
+	}
+
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	for notificationType, enabled := range preferences {
+		_, err := tx.Exec(`
+			INSERT INTO notification_preferences (user_id, type, enabled)
+			VALUES ($1, $2, $3)
+			ON CONFLICT (user_id, type) DO UPDATE SET enabled = EXCLUDED.enabled`,
+			userID, notificationType, enabled)
+		if err != nil {
+			return err
+		}
+	}
+
+	return tx.Commit()
+}
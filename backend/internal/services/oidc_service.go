@@ -0,0 +1,362 @@
+package services
+
+import (
+	"context"
+	"crypto/subtle"
+	"database/sql"
+	"errors"
+	"fmt"
+	"regexp"
+	"strings"
+	"time"
+	"ai-doc-system/internal/models"
+	"ai-doc-system/internal/oidc"
+)
+
+// How long a login at the identity provider may take
+const OIDCStateTTL = 10 * time.Minute
+
+const oidcHandoffTTL = time.Minute
+
+// OIDCOptions control how identity provider logins map to local users
+type OIDCOptions struct {
+	UsernameClaim string
+	GroupsClaim   string
+	RoleRules     []GroupRoleRule // First matching rule wins
+	DefaultRole   string          // Role of provisioned users no rule matches
+	AutoProvision bool            // Create users on their first login
+}
+
+// OIDCResult is where the browser goes after the identity provider redirected back
+type OIDCResult struct {
+	Linked      bool   // An identity was linked to the logged-in user, no login happened
+	HandoffCode string // Single-use code the frontend exchanges for tokens
+}
+
+type OIDCService struct {
+	db       *sql.DB
+	provider *oidc.Provider // Nil when single sign-on is not configured
+	options  OIDCOptions
+}
+
+func NewOIDCService(db *sql.DB, provider *oidc.Provider, options OIDCOptions) *OIDCService {
+	return &OIDCService{db: db, provider: provider, options: options}
+}
+
+func (s *OIDCService) Enabled() bool {
+	return s.provider != nil
+}
+
+// Start a login, or link the identity to linkUserID when it is not 0. Returns the identity
+// provider URL and the browser binding, which the browser starting the login must keep in a
+// cookie and present with the redirect back; anyone else following the URL is refused.
+func (s *OIDCService) StartLogin(ctx context.Context, linkUserID int) (string, string, error) {
+	if !s.Enabled() {
+		return "", "", errors.New("single sign-on is not configured")
+	}
+
+	state, err := generateToken()
+	if err != nil {
+		return "", "", err
+	}
+	nonce, err := generateToken()
+	if err != nil {
+		return "", "", err
+	}
+	verifier, err := oidc.NewCodeVerifier()
+	if err != nil {
+		return "", "", err
+	}
+
+	authURL, err := s.provider.AuthCodeURL(ctx, state, nonce, verifier)
+	if err != nil {
+		return "", "", err
+	}
+
+	_, err = s.db.Exec(`
+		INSERT INTO oidc_login_states (state_hash, nonce, code_verifier, link_user_id, expires_at)
+		VALUES ($1, $2, $3, NULLIF($4, 0), $5)`,
+		hashToken(state), nonce, verifier, linkUserID, time.Now().Add(OIDCStateTTL))
+	if err != nil {
+		return "", "", err
+	}
+
+	// Abandoned logins are cleaned up as new ones start
+	s.db.Exec("DELETE FROM oidc_login_states WHERE expires_at < CURRENT_TIMESTAMP - INTERVAL '1 day'")
+
+	return authURL, hashToken(state), nil
+}
+
+// Handle the redirect back from the identity provider: check it reached the browser that
+// started the login, verify the ID token, then find, link or provision the user
+func (s *OIDCService) CompleteLogin(ctx context.Context, state, binding, code string) (*OIDCResult, error) {
+	if !s.Enabled() {
+		return nil, errors.New("single sign-on is not configured")
+	}
+
+	// Without this a login or link URL started by someone else would log the victim into the
+	// other account, or link the victim's identity to it
+	if binding == "" || subtle.ConstantTimeCompare([]byte(binding), []byte(hashToken(state))) != 1 {
+		return nil, errors.New("login was started in another browser, please try again")
+	}
+
+	// The state is used up right away, replaying the redirect fails
+	var stateID int
+	var nonce, verifier string
+	var linkUserID sql.NullInt64
+	err := s.db.QueryRow(`
+		UPDATE oidc_login_states SET completed_at = CURRENT_TIMESTAMP
+		WHERE state_hash = $1 AND completed_at IS NULL AND expires_at > CURRENT_TIMESTAMP
+		RETURNING id, nonce, code_verifier, link_user_id`,
+		hashToken(state)).Scan(&stateID, &nonce, &verifier, &linkUserID)
+	if err == sql.ErrNoRows {
+		return nil, errors.New("login request expired, please try again")
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	claims, err := s.provider.Exchange(ctx, code, verifier, nonce)
+	if err != nil {
+		return nil, err
+	}
+
+	tx, err := s.db.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	var identityUserID int
+	err = tx.QueryRow(`
+		SELECT user_id FROM user_identities WHERE issuer = $1 AND subject = $2`,
+		s.provider.Issuer(), claims.Subject()).Scan(&identityUserID)
+	if err != nil && err != sql.ErrNoRows {
+		return nil, err
+	}
+
+	if linkUserID.Valid {
+		if identityUserID != 0 && identityUserID != int(linkUserID.Int64) {
+			return nil, errors.New("this identity is already linked to another account")
+		}
+		if identityUserID == 0 {
+			if err := s.insertIdentity(tx, int(linkUserID.Int64), claims); err != nil {
+				return nil, err
+			}
+		}
+		return &OIDCResult{Linked: true}, tx.Commit()
+	}
+
+	userID := identityUserID
+	if userID == 0 {
+		userID, err = s.provisionUser(tx, claims)
+		if err != nil {
+			return nil, err
+		}
+	} else {
+		_, err = tx.Exec(`
+			UPDATE user_identities SET last_login_at = CURRENT_TIMESTAMP, email = NULLIF($1, '')
+			WHERE issuer = $2 AND subject = $3`,
+			claims.VerifiedEmail(), s.provider.Issuer(), claims.Subject())
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	// Mapped groups keep the role in sync with the identity provider, users no rule matches
+	// get the default role so leaving a mapped group demotes them. Without rules roles are
+	// managed locally.
+	role := s.mapRole(claims)
+	if role == "" && len(s.options.RoleRules) > 0 {
+		role = s.options.DefaultRole
+	}
+	if role != "" {
+		_, err = tx.Exec("UPDATE users SET role = $1, updated_at = CURRENT_TIMESTAMP WHERE id = $2 AND role != $1", role, userID)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	handoff, err := generateToken()
+	if err != nil {
+		return nil, err
+	}
+	_, err = tx.Exec("UPDATE oidc_login_states SET user_id = $1, handoff_hash = $2 WHERE id = $3",
+		userID, hashToken(handoff), stateID)
+	if err != nil {
+		return nil, err
+	}
+
+	return &OIDCResult{HandoffCode: handoff}, tx.Commit()
+}
+
+// Redeem the handoff code of a completed login, returns the user to log in
+func (s *OIDCService) ExchangeHandoff(code string) (int, error) {
+	var userID int
+	var disabled bool
+	err := s.db.QueryRow(`
+		UPDATE oidc_login_states o SET used_at = CURRENT_TIMESTAMP
+		FROM users u
+		WHERE u.id = o.user_id AND o.handoff_hash = $1 AND o.used_at IS NULL
+			AND o.completed_at > CURRENT_TIMESTAMP - $2 * INTERVAL '1 second'
+		RETURNING o.user_id, u.disabled_at IS NOT NULL`,
+		hashToken(code), int(oidcHandoffTTL.Seconds())).Scan(&userID, &disabled)
+	if err == sql.ErrNoRows {
+		return 0, errors.New("single sign-on code is invalid or expired")
+	}
+	if err != nil {
+		return 0, err
+	}
+	if disabled {
+		return 0, ErrAccountDisabled
+	}
+	return userID, nil
+}
+
+func (s *OIDCService) insertIdentity(tx *sql.Tx, userID int, claims oidc.Claims) error {
+	_, err := tx.Exec(`
+		INSERT INTO user_identities (user_id, issuer, subject, email, last_login_at)
+		VALUES ($1, $2, $3, NULLIF($4, ''), CURRENT_TIMESTAMP)`,
+		userID, s.provider.Issuer(), claims.Subject(), claims.VerifiedEmail())
+	return err
+}
+
+func (s *OIDCService) mapRole(claims oidc.Claims) string {
+	return mapGroupRole(s.options.RoleRules, claims.Strings(s.options.GroupsClaim))
+}
+
+// Create the user of a first login. Accounts are never matched by email alone, that
+// would let whoever controls the address at the identity provider take over the account.
+func (s *OIDCService) provisionUser(tx *sql.Tx, claims oidc.Claims) (int, error) {
+	email := claims.VerifiedEmail()
+	if email != "" {
+		var count int
+		err := tx.QueryRow("SELECT COUNT(*) FROM users WHERE LOWER(email) = LOWER($1)", email).Scan(&count)
+		if err != nil {
+			return 0, err
+		}
+		if count > 0 {
+			return 0, errors.New("an account with this email already exists, log in with your password and link single sign-on from your profile")
+		}
+	}
+	if !s.options.AutoProvision {
+		return 0, errors.New("no account is linked to this identity")
+	}
+
+	username, err := s.availableUsername(tx, claims)
+	if err != nil {
+		return 0, err
+	}
+
+	role := s.mapRole(claims)
+	if role == "" {
+		role = s.options.DefaultRole
+	}
+
+	// No local password: the empty hash never matches, users can set one with a password reset
+	var userID int
+	err = tx.QueryRow(`
+		INSERT INTO users (username, password_hash, role, email)
+		VALUES ($1, '', $2, NULLIF($3, ''))
+		RETURNING id`,
+		username, role, email).Scan(&userID)
+	if err != nil {
+		return 0, err
+	}
+
+	return userID, s.insertIdentity(tx, userID, claims)
+}
+
+var usernameUnsafe = regexp.MustCompile(`[^A-Za-z0-9_.-]+`)
+
+// Username from the configured claim, falling back to the email's local part,
+// with a number appended while it is taken
+func (s *OIDCService) availableUsername(tx *sql.Tx, claims oidc.Claims) (string, error) {
+	base := claims.String(s.options.UsernameClaim)
+	if base == "" {
+		base = strings.SplitN(claims.String("email"), "@", 2)[0]
+	}
+	base = truncateRunes(usernameUnsafe.ReplaceAllString(base, ""), 16)
+	if len(base) < 3 {
+		base = "user"
+	}
+
+	for i := 0; i < 100; i++ {
+		candidate := base
+		if i > 0 {
+			candidate = fmt.Sprintf("%s%d", base, i+1)
+		}
+		var count int
+		err := tx.QueryRow("SELECT COUNT(*) FROM users WHERE LOWER(username) = LOWER($1)", candidate).Scan(&count)
+		if err != nil {
+			return "", err
+		}
+		if count == 0 {
+			return candidate, nil
+		}
+	}
+	return "", errors.New("could not find a free username")
+}
+
+// Identities linked to the user
+func (s *OIDCService) GetIdentities(userID int) ([]models.UserIdentity, error) {
+	rows, err := s.db.Query(`
+		SELECT id, user_id, issuer, subject, COALESCE(email, ''), created_at, last_login_at
+		FROM user_identities WHERE user_id = $1
+		ORDER BY created_at`,
+		userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	identities := []models.UserIdentity{}
+	for rows.Next() {
+		var identity models.UserIdentity
+		err := rows.Scan(&identity.ID, &identity.UserID, &identity.Issuer, &identity.Subject,
+			&identity.Email, &identity.CreatedAt, &identity.LastLoginAt)
+		if err != nil {
+			return nil, err
+		}
+		identities = append(identities, identity)
+	}
+
+	return identities, rows.Err()
+}
+
+// Unlink an identity; the last one stays while the user has no password to log in with
+func (s *OIDCService) UnlinkIdentity(identityID, userID int) error {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	var hasPassword bool
+	var identities int
+	err = tx.QueryRow(`
+		SELECT u.password_hash != '', (SELECT COUNT(*) FROM user_identities i WHERE i.user_id = u.id)
+		FROM users u WHERE u.id = $1
+		FOR UPDATE OF u`,
+		userID).Scan(&hasPassword, &identities)
+	if err != nil {
+		return err
+	}
+	if !hasPassword && identities <= 1 {
+		return errors.New("set a password before removing your last single sign-on identity")
+	}
+
+	result, err := tx.Exec("DELETE FROM user_identities WHERE id = $1 AND user_id = $2", identityID, userID)
+	if err != nil {
+		return err
+	}
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if rowsAffected == 0 {
+		return errors.New("identity not found")
+	}
+
+	return tx.Commit()
+}
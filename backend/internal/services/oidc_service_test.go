@@ -0,0 +1,361 @@
+package services
+
+import (
+	"context"
+	"database/sql"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"ai-doc-system/internal/oidc"
+	"ai-doc-system/internal/oidc/oidctest"
+)
+
+func TestParseGroupRoleRules(t *testing.T) {
+	// The role follows the last "=", so LDAP style group names work with another separator
+	rules, err := ParseGroupRoleRules(" admins=admin; ;cn=ops,dc=example=support ", ";")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []GroupRoleRule{{Group: "admins", Role: "admin"}, {Group: "cn=ops,dc=example", Role: "support"}}
+	if len(rules) != len(want) || rules[0] != want[0] || rules[1] != want[1] {
+		t.Fatalf("rules = %v, want %v", rules, want)
+	}
+
+	for _, value := range []string{"admins", "=admin", "admins="} {
+		if _, err := ParseGroupRoleRules(value, ","); err == nil {
+			t.Errorf("ParseGroupRoleRules(%q) succeeded, want an error", value)
+		}
+	}
+}
+
+func TestMapGroupRole(t *testing.T) {
+	rules := []GroupRoleRule{{Group: "Admins", Role: "admin"}, {Group: "auditors", Role: "auditor"}}
+
+	tests := []struct {
+		groups []string
+		want   string
+	}{
+		{groups: []string{"auditors", "admins"}, want: "admin"}, // First rule wins, not the first group
+		{groups: []string{"AUDITORS"}, want: "auditor"},
+		{groups: []string{"staff"}, want: ""},
+		{groups: nil, want: ""},
+	}
+	for _, tt := range tests {
+		if got := mapGroupRole(rules, tt.groups); got != tt.want {
+			t.Errorf("mapGroupRole(%v) = %q, want %q", tt.groups, got, tt.want)
+		}
+	}
+}
+
+func newTestOIDCService(t *testing.T, db *sql.DB, options OIDCOptions) (*OIDCService, *oidctest.Issuer) {
+	t.Helper()
+	issuer, err := oidctest.NewIssuer("", "test-client", "test-secret")
+	if err != nil {
+		t.Fatal(err)
+	}
+	server := httptest.NewServer(issuer.Handler())
+	t.Cleanup(server.Close)
+	issuer.URL = server.URL
+
+	provider := oidc.NewProvider(server.URL, "test-client", "test-secret", "http://app.example/api/auth/oidc/callback", []string{"openid"})
+	if options.UsernameClaim == "" {
+		options.UsernameClaim = "preferred_username"
+	}
+	if options.GroupsClaim == "" {
+		options.GroupsClaim = "groups"
+	}
+	if options.DefaultRole == "" {
+		options.DefaultRole = "user"
+	}
+	return NewOIDCService(db, provider, options), issuer
+}
+
+// Logs in at the issuer as login and completes the login, or the link to linkUserID
+func completeTestLogin(t *testing.T, s *OIDCService, issuer *oidctest.Issuer, linkUserID int, login oidctest.Login) (*OIDCResult, error) {
+	t.Helper()
+	authURL, binding, err := s.StartLogin(context.Background(), linkUserID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	callback, err := issuer.LogIn(authURL, login)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return s.CompleteLogin(context.Background(), callback.Query().Get("state"), binding, callback.Query().Get("code"))
+}
+
+func TestCompleteLoginRequiresBrowserBinding(t *testing.T) {
+	provider := oidc.NewProvider("http://issuer.invalid", "test-client", "test-secret", "http://app.example/callback", []string{"openid"})
+	s := NewOIDCService(nil, provider, OIDCOptions{})
+
+	for _, binding := range []string{"", hashToken("other-state")} {
+		_, err := s.CompleteLogin(context.Background(), "state", binding, "code")
+		if err == nil || !strings.Contains(err.Error(), "another browser") {
+			t.Errorf("binding %q: err = %v, want the login refused", binding, err)
+		}
+	}
+}
+
+func TestOIDCLoginProvisionsUser(t *testing.T) {
+	db := openTestDB(t)
+	s, issuer := newTestOIDCService(t, db, OIDCOptions{
+		RoleRules:     []GroupRoleRule{{Group: "auditors", Role: "auditor"}},
+		AutoProvision: true,
+	})
+	suffix := uniqueSuffix()
+	login := oidctest.Login{
+		Subject:       "sub-" + suffix,
+		Username:      "sso" + suffix,
+		Email:         "sso" + suffix + "@example.com",
+		EmailVerified: true,
+		Groups:        []string{"auditors"},
+	}
+
+	result, err := completeTestLogin(t, s, issuer, 0, login)
+	if err != nil {
+		t.Fatal(err)
+	}
+	userID, err := s.ExchangeHandoff(result.HandoffCode)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { db.Exec("DELETE FROM users WHERE id = $1", userID) })
+
+	var username, role, email string
+	err = db.QueryRow("SELECT username, role, email FROM users WHERE id = $1", userID).Scan(&username, &role, &email)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if username != login.Username || role != "auditor" || email != login.Email {
+		t.Errorf("provisioned user = %s/%s/%s, want %s/auditor/%s", username, role, email, login.Username, login.Email)
+	}
+
+	// The handoff code is single use
+	if _, err := s.ExchangeHandoff(result.HandoffCode); err == nil {
+		t.Error("second handoff exchange succeeded")
+	}
+
+	// The next login finds the same user; without a matching group the default role applies
+	login.Groups = nil
+	result, err = completeTestLogin(t, s, issuer, 0, login)
+	if err != nil {
+		t.Fatal(err)
+	}
+	again, err := s.ExchangeHandoff(result.HandoffCode)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if again != userID {
+		t.Errorf("second login resolved to user %d, want %d", again, userID)
+	}
+	db.QueryRow("SELECT role FROM users WHERE id = $1", userID).Scan(&role)
+	if role != "user" {
+		t.Errorf("role after a login without groups = %q, want user", role)
+	}
+}
+
+// loginTestUser logs in at the issuer and returns the user the login resolved to
+func loginTestUser(t *testing.T, db *sql.DB, s *OIDCService, issuer *oidctest.Issuer, login oidctest.Login) int {
+	t.Helper()
+	result, err := completeTestLogin(t, s, issuer, 0, login)
+	if err != nil {
+		t.Fatal(err)
+	}
+	userID, err := s.ExchangeHandoff(result.HandoffCode)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { db.Exec("DELETE FROM users WHERE id = $1", userID) })
+	return userID
+}
+
+func TestOIDCLoginDemotesRemovedGroup(t *testing.T) {
+	db := openTestDB(t)
+	s, issuer := newTestOIDCService(t, db, OIDCOptions{
+		RoleRules:     []GroupRoleRule{{Group: "idp-admins", Role: "admin"}},
+		AutoProvision: true,
+	})
+	suffix := uniqueSuffix()
+	login := oidctest.Login{Subject: "sub-" + suffix, Username: "ssoadmin" + suffix, Groups: []string{"idp-admins"}}
+
+	userID := loginTestUser(t, db, s, issuer, login)
+	var role string
+	db.QueryRow("SELECT role FROM users WHERE id = $1", userID).Scan(&role)
+	if role != "admin" {
+		t.Fatalf("role = %q, want admin", role)
+	}
+
+	// Taken out of the admin group at the identity provider
+	login.Groups = []string{"staff"}
+	loginTestUser(t, db, s, issuer, login)
+	db.QueryRow("SELECT role FROM users WHERE id = $1", userID).Scan(&role)
+	if role != "user" {
+		t.Errorf("role after leaving the admin group = %q, want user", role)
+	}
+}
+
+func TestOIDCLoginWithoutRoleRulesKeepsRole(t *testing.T) {
+	db := openTestDB(t)
+	s, issuer := newTestOIDCService(t, db, OIDCOptions{AutoProvision: true})
+	suffix := uniqueSuffix()
+	login := oidctest.Login{Subject: "sub-" + suffix, Username: "ssolocal" + suffix}
+
+	// Without rules, roles assigned in the application are left alone
+	userID := loginTestUser(t, db, s, issuer, login)
+	if _, err := db.Exec("UPDATE users SET role = 'auditor' WHERE id = $1", userID); err != nil {
+		t.Fatal(err)
+	}
+	loginTestUser(t, db, s, issuer, login)
+	var role string
+	db.QueryRow("SELECT role FROM users WHERE id = $1", userID).Scan(&role)
+	if role != "auditor" {
+		t.Errorf("role = %q, want auditor", role)
+	}
+}
+
+func TestOIDCLoginPicksFreeUsername(t *testing.T) {
+	db := openTestDB(t)
+	s, issuer := newTestOIDCService(t, db, OIDCOptions{AutoProvision: true})
+	suffix := uniqueSuffix()
+	taken := "sso" + suffix
+	createTestUser(t, db, taken, "")
+
+	result, err := completeTestLogin(t, s, issuer, 0, oidctest.Login{Subject: "sub-" + suffix, Username: taken})
+	if err != nil {
+		t.Fatal(err)
+	}
+	userID, err := s.ExchangeHandoff(result.HandoffCode)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { db.Exec("DELETE FROM users WHERE id = $1", userID) })
+
+	var username, role string
+	db.QueryRow("SELECT username, role FROM users WHERE id = $1", userID).Scan(&username, &role)
+	if username != taken+"2" {
+		t.Errorf("username = %q, want %q", username, taken+"2")
+	}
+	if role != "user" {
+		t.Errorf("role = %q, want the default role", role)
+	}
+}
+
+func TestOIDCLoginDoesNotMatchAccountsByEmail(t *testing.T) {
+	db := openTestDB(t)
+	s, issuer := newTestOIDCService(t, db, OIDCOptions{AutoProvision: true})
+	suffix := uniqueSuffix()
+	email := "local" + suffix + "@example.com"
+	createTestUser(t, db, "local"+suffix, email)
+
+	_, err := completeTestLogin(t, s, issuer, 0, oidctest.Login{Subject: "sub-" + suffix, Email: strings.ToUpper(email), EmailVerified: true})
+	if err == nil || !strings.Contains(err.Error(), "already exists") {
+		t.Fatalf("err = %v, want the login refused", err)
+	}
+
+	var identities int
+	db.QueryRow("SELECT COUNT(*) FROM user_identities WHERE subject = $1", "sub-"+suffix).Scan(&identities)
+	if identities != 0 {
+		t.Errorf("%d identities linked, want none", identities)
+	}
+}
+
+func TestOIDCLoginWithoutAutoProvision(t *testing.T) {
+	db := openTestDB(t)
+	s, issuer := newTestOIDCService(t, db, OIDCOptions{AutoProvision: false})
+	suffix := uniqueSuffix()
+
+	_, err := completeTestLogin(t, s, issuer, 0, oidctest.Login{Subject: "sub-" + suffix, Username: "sso" + suffix})
+	if err == nil || !strings.Contains(err.Error(), "no account is linked") {
+		t.Fatalf("err = %v, want the login refused", err)
+	}
+
+	var count int
+	db.QueryRow("SELECT COUNT(*) FROM users WHERE username = $1", "sso"+suffix).Scan(&count)
+	if count != 0 {
+		t.Error("user was provisioned with auto provisioning off")
+	}
+}
+
+func TestOIDCLinkConflicts(t *testing.T) {
+	db := openTestDB(t)
+	s, issuer := newTestOIDCService(t, db, OIDCOptions{
+		RoleRules:     []GroupRoleRule{{Group: "admins", Role: "admin"}},
+		AutoProvision: true,
+	})
+	suffix := uniqueSuffix()
+	owner := createTestUser(t, db, "owner"+suffix, "")
+	other := createTestUser(t, db, "other"+suffix, "")
+	login := oidctest.Login{Subject: "sub-" + suffix, Groups: []string{"admins"}}
+
+	result, err := completeTestLogin(t, s, issuer, owner, login)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !result.Linked || result.HandoffCode != "" {
+		t.Fatalf("result = %+v, want a link without a login", result)
+	}
+
+	// Linking does not apply the role mapping, only logins do
+	var role string
+	db.QueryRow("SELECT role FROM users WHERE id = $1", owner).Scan(&role)
+	if role != "user" {
+		t.Errorf("role after linking = %q, want user", role)
+	}
+
+	_, err = completeTestLogin(t, s, issuer, other, login)
+	if err == nil || !strings.Contains(err.Error(), "already linked to another account") {
+		t.Fatalf("linking to a second account: err = %v, want a conflict", err)
+	}
+
+	// Linking again to the same account is a no-op
+	if _, err := completeTestLogin(t, s, issuer, owner, login); err != nil {
+		t.Fatalf("linking again: %v", err)
+	}
+
+	result, err = completeTestLogin(t, s, issuer, 0, login)
+	if err != nil {
+		t.Fatal(err)
+	}
+	userID, err := s.ExchangeHandoff(result.HandoffCode)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if userID != owner {
+		t.Errorf("login resolved to user %d, want the linked user %d", userID, owner)
+	}
+	db.QueryRow("SELECT role FROM users WHERE id = $1", owner).Scan(&role)
+	if role != "admin" {
+		t.Errorf("role after login = %q, want the mapped admin role", role)
+	}
+
+	identities, err := s.GetIdentities(owner)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(identities) != 1 || identities[0].Subject != login.Subject {
+		t.Errorf("identities = %+v, want the one linked identity", identities)
+	}
+}
+
+func TestOIDCCompleteLoginIsSingleUse(t *testing.T) {
+	db := openTestDB(t)
+	s, issuer := newTestOIDCService(t, db, OIDCOptions{AutoProvision: true})
+	owner := createTestUser(t, db, "replay"+uniqueSuffix(), "")
+
+	authURL, binding, err := s.StartLogin(context.Background(), owner)
+	if err != nil {
+		t.Fatal(err)
+	}
+	callback, err := issuer.LogIn(authURL, oidctest.Login{Subject: "sub-" + uniqueSuffix()})
+	if err != nil {
+		t.Fatal(err)
+	}
+	query := callback.Query()
+	if _, err := s.CompleteLogin(context.Background(), query.Get("state"), binding, query.Get("code")); err != nil {
+		t.Fatal(err)
+	}
+	_, err = s.CompleteLogin(context.Background(), query.Get("state"), binding, query.Get("code"))
+	if err == nil || !strings.Contains(err.Error(), "expired") {
+		t.Fatalf("replayed callback: err = %v, want it refused", err)
+	}
+}
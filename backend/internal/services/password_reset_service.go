@@ -0,0 +1,215 @@
+package services
+
+import (
+	"database/sql"
+	"errors"
+	"log"
+	"net/url"
+	"time"
+)
+
+const (
+	passwordResetTTL       = time.Hour
+	passwordResetCooldown  = time.Minute    // Minimum time between reset emails to one account
+	forcedPasswordResetTTL = 24 * time.Hour // Users may only see the email of a forced reset hours later
+)
+
+var ErrPasswordResetUnavailable = errors.New("password reset by email is not available")
+
+type PasswordResetService struct {
+	db             *sql.DB
+	userService    *UserService
+	emailService   *EmailService
+	sessionService *SessionService
+}
+
+func NewPasswordResetService(db *sql.DB, userService *UserService, emailService *EmailService, sessionService *SessionService) *PasswordResetService {
+	return &PasswordResetService{
+		db:             db,
+		userService:    userService,
+		emailService:   emailService,
+		sessionService: sessionService,
+	}
+}
+
+// Email a reset link to the account with this address. Unknown addresses are ignored
+// without an error so the response does not reveal which addresses are registered.
+func (s *PasswordResetService) RequestReset(email string) error {
+	if !s.emailService.Enabled() {
+		return ErrPasswordResetUnavailable
+	}
+
+	var userID int
+	var username, address string
+	err := s.db.QueryRow(`
+		SELECT id, username, email FROM users
+		WHERE LOWER(email) = LOWER($1) AND auth_source = 'local' AND disabled_at IS NULL`,
+		email).Scan(&userID, &username, &address)
+	if err == sql.ErrNoRows {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	var recent int
+	err = s.db.QueryRow(`
+		SELECT COUNT(*) FROM password_reset_tokens
+		WHERE user_id = $1 AND created_at > CURRENT_TIMESTAMP - $2 * INTERVAL '1 second'`,
+		userID, int(passwordResetCooldown.Seconds())).Scan(&recent)
+	if err != nil {
+		return err
+	}
+	if recent > 0 {
+		return nil
+	}
+
+	token, err := generateToken()
+	if err != nil {
+		return err
+	}
+
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	// Only the newest link works
+	_, err = tx.Exec("UPDATE password_reset_tokens SET used_at = CURRENT_TIMESTAMP WHERE user_id = $1 AND used_at IS NULL", userID)
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.Exec(`
+		INSERT INTO password_reset_tokens (user_id, token_hash, expires_at)
+		VALUES ($1, $2, $3)`,
+		userID, hashToken(token), time.Now().Add(passwordResetTTL))
+	if err != nil {
+		return err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return err
+	}
+
+	// Sent in the background, a slow SMTP server would otherwise tell registered addresses apart
+	link := s.emailService.AppLink("/reset-password?token=" + url.QueryEscape(token))
+	go func() {
+		if err := s.emailService.SendPasswordReset(address, username, link, passwordResetTTL); err != nil {
+			log.Printf("Failed to send password reset email to user %d: %v", userID, err)
+		}
+	}()
+
+	return nil
+}
+
+// Make the user choose a new password: the current one stops logging in, all sessions and
+// personal access tokens end and a reset link is emailed to the user. The link only ever goes to the user's own
+// address, so the reset fails when it cannot be emailed.
+func (s *PasswordResetService) ForceReset(userID int) error {
+	var username, authSource string
+	var address sql.NullString
+	err := s.db.QueryRow("SELECT username, email, auth_source FROM users WHERE id = $1", userID).Scan(&username, &address, &authSource)
+	if err == sql.ErrNoRows {
+		return errors.New("user not found")
+	}
+	if err != nil {
+		return err
+	}
+	if authSource != "local" {
+		return errors.New("the user's password is managed by the directory")
+	}
+	if !s.emailService.Enabled() {
+		return ErrPasswordResetUnavailable
+	}
+	if !address.Valid || address.String == "" {
+		return errors.New("the user has no email address to send the reset link to")
+	}
+
+	token, err := generateToken()
+	if err != nil {
+		return err
+	}
+
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	_, err = tx.Exec("UPDATE users SET password_reset_required = TRUE, updated_at = CURRENT_TIMESTAMP WHERE id = $1", userID)
+	if err != nil {
+		return err
+	}
+	_, err = tx.Exec("UPDATE password_reset_tokens SET used_at = CURRENT_TIMESTAMP WHERE user_id = $1 AND used_at IS NULL", userID)
+	if err != nil {
+		return err
+	}
+	_, err = tx.Exec(`
+		INSERT INTO password_reset_tokens (user_id, token_hash, expires_at)
+		VALUES ($1, $2, $3)`,
+		userID, hashToken(token), time.Now().Add(forcedPasswordResetTTL))
+	if err != nil {
+		return err
+	}
+	if _, err := revokeAPITokens(tx, userID); err != nil {
+		return err
+	}
+
+	// Sent before committing, the account is not locked out of its password without a link
+	link := s.emailService.AppLink("/reset-password?token=" + url.QueryEscape(token))
+	if err := s.emailService.SendPasswordReset(address.String, username, link, forcedPasswordResetTTL); err != nil {
+		log.Printf("Failed to send forced password reset email to user %d: %v", userID, err)
+		return errors.New("failed to email the reset link")
+	}
+
+	if err := tx.Commit(); err != nil {
+		return err
+	}
+
+	_, err = s.sessionService.RevokeAllSessions(userID, "", "password_reset_forced")
+	return err
+}
+
+// Set a new password with a reset token, which is used up, and end all of the user's sessions
+// and personal access tokens
+func (s *PasswordResetService) ResetPassword(token, newPassword string) error {
+	// Checked first so a rejected password does not use up the link
+	if err := s.userService.PasswordPolicy().Validate(newPassword); err != nil {
+		return err
+	}
+
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	var userID int
+	err = tx.QueryRow(`
+		UPDATE password_reset_tokens SET used_at = CURRENT_TIMESTAMP
+		WHERE token_hash = $1 AND used_at IS NULL AND expires_at > CURRENT_TIMESTAMP
+		RETURNING user_id`,
+		hashToken(token)).Scan(&userID)
+	if err == sql.ErrNoRows {
+		return errors.New("invalid or expired reset token")
+	}
+	if err != nil {
+		return err
+	}
+
+	if err := setPassword(tx, userID, newPassword); err != nil {
+		return err
+	}
+	if _, err := revokeAPITokens(tx, userID); err != nil {
+		return err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return err
+	}
+
+	_, err = s.sessionService.RevokeAllSessions(userID, "", "password_reset")
+	return err
+}
@@ -0,0 +1,42 @@
+package services
+
+import (
+	"fmt"
+	"strings"
+)
+
+// GroupRoleRule gives members of an identity provider or directory group a role
+type GroupRoleRule struct {
+	Group string
+	Role  string
+}
+
+// ParseGroupRoleRules reads rules like "idp-admins=admin". Rules are split at the last
+// "=", so groups may be LDAP DNs; those contain commas and need another separator.
+func ParseGroupRoleRules(value, separator string) ([]GroupRoleRule, error) {
+	var rules []GroupRoleRule
+	for _, item := range strings.Split(value, separator) {
+		item = strings.TrimSpace(item)
+		if item == "" {
+			continue
+		}
+		i := strings.LastIndex(item, "=")
+		if i <= 0 || strings.TrimSpace(item[i+1:]) == "" {
+			return nil, fmt.Errorf("invalid role mapping %q, expected group=role", item)
+		}
+		rules = append(rules, GroupRoleRule{Group: strings.TrimSpace(item[:i]), Role: strings.TrimSpace(item[i+1:])})
+	}
+	return rules, nil
+}
+
+// Role of the first rule matching one of the groups, empty when none does
+func mapGroupRole(rules []GroupRoleRule, groups []string) string {
+	for _, rule := range rules {
+		for _, group := range groups {
+			if strings.EqualFold(group, rule.Group) {
+				return rule.Role
+			}
+		}
+	}
+	return ""
+}
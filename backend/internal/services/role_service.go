@@ -0,0 +1,360 @@
+package services
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"regexp"
+	"strings"
+	"ai-doc-system/internal/auth"
+	"ai-doc-system/internal/models"
+	"github.com/lib/pq"
+)
+
+// The admin role always has every permission so nobody can lock administration out
+const adminRole = "admin"
+
+var roleNamePattern = regexp.MustCompile(`^[a-z][a-z0-9_-]{1,19}$`)
+
+// RoleService stores roles and the permissions they grant, and checks them for the
+// permission middleware
+type RoleService struct {
+	db *sql.DB
+}
+
+func NewRoleService(db *sql.DB) *RoleService {
+	return &RoleService{db: db}
+}
+
+// HasPermission checks the user's current role, so role changes apply without logging in again
+func (s *RoleService) HasPermission(userID int, permission string) (bool, error) {
+	var allowed bool
+	err := s.db.QueryRow(`
+		SELECT EXISTS (
+			SELECT 1 FROM users u
+			JOIN role_permissions rp ON rp.role = u.role
+			WHERE u.id = $1 AND rp.permission = $2 AND u.disabled_at IS NULL
+		)`,
+		userID, permission).Scan(&allowed)
+	return allowed, err
+}
+
+// Permissions of the user's role, the frontend shows administration pages by them
+func (s *RoleService) GetUserPermissions(userID int) ([]string, error) {
+	rows, err := s.db.Query(`
+		SELECT rp.permission FROM users u
+		JOIN role_permissions rp ON rp.role = u.role
+		WHERE u.id = $1
+		ORDER BY rp.permission`,
+		userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	permissions := []string{}
+	for rows.Next() {
+		var permission string
+		if err := rows.Scan(&permission); err != nil {
+			return nil, err
+		}
+		permissions = append(permissions, permission)
+	}
+	return permissions, rows.Err()
+}
+
+// Get all roles with their permissions and how many users have them
+func (s *RoleService) GetRoles() ([]models.Role, error) {
+	rows, err := s.db.Query(`
+		SELECT r.name, r.description, r.builtin, r.created_at,
+			COALESCE(ARRAY(SELECT rp.permission FROM role_permissions rp WHERE rp.role = r.name ORDER BY rp.permission), '{}'),
+			(SELECT COUNT(*) FROM users u WHERE u.role = r.name)
+		FROM roles r
+		ORDER BY r.builtin DESC, r.name`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	roles := []models.Role{}
+	for rows.Next() {
+		var role models.Role
+		err := rows.Scan(&role.Name, &role.Description, &role.Builtin, &role.CreatedAt,
+			pq.Array(&role.Permissions), &role.UserCount)
+		if err != nil {
+			return nil, err
+		}
+		roles = append(roles, role)
+	}
+	return roles, rows.Err()
+}
+
+// Create a custom role
+func (s *RoleService) CreateRole(name, description string, permissions []string) error {
+	if !roleNamePattern.MatchString(name) {
+		return errors.New("role name must be 2-20 lowercase letters, digits, dashes or underscores, starting with a letter")
+	}
+	permissions, err := uniquePermissions(permissions)
+	if err != nil {
+		return err
+	}
+
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	result, err := tx.Exec(`
+		INSERT INTO roles (name, description) VALUES ($1, $2)
+		ON CONFLICT (name) DO NOTHING`,
+		name, strings.TrimSpace(description))
+	if err != nil {
+		return err
+	}
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if rowsAffected == 0 {
+		return errors.New("role already exists")
+	}
+
+	if err := setRolePermissions(tx, name, permissions); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// Replace a role's description and permissions, returns the permissions it had before
+func (s *RoleService) UpdateRole(name, description string, permissions []string) ([]string, error) {
+	if name == adminRole {
+		return nil, errors.New("the admin role always has every permission")
+	}
+	permissions, err := uniquePermissions(permissions)
+	if err != nil {
+		return nil, err
+	}
+
+	tx, err := s.db.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	var previous []string
+	err = tx.QueryRow(`
+		SELECT COALESCE(ARRAY(SELECT permission FROM role_permissions WHERE role = r.name ORDER BY permission), '{}')
+		FROM roles r WHERE r.name = $1
+		FOR UPDATE`,
+		name).Scan(pq.Array(&previous))
+	if err == sql.ErrNoRows {
+		return nil, errors.New("role not found")
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	_, err = tx.Exec("UPDATE roles SET description = $1, updated_at = CURRENT_TIMESTAMP WHERE name = $2",
+		strings.TrimSpace(description), name)
+	if err != nil {
+		return nil, err
+	}
+	if err := setRolePermissions(tx, name, permissions); err != nil {
+		return nil, err
+	}
+
+	return previous, tx.Commit()
+}
+
+// Delete a custom role nobody has
+func (s *RoleService) DeleteRole(name string) error {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	var builtin bool
+	err = tx.QueryRow("SELECT builtin FROM roles WHERE name = $1 FOR UPDATE", name).Scan(&builtin)
+	if err == sql.ErrNoRows {
+		return errors.New("role not found")
+	}
+	if err != nil {
+		return err
+	}
+	if builtin {
+		return errors.New("built-in roles cannot be deleted")
+	}
+
+	var users int
+	if err := tx.QueryRow("SELECT COUNT(*) FROM users WHERE role = $1", name).Scan(&users); err != nil {
+		return err
+	}
+	if users > 0 {
+		return fmt.Errorf("role is assigned to %d users, assign them another role first", users)
+	}
+
+	if _, err := tx.Exec("DELETE FROM roles WHERE name = $1", name); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// Assign a role to a user, returns the role the user had. Admins cannot change their own
+// role, so the last one cannot demote themselves by accident. The actor must be able to
+// manage the user, and cannot hand out permissions their own role lacks.
+func (s *RoleService) AssignRole(actorID, userID int, role string) (string, error) {
+	if actorID == userID {
+		return "", errors.New("you cannot change your own role")
+	}
+
+	tx, err := s.db.Begin()
+	if err != nil {
+		return "", err
+	}
+	defer tx.Rollback()
+
+	var exists bool
+	if err := tx.QueryRow("SELECT EXISTS (SELECT 1 FROM roles WHERE name = $1)", role).Scan(&exists); err != nil {
+		return "", err
+	}
+	if !exists {
+		return "", errors.New("role not found")
+	}
+
+	if err := checkCanManageUser(tx, actorID, userID); err != nil {
+		return "", err
+	}
+
+	var exceeds bool
+	err = tx.QueryRow(`
+		SELECT EXISTS (
+			SELECT 1 FROM role_permissions t
+			WHERE t.role = $1 AND NOT EXISTS (
+				SELECT 1 FROM role_permissions a
+				JOIN users u ON u.role = a.role
+				WHERE u.id = $2 AND a.permission = t.permission
+			)
+		)`,
+		role, actorID).Scan(&exceeds)
+	if err != nil {
+		return "", err
+	}
+	if exceeds {
+		return "", errors.New("the role has permissions yours does not")
+	}
+
+	var previous string
+	err = tx.QueryRow("SELECT role FROM users WHERE id = $1 FOR UPDATE", userID).Scan(&previous)
+	if err == sql.ErrNoRows {
+		return "", errors.New("user not found")
+	}
+	if err != nil {
+		return "", err
+	}
+
+	_, err = tx.Exec("UPDATE users SET role = $1, updated_at = CURRENT_TIMESTAMP WHERE id = $2", role, userID)
+	if err != nil {
+		return "", err
+	}
+
+	return previous, tx.Commit()
+}
+
+// Whether the actor may disable, delete, reset or log out the user
+func (s *RoleService) CanManageUser(actorID, userID int) error {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	return checkCanManageUser(tx, actorID, userID)
+}
+
+// The user's role may not grant anything the actor's role lacks, so custom roles cannot
+// act on admins, and the last enabled admin is left alone. Run in the transaction making
+// the change: the enabled admins stay locked until it ends so two admins cannot lock each
+// other out at once.
+func checkCanManageUser(tx *sql.Tx, actorID, userID int) error {
+	var role string
+	err := tx.QueryRow("SELECT role FROM users WHERE id = $1", userID).Scan(&role)
+	if err == sql.ErrNoRows {
+		return errors.New("user not found")
+	}
+	if err != nil {
+		return err
+	}
+
+	var exceeds bool
+	err = tx.QueryRow(`
+		SELECT EXISTS (
+			SELECT 1 FROM role_permissions t
+			WHERE t.role = $1 AND NOT EXISTS (
+				SELECT 1 FROM role_permissions a
+				JOIN users u ON u.role = a.role
+				WHERE u.id = $2 AND a.permission = t.permission
+			)
+		)`,
+		role, actorID).Scan(&exceeds)
+	if err != nil {
+		return err
+	}
+	if exceeds {
+		return errors.New("the user's role has permissions yours does not")
+	}
+
+	if role != adminRole {
+		return nil
+	}
+	rows, err := tx.Query("SELECT id FROM users WHERE role = $1 AND disabled_at IS NULL ORDER BY id FOR UPDATE", adminRole)
+	if err != nil {
+		return err
+	}
+	otherAdmins := 0
+	for rows.Next() {
+		var id int
+		if err := rows.Scan(&id); err != nil {
+			rows.Close()
+			return err
+		}
+		if id != userID {
+			otherAdmins++
+		}
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return err
+	}
+	if otherAdmins == 0 {
+		return errors.New("the last admin cannot be locked out")
+	}
+	return nil
+}
+
+func uniquePermissions(permissions []string) ([]string, error) {
+	seen := make(map[string]bool)
+	unique := []string{}
+	for _, permission := range permissions {
+		if !auth.ValidPermission(permission) {
+			return nil, fmt.Errorf("unknown permission %q", permission)
+		}
+		if !seen[permission] {
+			seen[permission] = true
+			unique = append(unique, permission)
+		}
+	}
+	return unique, nil
+}
+
+func setRolePermissions(tx *sql.Tx, role string, permissions []string) error {
+	if _, err := tx.Exec("DELETE FROM role_permissions WHERE role = $1", role); err != nil {
+		return err
+	}
+	_, err := tx.Exec(`
+		INSERT INTO role_permissions (role, permission)
+		SELECT $1, UNNEST($2::TEXT[])`,
+		role, pq.Array(permissions))
+	return err
+}
@@ -0,0 +1,290 @@
+package services
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"log"
+	"time"
+	"ai-doc-system/internal/auth"
+	"ai-doc-system/internal/models"
+	"ai-doc-system/internal/realtime"
+	"github.com/google/uuid"
+)
+
+// Sessions expire after this long without a refresh
+const sessionIdleTTL = 30 * 24 * time.Hour
+
+type SessionService struct {
+	db        *sql.DB
+	jwtSecret string
+	hub       *realtime.Hub
+}
+
+func NewSessionService(db *sql.DB, jwtSecret string, hub *realtime.Hub) *SessionService {
+	return &SessionService{db: db, jwtSecret: jwtSecret, hub: hub}
+}
+
+// Random URL-safe token for refresh tokens and links
+func generateToken() (string, error) {
+	buf := make([]byte, 32)
+	if _, err := rand.Read(buf); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(buf), nil
+}
+
+// Tokens are stored as SHA-256 hex digests only
+func hashToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+func truncateRunes(value string, length int) string {
+	if runes := []rune(value); len(runes) > length {
+		return string(runes[:length])
+	}
+	return value
+}
+
+func (s *SessionService) issueTokens(tx *sql.Tx, sessionID string, userID int, username, role string) (*models.TokenPair, error) {
+	refreshToken, err := generateToken()
+	if err != nil {
+		return nil, err
+	}
+
+	_, err = tx.Exec("INSERT INTO refresh_tokens (session_id, token_hash) VALUES ($1, $2)", sessionID, hashToken(refreshToken))
+	if err != nil {
+		return nil, err
+	}
+
+	accessToken, err := auth.GenerateToken(userID, username, role, sessionID, s.jwtSecret)
+	if err != nil {
+		return nil, err
+	}
+
+	return &models.TokenPair{
+		AccessToken:  accessToken,
+		RefreshToken: refreshToken,
+		ExpiresIn:    int(auth.AccessTokenTTL.Seconds()),
+		SessionID:    sessionID,
+	}, nil
+}
+
+// Start a session for a user who just logged in
+func (s *SessionService) CreateSession(user *models.User, device, ipAddress string) (*models.TokenPair, error) {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	var sessionID string
+	err = tx.QueryRow(`
+		INSERT INTO sessions (user_id, device, ip_address, expires_at)
+		VALUES ($1, $2, $3, $4)
+		RETURNING id`,
+		user.ID, truncateRunes(device, 255), ipAddress, time.Now().Add(sessionIdleTTL)).Scan(&sessionID)
+	if err != nil {
+		return nil, err
+	}
+
+	tokens, err := s.issueTokens(tx, sessionID, user.ID, user.Username, user.Role)
+	if err != nil {
+		return nil, err
+	}
+
+	return tokens, tx.Commit()
+}
+
+// Exchange a refresh token for a new access and refresh token.
+// Presenting an already rotated token means it was stolen or replayed, so the whole session is revoked.
+func (s *SessionService) Refresh(refreshToken, device, ipAddress string) (*models.TokenPair, error) {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	tokenHash := hashToken(refreshToken)
+	var sessionID string
+	var used bool
+	err = tx.QueryRow(`
+		SELECT session_id, used_at IS NOT NULL FROM refresh_tokens
+		WHERE token_hash = $1
+		FOR UPDATE`,
+		tokenHash).Scan(&sessionID, &used)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, errors.New("invalid refresh token")
+		}
+		return nil, err
+	}
+
+	if used {
+		var userID int
+		err = tx.QueryRow(`
+			UPDATE sessions SET revoked_at = CURRENT_TIMESTAMP, revoke_reason = 'token_reuse'
+			WHERE id = $1 AND revoked_at IS NULL
+			RETURNING user_id`,
+			sessionID).Scan(&userID)
+		if err != nil && err != sql.ErrNoRows {
+			return nil, err
+		}
+		if err := tx.Commit(); err != nil {
+			return nil, err
+		}
+		if err == nil {
+			s.endStreams(userID, []string{sessionID})
+		}
+		return nil, errors.New("refresh token reuse detected, session revoked")
+	}
+
+	var userID int
+	var username, role string
+	err = tx.QueryRow(`
+		SELECT u.id, u.username, u.role
+		FROM sessions s
+		JOIN users u ON u.id = s.user_id
+		WHERE s.id = $1 AND s.revoked_at IS NULL AND s.expires_at > CURRENT_TIMESTAMP AND u.disabled_at IS NULL
+		FOR UPDATE OF s`,
+		sessionID).Scan(&userID, &username, &role)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, errors.New("session expired or revoked")
+		}
+		return nil, err
+	}
+
+	_, err = tx.Exec("UPDATE refresh_tokens SET used_at = CURRENT_TIMESTAMP WHERE token_hash = $1", tokenHash)
+	if err != nil {
+		return nil, err
+	}
+
+	_, err = tx.Exec(`
+		UPDATE sessions SET last_used_at = CURRENT_TIMESTAMP, expires_at = $1,
+			ip_address = $2, device = COALESCE(NULLIF($3, ''), device)
+		WHERE id = $4`,
+		time.Now().Add(sessionIdleTTL), ipAddress, truncateRunes(device, 255), sessionID)
+	if err != nil {
+		return nil, err
+	}
+
+	tokens, err := s.issueTokens(tx, sessionID, userID, username, role)
+	if err != nil {
+		return nil, err
+	}
+
+	return tokens, tx.Commit()
+}
+
+// IsSessionActive is checked for every authenticated request; sessions of disabled users are not
+func (s *SessionService) IsSessionActive(sessionID string, userID int) (bool, error) {
+	if _, err := uuid.Parse(sessionID); err != nil {
+		return false, nil
+	}
+
+	var count int
+	err := s.db.QueryRow(`
+		SELECT COUNT(*) FROM sessions s
+		JOIN users u ON u.id = s.user_id
+		WHERE s.id = $1 AND s.user_id = $2 AND s.revoked_at IS NULL AND s.expires_at > CURRENT_TIMESTAMP
+			AND u.disabled_at IS NULL`,
+		sessionID, userID).Scan(&count)
+	return count > 0, err
+}
+
+// Get user's active sessions, most recently used first
+func (s *SessionService) GetSessions(userID int, currentSessionID string) ([]models.Session, error) {
+	rows, err := s.db.Query(`
+		SELECT id, user_id, COALESCE(device, ''), COALESCE(ip_address, ''), created_at, last_used_at, expires_at
+		FROM sessions
+		WHERE user_id = $1 AND revoked_at IS NULL AND expires_at > CURRENT_TIMESTAMP
+		ORDER BY last_used_at DESC`,
+		userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	sessions := []models.Session{}
+	for rows.Next() {
+		var session models.Session
+		err := rows.Scan(&session.ID, &session.UserID, &session.Device, &session.IPAddress,
+			&session.CreatedAt, &session.LastUsedAt, &session.ExpiresAt)
+		if err != nil {
+			return nil, err
+		}
+		session.Current = session.ID == currentSessionID
+		sessions = append(sessions, session)
+	}
+
+	return sessions, rows.Err()
+}
+
+// Revoke one of the user's sessions, its tokens stop working immediately
+func (s *SessionService) RevokeSession(sessionID string, userID int, reason string) error {
+	if _, err := uuid.Parse(sessionID); err != nil {
+		return errors.New("session not found")
+	}
+
+	result, err := s.db.Exec(`
+		UPDATE sessions SET revoked_at = CURRENT_TIMESTAMP, revoke_reason = $1
+		WHERE id = $2 AND user_id = $3 AND revoked_at IS NULL`,
+		reason, sessionID, userID)
+	if err != nil {
+		return err
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if rowsAffected == 0 {
+		return errors.New("session not found")
+	}
+
+	s.endStreams(userID, []string{sessionID})
+	return nil
+}
+
+// Revoke all of the user's sessions except keepSessionID (empty to revoke all)
+func (s *SessionService) RevokeAllSessions(userID int, keepSessionID, reason string) (int, error) {
+	rows, err := s.db.Query(`
+		UPDATE sessions SET revoked_at = CURRENT_TIMESTAMP, revoke_reason = $1
+		WHERE user_id = $2 AND revoked_at IS NULL AND id::text != $3
+		RETURNING id`,
+		reason, userID, keepSessionID)
+	if err != nil {
+		return 0, err
+	}
+	defer rows.Close()
+
+	var sessionIDs []string
+	for rows.Next() {
+		var sessionID string
+		if err := rows.Scan(&sessionID); err != nil {
+			return 0, err
+		}
+		sessionIDs = append(sessionIDs, sessionID)
+	}
+	if err := rows.Err(); err != nil {
+		return 0, err
+	}
+
+	s.endStreams(userID, sessionIDs)
+	return len(sessionIDs), nil
+}
+
+// Close the WebSockets and event streams of revoked sessions, on every instance
+func (s *SessionService) endStreams(userID int, sessionIDs []string) {
+	if len(sessionIDs) == 0 {
+		return
+	}
+	if err := s.hub.EndSessions(userID, sessionIDs); err != nil {
+		log.Printf("Failed to close event streams of user %d: %v", userID, err)
+	}
+}
@@ -0,0 +1,67 @@
+package services
+
+import (
+	"database/sql"
+	"errors"
+	"time"
+	"ai-doc-system/internal/auth"
+)
+
+// Stream tickets are redeemed right after they are issued, they only need to outlive the round trip
+const streamTicketTTL = 30 * time.Second
+
+// StreamTicketService issues the short-lived, single-use tickets that WebSocket and event
+// stream URLs carry instead of the session's access token, which would end up in logs
+type StreamTicketService struct {
+	db             *sql.DB
+	jwtSecret      string
+	sessionService *SessionService
+}
+
+func NewStreamTicketService(db *sql.DB, jwtSecret string, sessionService *SessionService) *StreamTicketService {
+	return &StreamTicketService{db: db, jwtSecret: jwtSecret, sessionService: sessionService}
+}
+
+// Issue a ticket for one stream of the session
+func (s *StreamTicketService) IssueTicket(userID int, sessionID string) (string, time.Time, error) {
+	return auth.GenerateStreamTicket(userID, sessionID, streamTicketTTL, s.jwtSecret)
+}
+
+// Redeem a ticket, which then stops working. The session must still be active.
+func (s *StreamTicketService) RedeemTicket(ticket string) (*auth.StreamTicketClaims, error) {
+	claims, err := auth.ValidateStreamTicket(ticket, s.jwtSecret)
+	if err != nil {
+		return nil, errors.New("invalid stream ticket")
+	}
+
+	active, err := s.sessionService.IsSessionActive(claims.SessionID, claims.UserID)
+	if err != nil {
+		return nil, err
+	}
+	if !active {
+		return nil, errors.New("session revoked")
+	}
+
+	// Expired tickets are rejected by their signature check already, their IDs are no longer needed
+	if _, err := s.db.Exec("DELETE FROM used_stream_tickets WHERE expires_at < NOW()"); err != nil {
+		return nil, err
+	}
+
+	result, err := s.db.Exec(`
+		INSERT INTO used_stream_tickets (jti, expires_at) VALUES ($1, $2)
+		ON CONFLICT (jti) DO NOTHING`,
+		claims.ID, claims.ExpiresAt.Time)
+	if err != nil {
+		return nil, err
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return nil, err
+	}
+	if rowsAffected == 0 {
+		return nil, errors.New("stream ticket has already been used")
+	}
+
+	return claims, nil
+}
@@ -0,0 +1,522 @@
+package services
+
+import (
+	"crypto/rand"
+	"database/sql"
+	"encoding/base32"
+	"errors"
+	"strings"
+	"time"
+	"ai-doc-system/internal/models"
+	"ai-doc-system/internal/utils"
+)
+
+const (
+	totpIssuer             = "AI Document System"
+	recoveryCodeCount      = 10
+	loginChallengeTTL      = 5 * time.Minute
+	loginChallengeAttempts = 5
+)
+
+var (
+	ErrInvalidTwoFactorCode = errors.New("invalid two-factor code")
+	ErrInvalidChallenge     = errors.New("login challenge is invalid or expired, please log in again")
+)
+
+type TwoFactorService struct {
+	db             *sql.DB
+	userService    *UserService
+	sessionService *SessionService
+}
+
+func NewTwoFactorService(db *sql.DB, userService *UserService, sessionService *SessionService) *TwoFactorService {
+	return &TwoFactorService{db: db, userService: userService, sessionService: sessionService}
+}
+
+// Random recovery code like "k3m9-x2qa"
+func generateRecoveryCode() (string, error) {
+	buf := make([]byte, 5)
+	if _, err := rand.Read(buf); err != nil {
+		return "", err
+	}
+	code := strings.ToLower(base32.StdEncoding.EncodeToString(buf))
+	return code[:4] + "-" + code[4:], nil
+}
+
+// Recovery codes are compared without case, spaces and dashes
+func normalizeRecoveryCode(code string) string {
+	code = strings.ToLower(code)
+	return strings.NewReplacer("-", "", " ", "").Replace(code)
+}
+
+// Replace the user's recovery codes with new ones and return them, they are only shown this once
+func (s *TwoFactorService) replaceRecoveryCodes(tx *sql.Tx, userID int) ([]string, error) {
+	if _, err := tx.Exec("DELETE FROM recovery_codes WHERE user_id = $1", userID); err != nil {
+		return nil, err
+	}
+
+	codes := make([]string, 0, recoveryCodeCount)
+	for i := 0; i < recoveryCodeCount; i++ {
+		code, err := generateRecoveryCode()
+		if err != nil {
+			return nil, err
+		}
+		_, err = tx.Exec("INSERT INTO recovery_codes (user_id, code_hash) VALUES ($1, $2)",
+			userID, hashToken(normalizeRecoveryCode(code)))
+		if err != nil {
+			return nil, err
+		}
+		codes = append(codes, code)
+	}
+
+	return codes, nil
+}
+
+// Check a TOTP code or, failing that, redeem a recovery code
+func (s *TwoFactorService) verifyCode(tx *sql.Tx, userID int, code string) error {
+	var secret sql.NullString
+	err := tx.QueryRow("SELECT totp_secret FROM users WHERE id = $1 AND totp_enabled = TRUE FOR UPDATE", userID).Scan(&secret)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return errors.New("two-factor authentication is not enabled")
+		}
+		return err
+	}
+
+	if step, ok := utils.ValidateTOTP(secret.String, code, time.Now()); ok {
+		result, err := tx.Exec(`
+			UPDATE users SET totp_last_step = $1
+			WHERE id = $2 AND (totp_last_step IS NULL OR totp_last_step < $1)`,
+			step, userID)
+		if err != nil {
+			return err
+		}
+		rowsAffected, err := result.RowsAffected()
+		if err != nil {
+			return err
+		}
+		if rowsAffected == 0 {
+			return errors.New("code has already been used, wait for the next one")
+		}
+		return nil
+	}
+
+	result, err := tx.Exec(`
+		UPDATE recovery_codes SET used_at = CURRENT_TIMESTAMP
+		WHERE user_id = $1 AND code_hash = $2 AND used_at IS NULL`,
+		userID, hashToken(normalizeRecoveryCode(code)))
+	if err != nil {
+		return err
+	}
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if rowsAffected == 0 {
+		return ErrInvalidTwoFactorCode
+	}
+	return nil
+}
+
+// Whether the admin policy requires 2FA for users with this role. The policy covers every
+// role that grants administration permissions, not only admin.
+func (s *TwoFactorService) IsRequiredForRole(role string) (bool, error) {
+	var privileged bool
+	err := s.db.QueryRow("SELECT EXISTS (SELECT 1 FROM role_permissions WHERE role = $1)", role).Scan(&privileged)
+	if err != nil || !privileged {
+		return false, err
+	}
+	policy, err := s.GetPolicy()
+	if err != nil {
+		return false, err
+	}
+	return policy.RequireForAdmins, nil
+}
+
+func (s *TwoFactorService) GetStatus(userID int) (*models.TwoFactorStatus, error) {
+	var status models.TwoFactorStatus
+	var role string
+	err := s.db.QueryRow(`
+		SELECT u.role, u.totp_enabled,
+			(SELECT COUNT(*) FROM recovery_codes r WHERE r.user_id = u.id AND r.used_at IS NULL)
+		FROM users u WHERE u.id = $1`,
+		userID).Scan(&role, &status.Enabled, &status.RecoveryCodesRemaining)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, errors.New("user not found")
+		}
+		return nil, err
+	}
+
+	status.Required, err = s.IsRequiredForRole(role)
+	if err != nil {
+		return nil, err
+	}
+
+	return &status, nil
+}
+
+// Start enrollment with a new secret, 2FA is only switched on once EnableTwoFactor confirms a code
+func (s *TwoFactorService) BeginSetup(userID int) (*models.TwoFactorSetup, error) {
+	var username string
+	var enabled bool
+	err := s.db.QueryRow("SELECT username, totp_enabled FROM users WHERE id = $1", userID).Scan(&username, &enabled)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, errors.New("user not found")
+		}
+		return nil, err
+	}
+	if enabled {
+		return nil, errors.New("two-factor authentication is already enabled")
+	}
+
+	secret, err := utils.GenerateTOTPSecret()
+	if err != nil {
+		return nil, err
+	}
+
+	_, err = s.db.Exec("UPDATE users SET totp_pending_secret = $1 WHERE id = $2", secret, userID)
+	if err != nil {
+		return nil, err
+	}
+
+	return &models.TwoFactorSetup{
+		Secret:          secret,
+		ProvisioningURI: utils.TOTPProvisioningURI(totpIssuer, username, secret),
+	}, nil
+}
+
+// Confirm enrollment with a code from the authenticator app and return the recovery codes
+func (s *TwoFactorService) EnableTwoFactor(userID int, code string) ([]string, error) {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	codes, err := s.enable(tx, userID, code)
+	if err != nil {
+		return nil, err
+	}
+
+	return codes, tx.Commit()
+}
+
+func (s *TwoFactorService) enable(tx *sql.Tx, userID int, code string) ([]string, error) {
+	var secret sql.NullString
+	var enabled bool
+	err := tx.QueryRow("SELECT totp_pending_secret, totp_enabled FROM users WHERE id = $1 FOR UPDATE", userID).Scan(&secret, &enabled)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, errors.New("user not found")
+		}
+		return nil, err
+	}
+	if enabled {
+		return nil, errors.New("two-factor authentication is already enabled")
+	}
+	if !secret.Valid {
+		return nil, errors.New("start two-factor setup first")
+	}
+
+	step, ok := utils.ValidateTOTP(secret.String, code, time.Now())
+	if !ok {
+		return nil, ErrInvalidTwoFactorCode
+	}
+
+	_, err = tx.Exec(`
+		UPDATE users SET totp_enabled = TRUE, totp_secret = totp_pending_secret, totp_pending_secret = NULL,
+			totp_last_step = $1, updated_at = CURRENT_TIMESTAMP
+		WHERE id = $2`,
+		step, userID)
+	if err != nil {
+		return nil, err
+	}
+
+	return s.replaceRecoveryCodes(tx, userID)
+}
+
+// Switch 2FA off after checking the password and a current code, unless the policy requires it.
+// Accounts without a password, like those provisioned by single sign-on, confirm with the code alone.
+func (s *TwoFactorService) DisableTwoFactor(userID int, password, code string) error {
+	var role string
+	err := s.db.QueryRow("SELECT role FROM users WHERE id = $1", userID).Scan(&role)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return errors.New("user not found")
+		}
+		return err
+	}
+	if err := s.userService.VerifyPassword(userID, password); err != nil {
+		return err
+	}
+
+	required, err := s.IsRequiredForRole(role)
+	if err != nil {
+		return err
+	}
+	if required {
+		return errors.New("two-factor authentication is required for your role")
+	}
+
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if err := s.verifyCode(tx, userID, code); err != nil {
+		return err
+	}
+
+	_, err = tx.Exec(`
+		UPDATE users SET totp_enabled = FALSE, totp_secret = NULL, totp_pending_secret = NULL,
+			totp_last_step = NULL, updated_at = CURRENT_TIMESTAMP
+		WHERE id = $1`,
+		userID)
+	if err != nil {
+		return err
+	}
+
+	if _, err := tx.Exec("DELETE FROM recovery_codes WHERE user_id = $1", userID); err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// Replace the recovery codes after checking a current code
+func (s *TwoFactorService) RegenerateRecoveryCodes(userID int, code string) ([]string, error) {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	if err := s.verifyCode(tx, userID, code); err != nil {
+		return nil, err
+	}
+
+	codes, err := s.replaceRecoveryCodes(tx, userID)
+	if err != nil {
+		return nil, err
+	}
+
+	return codes, tx.Commit()
+}
+
+// Decide whether a login with correct password needs a second step.
+// Returns nil when tokens can be issued right away.
+func (s *TwoFactorService) StartLogin(user *models.User) (*models.TwoFactorChallenge, error) {
+	var enabled bool
+	err := s.db.QueryRow("SELECT totp_enabled FROM users WHERE id = $1", user.ID).Scan(&enabled)
+	if err != nil {
+		return nil, err
+	}
+
+	setupRequired := false
+	if !enabled {
+		setupRequired, err = s.IsRequiredForRole(user.Role)
+		if err != nil {
+			return nil, err
+		}
+		if !setupRequired {
+			return nil, nil
+		}
+	}
+
+	token, err := generateToken()
+	if err != nil {
+		return nil, err
+	}
+
+	_, err = s.db.Exec(`
+		INSERT INTO login_challenges (user_id, token_hash, setup_required, expires_at)
+		VALUES ($1, $2, $3, $4)`,
+		user.ID, hashToken(token), setupRequired, time.Now().Add(loginChallengeTTL))
+	if err != nil {
+		return nil, err
+	}
+
+	return &models.TwoFactorChallenge{
+		TwoFactorRequired: true,
+		SetupRequired:     setupRequired,
+		ChallengeToken:    token,
+		ExpiresIn:         int(loginChallengeTTL.Seconds()),
+	}, nil
+}
+
+// Username of a pending login challenge, for the login throttle
+func (s *TwoFactorService) ChallengeUsername(token string, setupRequired bool) (string, error) {
+	var username string
+	err := s.db.QueryRow(`
+		SELECT u.username FROM login_challenges c
+		JOIN users u ON u.id = c.user_id
+		WHERE c.token_hash = $1 AND c.setup_required = $2 AND c.used_at IS NULL
+			AND c.expires_at > CURRENT_TIMESTAMP AND c.attempts < $3`,
+		hashToken(token), setupRequired, loginChallengeAttempts).Scan(&username)
+	if err == sql.ErrNoRows {
+		return "", ErrInvalidChallenge
+	}
+	return username, err
+}
+
+// Lock a pending login challenge; wrong codes count against its attempts
+func (s *TwoFactorService) lockChallenge(tx *sql.Tx, token string, setupRequired bool) (int, int, error) {
+	var challengeID, userID int
+	err := tx.QueryRow(`
+		SELECT id, user_id FROM login_challenges
+		WHERE token_hash = $1 AND setup_required = $2 AND used_at IS NULL
+			AND expires_at > CURRENT_TIMESTAMP AND attempts < $3
+		FOR UPDATE`,
+		hashToken(token), setupRequired, loginChallengeAttempts).Scan(&challengeID, &userID)
+	if err == sql.ErrNoRows {
+		return 0, 0, ErrInvalidChallenge
+	}
+	return challengeID, userID, err
+}
+
+func (s *TwoFactorService) recordFailedAttempt(challengeID int) {
+	s.db.Exec("UPDATE login_challenges SET attempts = attempts + 1 WHERE id = $1", challengeID)
+}
+
+// Complete a login with a TOTP or recovery code, returns the user to issue tokens for
+func (s *TwoFactorService) VerifyLogin(token, code string) (int, error) {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	challengeID, userID, err := s.lockChallenge(tx, token, false)
+	if err != nil {
+		return 0, err
+	}
+
+	if err := s.verifyCode(tx, userID, code); err != nil {
+		tx.Rollback()
+		s.recordFailedAttempt(challengeID)
+		return 0, err
+	}
+
+	if _, err := tx.Exec("UPDATE login_challenges SET used_at = CURRENT_TIMESTAMP WHERE id = $1", challengeID); err != nil {
+		return 0, err
+	}
+
+	return userID, tx.Commit()
+}
+
+// Start enrollment for a login that the policy holds back until 2FA is set up
+func (s *TwoFactorService) BeginLoginSetup(token string) (*models.TwoFactorSetup, error) {
+	var userID int
+	err := s.db.QueryRow(`
+		SELECT user_id FROM login_challenges
+		WHERE token_hash = $1 AND setup_required = TRUE AND used_at IS NULL
+			AND expires_at > CURRENT_TIMESTAMP AND attempts < $2`,
+		hashToken(token), loginChallengeAttempts).Scan(&userID)
+	if err == sql.ErrNoRows {
+		return nil, ErrInvalidChallenge
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return s.BeginSetup(userID)
+}
+
+// Confirm enrollment during login, returns the user to issue tokens for and the recovery codes
+func (s *TwoFactorService) CompleteLoginSetup(token, code string) (int, []string, error) {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return 0, nil, err
+	}
+	defer tx.Rollback()
+
+	challengeID, userID, err := s.lockChallenge(tx, token, true)
+	if err != nil {
+		return 0, nil, err
+	}
+
+	codes, err := s.enable(tx, userID, code)
+	if err != nil {
+		tx.Rollback()
+		s.recordFailedAttempt(challengeID)
+		return 0, nil, err
+	}
+
+	if _, err := tx.Exec("UPDATE login_challenges SET used_at = CURRENT_TIMESTAMP WHERE id = $1", challengeID); err != nil {
+		return 0, nil, err
+	}
+
+	return userID, codes, tx.Commit()
+}
+
+func (s *TwoFactorService) GetPolicy() (*models.TwoFactorPolicy, error) {
+	var value string
+	err := s.db.QueryRow("SELECT value FROM security_settings WHERE key = 'require_2fa_admin'").Scan(&value)
+	if err != nil && err != sql.ErrNoRows {
+		return nil, err
+	}
+	return &models.TwoFactorPolicy{RequireForAdmins: value == "true"}, nil
+}
+
+// Change the 2FA policy. Turning it on ends the sessions of staff without 2FA so they
+// have to enroll on their next login; the admin changing it must have 2FA already.
+func (s *TwoFactorService) SetPolicy(adminID int, policy models.TwoFactorPolicy) error {
+	if policy.RequireForAdmins {
+		var enabled bool
+		err := s.db.QueryRow("SELECT totp_enabled FROM users WHERE id = $1", adminID).Scan(&enabled)
+		if err != nil {
+			return err
+		}
+		if !enabled {
+			return errors.New("enable two-factor authentication for your own account first")
+		}
+	}
+
+	value := "false"
+	if policy.RequireForAdmins {
+		value = "true"
+	}
+
+	_, err := s.db.Exec(`
+		INSERT INTO security_settings (key, value) VALUES ('require_2fa_admin', $1)
+		ON CONFLICT (key) DO UPDATE SET value = EXCLUDED.value, updated_at = CURRENT_TIMESTAMP`,
+		value)
+	if err != nil {
+		return err
+	}
+	if !policy.RequireForAdmins {
+		return nil
+	}
+
+	// Revoking through the session service also closes their open streams
+	rows, err := s.db.Query(`
+		SELECT DISTINCT u.id FROM users u
+		JOIN sessions ss ON ss.user_id = u.id AND ss.revoked_at IS NULL
+		WHERE u.totp_enabled = FALSE AND u.role IN (SELECT role FROM role_permissions)`)
+	if err != nil {
+		return err
+	}
+	var userIDs []int
+	for rows.Next() {
+		var userID int
+		if err := rows.Scan(&userID); err != nil {
+			rows.Close()
+			return err
+		}
+		userIDs = append(userIDs, userID)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	for _, userID := range userIDs {
+		if _, err := s.sessionService.RevokeAllSessions(userID, "", "2fa_required"); err != nil {
+			return err
+		}
+	}
+	return nil
+}
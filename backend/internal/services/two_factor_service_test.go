@@ -0,0 +1,109 @@
+package services
+
+import (
+	"database/sql"
+	"testing"
+	"ai-doc-system/internal/utils"
+)
+
+// enableTestTwoFactor turns 2FA on for the user and returns their recovery codes
+func enableTestTwoFactor(t *testing.T, db *sql.DB, s *TwoFactorService, userID int) []string {
+	t.Helper()
+	secret, err := utils.GenerateTOTPSecret()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tx, err := db.Begin()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer tx.Rollback()
+	if _, err := tx.Exec("UPDATE users SET totp_enabled = TRUE, totp_secret = $1 WHERE id = $2", secret, userID); err != nil {
+		t.Fatal(err)
+	}
+	codes, err := s.replaceRecoveryCodes(tx, userID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := tx.Commit(); err != nil {
+		t.Fatal(err)
+	}
+	return codes
+}
+
+func totpEnabled(t *testing.T, db *sql.DB, userID int) bool {
+	t.Helper()
+	var enabled bool
+	if err := db.QueryRow("SELECT totp_enabled FROM users WHERE id = $1", userID).Scan(&enabled); err != nil {
+		t.Fatal(err)
+	}
+	return enabled
+}
+
+func TestDisableTwoFactorWithoutPassword(t *testing.T) {
+	db := openTestDB(t)
+	s := NewTwoFactorService(db, NewUserService(db, utils.PasswordPolicy{}, nil), nil)
+
+	// Accounts provisioned by single sign-on have no password, the code alone confirms
+	userID := createTestUser(t, db, "sso2fa_"+uniqueSuffix(), "")
+	codes := enableTestTwoFactor(t, db, s, userID)
+
+	if err := s.DisableTwoFactor(userID, "", "000000"); err == nil {
+		t.Fatal("DisableTwoFactor accepted a wrong code")
+	}
+	if err := s.DisableTwoFactor(userID, "", codes[0]); err != nil {
+		t.Fatalf("DisableTwoFactor: %v", err)
+	}
+	if totpEnabled(t, db, userID) {
+		t.Fatal("2FA is still enabled")
+	}
+}
+
+func TestDisableTwoFactorChecksPassword(t *testing.T) {
+	db := openTestDB(t)
+	s := NewTwoFactorService(db, NewUserService(db, utils.PasswordPolicy{}, nil), nil)
+
+	userID := createTestUser(t, db, "local2fa_"+uniqueSuffix(), "")
+	hash, err := utils.HashPassword("correct horse")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := db.Exec("UPDATE users SET password_hash = $1 WHERE id = $2", hash, userID); err != nil {
+		t.Fatal(err)
+	}
+	codes := enableTestTwoFactor(t, db, s, userID)
+
+	if err := s.DisableTwoFactor(userID, "wrong", codes[0]); err == nil {
+		t.Fatal("DisableTwoFactor accepted a wrong password")
+	}
+	if err := s.DisableTwoFactor(userID, "correct horse", codes[0]); err != nil {
+		t.Fatalf("DisableTwoFactor: %v", err)
+	}
+	if totpEnabled(t, db, userID) {
+		t.Fatal("2FA is still enabled")
+	}
+}
+
+func TestDisableTwoFactorChecksDirectoryPassword(t *testing.T) {
+	db := openTestDB(t)
+	s := NewTwoFactorService(db, NewUserService(db, utils.PasswordPolicy{}, nil), nil)
+
+	// LDAP passwords live in the directory, a local hash left on the account does not count
+	userID := createTestUser(t, db, "ldap2fa_"+uniqueSuffix(), "")
+	hash, err := utils.HashPassword("local secret")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := db.Exec("UPDATE users SET password_hash = $1, auth_source = 'ldap' WHERE id = $2", hash, userID); err != nil {
+		t.Fatal(err)
+	}
+	codes := enableTestTwoFactor(t, db, s, userID)
+
+	if err := s.DisableTwoFactor(userID, "local secret", codes[0]); err == nil {
+		t.Fatal("DisableTwoFactor checked the local hash of a directory account")
+	}
+	if !totpEnabled(t, db, userID) {
+		t.Fatal("2FA was disabled")
+	}
+}
@@ -0,0 +1,204 @@
+package services
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"strings"
+	"ai-doc-system/internal/models"
+)
+
+// UserQueryOptions filter the admin user list, zero values match everything
+type UserQueryOptions struct {
+	Search     string // Part of the username or email
+	Role       string
+	Status     string // active, disabled, deletion_scheduled, password_reset_required
+	AuthSource string
+	Sort       string // created_at (newest first, the default), username, last_active, storage
+	Limit      int
+	Offset     int
+}
+
+var userSortOrders = map[string]string{
+	"created_at":  "u.created_at DESC, u.id DESC",
+	"username":    "u.username, u.id",
+	"last_active": "last_active_at DESC NULLS LAST, u.id",
+	"storage":     "storage_used DESC, u.id",
+}
+
+var userStatusConditions = map[string]string{
+	"active":                  "u.disabled_at IS NULL",
+	"disabled":                "u.disabled_at IS NOT NULL",
+	"deletion_scheduled":      "u.deletion_scheduled_at IS NOT NULL",
+	"password_reset_required": "u.password_reset_required",
+}
+
+const adminUserColumns = `
+	SELECT u.id, u.username, u.role, u.email, u.email_notifications, u.discoverable, u.avatar, u.profile,
+		u.created_at, u.updated_at, u.auth_source, u.totp_enabled, u.disabled_at, COALESCE(u.disabled_reason, ''),
+		u.password_reset_required, u.deletion_scheduled_at,
+		(SELECT COALESCE(SUM(f.file_size), 0) FROM files f WHERE f.user_id = u.id) AS storage_used,
+		(SELECT MAX(s.last_used_at) FROM sessions s WHERE s.user_id = u.id) AS last_active_at
+	FROM users u`
+
+// UserAdminService lists users with their account details and disables or enables them
+type UserAdminService struct {
+	db             *sql.DB
+	sessionService *SessionService
+}
+
+func NewUserAdminService(db *sql.DB, sessionService *SessionService) *UserAdminService {
+	return &UserAdminService{db: db, sessionService: sessionService}
+}
+
+// Get a page of users matching the filters
+func (s *UserAdminService) GetUsers(opts UserQueryOptions) (*models.AdminUserPage, error) {
+	order, ok := userSortOrders[opts.Sort]
+	if opts.Sort == "" {
+		order, ok = userSortOrders["created_at"], true
+	}
+	if !ok {
+		return nil, errors.New("invalid sort")
+	}
+
+	var conditions []string
+	var args []interface{}
+	addCondition := func(condition string, value interface{}) {
+		args = append(args, value)
+		conditions = append(conditions, fmt.Sprintf(condition, len(args)))
+	}
+	if search := strings.TrimSpace(opts.Search); search != "" {
+		// LIKE wildcards typed by the admin match literally
+		pattern := "%" + strings.NewReplacer(`\`, `\\`, "%", `\%`, "_", `\_`).Replace(search) + "%"
+		addCondition("(u.username ILIKE $%[1]d OR u.email ILIKE $%[1]d)", pattern)
+	}
+	if opts.Role != "" {
+		addCondition("u.role = $%d", opts.Role)
+	}
+	if opts.AuthSource != "" {
+		addCondition("u.auth_source = $%d", opts.AuthSource)
+	}
+	if opts.Status != "" {
+		condition, ok := userStatusConditions[opts.Status]
+		if !ok {
+			return nil, errors.New("invalid status")
+		}
+		conditions = append(conditions, condition)
+	}
+	where := ""
+	if len(conditions) > 0 {
+		where = "WHERE " + strings.Join(conditions, " AND ")
+	}
+
+	page := &models.AdminUserPage{Users: []models.AdminUser{}}
+	err := s.db.QueryRow("SELECT COUNT(*) FROM users u "+where, args...).Scan(&page.Total)
+	if err != nil {
+		return nil, err
+	}
+
+	args = append(args, opts.Limit, opts.Offset)
+	rows, err := s.db.Query(fmt.Sprintf(`%s
+		%s
+		ORDER BY %s
+		LIMIT $%d OFFSET $%d`,
+		adminUserColumns, where, order, len(args)-1, len(args)), args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		user, err := scanAdminUser(rows)
+		if err != nil {
+			return nil, err
+		}
+		page.Users = append(page.Users, *user)
+	}
+	return page, rows.Err()
+}
+
+// Get one user with their account details
+func (s *UserAdminService) GetUser(userID int) (*models.AdminUser, error) {
+	user, err := scanAdminUser(s.db.QueryRow(adminUserColumns+" WHERE u.id = $1", userID))
+	if err == sql.ErrNoRows {
+		return nil, errors.New("user not found")
+	}
+	return user, err
+}
+
+func scanAdminUser(row rowScanner) (*models.AdminUser, error) {
+	var user models.AdminUser
+	err := row.Scan(&user.ID, &user.Username, &user.Role, &user.Email, &user.EmailNotifications, &user.Discoverable,
+		&user.Avatar, &user.Profile, &user.CreatedAt, &user.UpdatedAt, &user.AuthSource, &user.TOTPEnabled,
+		&user.DisabledAt, &user.DisabledReason, &user.PasswordResetRequired, &user.DeletionScheduledAt,
+		&user.StorageUsed, &user.LastActiveAt)
+	if err != nil {
+		return nil, err
+	}
+	return &user, nil
+}
+
+// Disable a user's account: logins are refused and all sessions end, so their tokens stop
+// working right away. Admins cannot disable their own account, users whose role has
+// permissions the actor's lacks, or the last admin.
+func (s *UserAdminService) DisableUser(actorID, userID int) error {
+	if actorID == userID {
+		return errors.New("you cannot disable your own account")
+	}
+
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if err := checkCanManageUser(tx, actorID, userID); err != nil {
+		return err
+	}
+
+	result, err := tx.Exec(`
+		UPDATE users SET disabled_at = CURRENT_TIMESTAMP, disabled_reason = 'admin', updated_at = CURRENT_TIMESTAMP
+		WHERE id = $1 AND disabled_at IS NULL`,
+		userID)
+	if err != nil {
+		return err
+	}
+	if affected, _ := result.RowsAffected(); affected == 0 {
+		return errors.New("user is already disabled")
+	}
+
+	if err := tx.Commit(); err != nil {
+		return err
+	}
+
+	_, err = s.sessionService.RevokeAllSessions(userID, "", "account_disabled")
+	return err
+}
+
+// Enable a disabled account. Directory users the directory no longer has are disabled
+// again by the next sync.
+func (s *UserAdminService) EnableUser(userID int) error {
+	result, err := s.db.Exec(`
+		UPDATE users SET disabled_at = NULL, disabled_reason = NULL, updated_at = CURRENT_TIMESTAMP
+		WHERE id = $1 AND disabled_at IS NOT NULL`,
+		userID)
+	if err != nil {
+		return err
+	}
+	if affected, _ := result.RowsAffected(); affected == 0 {
+		return s.missingOr(userID, "user is not disabled")
+	}
+	return nil
+}
+
+// Error for an update that matched nothing: the user does not exist, or message
+func (s *UserAdminService) missingOr(userID int, message string) error {
+	var exists bool
+	if err := s.db.QueryRow("SELECT EXISTS (SELECT 1 FROM users WHERE id = $1)", userID).Scan(&exists); err != nil {
+		return err
+	}
+	if !exists {
+		return errors.New("user not found")
+	}
+	return errors.New(message)
+}
@@ -7,15 +7,38 @@ import (
 	"ai-doc-system/internal/utils"
 )
 
+// ErrInvalidCredentials is returned for unknown usernames and wrong passwords alike, so
+// logins do not reveal which usernames exist
+var ErrInvalidCredentials = errors.New("invalid username or password")
+
+// Logins refused for the account's state, their messages are shown to the user
+var (
+	ErrAccountDisabled       = errors.New("account is disabled")
+	ErrPasswordResetRequired = errors.New("password reset required, choose a new password with the reset link")
+)
+
+// Compared against when there is no password to check, so those logins take as long as others
+var dummyPasswordHash, _ = utils.HashPassword("no password to check")
+
 type UserService struct {
-	db *sql.DB
+	db             *sql.DB
+	passwordPolicy utils.PasswordPolicy
+	ldapService    *LDAPService // Checks passwords of directory users
 }
 
-func NewUserService(db *sql.DB) *UserService {
-	return &UserService{db: db}
+func NewUserService(db *sql.DB, passwordPolicy utils.PasswordPolicy, ldapService *LDAPService) *UserService {
+	return &UserService{db: db, passwordPolicy: passwordPolicy, ldapService: ldapService}
 }
 
-func (s *UserService) Register(username, password string) (*models.User, error) {
+func (s *UserService) PasswordPolicy() utils.PasswordPolicy {
+	return s.passwordPolicy
+}
+
+func (s *UserService) Register(username, password, email string) (*models.User, error) {
+	if err := s.passwordPolicy.Validate(password); err != nil {
+		return nil, err
+	}
+	
 	// Check if username already exists
 	var count int
 	err := s.db.QueryRow("SELECT COUNT(*) FROM users WHERE username = $1", username).Scan(&count)
@@ -26,6 +49,17 @@ func (s *UserService) Register(username, password string) (*models.User, error)
 		return nil, errors.New("username already exists")
 	}
 	
+	// Check if email is already used, it is optional
+	if email != "" {
+		err = s.db.QueryRow("SELECT COUNT(*) FROM users WHERE LOWER(email) = LOWER($1)", email).Scan(&count)
+		if err != nil {
+			return nil, err
+		}
+		if count > 0 {
+			return nil, errors.New("email already in use")
+		}
+	}
+	
 	// Hash password
 	hashedPassword, err := utils.HashPassword(password)
 	if err != nil {
@@ -35,11 +69,11 @@ func (s *UserService) Register(username, password string) (*models.User, error)
 	// Create user
 	var user models.User
 	err = s.db.QueryRow(`
-		INSERT INTO users (username, password_hash, role) 
-		VALUES ($1, $2, 'user') 
-		RETURNING id, username, role, created_at, updated_at`,
-		username, hashedPassword).Scan(
-		&user.ID, &user.Username, &user.Role, &user.CreatedAt, &user.UpdatedAt)
+		INSERT INTO users (username, password_hash, role, email, password_changed_at) 
+		VALUES ($1, $2, 'user', NULLIF($3, ''), CURRENT_TIMESTAMP) 
+		RETURNING id, username, role, email, email_notifications, discoverable, created_at, updated_at`,
+		username, hashedPassword, email).Scan(
+		&user.ID, &user.Username, &user.Role, &user.Email, &user.EmailNotifications, &user.Discoverable, &user.CreatedAt, &user.UpdatedAt)
 	
 	if err != nil {
 		return nil, err
@@ -48,37 +82,71 @@ func (s *UserService) Register(username, password string) (*models.User, error)
 	return &user, nil
 }
 
+// Log in with a local password, or against the directory for directory users and
+// usernames without a local account. Local accounts keep working when a directory is
+// configured, so the seeded admin can always log in.
 func (s *UserService) Login(username, password string) (*models.User, error) {
 	var user models.User
-	var hashedPassword string
+	var hashedPassword, authSource string
+	var disabled, resetRequired bool
 	
 	err := s.db.QueryRow(`
-		SELECT id, username, password_hash, role, avatar, profile, created_at, updated_at 
+		SELECT id, username, password_hash, role, email, email_notifications, discoverable, avatar, profile, created_at, updated_at, 
+			auth_source, disabled_at IS NOT NULL, password_reset_required 
 		FROM users WHERE username = $1`, username).Scan(
-		&user.ID, &user.Username, &hashedPassword, &user.Role, 
-		&user.Avatar, &user.Profile, &user.CreatedAt, &user.UpdatedAt)
+		&user.ID, &user.Username, &hashedPassword, &user.Role, &user.Email, &user.EmailNotifications, &user.Discoverable,
+		&user.Avatar, &user.Profile, &user.CreatedAt, &user.UpdatedAt, &authSource, &disabled, &resetRequired)
 	
 	if err != nil {
 		if err == sql.ErrNoRows {
-			return nil, errors.New("user not found")
+			if s.ldapService.Enabled() {
+				return s.directoryLogin(username, password)
+			}
+			utils.CheckPassword(password, dummyPasswordHash)
+			return nil, ErrInvalidCredentials
 		}
 		return nil, err
 	}
 	
+	if authSource == "ldap" {
+		return s.directoryLogin(username, password)
+	}
+	
+	// Accounts without a local password only log in with single sign-on
+	if hashedPassword == "" {
+		utils.CheckPassword(password, dummyPasswordHash)
+		return nil, ErrInvalidCredentials
+	}
 	if !utils.CheckPassword(password, hashedPassword) {
-		return nil, errors.New("invalid password")
+		return nil, ErrInvalidCredentials
+	}
+	
+	// Only told after the password matched, or disabled accounts could be probed for
+	if disabled {
+		return nil, ErrAccountDisabled
+	}
+	if resetRequired {
+		return nil, ErrPasswordResetRequired
 	}
 	
 	return &user, nil
 }
 
+func (s *UserService) directoryLogin(username, password string) (*models.User, error) {
+	userID, err := s.ldapService.Login(username, password)
+	if err != nil {
+		return nil, err
+	}
+	return s.GetUserByID(userID)
+}
+
 func (s *UserService) GetUserByID(userID int) (*models.User, error) {
 	var user models.User
 	
 	err := s.db.QueryRow(`
-		SELECT id, username, role, avatar, profile, created_at, updated_at 
+		SELECT id, username, role, email, email_notifications, discoverable, avatar, profile, created_at, updated_at 
 		FROM users WHERE id = $1`, userID).Scan(
-		&user.ID, &user.Username, &user.Role, &user.Avatar, 
+		&user.ID, &user.Username, &user.Role, &user.Email, &user.EmailNotifications, &user.Discoverable, &user.Avatar, 
 		&user.Profile, &user.CreatedAt, &user.UpdatedAt)
 	
 	if err != nil {
@@ -98,29 +166,94 @@ func (s *UserService) UpdateProfile(userID int, avatar, profile string) error {
 	return err
 }
 
-func (s *UserService) GetAllUsers() ([]models.User, error) {
-	rows, err := s.db.Query(`
-		SELECT id, username, role, avatar, profile, created_at, updated_at 
-		FROM users ORDER BY created_at DESC`)
+// Change the user's password after checking the current one.
+// Pending password reset links stop working.
+func (s *UserService) ChangePassword(userID int, currentPassword, newPassword string) error {
+	var hashedPassword, authSource string
+	err := s.db.QueryRow("SELECT password_hash, auth_source FROM users WHERE id = $1", userID).Scan(&hashedPassword, &authSource)
 	if err != nil {
-		return nil, err
+		if err == sql.ErrNoRows {
+			return errors.New("user not found")
+		}
+		return err
+	}
+	if authSource != "local" {
+		return errors.New("your password is managed by the directory")
 	}
-	defer rows.Close()
 	
-	var users []models.User
-	for rows.Next() {
-		var user models.User
-		err := rows.Scan(&user.ID, &user.Username, &user.Role, 
-			&user.Avatar, &user.Profile, &user.CreatedAt, &user.UpdatedAt)
-		if err != nil {
-			return nil, err
+	if !utils.CheckPassword(currentPassword, hashedPassword) {
+		return errors.New("current password is incorrect")
+	}
+	if currentPassword == newPassword {
+		return errors.New("new password must be different from the current one")
+	}
+	if err := s.passwordPolicy.Validate(newPassword); err != nil {
+		return err
+	}
+	
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	
+	if err := setPassword(tx, userID, newPassword); err != nil {
+		return err
+	}
+	
+	return tx.Commit()
+}
+
+// Store a new password hash and invalidate the user's unused reset tokens
+func setPassword(tx *sql.Tx, userID int, password string) error {
+	hashedPassword, err := utils.HashPassword(password)
+	if err != nil {
+		return err
+	}
+	
+	_, err = tx.Exec(`
+		UPDATE users SET password_hash = $1, password_changed_at = CURRENT_TIMESTAMP, password_reset_required = FALSE, 
+			updated_at = CURRENT_TIMESTAMP 
+		WHERE id = $2`, hashedPassword, userID)
+	if err != nil {
+		return err
+	}
+	
+	_, err = tx.Exec("UPDATE password_reset_tokens SET used_at = CURRENT_TIMESTAMP WHERE user_id = $1 AND used_at IS NULL", userID)
+	return err
+}
+
+// Check the user's password before a sensitive action. Accounts that only log in with
+// single sign-on have no password, for them there is nothing to check.
+func (s *UserService) VerifyPassword(userID int, password string) error {
+	var username, hashedPassword, authSource string
+	err := s.db.QueryRow("SELECT username, password_hash, auth_source FROM users WHERE id = $1", userID).Scan(
+		&username, &hashedPassword, &authSource)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return errors.New("user not found")
 		}
-		users = append(users, user)
+		return err
 	}
 	
-	if err = rows.Err(); err != nil {
-		return nil, err
+	if authSource == "ldap" {
+		directoryUserID, err := s.ldapService.Login(username, password)
+		if err == ErrInvalidCredentials || (err == nil && directoryUserID != userID) {
+			return errors.New("password is incorrect")
+		}
+		return err
 	}
 	
-	return users, nil
+	if hashedPassword != "" && !utils.CheckPassword(password, hashedPassword) {
+		return errors.New("password is incorrect")
+	}
+	return nil
+}
+
+// Set whether the user shows up in friend suggestions and fuzzy user search
+func (s *UserService) SetDiscoverable(userID int, discoverable bool) error {
+	_, err := s.db.Exec(`
+		UPDATE users SET discoverable = $1, updated_at = CURRENT_TIMESTAMP 
+		WHERE id = $2`, discoverable, userID)
+	return err
 }
\ No newline at end of file
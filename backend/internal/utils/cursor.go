@@ -0,0 +1,35 @@
+package utils
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+)
+
+// Cursor marks a position in a keyset paginated listing: the sort key value
+// of a row and its ID as tie breaker. Clients treat it as an opaque string.
+type Cursor struct {
+	Sort  string `json:"s,omitempty"` // Sort option the cursor was created for
+	Value string `json:"v,omitempty"`
+	ID    int    `json:"id"`
+}
+
+var ErrInvalidCursor = errors.New("invalid cursor")
+
+func EncodeCursor(cursor Cursor) string {
+	data, _ := json.Marshal(cursor)
+	return base64.RawURLEncoding.EncodeToString(data)
+}
+
+// DecodeCursor parses a cursor and checks it belongs to the given sort option
+func DecodeCursor(encoded, sort string) (Cursor, error) {
+	var cursor Cursor
+	data, err := base64.RawURLEncoding.DecodeString(encoded)
+	if err != nil {
+		return cursor, ErrInvalidCursor
+	}
+	if err := json.Unmarshal(data, &cursor); err != nil || cursor.ID <= 0 || cursor.Sort != sort {
+		return cursor, ErrInvalidCursor
+	}
+	return cursor, nil
+}
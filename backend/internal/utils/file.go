@@ -2,10 +2,12 @@ package utils
 
 import (
 	"crypto/md5"
+	"errors"
 	"fmt"
 	"io"
 	"mime/multipart"
 	"os"
+	"path"
 	"path/filepath"
 	"strings"
 	"time"
@@ -84,4 +86,31 @@ func DeleteFile(filePath string) error {
 func FileExists(filePath string) bool {
 	_, err := os.Stat(filePath)
 	return !os.IsNotExist(err)
+}
+
+// CleanFolderPath normalizes a user supplied folder path such as "/clients/acme/"
+// into "clients/acme". The empty string denotes the root folder.
+func CleanFolderPath(folder string) (string, error) {
+	folder = strings.TrimSpace(strings.ReplaceAll(folder, "\\", "/"))
+	if folder == "" {
+		return "", nil
+	}
+	
+	for _, part := range strings.Split(folder, "/") {
+		if part == ".." {
+			return "", errors.New("invalid folder path")
+		}
+	}
+	
+	cleaned := strings.Trim(path.Clean("/"+folder), "/")
+	if len(cleaned) > 255 {
+		return "", errors.New("folder path too long")
+	}
+	
+	return cleaned, nil
+}
+
+// FileExtension returns the lower-case extension of a file name without the leading dot
+func FileExtension(fileName string) string {
+	return strings.ToLower(strings.TrimPrefix(filepath.Ext(fileName), "."))
 }
\ No newline at end of file
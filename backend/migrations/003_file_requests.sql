@@ -0,0 +1,48 @@
+-- Add folder column to files table (slash separated path, empty for root)
+ALTER TABLE files ADD COLUMN IF NOT EXISTS folder VARCHAR(255) NOT NULL DEFAULT '';
+
+-- Create file requests table (upload-only links)
+CREATE TABLE IF NOT EXISTS file_requests (
+    id SERIAL PRIMARY KEY,
+    user_id INTEGER REFERENCES users(id) ON DELETE CASCADE,
+    title VARCHAR(255) NOT NULL,
+    description TEXT,
+    folder VARCHAR(255) NOT NULL DEFAULT '',
+    request_token VARCHAR(255) UNIQUE NOT NULL,
+    password_hash VARCHAR(255),
+    max_file_size BIGINT,
+    max_files INTEGER,
+    allowed_extensions VARCHAR(255) NOT NULL DEFAULT '',
+    upload_count INTEGER NOT NULL DEFAULT 0,
+    is_active BOOLEAN NOT NULL DEFAULT TRUE,
+    expires_at TIMESTAMP,
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+);
+
+-- Create file request uploads table
+CREATE TABLE IF NOT EXISTS file_request_uploads (
+    id SERIAL PRIMARY KEY,
+    request_id INTEGER REFERENCES file_requests(id) ON DELETE CASCADE,
+    file_id INTEGER REFERENCES files(id) ON DELETE SET NULL,
+    uploader_name VARCHAR(100),
+    uploader_ip VARCHAR(64),
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+);
+
+-- Create notifications table
+CREATE TABLE IF NOT EXISTS notifications (
+    id SERIAL PRIMARY KEY,
+    user_id INTEGER REFERENCES users(id) ON DELETE CASCADE,
+    type VARCHAR(50) NOT NULL,
+    title VARCHAR(255) NOT NULL,
+    content TEXT,
+    is_read BOOLEAN NOT NULL DEFAULT FALSE,
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+);
+
+-- Create indexes
+CREATE INDEX IF NOT EXISTS idx_files_user_folder ON files(user_id, folder);
+CREATE INDEX IF NOT EXISTS idx_file_requests_user_id ON file_requests(user_id);
+CREATE INDEX IF NOT EXISTS idx_file_requests_token ON file_requests(request_token);
+CREATE INDEX IF NOT EXISTS idx_file_request_uploads_request_id ON file_request_uploads(request_id);
+CREATE INDEX IF NOT EXISTS idx_notifications_user_id ON notifications(user_id);
@@ -0,0 +1,19 @@
+-- Create file ownership transfers table
+-- A transfer covers a single file (file_id), a folder subtree (folder) or, for admin bulk transfers, every file of the user (both NULL)
+CREATE TABLE IF NOT EXISTS file_transfers (
+    id SERIAL PRIMARY KEY,
+    file_id INTEGER REFERENCES files(id) ON DELETE CASCADE,
+    folder VARCHAR(255),
+    from_user_id INTEGER REFERENCES users(id) ON DELETE CASCADE,
+    to_user_id INTEGER REFERENCES users(id) ON DELETE CASCADE,
+    offered_by INTEGER REFERENCES users(id) ON DELETE SET NULL,
+    status VARCHAR(20) NOT NULL DEFAULT 'pending',
+    file_count INTEGER NOT NULL DEFAULT 0,
+    total_size BIGINT NOT NULL DEFAULT 0,
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    responded_at TIMESTAMP
+);
+
+-- Create indexes
+CREATE INDEX IF NOT EXISTS idx_file_transfers_from_user_id ON file_transfers(from_user_id);
+CREATE INDEX IF NOT EXISTS idx_file_transfers_to_user_id ON file_transfers(to_user_id);
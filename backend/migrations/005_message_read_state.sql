@@ -0,0 +1,8 @@
+-- Add read state to messages, NULL while unread
+ALTER TABLE messages ADD COLUMN IF NOT EXISTS read_at TIMESTAMP;
+
+-- Messages sent before read tracking existed are considered read
+UPDATE messages SET read_at = created_at WHERE read_at IS NULL;
+
+-- Create index for unread lookups
+CREATE INDEX IF NOT EXISTS idx_messages_unread ON messages(receiver_id, sender_id) WHERE read_at IS NULL;
@@ -0,0 +1,58 @@
+-- Create conversations table (direct conversations have exactly two members)
+CREATE TABLE IF NOT EXISTS conversations (
+    id SERIAL PRIMARY KEY,
+    type VARCHAR(20) NOT NULL DEFAULT 'direct',
+    name VARCHAR(100),
+    direct_key VARCHAR(50) UNIQUE, -- "<smaller user id>:<larger user id>" for direct conversations
+    created_by INTEGER REFERENCES users(id) ON DELETE SET NULL,
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+);
+
+-- Create conversation members table
+CREATE TABLE IF NOT EXISTS conversation_members (
+    conversation_id INTEGER REFERENCES conversations(id) ON DELETE CASCADE,
+    user_id INTEGER REFERENCES users(id) ON DELETE CASCADE,
+    role VARCHAR(20) NOT NULL DEFAULT 'member', -- owner, admin, member
+    last_read_message_id INTEGER NOT NULL DEFAULT 0,
+    joined_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    PRIMARY KEY (conversation_id, user_id)
+);
+
+-- Link messages to conversations, receiver_id stays set for direct messages only
+ALTER TABLE messages ADD COLUMN IF NOT EXISTS conversation_id INTEGER REFERENCES conversations(id) ON DELETE CASCADE;
+
+-- Migrate existing direct messages into two-person conversations
+INSERT INTO conversations (type, direct_key, created_at, updated_at)
+SELECT 'direct',
+       LEAST(sender_id, receiver_id) || ':' || GREATEST(sender_id, receiver_id),
+       MIN(created_at), MAX(created_at)
+FROM messages
+WHERE conversation_id IS NULL AND sender_id IS NOT NULL AND receiver_id IS NOT NULL
+GROUP BY LEAST(sender_id, receiver_id), GREATEST(sender_id, receiver_id)
+ON CONFLICT (direct_key) DO NOTHING;
+
+UPDATE messages m SET conversation_id = c.id
+FROM conversations c
+WHERE m.conversation_id IS NULL
+AND c.direct_key = LEAST(m.sender_id, m.receiver_id) || ':' || GREATEST(m.sender_id, m.receiver_id);
+
+-- Each member has read everything before their oldest unread message
+INSERT INTO conversation_members (conversation_id, user_id, last_read_message_id, joined_at)
+SELECT c.id, u.user_id,
+       COALESCE(
+           (SELECT MIN(m.id) - 1 FROM messages m
+            WHERE m.conversation_id = c.id AND m.receiver_id = u.user_id AND m.read_at IS NULL),
+           (SELECT MAX(m.id) FROM messages m WHERE m.conversation_id = c.id),
+           0),
+       c.created_at
+FROM conversations c
+CROSS JOIN LATERAL (
+    VALUES (split_part(c.direct_key, ':', 1)::INTEGER), (split_part(c.direct_key, ':', 2)::INTEGER)
+) AS u(user_id)
+WHERE c.type = 'direct'
+ON CONFLICT (conversation_id, user_id) DO NOTHING;
+
+-- Create indexes
+CREATE INDEX IF NOT EXISTS idx_messages_conversation_id ON messages(conversation_id, id);
+CREATE INDEX IF NOT EXISTS idx_conversation_members_user_id ON conversation_members(user_id);
@@ -0,0 +1,9 @@
+-- File attachments in messages, file_id becomes NULL when the file is deleted
+ALTER TABLE messages ADD COLUMN IF NOT EXISTS file_id INTEGER REFERENCES files(id) ON DELETE SET NULL;
+
+-- Friend shares created by sending a file in a conversation
+ALTER TABLE file_shares ADD COLUMN IF NOT EXISTS message_id INTEGER REFERENCES messages(id) ON DELETE SET NULL;
+
+-- Create indexes
+CREATE INDEX IF NOT EXISTS idx_file_shares_shared_with ON file_shares(shared_with);
+CREATE INDEX IF NOT EXISTS idx_file_shares_message_id ON file_shares(message_id);
@@ -0,0 +1,17 @@
+-- Related object IDs of a notification, e.g. {"file_id": 1}
+ALTER TABLE notifications ADD COLUMN IF NOT EXISTS data JSONB;
+
+-- Create notification preferences table, a missing row means the type is enabled
+CREATE TABLE IF NOT EXISTS notification_preferences (
+    user_id INTEGER REFERENCES users(id) ON DELETE CASCADE,
+    type VARCHAR(50) NOT NULL,
+    enabled BOOLEAN NOT NULL DEFAULT TRUE,
+    PRIMARY KEY (user_id, type)
+);
+
+-- Remember which expiring shares the owner was already told about
+ALTER TABLE file_shares ADD COLUMN IF NOT EXISTS expiry_notified_at TIMESTAMP;
+
+-- Create indexes
+CREATE INDEX IF NOT EXISTS idx_notifications_user_unread ON notifications(user_id) WHERE is_read = FALSE;
+CREATE INDEX IF NOT EXISTS idx_file_shares_expires_at ON file_shares(expires_at) WHERE expires_at IS NOT NULL;
@@ -0,0 +1,25 @@
+-- Email address and email notification settings
+-- email_notifications: instant (one email per notification), digest (daily summary) or off
+ALTER TABLE users ADD COLUMN IF NOT EXISTS email VARCHAR(255);
+ALTER TABLE users ADD COLUMN IF NOT EXISTS email_notifications VARCHAR(10) NOT NULL DEFAULT 'instant';
+ALTER TABLE users ADD COLUMN IF NOT EXISTS last_digest_at TIMESTAMP;
+
+CREATE UNIQUE INDEX IF NOT EXISTS idx_users_email ON users (LOWER(email));
+
+-- Outgoing emails, delivered and retried by a background worker
+CREATE TABLE IF NOT EXISTS email_queue (
+    id SERIAL PRIMARY KEY,
+    user_id INTEGER REFERENCES users(id) ON DELETE CASCADE,
+    notification_id INTEGER REFERENCES notifications(id) ON DELETE CASCADE, -- Dropped when the notification is read before delivery
+    to_address VARCHAR(255) NOT NULL,
+    subject VARCHAR(255) NOT NULL,
+    body TEXT NOT NULL,
+    attempts INTEGER NOT NULL DEFAULT 0,
+    next_attempt_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    last_error TEXT,
+    sent_at TIMESTAMP,
+    failed_at TIMESTAMP, -- Set after the last attempt failed
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE INDEX IF NOT EXISTS idx_email_queue_pending ON email_queue(next_attempt_at) WHERE sent_at IS NULL AND failed_at IS NULL;
@@ -0,0 +1,5 @@
+-- User-defined order of friend groups
+ALTER TABLE friend_groups ADD COLUMN IF NOT EXISTS position INTEGER NOT NULL DEFAULT 0;
+
+CREATE INDEX IF NOT EXISTS idx_friend_groups_user_id ON friend_groups(user_id);
+CREATE INDEX IF NOT EXISTS idx_friendships_group_id ON friendships(group_id);
@@ -0,0 +1,10 @@
+-- Users who are not discoverable are left out of friend suggestions and fuzzy user search
+ALTER TABLE users ADD COLUMN IF NOT EXISTS discoverable BOOLEAN NOT NULL DEFAULT TRUE;
+
+-- Create dismissed friend suggestions table
+CREATE TABLE IF NOT EXISTS dismissed_suggestions (
+    user_id INTEGER REFERENCES users(id) ON DELETE CASCADE,
+    suggested_user_id INTEGER REFERENCES users(id) ON DELETE CASCADE,
+    dismissed_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    PRIMARY KEY (user_id, suggested_user_id)
+);
@@ -0,0 +1,9 @@
+-- IDs of redeemed one-time file access tokens, rows can be dropped once the token has expired
+CREATE TABLE IF NOT EXISTS used_file_tokens (
+    jti UUID PRIMARY KEY,
+    expires_at TIMESTAMP NOT NULL,
+    used_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+);
+
+-- Create indexes
+CREATE INDEX IF NOT EXISTS idx_used_file_tokens_expires_at ON used_file_tokens(expires_at);
@@ -0,0 +1,30 @@
+-- Accounts at external identity providers linked to users
+CREATE TABLE IF NOT EXISTS user_identities (
+    id SERIAL PRIMARY KEY,
+    user_id INTEGER REFERENCES users(id) ON DELETE CASCADE,
+    issuer VARCHAR(255) NOT NULL,
+    subject VARCHAR(255) NOT NULL,
+    email VARCHAR(255),
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    last_login_at TIMESTAMP,
+    UNIQUE(issuer, subject)
+);
+
+-- OpenID Connect logins in progress: nonce and PKCE verifier per state, then a
+-- single-use handoff code the frontend exchanges for tokens
+CREATE TABLE IF NOT EXISTS oidc_login_states (
+    id SERIAL PRIMARY KEY,
+    state_hash VARCHAR(64) UNIQUE NOT NULL,
+    nonce VARCHAR(64) NOT NULL,
+    code_verifier VARCHAR(128) NOT NULL,
+    link_user_id INTEGER REFERENCES users(id) ON DELETE CASCADE, -- Set when a logged-in user links the identity
+    user_id INTEGER REFERENCES users(id) ON DELETE CASCADE, -- User the login resolved to
+    handoff_hash VARCHAR(64) UNIQUE,
+    expires_at TIMESTAMP NOT NULL,
+    completed_at TIMESTAMP,
+    used_at TIMESTAMP,
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+);
+
+-- Create indexes
+CREATE INDEX IF NOT EXISTS idx_user_identities_user_id ON user_identities(user_id);
@@ -0,0 +1,11 @@
+-- Where a user's password is checked: 'local' (bcrypt) or 'ldap' (bind against the directory)
+ALTER TABLE users ADD COLUMN IF NOT EXISTS auth_source VARCHAR(20) NOT NULL DEFAULT 'local';
+ALTER TABLE users ADD COLUMN IF NOT EXISTS external_id VARCHAR(512); -- DN of directory users
+ALTER TABLE users ADD COLUMN IF NOT EXISTS directory_synced_at TIMESTAMP;
+
+-- Disabled users cannot log in; the reason tells the directory sync which accounts it may enable again
+ALTER TABLE users ADD COLUMN IF NOT EXISTS disabled_at TIMESTAMP;
+ALTER TABLE users ADD COLUMN IF NOT EXISTS disabled_reason VARCHAR(50);
+
+-- Create indexes
+CREATE INDEX IF NOT EXISTS idx_users_auth_source ON users(auth_source);
@@ -0,0 +1,16 @@
+-- Personal access tokens for scripts and integrations, stored hashed and limited to their scopes
+CREATE TABLE IF NOT EXISTS api_tokens (
+    id SERIAL PRIMARY KEY,
+    user_id INTEGER REFERENCES users(id) ON DELETE CASCADE,
+    name VARCHAR(100) NOT NULL,
+    token_hash VARCHAR(64) UNIQUE NOT NULL,
+    token_prefix VARCHAR(16) NOT NULL, -- Start of the token, shown to tell tokens apart
+    scopes TEXT[] NOT NULL DEFAULT '{}',
+    expires_at TIMESTAMP, -- Never expires when null
+    last_used_at TIMESTAMP,
+    revoked_at TIMESTAMP,
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+);
+
+-- Create indexes
+CREATE INDEX IF NOT EXISTS idx_api_tokens_user_id ON api_tokens(user_id);
@@ -0,0 +1,64 @@
+-- Roles and the permissions they grant; users.role names one of these roles
+CREATE TABLE IF NOT EXISTS roles (
+    name VARCHAR(20) PRIMARY KEY,
+    description VARCHAR(255) NOT NULL DEFAULT '',
+    builtin BOOLEAN NOT NULL DEFAULT FALSE, -- Shipped roles cannot be deleted, admin cannot be edited
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE TABLE IF NOT EXISTS role_permissions (
+    role VARCHAR(20) REFERENCES roles(name) ON DELETE CASCADE,
+    permission VARCHAR(50) NOT NULL,
+    PRIMARY KEY (role, permission)
+);
+
+INSERT INTO roles (name, description, builtin) VALUES
+    ('admin', 'Full access to administration', TRUE),
+    ('user', 'Regular account without administration access', TRUE),
+    ('auditor', 'Reads the audit log and the user list', TRUE),
+    ('support', 'Views users but not their files', TRUE),
+    ('quota-manager', 'Changes users'' storage quotas', TRUE)
+ON CONFLICT (name) DO NOTHING;
+
+INSERT INTO role_permissions (role, permission) VALUES
+    ('admin', 'users:read'),
+    ('admin', 'roles:manage'),
+    ('admin', 'files:read_all'),
+    ('admin', 'files:transfer_all'),
+    ('admin', 'quotas:manage'),
+    ('admin', 'audit:read'),
+    ('admin', 'settings:manage'),
+    ('auditor', 'users:read'),
+    ('auditor', 'audit:read'),
+    ('support', 'users:read'),
+    ('quota-manager', 'users:read'),
+    ('quota-manager', 'quotas:manage')
+ON CONFLICT DO NOTHING;
+
+-- Roles already given to users, e.g. by single sign-on role mappings, keep working without permissions
+INSERT INTO roles (name)
+SELECT DISTINCT role FROM users WHERE role IS NOT NULL
+ON CONFLICT (name) DO NOTHING;
+
+-- Storage quota in bytes, the default limit applies when null
+ALTER TABLE users ADD COLUMN IF NOT EXISTS storage_quota BIGINT;
+
+-- Administrative actions, readable with the audit:read permission
+CREATE TABLE IF NOT EXISTS audit_log (
+    id SERIAL PRIMARY KEY,
+    actor_id INTEGER REFERENCES users(id) ON DELETE SET NULL,
+    actor_username VARCHAR(50) NOT NULL DEFAULT '', -- Kept when the actor's account is deleted
+    action VARCHAR(50) NOT NULL, -- e.g. role.update, user.role
+    target_type VARCHAR(20) NOT NULL DEFAULT '', -- user, role, setting
+    target_id VARCHAR(100) NOT NULL DEFAULT '',
+    details JSONB,
+    ip_address VARCHAR(45),
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+);
+
+-- Create indexes
+CREATE INDEX IF NOT EXISTS idx_users_role ON users(role);
+CREATE INDEX IF NOT EXISTS idx_audit_log_created_at ON audit_log(created_at);
+CREATE INDEX IF NOT EXISTS idx_audit_log_actor_id ON audit_log(actor_id);
+CREATE INDEX IF NOT EXISTS idx_audit_log_target ON audit_log(target_type, target_id);
@@ -0,0 +1,20 @@
+-- Failed password logins per account and per IP address. Accounts are tracked by the
+-- username tried, so unknown usernames are throttled the same way as real ones.
+CREATE TABLE IF NOT EXISTS login_throttles (
+    id SERIAL PRIMARY KEY,
+    scope VARCHAR(10) NOT NULL, -- account, ip
+    identifier VARCHAR(100) NOT NULL, -- Lowercased username or IP address
+    failures INTEGER NOT NULL DEFAULT 0, -- Consecutive failures, reset by a successful login or after a quiet period
+    last_failure_at TIMESTAMP,
+    locked_until TIMESTAMP, -- Logins are refused until then
+    UNIQUE (scope, identifier)
+);
+
+-- Admins can lift lockouts
+INSERT INTO role_permissions (role, permission) VALUES ('admin', 'users:manage')
+ON CONFLICT DO NOTHING;
+
+-- Create indexes
+CREATE INDEX IF NOT EXISTS idx_login_throttles_locked_until ON login_throttles(locked_until);
+CREATE INDEX IF NOT EXISTS idx_login_throttles_last_failure_at ON login_throttles(last_failure_at);
+CREATE INDEX IF NOT EXISTS idx_audit_log_action ON audit_log(action);
@@ -0,0 +1,6 @@
+-- Set when an admin forces a password reset, the old password no longer logs in until a new one is set
+ALTER TABLE users ADD COLUMN IF NOT EXISTS password_reset_required BOOLEAN NOT NULL DEFAULT FALSE;
+
+-- Create indexes
+CREATE INDEX IF NOT EXISTS idx_users_disabled_at ON users(disabled_at);
+CREATE INDEX IF NOT EXISTS idx_users_created_at ON users(created_at);
@@ -0,0 +1,13 @@
+-- Realtime events published by any backend instance. The id orders events across instances
+-- and is the replay cursor clients send back; NOTIFY only carries it. Rows are kept for a
+-- few minutes, long enough for listeners to load them and to catch up after reconnecting.
+CREATE TABLE IF NOT EXISTS realtime_events (
+    id BIGSERIAL PRIMARY KEY,
+    user_ids INTEGER[] NOT NULL,
+    type VARCHAR(100) NOT NULL,
+    data JSONB NOT NULL,
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+);
+
+-- Create indexes
+CREATE INDEX IF NOT EXISTS idx_realtime_events_created_at ON realtime_events(created_at);
@@ -0,0 +1,10 @@
+-- IDs of redeemed stream tickets, which open one WebSocket or event stream each; rows can
+-- be dropped once the ticket has expired
+CREATE TABLE IF NOT EXISTS used_stream_tickets (
+    jti UUID PRIMARY KEY,
+    expires_at TIMESTAMP NOT NULL,
+    used_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+);
+
+-- Create indexes
+CREATE INDEX IF NOT EXISTS idx_used_stream_tickets_expires_at ON used_stream_tickets(expires_at);
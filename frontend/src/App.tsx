@@ -6,6 +6,7 @@ import { AuthProvider, useAuth } from './hooks/useAuth';
 import Layout from './components/Layout';
 import Login from './pages/Login';
 import Register from './pages/Register';
+import ResetPassword from './pages/ResetPassword';
 import Dashboard from './pages/Dashboard';
 import Files from './pages/Files';
 import DocumentEditor from './pages/DocumentEditor';
@@ -66,6 +67,14 @@ function App() {
                 </PublicRoute>
               }
             />
+            <Route
+              path="/reset-password"
+              element={
+                <PublicRoute>
+                  <ResetPassword />
+                </PublicRoute>
+              }
+            />
 
             {/* Protected routes with Layout */}
             <Route
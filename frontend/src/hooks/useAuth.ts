@@ -1,12 +1,13 @@
 import React, { useState, useEffect, createContext, useContext, ReactNode } from 'react';
-import { User } from '../types';
+import { User, TwoFactorChallenge } from '../types';
 import { authService } from '../services/authService';
 
 interface AuthContextType {
   user: User | null;
   isAuthenticated: boolean;
   isLoading: boolean;
-  login: (username: string, password: string) => Promise<void>;
+  login: (username: string, password: string) => Promise<TwoFactorChallenge | null>;
+  finishLogin: (user: User) => void; // After the second factor was verified
   register: (username: string, email: string, password: string, nickname: string) => Promise<void>;
   logout: () => void;
   updateUser: (userData: Partial<User>) => Promise<void>;
@@ -30,12 +31,20 @@ export const AuthProvider = ({ children }: { children: ReactNode }) => {
   const login = async (username: string, password: string) => {
     try {
       const response = await authService.login({ username, password });
+      if ('two_factor_required' in response) {
+        return response;
+      }
       setUser(response.user);
+      return null;
     } catch (error) {
       throw error;
     }
   };
 
+  const finishLogin = (loggedInUser: User) => {
+    setUser(loggedInUser);
+  };
+
   const register = async (username: string, email: string, password: string, nickname: string) => {
     try {
       const response = await authService.register({ username, email, password, nickname });
@@ -64,6 +73,7 @@ export const AuthProvider = ({ children }: { children: ReactNode }) => {
     isAuthenticated: !!user,
     isLoading,
     login,
+    finishLogin,
     register,
     logout,
     updateUser,
@@ -60,12 +60,34 @@ const Dashboard: React.FC = () => {
  
   // Inline editor state
   const [editingFile, setEditingFile] = useState<FileItem | null>(null);
+  const [editorSrc, setEditorSrc] = useState('');
 
   useEffect(() => {
     loadFiles();
     loadFriends();
   }, []);
 
+  // Build editor iframe src when a file is selected, from a single-use edit URL
+  useEffect(() => {
+    setEditorSrc('');
+    if (!editingFile) return;
+
+    let cancelled = false;
+    fileService
+      .getAccessUrl(editingFile.id, 'edit')
+      .then((url) => {
+        if (!cancelled) setEditorSrc(url);
+      })
+      .catch((e) => {
+        console.error(e);
+        setError('Failed to open editor');
+        setSnackbarOpen(true);
+      });
+    return () => {
+      cancelled = true;
+    };
+  }, [editingFile]);
+
   const loadFiles = async () => {
     try {
       setLoadingFiles(true);
@@ -120,12 +142,6 @@ const Dashboard: React.FC = () => {
     return ext ? `${head}…${ext}` : `${head}…`;
   };
 
-  // Build editor iframe src when a file is selected
-  const token = localStorage.getItem('token') || '';
-  const editorSrc = editingFile
-    ? `/api/files/${editingFile.id}/edit?token=${encodeURIComponent(token)}`
-    : '';
- 
 
   const handleEdit = (file: FileItem) => {
     // Open editor inline inside Dashboard (2/3 width area)
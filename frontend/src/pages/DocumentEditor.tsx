@@ -1,13 +1,34 @@
-import React from 'react';
+import React, { useEffect, useState } from 'react';
 import { useParams } from 'react-router-dom';
+import { fileService } from '../services/fileService';
 
 const DocumentEditor: React.FC = () => {
   const { fileId } = useParams<{ fileId: string }>();
-  const token = localStorage.getItem('token') || '';
+  const [src, setSrc] = useState('');
+  const [failed, setFailed] = useState(false);
 
-  const src = fileId
-    ? `/api/files/${fileId}/edit?token=${encodeURIComponent(token)}`
-    : '';
+  // The editor URL carries a single-use edit token instead of the session token
+  useEffect(() => {
+    const id = Number(fileId);
+    if (!id) {
+      setFailed(true);
+      return;
+    }
+
+    let cancelled = false;
+    fileService
+      .getAccessUrl(id, 'edit')
+      .then((url) => {
+        if (!cancelled) setSrc(url);
+      })
+      .catch((e) => {
+        console.error(e);
+        if (!cancelled) setFailed(true);
+      });
+    return () => {
+      cancelled = true;
+    };
+  }, [fileId]);
 
   return (
     <div style={{ width: '100%', height: '100vh', margin: 0, padding: 0 }}>
@@ -20,7 +41,7 @@ const DocumentEditor: React.FC = () => {
         />
       ) : (
         <div style={{ display: 'flex', alignItems: 'center', justifyContent: 'center', height: '100%' }}>
-          Invalid file ID
+          {failed ? 'Unable to open this file' : 'Loading editor...'}
         </div>
       )}
     </div>
@@ -1,4 +1,4 @@
-import React, { useState } from 'react';
+import React, { useEffect, useState } from 'react';
 import {
   Container,
   Paper,
@@ -12,23 +12,75 @@ import {
 } from '@mui/material';
 import { useAuth } from '../hooks/useAuth';
 import { useNavigate, Link as RouterLink } from 'react-router-dom';
+import { authService } from '../services/authService';
+import { AuthResponse, TwoFactorChallenge, TwoFactorSetup } from '../types';
 
 const Login: React.FC = () => {
   const [username, setUsername] = useState('');
   const [password, setPassword] = useState('');
   const [error, setError] = useState('');
   const [loading, setLoading] = useState(false);
-  const { login } = useAuth();
+  // Second login step for accounts with two-factor authentication
+  const [challenge, setChallenge] = useState<TwoFactorChallenge | null>(null);
+  const [setup, setSetup] = useState<TwoFactorSetup | null>(null);
+  const [code, setCode] = useState('');
+  // Recovery codes from an enrollment during login, shown before entering the app
+  const [completed, setCompleted] = useState<AuthResponse | null>(null);
+  const [ssoEnabled, setSSOEnabled] = useState(false);
+  const { login, finishLogin } = useAuth();
   const navigate = useNavigate();
 
+  // Continue a login that needs a second factor
+  const startChallenge = async (pending: TwoFactorChallenge) => {
+    setChallenge(pending);
+    if (pending.setup_required) {
+      setSetup(await authService.beginTwoFactorSetup(pending.challenge_token));
+    }
+  };
+
+  // Single sign-on returns here with a code in the fragment, or an error in the query
+  useEffect(() => {
+    authService.getSSOConfig()
+      .then((config) => setSSOEnabled(config.enabled))
+      .catch(() => setSSOEnabled(false));
+
+    const params = new URLSearchParams(window.location.search);
+    const ssoError = params.get('sso_error');
+    if (ssoError) {
+      setError(ssoError);
+    }
+
+    const ssoCode = new URLSearchParams(window.location.hash.slice(1)).get('sso_code');
+    if (!ssoCode) return;
+    window.history.replaceState(null, '', window.location.pathname);
+
+    setLoading(true);
+    authService.exchangeSSOCode(ssoCode)
+      .then(async (response) => {
+        if ('two_factor_required' in response) {
+          await startChallenge(response);
+          return;
+        }
+        finishLogin(response.user);
+        navigate('/dashboard');
+      })
+      .catch((err: any) => setError(err.response?.data?.error || 'Single sign-on failed'))
+      .finally(() => setLoading(false));
+    // eslint-disable-next-line react-hooks/exhaustive-deps
+  }, []);
+
   const handleSubmit = async (e: React.FormEvent) => {
     e.preventDefault();
     setError('');
     setLoading(true);
 
     try {
-      await login(username, password);
-      navigate('/dashboard');
+      const pending = await login(username, password);
+      if (!pending) {
+        navigate('/dashboard');
+        return;
+      }
+      await startChallenge(pending);
     } catch (err: any) {
       setError(err.response?.data?.error || 'Login failed, please check your username and password');
     } finally {
@@ -36,6 +88,34 @@ const Login: React.FC = () => {
     }
   };
 
+  const handleVerify = async (e: React.FormEvent) => {
+    e.preventDefault();
+    if (!challenge) return;
+    setError('');
+    setLoading(true);
+
+    try {
+      if (challenge.setup_required) {
+        setCompleted(await authService.completeTwoFactorSetup(challenge.challenge_token, code));
+      } else {
+        const response = await authService.verifyTwoFactor(challenge.challenge_token, code);
+        finishLogin(response.user);
+        navigate('/dashboard');
+      }
+    } catch (err: any) {
+      setError(err.response?.data?.error || 'Verification failed');
+      setCode('');
+    } finally {
+      setLoading(false);
+    }
+  };
+
+  const handleContinue = () => {
+    if (!completed) return;
+    finishLogin(completed.user);
+    navigate('/dashboard');
+  };
+
   return (
     <Container component="main" maxWidth="xs">
       <Box
@@ -61,48 +141,120 @@ const Login: React.FC = () => {
               </Alert>
             )}
 
-            <Box component="form" onSubmit={handleSubmit} sx={{ mt: 1, width: '100%' }}>
-              <TextField
-                margin="normal"
-                required
-                fullWidth
-                id="username"
-                label="Username"
-                name="username"
-                autoComplete="username"
-                autoFocus
-                value={username}
-                onChange={(e) => setUsername(e.target.value)}
-                disabled={loading}
-              />
-              <TextField
-                margin="normal"
-                required
-                fullWidth
-                name="password"
-                label="Password"
-                type="password"
-                id="password"
-                autoComplete="current-password"
-                value={password}
-                onChange={(e) => setPassword(e.target.value)}
-                disabled={loading}
-              />
-              <Button
-                type="submit"
-                fullWidth
-                variant="contained"
-                sx={{ mt: 3, mb: 2 }}
-                disabled={loading || !username || !password}
-              >
-                {loading ? <CircularProgress size={24} /> : 'Login'}
-              </Button>
-              <Box sx={{ textAlign: 'center' }}>
-                <Link component={RouterLink} to="/register" variant="body2">
-                  Don't have an account? Sign up
-                </Link>
+            {completed ? (
+              <Box sx={{ width: '100%' }}>
+                <Alert severity="success" sx={{ mb: 2 }}>
+                  Two-factor authentication is enabled. Store these recovery codes somewhere safe, each one can replace an authenticator code once.
+                </Alert>
+                <Box component="pre" sx={{ fontFamily: 'monospace', textAlign: 'center', mb: 2 }}>
+                  {(completed.recovery_codes || []).join('\n')}
+                </Box>
+                <Button fullWidth variant="contained" onClick={handleContinue}>
+                  Continue
+                </Button>
               </Box>
-            </Box>
+            ) : challenge ? (
+              <Box component="form" onSubmit={handleVerify} sx={{ mt: 1, width: '100%' }}>
+                {setup ? (
+                  <>
+                    <Typography variant="body2" sx={{ mb: 1 }}>
+                      Two-factor authentication is required for your account. Add this key to your authenticator app, then enter the code it shows.
+                    </Typography>
+                    <Typography variant="body2" sx={{ fontFamily: 'monospace', wordBreak: 'break-all', mb: 1 }}>
+                      {setup.secret}
+                    </Typography>
+                    <Link href={setup.provisioning_uri} variant="body2">
+                      Open in authenticator app
+                    </Link>
+                  </>
+                ) : (
+                  <Typography variant="body2">
+                    Enter the code from your authenticator app, or one of your recovery codes.
+                  </Typography>
+                )}
+                <TextField
+                  margin="normal"
+                  required
+                  fullWidth
+                  id="code"
+                  label="Authentication code"
+                  name="code"
+                  autoComplete="one-time-code"
+                  autoFocus
+                  value={code}
+                  onChange={(e) => setCode(e.target.value)}
+                  disabled={loading}
+                />
+                <Button
+                  type="submit"
+                  fullWidth
+                  variant="contained"
+                  sx={{ mt: 3, mb: 2 }}
+                  disabled={loading || !code}
+                >
+                  {loading ? <CircularProgress size={24} /> : 'Verify'}
+                </Button>
+              </Box>
+            ) : (
+              <Box component="form" onSubmit={handleSubmit} sx={{ mt: 1, width: '100%' }}>
+                <TextField
+                  margin="normal"
+                  required
+                  fullWidth
+                  id="username"
+                  label="Username"
+                  name="username"
+                  autoComplete="username"
+                  autoFocus
+                  value={username}
+                  onChange={(e) => setUsername(e.target.value)}
+                  disabled={loading}
+                />
+                <TextField
+                  margin="normal"
+                  required
+                  fullWidth
+                  name="password"
+                  label="Password"
+                  type="password"
+                  id="password"
+                  autoComplete="current-password"
+                  value={password}
+                  onChange={(e) => setPassword(e.target.value)}
+                  disabled={loading}
+                />
+                <Button
+                  type="submit"
+                  fullWidth
+                  variant="contained"
+                  sx={{ mt: 3, mb: 2 }}
+                  disabled={loading || !username || !password}
+                >
+                  {loading ? <CircularProgress size={24} /> : 'Login'}
+                </Button>
+                {ssoEnabled && (
+                  <Button
+                    fullWidth
+                    variant="outlined"
+                    sx={{ mb: 2 }}
+                    href={authService.ssoLoginUrl()}
+                    disabled={loading}
+                  >
+                    Log in with single sign-on
+                  </Button>
+                )}
+                <Box sx={{ textAlign: 'center' }}>
+                  <Link component={RouterLink} to="/register" variant="body2">
+                    Don't have an account? Sign up
+                  </Link>
+                </Box>
+                <Box sx={{ textAlign: 'center', mt: 1 }}>
+                  <Link component={RouterLink} to="/reset-password" variant="body2">
+                    Forgot your password?
+                  </Link>
+                </Box>
+              </Box>
+            )}
           </Box>
         </Paper>
       </Box>
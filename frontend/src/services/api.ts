@@ -23,17 +23,62 @@ api.interceptors.request.use(
   }
 );
 
-// Response interceptor - handle common errors
+// Clear the session and send the user back to the login page
+const redirectToLogin = () => {
+  localStorage.removeItem('token');
+  localStorage.removeItem('refresh_token');
+  localStorage.removeItem('user');
+  window.location.href = '/login';
+};
+
+// One refresh at a time: concurrent 401s wait for the same rotation,
+// a second refresh with the old token would count as reuse and end the session
+let refreshPromise: Promise<string> | null = null;
+
+export const refreshAccessToken = (): Promise<string> => {
+  if (!refreshPromise) {
+    const refreshToken = localStorage.getItem('refresh_token');
+    refreshPromise = (refreshToken
+      ? axios.post(`${api.defaults.baseURL}/auth/refresh`, { refresh_token: refreshToken })
+      : Promise.reject(new Error('No refresh token'))
+    )
+      .then((response) => {
+        localStorage.setItem('token', response.data.token);
+        localStorage.setItem('refresh_token', response.data.refresh_token);
+        return response.data.token as string;
+      })
+      .finally(() => {
+        refreshPromise = null;
+      });
+  }
+  return refreshPromise;
+};
+
+// Response interceptor - renew expired access tokens and handle common errors
 api.interceptors.response.use(
   (response: AxiosResponse) => {
     return response;
   },
-  (error) => {
-    if (error.response?.status === 401) {
+  async (error) => {
+    const original = error.config;
+    if (error.response?.status === 401 && original && !original._retry && !original.url?.startsWith('/auth/')) {
+      original._retry = true;
+      try {
+        const token = await refreshAccessToken();
+        original.headers.Authorization = `Bearer ${token}`;
+        if (original.params?.token) {
+          // Download and preview routes read the token from the query string
+          original.params = { ...original.params, token };
+        }
+        return api(original);
+      } catch (refreshError) {
+        redirectToLogin();
+        return Promise.reject(refreshError);
+      }
+    }
+    if (error.response?.status === 401 && !original?.url?.startsWith('/auth/')) {
       // Token expired or invalid, clear local storage and redirect to login
-      localStorage.removeItem('token');
-      localStorage.removeItem('user');
-      window.location.href = '/login';
+      redirectToLogin();
     }
     return Promise.reject(error);
   }
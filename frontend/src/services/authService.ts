@@ -1,35 +1,104 @@
 import api from './api';
-import { LoginRequest, RegisterRequest, AuthResponse, User } from '../types';
+import { LoginRequest, RegisterRequest, AuthResponse, User, TwoFactorChallenge, TwoFactorSetup } from '../types';
+
+// Save tokens and user info to local storage
+const storeSession = (data: AuthResponse) => {
+  localStorage.setItem('token', data.token);
+  localStorage.setItem('refresh_token', data.refresh_token);
+  localStorage.setItem('user', JSON.stringify(data.user));
+};
 
 export const authService = {
-  // User login
-  async login(credentials: LoginRequest): Promise<AuthResponse> {
-    const response = await api.post<AuthResponse>('/auth/login', credentials);
-    const { token, user } = response.data;
-    
-    // Save token and user info to local storage
-    localStorage.setItem('token', token);
-    localStorage.setItem('user', JSON.stringify(user));
-    
+  // User login, accounts with two-factor authentication get a challenge instead of tokens
+  async login(credentials: LoginRequest): Promise<AuthResponse | TwoFactorChallenge> {
+    const response = await api.post<AuthResponse | TwoFactorChallenge>('/auth/login', credentials);
+    if ('two_factor_required' in response.data) {
+      return response.data;
+    }
+    storeSession(response.data);
+    return response.data;
+  },
+
+  // Whether the login page offers single sign-on
+  async getSSOConfig(): Promise<{ enabled: boolean }> {
+    const response = await api.get<{ enabled: boolean }>('/auth/oidc/config');
+    return response.data;
+  },
+
+  // The backend redirects to the identity provider from here
+  ssoLoginUrl(): string {
+    return `${api.defaults.baseURL}/auth/oidc/login`;
+  },
+
+  // Trade the code from the single sign-on redirect for tokens, 2FA applies as with passwords
+  async exchangeSSOCode(code: string): Promise<AuthResponse | TwoFactorChallenge> {
+    const response = await api.post<AuthResponse | TwoFactorChallenge>('/auth/oidc/exchange', { code });
+    if ('two_factor_required' in response.data) {
+      return response.data;
+    }
+    storeSession(response.data);
+    return response.data;
+  },
+
+  // Finish a login with an authenticator or recovery code
+  async verifyTwoFactor(challengeToken: string, code: string): Promise<AuthResponse> {
+    const response = await api.post<AuthResponse>('/auth/2fa/verify', { challenge_token: challengeToken, code });
+    storeSession(response.data);
+    return response.data;
+  },
+
+  // Enrollment required by the admin policy during login
+  async beginTwoFactorSetup(challengeToken: string): Promise<TwoFactorSetup> {
+    const response = await api.post<TwoFactorSetup>('/auth/2fa/setup', { challenge_token: challengeToken });
+    return response.data;
+  },
+
+  async completeTwoFactorSetup(challengeToken: string, code: string): Promise<AuthResponse> {
+    const response = await api.post<AuthResponse>('/auth/2fa/setup/confirm', { challenge_token: challengeToken, code });
+    storeSession(response.data);
     return response.data;
   },
 
   // User registration
   async register(userData: RegisterRequest): Promise<AuthResponse> {
     const response = await api.post<AuthResponse>('/auth/register', userData);
-    const { token, user } = response.data;
-    
-    // Save token and user info to local storage
-    localStorage.setItem('token', token);
-    localStorage.setItem('user', JSON.stringify(user));
-    
+    storeSession(response.data);
     return response.data;
   },
 
-  // User logout
-  logout(): void {
+  // User logout, ends the session on the server as well
+  async logout(): Promise<void> {
+    const token = localStorage.getItem('token');
     localStorage.removeItem('token');
+    localStorage.removeItem('refresh_token');
     localStorage.removeItem('user');
+    if (!token) {
+      return;
+    }
+    try {
+      await api.post('/auth/logout', null, { headers: { Authorization: `Bearer ${token}` } });
+    } catch (error) {
+      // The session may already be gone or the token expired, the local logout still applies
+    }
+  },
+
+  // Change password, the user's other sessions are logged out
+  async changePassword(currentPassword: string, newPassword: string): Promise<void> {
+    await api.put('/profile/password', {
+      current_password: currentPassword,
+      new_password: newPassword,
+    });
+  },
+
+  // Ask for a password reset link by email
+  async forgotPassword(email: string): Promise<string> {
+    const response = await api.post<{ message: string }>('/auth/password/forgot', { email });
+    return response.data.message;
+  },
+
+  // Set a new password with the token from the reset email
+  async resetPassword(token: string, newPassword: string): Promise<void> {
+    await api.post('/auth/password/reset', { token, new_password: newPassword });
   },
 
   // Get current user info
@@ -1,5 +1,5 @@
 import api from './api';
-import { FileItem, UploadResponse, StorageUsage } from '../types';
+import { FileItem, UploadResponse, StorageUsage, FileAction, FileAccessToken } from '../types';
 
 export const fileService = {
   // Upload file
@@ -18,8 +18,16 @@ export const fileService = {
 
 // Get user file list
   async getUserFiles(): Promise<FileItem[]> {
-    const response = await api.get<{ files: FileItem[] }>('/files');
-    return response.data.files || [];
+    const files: FileItem[] = [];
+    let after: string | undefined;
+    do {
+      const response = await api.get<{ files: FileItem[]; next_cursor?: string }>('/files', {
+        params: { limit: 200, after },
+      });
+      files.push(...(response.data.files || []));
+      after = response.data.next_cursor;
+    } while (after);
+    return files;
   },
 
 // Get single file information
@@ -30,13 +38,21 @@ export const fileService = {
 
 // Download file
   async downloadFile(fileId: number): Promise<Blob> {
-    const token = localStorage.getItem('token');
-    const response = await api.get(`/files/${fileId}/download?token=${token}`, {
+    const response = await api.get(`/files/${fileId}/download`, {
       responseType: 'blob',
     });
     return response.data;
   },
 
+// Get a short-lived URL for one action on a file, for iframes and links that cannot send headers
+  async getAccessUrl(fileId: number, action: FileAction, oneTime = true): Promise<string> {
+    const response = await api.post<FileAccessToken>(`/files/${fileId}/access-token`, {
+      action,
+      one_time: oneTime,
+    });
+    return response.data.url;
+  },
+
 // Delete file
   async deleteFile(fileId: number): Promise<void> {
     await api.delete(`/files/${fileId}`);
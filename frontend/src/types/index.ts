@@ -5,7 +5,7 @@ export interface User {
   email: string;
   nickname: string;
   avatar?: string;
-  role: 'user' | 'admin';
+  role: string; // Name of a role stored on the server, e.g. user, admin, auditor
   storage_used: number;
   storage_limit: number;
   created_at: string;
@@ -26,7 +26,23 @@ export interface RegisterRequest {
 
 export interface AuthResponse {
   token: string;
+  refresh_token: string;
+  expires_in: number; // Access token lifetime in seconds
   user: User;
+  recovery_codes?: string[]; // Only when two-factor setup was completed during login
+}
+
+// Returned by login instead of tokens while the second factor is pending
+export interface TwoFactorChallenge {
+  two_factor_required: true;
+  setup_required: boolean; // Policy requires 2FA but the user has not enrolled yet
+  challenge_token: string;
+  expires_in: number;
+}
+
+export interface TwoFactorSetup {
+  secret: string;
+  provisioning_uri: string; // otpauth:// URI for authenticator apps
 }
 
 // File related types
@@ -53,6 +69,14 @@ export interface StorageUsage {
   percentage: number;
 }
 
+export type FileAction = 'download' | 'preview' | 'edit';
+
+export interface FileAccessToken {
+  token: string;
+  url: string;
+  expires_at: string;
+}
+
 // Friend related types
 export interface Friend {
   id: number;